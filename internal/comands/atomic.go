@@ -0,0 +1,153 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// atomicRename applies the renames of a run all-or-nothing, see applyAtomic
+var atomicRename bool
+
+// stagedMove is a rename performed while applying an atomic plan
+type stagedMove struct {
+	from string
+	to   string
+}
+
+// atomicMoves tracks the moves of an atomic apply so that later paths can be
+// resolved after their parent directories moved, and so everything can be rolled back
+type atomicMoves []stagedMove
+
+// resolve returns the current location of path after the moves performed so far
+func (m atomicMoves) resolve(path string) string {
+	for _, move := range m {
+		if path == move.from {
+			path = move.to
+		} else if strings.HasPrefix(path, move.from+string(filepath.Separator)) {
+			path = move.to + path[len(move.from):]
+		}
+	}
+	return path
+}
+
+// move renames from to to and records it
+func (m *atomicMoves) move(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	*m = append(*m, stagedMove{from: from, to: to})
+	return nil
+}
+
+// rollback undoes the recorded moves in reverse order, returning the moves that could not be undone
+func (m atomicMoves) rollback() []error {
+	var errs []error
+	for i := len(m) - 1; i >= 0; i-- {
+		if err := os.Rename(m[i].to, m[i].from); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %v", m[i].from, err))
+		}
+	}
+	return errs
+}
+
+// applyAtomic performs the renames of plan in two steps. Every file is first moved to a
+// unique temporary name next to it, then the temporary names are moved to the final names.
+// If any step fails, every move made so far is rolled back and nothing is left renamed.
+func applyAtomic(plan *renamePlan) error {
+	// Deeper paths first, so files are moved before the directories containing them
+	renames := append([]plannedRename(nil), plan.Renames...)
+	sort.SliceStable(
+		renames, func(i, j int) bool {
+			return pathDepth(renames[i].Old) > pathDepth(renames[j].Old)
+		},
+	)
+
+	// Read the EXIF values for the manifest before the files move
+	metas := make([]map[string]string, len(renames))
+	if activeManifest != nil && manifestIncludeExif {
+		for i, rename := range renames {
+			if pyrgear.IsEXIFImage(rename.Old) {
+				metas[i] = readExifMeta(rename.Old)
+			}
+		}
+	}
+
+	var moves atomicMoves
+	fail := func(path string, err error) error {
+		fmt.Printf("Error renaming %s: %v\n", path, err)
+		activeIssues.addError("rename", path, err)
+		for _, rbErr := range moves.rollback() {
+			fmt.Printf("Error rolling back: %v\n", rbErr)
+			activeIssues.addError("rollback", path, rbErr)
+		}
+		return fmt.Errorf("atomic rename failed, all renames were rolled back: %w", err)
+	}
+
+	// Stage: move every file to a temporary name
+	staged := make([]string, len(renames))
+	for i, rename := range renames {
+		oldPath := moves.resolve(rename.Old)
+		staged[i] = filepath.Join(filepath.Dir(oldPath), fmt.Sprintf(".pyrgear-atomic-%d-%d", os.Getpid(), i))
+		if _, err := os.Lstat(staged[i]); err == nil {
+			return fail(rename.Old, fmt.Errorf("%w: %s", ErrCollision, staged[i]))
+		}
+		if err := moves.move(oldPath, staged[i]); err != nil {
+			return fail(rename.Old, err)
+		}
+	}
+
+	// Commit: move the temporary names to the final names
+	for i, rename := range renames {
+		tmpPath, newPath := moves.resolve(staged[i]), moves.resolve(rename.New)
+		if _, err := os.Lstat(newPath); err == nil {
+			return fail(rename.Old, fmt.Errorf("%w: %s", ErrCollision, newPath))
+		}
+		if err := moves.move(tmpPath, newPath); err != nil {
+			return fail(rename.Old, err)
+		}
+	}
+
+	for i, rename := range renames {
+		fmt.Printf("Renamed: %s -> %s\n", rename.Old, rename.New)
+		activeManifest.record(rename.Old, rename.New, metas[i])
+		activeRenameLog.record(rename.Old, rename.New)
+		activeRenameStats.record(rename.Old)
+	}
+	return nil
+}
+
+// pathDepth returns the number of elements of path
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// runRenames runs process, a rename processor. With --atomic (and without --dry-run) the
+// renames are collected first and only applied if all of them could be planned, all-or-nothing.
+// With --tui they are collected and reviewed first, see runReviewedRenames.
+func runRenames(process func() error) error {
+	emptyNamesTaken, vacatedPaths = nil, nil
+	if reviewRenames {
+		return runReviewedRenames(process)
+	}
+	if !atomicRename || dryRun {
+		return process()
+	}
+
+	plan, err := collectRenamePlan(process)
+	if err == nil && plan.failed > 0 {
+		err = fmt.Errorf("%d renames could not be planned, nothing was renamed", plan.failed)
+	}
+	if err == nil {
+		err = applyAtomic(plan)
+	}
+	if err != nil {
+		// Nothing was renamed, so the numbering of this run must not be remembered
+		activeState = nil
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// burstGap is the maximum time between two images of the same burst
+var burstGap time.Duration
+
+// burstImage is an image with the time it was taken
+type burstImage struct {
+	name  string
+	taken time.Time
+}
+
+// renameBursts renames the images of dir that were taken within burstGap of each other to
+// burstGG_NNN.ext, numbering the groups in the order they were taken. Images without an
+// EXIF time are skipped. Groups continue after the highest group already present in dir.
+func renameBursts(dir string, entries []os.DirEntry, dryRun bool) error {
+	if burstGap <= 0 {
+		return fmt.Errorf("gap must be positive for burst rule, use --gap flag")
+	}
+
+	var images []burstImage
+	lastGroup := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// Images renamed by a previous run keep their group
+		if group, ok := pyrgear.BurstGroup(entry.Name()); ok {
+			if group > lastGroup {
+				lastGroup = group
+			}
+			continue
+		}
+		if image, ok := readBurstImage(dir, entry.Name()); ok {
+			images = append(images, image)
+		}
+	}
+
+	group := lastGroup
+	for _, burst := range groupBursts(images) {
+		group++
+		for i, image := range burst {
+			oldPath := filepath.Join(dir, image.name)
+			newPath := filepath.Join(dir, pyrgear.BurstName(group, i+1, filepath.Ext(image.name)))
+			renameFile(oldPath, newPath, dryRun)
+		}
+	}
+
+	return nil
+}
+
+// readBurstImage reads the EXIF time of the image name in dir. Files that are not images
+// or have no EXIF time are skipped.
+func readBurstImage(dir, name string) (burstImage, bool) {
+	if !pyrgear.IsEXIFImage(name) {
+		return burstImage{}, false
+	}
+
+	path := filepath.Join(dir, name)
+	record, err := loadExifRecord(path)
+	if err != nil {
+		fmt.Printf("Skipping %s: %v\n", path, err)
+		activeReport.countSkipped()
+		stepProgress()
+		return burstImage{}, false
+	}
+	taken, ok := exifTime(record)
+	if !ok {
+		fmt.Printf("Skipping %s: no EXIF date\n", path)
+		activeReport.countSkipped()
+		stepProgress()
+		return burstImage{}, false
+	}
+	return burstImage{name: name, taken: taken}, true
+}
+
+// groupBursts orders images by the time they were taken and splits them into bursts
+// wherever two consecutive images are more than burstGap apart
+func groupBursts(images []burstImage) [][]burstImage {
+	// Images taken at the same second keep their filename order
+	sort.SliceStable(
+		images, func(i, j int) bool {
+			return images[i].taken.Before(images[j].taken)
+		},
+	)
+
+	var bursts [][]burstImage
+	for i, image := range images {
+		if i == 0 || image.taken.Sub(images[i-1].taken) > burstGap {
+			bursts = append(bursts, nil)
+		}
+		bursts[len(bursts)-1] = append(bursts[len(bursts)-1], image)
+	}
+	return bursts
+}
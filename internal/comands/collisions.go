@@ -0,0 +1,164 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	collisionsRecursive bool
+	collisionsFormat    string
+)
+
+// CollisionsCmd represents the collisions command
+var CollisionsCmd = &cobra.Command{
+	Use:   "collisions",
+	Short: "Report names that collide on case-insensitive or normalizing filesystems",
+	Long: `Scan a directory for files and directories whose names differ only by case (Photo.jpg and
+photo.JPG) or by Unicode normalization form (café in NFC and NFD). Such names cannot coexist on
+macOS or Windows filesystems and cause trouble when moving files there. Nothing is renamed.
+
+Examples:
+  pyrgear collisions --dir ./archive --recursive
+  pyrgear collisions --dir ./archive --recursive --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if directory == "" {
+			fmt.Println("Error: --dir is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		groups, err := findNameCollisions(directory, collisionsRecursive)
+		if err != nil {
+			fmt.Printf("Error processing directory: %v\n", err)
+			activeIssues.addError("collisions", directory, err)
+			return
+		}
+
+		out, finishOutput := startOutput(collisionsFormat)
+		defer finishOutput()
+		if err := writeNameCollisions(out, groups, collisionsFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	CollisionsCmd.Flags().StringVar(&directory, "dir", "", "Directory to scan")
+	CollisionsCmd.Flags().BoolVar(&collisionsRecursive, "recursive", false, "Scan subdirectories recursively")
+	CollisionsCmd.Flags().StringVar(&collisionsFormat, "format", "text", "Output format: text or json")
+}
+
+// nameCollision is a group of names of one directory that collide
+type nameCollision struct {
+	Dir   string   `json:"dir"`
+	Names []string `json:"names"`
+	// Kinds are "case" and/or "normalization", the differences between the names
+	Kinds []string `json:"kinds"`
+}
+
+// findNameCollisions returns the groups of colliding names of dir, and of its subdirectories when recursive
+func findNameCollisions(dir string, recursive bool) ([]nameCollision, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %v", dir, err)
+	}
+
+	byKey := make(map[string][]string)
+	for _, entry := range entries {
+		key := pyrgear.CollisionKey(entry.Name())
+		byKey[key] = append(byKey[key], entry.Name())
+	}
+
+	var groups []nameCollision
+	for _, key := range sortedKeys(byKey) {
+		if names := byKey[key]; len(names) > 1 {
+			groups = append(groups, nameCollision{Dir: dir, Names: names, Kinds: collisionKinds(names)})
+		}
+	}
+
+	if recursive {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			sub, err := findNameCollisions(filepath.Join(dir, entry.Name()), recursive)
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				activeIssues.addWarning("collisions", filepath.Join(dir, entry.Name()), err)
+				continue
+			}
+			groups = append(groups, sub...)
+		}
+	}
+	return groups, nil
+}
+
+// collisionKinds returns how the colliding names differ: by normalization form when
+// several names share the same NFC form, by case when their NFC forms differ
+func collisionKinds(names []string) []string {
+	nfc := make(map[string]bool)
+	for _, name := range names {
+		nfc[norm.NFC.String(name)] = true
+	}
+
+	var kinds []string
+	if len(nfc) > 1 {
+		kinds = append(kinds, "case")
+	}
+	if len(nfc) < len(names) {
+		kinds = append(kinds, "normalization")
+	}
+	return kinds
+}
+
+// writeNameCollisions writes the groups to w in the given format
+func writeNameCollisions(w io.Writer, groups []nameCollision, format string) error {
+	switch format {
+	case "text":
+		if len(groups) == 0 {
+			fmt.Fprintln(w, "No colliding names found")
+			return nil
+		}
+		for _, group := range groups {
+			names := make([]string, len(group.Names))
+			for i, name := range group.Names {
+				names[i] = fmt.Sprintf("%q", name)
+				if !norm.NFC.IsNormalString(name) {
+					names[i] += " (NFD)"
+				}
+			}
+			fmt.Fprintf(
+				w, "%s: %s differ only by %s\n", group.Dir, strings.Join(names, ", "), strings.Join(group.Kinds, " and "),
+			)
+		}
+		fmt.Fprintf(w, "Found %d groups of colliding names\n", len(groups))
+		return nil
+	case "json":
+		if groups == nil {
+			groups = []nameCollision{}
+		}
+		data, err := marshalJSON(groups)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+}
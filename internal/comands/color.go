@@ -0,0 +1,78 @@
+package comands
+
+import (
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// noColor disables colored output, which is also off when stdout is not a terminal
+var noColor bool
+
+// ANSI escape sequences of the colored output
+const (
+	colorReset = "\x1b[0m"
+	colorDim   = "\x1b[2m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+)
+
+// useColor reports whether output is colored: not with --no-color or the NO_COLOR
+// environment variable (https://no-color.org), and only on a terminal
+func useColor() bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// renameArrow returns "old -> new" for the dry-run output of a rename. With colored output
+// the part that changes is highlighted, red in old and green in new, and the common prefix
+// and suffix are dimmed, see diffNames.
+func renameArrow(oldPath, newPath string) string {
+	if !useColor() {
+		return oldPath + " -> " + newPath
+	}
+	return colorDiff(oldPath, newPath)
+}
+
+// colorDiff returns "old -> new" with the changed parts of old and new colored
+func colorDiff(oldPath, newPath string) string {
+	prefix, suffix := diffNames(oldPath, newPath)
+	paint := func(s string, color string) string {
+		dim := func(part string) string {
+			if part == "" {
+				return ""
+			}
+			return colorDim + part + colorReset
+		}
+		middle := s[prefix : len(s)-suffix]
+		if middle != "" {
+			middle = color + middle + colorReset
+		}
+		return dim(s[:prefix]) + middle + dim(s[len(s)-suffix:])
+	}
+	return paint(oldPath, colorRed) + " -> " + paint(newPath, colorGreen)
+}
+
+// diffNames returns the length in bytes of the longest common prefix and suffix of a and b.
+// Both end at character boundaries and never overlap within either string.
+func diffNames(a, b string) (prefix int, suffix int) {
+	for prefix < len(a) && prefix < len(b) {
+		ra, size := utf8.DecodeRuneInString(a[prefix:])
+		rb, _ := utf8.DecodeRuneInString(b[prefix:])
+		if ra != rb {
+			break
+		}
+		prefix += size
+	}
+
+	limit := min(len(a), len(b)) - prefix
+	for suffix < limit {
+		ra, size := utf8.DecodeLastRuneInString(a[:len(a)-suffix])
+		rb, _ := utf8.DecodeLastRuneInString(b[:len(b)-suffix])
+		if ra != rb || suffix+size > limit {
+			break
+		}
+		suffix += size
+	}
+	return prefix, suffix
+}
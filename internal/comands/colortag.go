@@ -0,0 +1,69 @@
+package comands
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// colorFormat is how the color-tag rule writes the dominant color: name or hex
+var colorFormat string
+
+// renameByColorTag prefixes the images of dir with their dominant color, e.g. red_swatch.png
+// or with --color-format hex dc141e_swatch.png, for moodboards and swatch libraries. Each image
+// is decoded in full, with the decoders the info command reads headers with. Files that are
+// not images are left alone, images that cannot be decoded are skipped with a warning, and
+// files already tagged are skipped.
+func renameByColorTag(dir string, entries []os.DirEntry, dryRun bool) error {
+	if colorFormat != "name" && colorFormat != "hex" {
+		return fmt.Errorf("unknown --color-format: %s (supported: name, hex)", colorFormat)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !infoExtensions[strings.ToLower(filepath.Ext(entry.Name()))] ||
+			alreadyApplied("color-tag", entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		c, err := decodeDominantColor(path)
+		if err != nil {
+			fmt.Printf("Warning: Skipping %s: %v\n", path, err)
+			activeIssues.addWarning("color-tag", path, err)
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+
+		tag := pyrgear.ColorName(c)
+		if colorFormat == "hex" {
+			tag = pyrgear.ColorHex(c)
+		}
+		renameFile(path, filepath.Join(dir, tag+"_"+entry.Name()), dryRun)
+	}
+	return nil
+}
+
+// decodeDominantColor decodes the image at path and returns its dominant color
+func decodeDominantColor(path string) (color.RGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+	c, ok := pyrgear.DominantColor(img)
+	if !ok {
+		return color.RGBA{}, fmt.Errorf("image is fully transparent")
+	}
+	return c, nil
+}
@@ -0,0 +1,132 @@
+package comands
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+// CSV output options shared by the commands with --format csv
+var (
+	csvDelimiter string
+	csvCRLF      bool
+	csvQuote     string
+)
+
+// Policies of --csv-quote
+const (
+	// csvQuoteMinimal quotes fields that contain the delimiter, a quote, a line break or
+	// leading space, as encoding/csv does
+	csvQuoteMinimal = "minimal"
+	// csvQuoteAll quotes every field, for tools that read unquoted fields as numbers
+	csvQuoteAll = "all"
+)
+
+// addCSVFlags registers the CSV output flags on cmd
+func addCSVFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&csvDelimiter, "csv-delimiter", ",",
+		`Field delimiter of --format csv, a single character such as ';' (tab as '\t' or 'tab')`,
+	)
+	cmd.Flags().BoolVar(&csvCRLF, "csv-crlf", false, "End the lines of --format csv with \\r\\n (Windows line endings)")
+	cmd.Flags().StringVar(
+		&csvQuote, "csv-quote", csvQuoteMinimal,
+		"When fields of --format csv are quoted: minimal (only when needed) or all",
+	)
+}
+
+// parseCSVDelimiter returns the delimiter rune of value. "\t" and "tab" are a tab.
+func parseCSVDelimiter(value string) (rune, error) {
+	switch strings.ToLower(value) {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) {
+		return 0, fmt.Errorf("invalid --csv-delimiter %q: must be a single character", value)
+	}
+	if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid --csv-delimiter %q: cannot be a quote or line break", value)
+	}
+	return r, nil
+}
+
+// csvWriter writes CSV records with the --csv-delimiter, --csv-crlf and --csv-quote options.
+// Minimal quoting is encoding/csv's; with --csv-quote all every field is quoted.
+type csvWriter struct {
+	csv      *csv.Writer
+	buf      *bufio.Writer
+	comma    rune
+	crlf     bool
+	quoteAll bool
+}
+
+// checkCSVOptions returns an error for an invalid --csv-delimiter or --csv-quote
+func checkCSVOptions() error {
+	if _, err := parseCSVDelimiter(csvDelimiter); err != nil {
+		return err
+	}
+	if csvQuote != csvQuoteMinimal && csvQuote != csvQuoteAll {
+		return fmt.Errorf("unknown --csv-quote policy: %s (supported: minimal, all)", csvQuote)
+	}
+	return nil
+}
+
+// newCSVWriter returns a writer of CSV records to w with the CSV output options
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	if err := checkCSVOptions(); err != nil {
+		return nil, err
+	}
+	comma, _ := parseCSVDelimiter(csvDelimiter)
+
+	cw := &csvWriter{comma: comma, crlf: csvCRLF, quoteAll: csvQuote == csvQuoteAll}
+	if cw.quoteAll {
+		cw.buf = bufio.NewWriter(w)
+	} else {
+		cw.csv = csv.NewWriter(w)
+		cw.csv.Comma = comma
+		cw.csv.UseCRLF = csvCRLF
+	}
+	return cw, nil
+}
+
+// Write writes a record
+func (w *csvWriter) Write(record []string) error {
+	if !w.quoteAll {
+		return w.csv.Write(record)
+	}
+
+	for i, field := range record {
+		if i > 0 {
+			w.buf.WriteRune(w.comma)
+		}
+		if w.crlf {
+			// Like encoding/csv, line breaks within fields follow --csv-crlf too
+			field = strings.ReplaceAll(strings.ReplaceAll(field, "\r\n", "\n"), "\n", "\r\n")
+		}
+		w.buf.WriteByte('"')
+		w.buf.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		w.buf.WriteByte('"')
+	}
+	if w.crlf {
+		w.buf.WriteString("\r\n")
+	} else {
+		w.buf.WriteByte('\n')
+	}
+	// Errors of the buffered writer stick, Flush returns them
+	return nil
+}
+
+// Flush writes any buffered data and returns the first error of the writer
+func (w *csvWriter) Flush() error {
+	if !w.quoteAll {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return w.buf.Flush()
+}
@@ -0,0 +1,175 @@
+package comands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// outputDirTemplate is the subdirectory of --output-dir each file goes to with the date-tree rule
+	outputDirTemplate string
+	// moveFiles moves the files of the date-tree rule instead of copying them
+	moveFiles bool
+)
+
+// unknownDateDir is the subdirectory of files without an EXIF date
+const unknownDateDir = "unknown"
+
+// dirTemplateData are the fields available to --output-dir-template
+type dirTemplateData struct {
+	// ExifYear, ExifMonth and ExifDay are the date taken, 2024, 05 and 06
+	ExifYear, ExifMonth, ExifDay string
+	// Make and Model are the camera, "unknown" if not recorded
+	Make, Model string
+	// Ext is the lowercase extension without the dot, e.g. jpg
+	Ext string
+}
+
+// parseDirTemplate parses --output-dir-template. Unknown fields are reported when the
+// template is first rendered.
+func parseDirTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output-dir-template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --output-dir-template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// dateTreeDir returns the subdirectory tmpl renders for the file at path. Files without an
+// EXIF date go to unknown/. The result must stay within the output directory.
+func dateTreeDir(tmpl *template.Template, path string) (string, error) {
+	if !pyrgear.IsEXIFImage(path) {
+		return unknownDateDir, nil
+	}
+	record, err := loadExifRecord(path)
+	if err != nil {
+		return unknownDateDir, nil
+	}
+	taken, ok := exifTime(record)
+	if !ok {
+		return unknownDateDir, nil
+	}
+
+	data := dirTemplateData{
+		ExifYear:  taken.Format("2006"),
+		ExifMonth: taken.Format("01"),
+		ExifDay:   taken.Format("02"),
+		Make:      unknownDateDir,
+		Model:     unknownDateDir,
+		Ext:       strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")),
+	}
+	if value, ok := record.Get("Make"); ok && strings.TrimSpace(value) != "" {
+		data.Make = strings.TrimSpace(value)
+	}
+	if value, ok := record.Get("Model"); ok && strings.TrimSpace(value) != "" {
+		data.Model = strings.TrimSpace(value)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	sub := filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+	if sub == "." {
+		return unknownDateDir, nil
+	}
+	if filepath.IsAbs(sub) || !pyrgear.IsWithinBase(".", sub) {
+		return "", fmt.Errorf("--output-dir-template rendered %s, which is outside of the output directory", sub)
+	}
+	return sub, nil
+}
+
+// copyToDateTree copies the files of dir, and of its subdirectories when recursive, into the
+// subdirectories of outDir that --output-dir-template renders for each, e.g. 2024/05. The
+// originals are left alone unless --move is set. Existing files are handled as --on-duplicate says.
+func copyToDateTree(dir string, recursive bool, outDir string, dryRun bool) error {
+	tmpl, err := parseDirTemplate(outputDirTemplate)
+	if err != nil {
+		return err
+	}
+	// A plan only holds renames, copies and the directories of the tree would be made while it is collected
+	if activePlan != nil {
+		return fmt.Errorf("--atomic is not supported for date-tree rule")
+	}
+	copyBufferSize = resolveCopyBufferSize(copyBufferSizeFlag)
+
+	// Files sorted by an earlier run into an output directory within dir are not sorted again
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	err = walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			if abs, err := filepath.Abs(path); err != nil || !pyrgear.IsWithinBase(absOut, abs) {
+				files = append(files, path)
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	taken := make(map[string]bool)
+	for _, src := range files {
+		if activeErrorBudget.exceeded() {
+			break
+		}
+		sub, err := dateTreeDir(tmpl, src)
+		if err != nil {
+			fmt.Printf("Error sorting %s: %v\n", src, err)
+			activeIssues.addError("copy", src, err)
+			continue
+		}
+		dst := filepath.Join(outDir, sub, filepath.Base(src))
+		if !dryRun {
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				fmt.Printf("Error creating %s: %v\n", filepath.Dir(dst), err)
+				activeIssues.addError("copy", filepath.Dir(dst), err)
+				continue
+			}
+		}
+		if moveFiles {
+			renameFileWithin(src, dst, outDir, dryRun)
+			continue
+		}
+		copyToTree(src, dst, taken, dryRun)
+	}
+	return nil
+}
+
+// copyToTree copies src to dst, resolving an existing dst with --on-duplicate. Destinations
+// already written in this run are in taken.
+func copyToTree(src, dst string, taken map[string]bool, dryRun bool) {
+	defer stepProgress()
+
+	resolved, err := resolveDestination("", dst, onDuplicate, taken)
+	if err != nil {
+		fmt.Printf("Error copying %s: %v\n", src, err)
+		activeIssues.addError("copy", src, err)
+		return
+	}
+	if resolved == "" {
+		fmt.Printf("Skipping %s: target already exists: %s\n", src, dst)
+		activeReport.countSkipped()
+		return
+	}
+	taken[resolved] = true
+
+	if dryRun {
+		fmt.Printf("Would copy: %s -> %s\n", src, resolved)
+		return
+	}
+	if err := copyFile(src, resolved); err != nil {
+		fmt.Printf("Error copying %s: %v\n", src, err)
+		activeIssues.addError("copy", src, err)
+		return
+	}
+	fmt.Printf("Copying: %s -> %s\n", src, resolved)
+}
@@ -0,0 +1,92 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rejectedDirName is the folder of dir the deburst-keep-best rule moves culled frames to
+const rejectedDirName = "rejected"
+
+// keepBest is the heuristic choosing the frame of a burst to keep
+var keepBest string
+
+// keepBestHeuristics score a frame, the frame with the highest score is kept.
+// True sharpness needs image analysis, the heuristics are cheap proxies for it.
+var keepBestHeuristics = map[string]func(path string) (int64, error){
+	// A larger JPEG compresses worse, which usually means more detail
+	"size": func(path string) (int64, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	},
+}
+
+// deburstKeepBest groups the images of dir into bursts like the burst rule and keeps only
+// the best frame of each, moving the others to the rejected folder of dir. Frames that
+// cannot be scored are kept.
+func deburstKeepBest(dir string, entries []os.DirEntry, dryRun bool) error {
+	if burstGap <= 0 {
+		return fmt.Errorf("gap must be positive for deburst-keep-best rule, use --gap flag")
+	}
+	score, ok := keepBestHeuristics[strings.ToLower(keepBest)]
+	if !ok {
+		return fmt.Errorf(
+			"unknown --keep-best heuristic %q, use one of: %s",
+			keepBest, strings.Join(sortedKeys(keepBestHeuristics), ", "),
+		)
+	}
+
+	var images []burstImage
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if image, ok := readBurstImage(dir, entry.Name()); ok {
+			images = append(images, image)
+		}
+	}
+
+	rejectedDir := filepath.Join(dir, rejectedDirName)
+	for _, burst := range groupBursts(images) {
+		if len(burst) < 2 {
+			continue
+		}
+
+		// Equal scores keep the frame taken first
+		best, bestScore := -1, int64(0)
+		scored := make([]bool, len(burst))
+		for i, image := range burst {
+			s, err := score(filepath.Join(dir, image.name))
+			if err != nil {
+				fmt.Printf("Keeping %s: %v\n", filepath.Join(dir, image.name), err)
+				continue
+			}
+			scored[i] = true
+			if best < 0 || s > bestScore {
+				best, bestScore = i, s
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		fmt.Printf("Burst of %d: keeping %s\n", len(burst), filepath.Join(dir, burst[best].name))
+		if !dryRun {
+			if err := os.MkdirAll(rejectedDir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %v", rejectedDir, err)
+			}
+		}
+		for i, image := range burst {
+			if i == best || !scored[i] {
+				continue
+			}
+			renameFile(filepath.Join(dir, image.name), filepath.Join(rejectedDir, image.name), dryRun)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dedupeRecursive bool
+	dedupeFormat    string
+	dedupeDelete    bool
+	dedupeHardlink  bool
+	dedupeKeep      string
+	dedupeParallel  int
+)
+
+// DedupeCmd represents the dedupe command
+var DedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Find files with identical content",
+	Long: `Scan a directory for files with identical content, compared by their hash (SHA-256 unless
+--hash-algo says otherwise). Without --delete or --hardlink the duplicate sets are only reported.
+
+--keep decides which member of a set is kept:
+  oldest  - the file modified first (default, usually the original)
+  newest  - the file modified last
+  largest - the largest file (members of a set only differ in size when they change during the scan)
+  first   - the first file in path order
+Ties are broken by path order.
+
+Examples:
+  pyrgear dedupe --dir ./photos --recursive
+  pyrgear dedupe --dir ./photos --recursive --delete --keep newest --dry-run
+  pyrgear dedupe --dir ./photos --recursive --hardlink
+  pyrgear dedupe --dir ./photos --recursive --parallel 16
+  pyrgear dedupe --dir ./photos --recursive --hash-algo blake3`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if directory == "" {
+			fmt.Println("Error: --dir is required")
+			cmd.Help()
+			return
+		}
+		if dedupeDelete && dedupeHardlink {
+			fmt.Println("Error: --delete and --hardlink cannot be used together")
+			return
+		}
+		if err := checkKeepPolicy(dedupeKeep); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := checkHashAlgo(hashAlgo); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		groups, err := findDuplicates(directory, dedupeRecursive, dedupeKeep, dedupeParallel)
+		if err != nil {
+			fmt.Printf("Error processing directory: %v\n", err)
+			activeIssues.addError("dedupe", directory, err)
+			return
+		}
+
+		out, finishOutput := startOutput(dedupeFormat)
+		defer finishOutput()
+		if err := writeDuplicates(out, groups, dedupeFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+			return
+		}
+
+		switch {
+		case dedupeDelete:
+			removeDuplicates(groups, false, dryRun)
+		case dedupeHardlink:
+			removeDuplicates(groups, true, dryRun)
+		}
+	},
+}
+
+func init() {
+	DedupeCmd.Flags().StringVar(&directory, "dir", "", "Directory to scan")
+	DedupeCmd.Flags().BoolVar(&dedupeRecursive, "recursive", false, "Scan subdirectories recursively")
+	DedupeCmd.Flags().StringVar(&dedupeFormat, "format", "text", "Output format: text or json")
+	DedupeCmd.Flags().BoolVar(&dedupeDelete, "delete", false, "Delete duplicates, keeping one file of each set")
+	DedupeCmd.Flags().BoolVar(
+		&dedupeHardlink, "hardlink", false, "Replace duplicates with hard links to the kept file of each set",
+	)
+	DedupeCmd.Flags().StringVar(
+		&dedupeKeep, "keep", "oldest", "File kept of each set: oldest, newest, largest or first",
+	)
+	DedupeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be deleted or linked without changing any file")
+	DedupeCmd.Flags().IntVar(
+		&dedupeParallel, "parallel", 1,
+		"Number of files hashed concurrently; hashing waits on the disk, so more than the number of cores can help",
+	)
+	addHashAlgoFlag(DedupeCmd, "Algorithm files are compared with")
+	addFilterFlags(DedupeCmd)
+}
+
+// duplicateSet is a group of files with identical content
+type duplicateSet struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	// Keep is the file kept by --delete and --hardlink
+	Keep       string   `json:"keep"`
+	Duplicates []string `json:"duplicates"`
+}
+
+// dedupeMember is a file of a duplicate set with the stat --keep decides on
+type dedupeMember struct {
+	path string
+	info os.FileInfo
+}
+
+// checkKeepPolicy returns an error for unknown --keep values
+func checkKeepPolicy(policy string) error {
+	switch policy {
+	case "oldest", "newest", "largest", "first":
+		return nil
+	default:
+		return fmt.Errorf("unknown keep policy: %s (supported: oldest, newest, largest, first)", policy)
+	}
+}
+
+// findDuplicates returns the sets of files of dir, and of its subdirectories when recursive, with
+// identical content. Only files of equal size are hashed, by up to workers at a time. Each set is sorted so the file kept by
+// policy comes first, sets are in the path order of their kept files.
+func findDuplicates(dir string, recursive bool, policy string, workers int) ([]duplicateSet, error) {
+	bySize := make(map[int64][]dedupeMember)
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			info, err := entry.Info()
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				activeIssues.addWarning("dedupe", path, err)
+				return
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], dedupeMember{path: path, info: info})
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only files sharing their size with another file can be duplicates
+	var candidates []dedupeMember
+	for _, members := range bySize {
+		if len(members) > 1 {
+			candidates = append(candidates, members...)
+		}
+	}
+	hashes := hashFiles(candidates, workers)
+
+	bySum := make(map[string][]dedupeMember)
+	for i, member := range candidates {
+		if hashes[i] != "" {
+			bySum[hashes[i]] = append(bySum[hashes[i]], member)
+		}
+	}
+	// Sets and their members are sorted, so the result does not depend on the order hashes complete
+	var groups []duplicateSet
+	for hash, same := range bySum {
+		if len(same) < 2 {
+			continue
+		}
+		sortByKeepPolicy(same, policy)
+		group := duplicateSet{Hash: hash, Size: same[0].info.Size(), Keep: same[0].path}
+		for _, member := range same[1:] {
+			group.Duplicates = append(group.Duplicates, member.path)
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(
+		groups, func(i, j int) bool {
+			return groups[i].Keep < groups[j].Keep
+		},
+	)
+	return groups, nil
+}
+
+// hashFiles returns the hash of each file with the --hash-algo algorithm, SHA-256 by default,
+// hashed by up to workers concurrent workers. Hashing is mostly waiting for the disk, so more
+// workers than cores still help. Files that cannot be read get an empty hash and a warning.
+func hashFiles(members []dedupeMember, workers int) []string {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each worker writes only the hashes of its own files, mu guards the output lines
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	hashes := make([]string, len(members))
+	queue := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				hash, err := pyrgear.HashFileAlgo(members[i].path, hashAlgo)
+				if err != nil {
+					mu.Lock()
+					fmt.Printf("Warning: failed to hash %s: %v\n", members[i].path, err)
+					activeIssues.addWarning("dedupe", members[i].path, err)
+					mu.Unlock()
+					continue
+				}
+				hashes[i] = hash
+			}
+		}()
+	}
+
+	for i := range members {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return hashes
+}
+
+// sortByKeepPolicy sorts the members of a set so the one kept by policy comes first
+func sortByKeepPolicy(members []dedupeMember, policy string) {
+	sort.Slice(
+		members, func(i, j int) bool {
+			a, b := members[i].info, members[j].info
+			switch {
+			case policy == "oldest" && !a.ModTime().Equal(b.ModTime()):
+				return a.ModTime().Before(b.ModTime())
+			case policy == "newest" && !a.ModTime().Equal(b.ModTime()):
+				return a.ModTime().After(b.ModTime())
+			case policy == "largest" && a.Size() != b.Size():
+				return a.Size() > b.Size()
+			}
+			return members[i].path < members[j].path
+		},
+	)
+}
+
+// removeDuplicates deletes the duplicates of each set, or replaces them with hard links to
+// the kept file when link is set. Duplicates already linked to the kept file are skipped.
+func removeDuplicates(groups []duplicateSet, link bool, dryRun bool) {
+	for _, group := range groups {
+		for _, dup := range group.Duplicates {
+			if link && pyrgear.IsSameFile(group.Keep, dup) {
+				continue
+			}
+			verb, done := "delete", "Deleted"
+			if link {
+				verb, done = "link", "Linked"
+			}
+			if dryRun {
+				fmt.Printf("Would %s: %s (keeping %s)\n", verb, dup, group.Keep)
+				continue
+			}
+
+			var err error
+			if link {
+				err = pyrgear.LinkFile(group.Keep, dup)
+			} else {
+				err = os.Remove(dup)
+			}
+			if err != nil {
+				fmt.Printf("Error: failed to %s %s: %v\n", verb, dup, err)
+				activeIssues.addError("dedupe", dup, err)
+				continue
+			}
+			fmt.Printf("%s: %s (keeping %s)\n", done, dup, group.Keep)
+		}
+	}
+}
+
+// writeDuplicates writes the duplicate sets to w in the given format
+func writeDuplicates(w io.Writer, groups []duplicateSet, format string) error {
+	switch format {
+	case "text":
+		if len(groups) == 0 {
+			fmt.Fprintln(w, "No duplicates found")
+			return nil
+		}
+		wasted := int64(0)
+		for _, group := range groups {
+			fmt.Fprintf(w, "%s (%s)\n", group.Keep, formatSize(group.Size))
+			for _, dup := range group.Duplicates {
+				fmt.Fprintf(w, "  = %s\n", dup)
+			}
+			wasted += group.Size * int64(len(group.Duplicates))
+		}
+		fmt.Fprintf(w, "Found %d sets of duplicates, %s reclaimable\n", len(groups), formatSize(wasted))
+		return nil
+	case "json":
+		if groups == nil {
+			groups = []duplicateSet{}
+		}
+		data, err := marshalJSON(groups)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+}
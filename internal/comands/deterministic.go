@@ -0,0 +1,44 @@
+package comands
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// deterministic refuses rename runs whose plan could differ between machines or runs
+var deterministic bool
+
+// mtimeFilterFlags are the selection flags that select files by modification time
+var mtimeFilterFlags = []string{"since-last-run", "modified-after", "modified-before"}
+
+// checkDeterministic returns an error for --deterministic runs of the rename command whose
+// names or output depend on inputs that are not the same on every machine: modification times,
+// which a checkout or copy resets, random tokens and the completion order of concurrent copies.
+// Directory entries are always read in name order, so other rules only need the order they
+// number files in to be given. The progress bar and colors are turned off, so the output of
+// a dry run can be compared byte for byte.
+func checkDeterministic(flags *pflag.FlagSet, rule string) error {
+	if !deterministic {
+		return nil
+	}
+	showProgress, noColor = false, true
+
+	for _, name := range mtimeFilterFlags {
+		if flags.Changed(name) {
+			return fmt.Errorf("--deterministic does not allow --%s, which selects files by modification time", name)
+		}
+	}
+	if sortBy == "mtime" {
+		return fmt.Errorf("--deterministic does not allow --sort-by mtime, use name or size")
+	}
+
+	r := lookupRule(rule)
+	if r == nil || r.unstable == nil {
+		return nil
+	}
+	if reason := r.unstable(flags); reason != "" {
+		return fmt.Errorf("--deterministic does not allow %s rule: %s", r.name, reason)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package comands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorFormat string
+
+// doctorTools are the programs the doctor command looks for on PATH. The interpreters are
+// run with --version, the other tools are only located.
+var doctorTools = []struct {
+	name        string
+	interpreter bool
+}{
+	{"python", true},
+	{"python3", true},
+	{"Rscript", true},
+	{"R", false},
+	{"pip3", false},
+	{"tesseract", false},
+}
+
+// doctorTimeout is how long the --version of a single interpreter may run
+const doctorTimeout = 10 * time.Second
+
+// toolCheck is what the doctor command found out about a program
+type toolCheck struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// tempDirCheck is whether a temporary directory can be written to
+type tempDirCheck struct {
+	Path     string `json:"path"`
+	Writable bool   `json:"writable"`
+	Error    string `json:"error,omitempty"`
+}
+
+// doctorReport is the result of the doctor command
+type doctorReport struct {
+	Tools    []toolCheck    `json:"tools"`
+	TempDirs []tempDirCheck `json:"temp_dirs"`
+}
+
+// DoctorCmd represents the doctor command
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the Python and R interpreters and tools of the environment",
+	Long: `Check the environment pyrgear works in: where python, python3 and Rscript are found on
+PATH and which versions they are, whether R, pip3 and tesseract (used by the ocr-date rule)
+are installed, and whether the usual temporary directories can be written to.
+Missing programs are warnings, unwritable temporary directories errors; both make the
+command exit non-zero with --errors-out.
+
+Examples:
+  pyrgear doctor
+  pyrgear doctor --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		report := runDoctor()
+		out, finishOutput := startOutput(doctorFormat)
+		defer finishOutput()
+		if err := report.write(out, doctorFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	DoctorCmd.Flags().StringVar(&doctorFormat, "format", "text", "Output format: text or json")
+}
+
+// runDoctor checks the tools and temporary directories, reporting problems to activeIssues
+func runDoctor() doctorReport {
+	report := doctorReport{Tools: []toolCheck{}, TempDirs: []tempDirCheck{}}
+	for _, tool := range doctorTools {
+		check := checkTool(tool.name, tool.interpreter)
+		if check.Error != "" {
+			activeIssues.addWarning("doctor", tool.name, errors.New(check.Error))
+		}
+		report.Tools = append(report.Tools, check)
+	}
+	for _, dir := range tempDirs() {
+		check := checkTempDir(dir)
+		if !check.Writable {
+			activeIssues.addError("doctor", dir, errors.New(check.Error))
+		}
+		report.TempDirs = append(report.TempDirs, check)
+	}
+	return report
+}
+
+// checkTool looks up name on PATH and, for interpreters, asks it for its version
+func checkTool(name string, interpreter bool) toolCheck {
+	check := toolCheck{Name: name}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		check.Error = "not found on PATH"
+		return check
+	}
+	check.Path = path
+	if !interpreter {
+		return check
+	}
+	if check.Version, err = toolVersion(path); err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// toolVersion returns the first line path --version prints. Python 2 and Rscript print it to
+// stderr, so both outputs are read.
+func toolVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("--version did not finish within %s", doctorTimeout)
+		}
+		return "", fmt.Errorf("--version failed: %v", err)
+	}
+	for _, line := range strings.Split(output.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("--version printed nothing")
+}
+
+// tempDirs returns the common temporary directories of the platform, os.TempDir first,
+// without duplicates
+func tempDirs() []string {
+	dirs := []string{os.TempDir()}
+	if runtime.GOOS == "windows" {
+		dirs = append(dirs, os.Getenv("TEMP"), os.Getenv("TMP"))
+	} else {
+		dirs = append(dirs, os.Getenv("TMPDIR"), "/tmp", "/var/tmp")
+	}
+
+	var unique []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir = filepath.Clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			unique = append(unique, dir)
+		}
+	}
+	return unique
+}
+
+// checkTempDir checks that a file can be created in dir by creating and removing one
+func checkTempDir(dir string) tempDirCheck {
+	check := tempDirCheck{Path: dir}
+	file, err := os.CreateTemp(dir, ".pyrgear-doctor-*")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+	check.Writable = true
+	return check
+}
+
+// write writes the report to w in the given format, a line per tool and directory for text:
+// python3: /usr/bin/python3 (Python 3.12.3)
+func (r doctorReport) write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		fmt.Fprintln(w, "Tools:")
+		for _, tool := range r.Tools {
+			switch {
+			case tool.Path == "":
+				fmt.Fprintf(w, "  %s: %s\n", tool.Name, tool.Error)
+			case tool.Error != "":
+				fmt.Fprintf(w, "  %s: %s (%s)\n", tool.Name, tool.Path, tool.Error)
+			case tool.Version != "":
+				fmt.Fprintf(w, "  %s: %s (%s)\n", tool.Name, tool.Path, tool.Version)
+			default:
+				fmt.Fprintf(w, "  %s: %s\n", tool.Name, tool.Path)
+			}
+		}
+		fmt.Fprintln(w, "Temporary directories:")
+		for _, dir := range r.TempDirs {
+			if dir.Writable {
+				fmt.Fprintf(w, "  %s: writable\n", dir.Path)
+			} else {
+				fmt.Fprintf(w, "  %s: not writable (%s)\n", dir.Path, dir.Error)
+			}
+		}
+		return nil
+	case "json":
+		data, err := marshalJSON(r)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+}
@@ -0,0 +1,82 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// onDuplicate is the --on-duplicate policy for rename and copy destinations that already exist
+var onDuplicate string
+
+// vacatedPaths are the paths earlier renames of a dry-run, or of a plan being collected, move
+// away from. The files are still there, but no longer would be when later renames run, so a
+// chain such as shot_2 -> shot_3 after shot_3 -> shot_4 is not a collision. See vacate.
+var vacatedPaths map[string]bool
+
+// Policies of --on-duplicate
+const (
+	// duplicateError refuses to write over the existing file and reports an error
+	duplicateError = "error"
+	// duplicateSkip leaves the source where it is and the existing file unchanged
+	duplicateSkip = "skip"
+	// duplicateOverwrite replaces the existing file
+	duplicateOverwrite = "overwrite"
+	// duplicateRename writes to the first free name-1.ext, name-2.ext, ... instead
+	duplicateRename = "rename"
+)
+
+// checkDuplicatePolicy returns an error for unknown --on-duplicate policies
+func checkDuplicatePolicy(policy string) error {
+	switch policy {
+	case duplicateError, duplicateSkip, duplicateOverwrite, duplicateRename:
+		return nil
+	default:
+		return fmt.Errorf("unknown --on-duplicate policy: %s (supported: error, skip, overwrite, rename)", policy)
+	}
+}
+
+// resolveDestination applies policy to path, the destination of a rename or copy of src.
+// It returns the path to write to, or "" if the file is to be skipped. src is empty for
+// copies; for renames, path being src itself (a case-only rename) is not a duplicate.
+// Paths in taken are claimed by other writes of the same run and count as existing.
+// Every rename and copy that could replace an existing file must go through it.
+func resolveDestination(src, path, policy string, taken map[string]bool) (string, error) {
+	exists := func(p string) bool {
+		return taken[p] || (!vacatedPaths[p] && pyrgear.CheckCollision(src, p) != nil)
+	}
+	if !exists(path) {
+		return path, nil
+	}
+
+	switch policy {
+	case duplicateSkip:
+		return "", nil
+	case duplicateOverwrite:
+		if info, err := os.Lstat(path); err == nil && info.IsDir() {
+			return "", fmt.Errorf("%w: %s is a directory", ErrCollision, path)
+		}
+		return path, nil
+	case duplicateRename:
+		dir, name := filepath.Split(path)
+		for n := 1; ; n++ {
+			candidate := filepath.Join(dir, pyrgear.SuffixedName(name, n))
+			if !exists(candidate) {
+				return candidate, nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", ErrCollision, path)
+	}
+}
+
+// vacate records that a rename of a dry-run or plan moves oldPath to newPath
+func vacate(oldPath, newPath string) {
+	if vacatedPaths == nil {
+		vacatedPaths = make(map[string]bool)
+	}
+	vacatedPaths[oldPath] = true
+	delete(vacatedPaths, newPath)
+}
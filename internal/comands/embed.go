@@ -0,0 +1,245 @@
+package comands
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+// embedSidecar is the CSV file of the values the embed command writes
+var embedSidecar string
+
+// sidecarColumns maps the accepted header names of the sidecar columns to their column
+var sidecarColumns = map[string]string{
+	"file": "file", "filename": "file", "path": "file",
+	"date": "date", "datetimeoriginal": "date",
+	"make":  "make",
+	"model": "model",
+	"lat":   "lat", "latitude": "lat",
+	"lon": "lon", "lng": "lon", "longitude": "lon",
+}
+
+// sidecarDateLayouts are the accepted formats of the date column, in the local time zone
+var sidecarDateLayouts = []string{
+	"2006-01-02 15:04:05", "2006:01:02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02",
+}
+
+// sidecarEntry is a row of the sidecar
+type sidecarEntry struct {
+	line   int
+	path   string
+	update pyrgear.EXIFUpdate
+}
+
+// EmbedCmd represents the embed command
+var EmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Write EXIF dates and camera information from a CSV sidecar into JPEG images",
+	Long: `Write the EXIF DateTimeOriginal, and optionally Make, Model and GPS position, listed in a
+CSV sidecar into JPEG images, in place. Images without EXIF data, such as scans, get a new
+EXIF segment; all other tags are kept and the image data is not re-encoded. Every image is
+read back after writing and only replaced if it holds the new values.
+
+The sidecar starts with a header naming its columns: file (required), date, make, model,
+lat and lon. Empty cells leave the tag unchanged. Dates are written as they are, e.g.
+1987-07-14 16:30:00 or 1987-07-14. Relative paths are relative to --dir, or to the
+directory of the sidecar without it.
+
+Examples:
+  # scans.csv:
+  #   file,date,make
+  #   scan_001.jpg,1987-07-14 16:30,Kodak
+  #   scan_002.jpg,1987-07-15,
+  pyrgear embed --sidecar scans.csv --dry-run
+  pyrgear embed --sidecar scans.csv --dir ./scans`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if embedSidecar == "" {
+			fmt.Println("Error: --sidecar is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		baseDir := directory
+		if baseDir == "" {
+			baseDir = filepath.Dir(embedSidecar)
+		}
+		entries, err := readSidecar(embedSidecar, baseDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			activeIssues.addError("embed", embedSidecar, err)
+			return
+		}
+
+		embedded := 0
+		for _, entry := range entries {
+			if activeErrorBudget.exceeded() {
+				break
+			}
+			stepProgress()
+			if err := embedEXIF(entry, dryRun); err != nil {
+				err = fmt.Errorf("%s line %d: %w", embedSidecar, entry.line, err)
+				fmt.Printf("Error embedding EXIF into %s: %v\n", entry.path, err)
+				activeIssues.addError("embed", entry.path, err)
+				continue
+			}
+			embedded++
+		}
+		if !quiet && !dryRun {
+			fmt.Printf("Embedded EXIF into %d of %d images\n", embedded, len(entries))
+		}
+	},
+}
+
+func init() {
+	EmbedCmd.Flags().StringVar(
+		&embedSidecar, "sidecar", "", "CSV file with a file column and date, make, model, lat or lon columns",
+	)
+	EmbedCmd.Flags().StringVar(
+		&directory, "dir", "", "Directory relative paths of the sidecar are relative to (default: the sidecar's directory)",
+	)
+	EmbedCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be written without changing any file")
+}
+
+// readSidecar reads the rows of the sidecar CSV at path, resolving relative paths against
+// baseDir. Rows with invalid values fail the whole sidecar, so nothing is half applied.
+func readSidecar(path string, baseDir string) ([]sidecarEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sidecar %s: %v", path, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sidecar header of %s: %v", path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		column, ok := sidecarColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown column %q (supported: file, date, make, model, lat, lon)", path, name)
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["file"]; !ok {
+		return nil, fmt.Errorf("%s: missing file column", path)
+	}
+
+	var entries []sidecarEntry
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sidecar %s: %v", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		cell := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		entry, err := parseSidecarRow(cell)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		entry.line = line
+		if !filepath.IsAbs(entry.path) {
+			entry.path = filepath.Join(baseDir, entry.path)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseSidecarRow parses the values of a sidecar row, read by cell
+func parseSidecarRow(cell func(column string) string) (sidecarEntry, error) {
+	entry := sidecarEntry{path: cell("file")}
+	if entry.path == "" {
+		return entry, fmt.Errorf("empty file name")
+	}
+
+	if date := cell("date"); date != "" {
+		for _, layout := range sidecarDateLayouts {
+			if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+				entry.update.DateTimeOriginal = t
+				break
+			}
+		}
+		if entry.update.DateTimeOriginal.IsZero() {
+			return entry, fmt.Errorf("invalid date %q, use e.g. 1987-07-14 16:30:00", date)
+		}
+	}
+	entry.update.Make, entry.update.Model = cell("make"), cell("model")
+
+	lat, lon := cell("lat"), cell("lon")
+	if lat != "" || lon != "" {
+		var err error
+		if entry.update.Lat, err = strconv.ParseFloat(lat, 64); err != nil || entry.update.Lat < -90 ||
+			entry.update.Lat > 90 {
+			return entry, fmt.Errorf("invalid latitude %q", lat)
+		}
+		if entry.update.Lon, err = strconv.ParseFloat(lon, 64); err != nil || entry.update.Lon < -180 ||
+			entry.update.Lon > 180 {
+			return entry, fmt.Errorf("invalid longitude %q", lon)
+		}
+		entry.update.GPS = true
+	}
+
+	if entry.update == (pyrgear.EXIFUpdate{}) {
+		return entry, fmt.Errorf("no values for %s", entry.path)
+	}
+	return entry, nil
+}
+
+// embedEXIF writes the values of a sidecar row into its image, or only reports them in dry-run mode
+func embedEXIF(entry sidecarEntry, dryRun bool) error {
+	if ext := strings.ToLower(filepath.Ext(entry.path)); ext != ".jpg" && ext != ".jpeg" {
+		return fmt.Errorf("%w: %s (supported: jpg, jpeg)", ErrUnsupportedFormat, entry.path)
+	}
+	if _, err := os.Stat(entry.path); err != nil {
+		return err
+	}
+
+	var values []string
+	if !entry.update.DateTimeOriginal.IsZero() {
+		values = append(values, "DateTimeOriginal="+entry.update.DateTimeOriginal.Format("2006:01:02 15:04:05"))
+	}
+	if entry.update.Make != "" {
+		values = append(values, "Make="+entry.update.Make)
+	}
+	if entry.update.Model != "" {
+		values = append(values, "Model="+entry.update.Model)
+	}
+	if entry.update.GPS {
+		values = append(values, fmt.Sprintf("GPS=%f,%f", entry.update.Lat, entry.update.Lon))
+	}
+
+	if dryRun {
+		fmt.Printf("Would embed: %s (%s)\n", entry.path, strings.Join(values, ", "))
+		return nil
+	}
+	fmt.Printf("Embedding: %s (%s)\n", entry.path, strings.Join(values, ", "))
+	return pyrgear.UpdateEXIFFile(entry.path, entry.update)
+}
@@ -0,0 +1,134 @@
+package comands
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	// maxErrors aborts a run once more files failed, 0 for no limit
+	maxErrors int
+	// maxErrorRate aborts a run once a larger fraction of its files failed, 0 for no limit
+	maxErrorRate float64
+	// failFast aborts a run at its first error
+	failFast bool
+	// keepGoing processes all files whatever fails, the default, and lists the errors at the end
+	keepGoing bool
+)
+
+// errorRateMinFiles is the number of files processed before --max-error-rate is checked,
+// so a single early failure does not abort the run
+const errorRateMinFiles = 20
+
+// activeErrorBudget counts the errors of the current run when --max-errors or --max-error-rate is set
+var activeErrorBudget *errorBudget
+
+// errorBudget counts the processed files and the errors of a run, it is safe for concurrent use
+type errorBudget struct {
+	mu     sync.Mutex
+	files  int
+	errors int
+	// first is the first error of the run, see countFailure
+	first error
+	// aborted is set once the errors went over the limit, processors then stop
+	aborted bool
+}
+
+// startErrorBudget starts counting errors if --max-errors, --max-error-rate or --fail-fast is
+// set. The returned function stops counting and marks the run as failed if it was aborted.
+func startErrorBudget() func() {
+	if maxErrors <= 0 && maxErrorRate <= 0 && !failFast {
+		return func() {}
+	}
+
+	activeErrorBudget = &errorBudget{}
+	return func() {
+		if activeErrorBudget.exceeded() {
+			runFailed = true
+		}
+		activeErrorBudget = nil
+	}
+}
+
+// countFile counts a processed file, it is a no-op on a nil budget
+func (b *errorBudget) countFile() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files++
+}
+
+// countFailure is countError for the error err of path, keeping the first error of the run
+// for the --fail-fast message. It is a no-op on a nil budget.
+func (b *errorBudget) countFailure(path string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.first == nil {
+		b.first = err
+		if path != "" {
+			b.first = fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	b.mu.Unlock()
+	b.countError()
+}
+
+// countError counts an error and aborts the run once the errors go over the limit, with
+// --fail-fast at the first error. It is a no-op on a nil budget.
+func (b *errorBudget) countError() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errors++
+	if b.aborted {
+		return
+	}
+
+	switch {
+	case failFast:
+		if b.first != nil {
+			fmt.Printf("Error: stopping at the first error (--fail-fast): %v\n", b.first)
+		} else {
+			fmt.Println("Error: stopping at the first error (--fail-fast)")
+		}
+	case maxErrors > 0 && b.errors > maxErrors:
+		fmt.Printf("Error: %v: %d errors (--max-errors %d), aborting\n", ErrTooManyErrors, b.errors, maxErrors)
+	case maxErrorRate > 0 && b.files >= errorRateMinFiles && float64(b.errors) > maxErrorRate*float64(b.files):
+		fmt.Printf(
+			"Error: %v: %d errors in %d files (--max-error-rate %g), aborting\n",
+			ErrTooManyErrors, b.errors, b.files, maxErrorRate,
+		)
+	default:
+		return
+	}
+	b.aborted = true
+}
+
+// exceeded reports whether the run was aborted for too many errors. Processors check it
+// before each file and stop. It is false on a nil budget.
+func (b *errorBudget) exceeded() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.aborted
+}
+
+// checkErrorLimits returns an error for --max-error-rate values outside 0 to 1 and for
+// --fail-fast together with --keep-going
+func checkErrorLimits() error {
+	if failFast && keepGoing {
+		return fmt.Errorf("--fail-fast and --keep-going cannot be combined")
+	}
+	if maxErrorRate < 0 || maxErrorRate > 1 {
+		return fmt.Errorf("--max-error-rate must be between 0 and 1, got %g", maxErrorRate)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package comands
+
+import (
+	"errors"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// Errors returned by the exif and rename processors. They are wrapped with
+// details, use errors.Is to check for them.
+var (
+	// ErrUnsupportedFormat is returned for files whose format the exif command cannot read
+	ErrUnsupportedFormat = pyrgear.ErrUnsupportedFormat
+	// ErrNoEXIF is returned for images without readable EXIF data
+	ErrNoEXIF = pyrgear.ErrNoEXIF
+	// ErrCollision is returned when a rename target already exists
+	ErrCollision = pyrgear.ErrCollision
+	// ErrCopyMismatch is returned when --verify-copy finds a copy that differs from its source
+	ErrCopyMismatch = pyrgear.ErrCopyMismatch
+	// ErrNameTooLong is returned for new names over the filesystem limit when --truncate is not set
+	ErrNameTooLong = pyrgear.ErrNameTooLong
+	// ErrUnknownRule is returned for rule names that are not in the rule registry
+	ErrUnknownRule = errors.New("unknown rule type")
+	// ErrTooManyFiles is returned when more files match than --max-files allows
+	ErrTooManyFiles = errors.New("too many files")
+	// ErrTooManyErrors is returned for files skipped after a run went over --max-errors or --max-error-rate
+	ErrTooManyErrors = errors.New("too many errors")
+)
@@ -0,0 +1,54 @@
+package comands
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// renameEventSeq renames the files of dir, and of its subdirectories when recursive, to
+// <parent folder>_<date>_<seq>, see pyrgear.EventName. The date is the EXIF date of an image,
+// or the modification time of files without one. All files are numbered as one sequence in
+// the order they were taken, so the numbers of the folders of an event never clash. Files
+// named by a previous run are kept, new files continue after the highest number.
+func renameEventSeq(dir string, recursive bool, dryRun bool) error {
+	var files []datedFile
+	last := 0
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			if n, ok := pyrgear.EventIndex(eventFolder(path), entry.Name()); ok {
+				last = max(last, n)
+				return
+			}
+			files = append(files, datedFile{path: path, time: fileDate(path, entry)})
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Equal times keep the path order of the walk
+	sort.SliceStable(
+		files, func(i, j int) bool {
+			return files[i].time.Before(files[j].time)
+		},
+	)
+
+	for i, file := range files {
+		newName := pyrgear.EventName(eventFolder(file.path), file.time, last+i+1, filepath.Ext(file.path))
+		renameFile(file.path, filepath.Join(filepath.Dir(file.path), newName), dryRun)
+	}
+	return nil
+}
+
+// eventFolder returns the name of the folder of path, the event it belongs to.
+// Relative paths are resolved, so files of --dir . get the name of the working directory.
+func eventFolder(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		dir = filepath.Dir(path)
+	}
+	return filepath.Base(dir)
+}
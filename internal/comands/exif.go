@@ -1,22 +1,31 @@
 package comands
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
 	"github.com/rwcarlsen/goexif/exif"
-	"github.com/rwcarlsen/goexif/tiff"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
 	exifImagePath    string
 	exifOutputFormat string
 	exifRecursive    bool
+	exifListOut      string
+	exifFromFile     string
+	exifStdin        bool
 )
 
+// exifFlushInterval is the number of files after which buffered directory output is flushed
+const exifFlushInterval = 100
+
 // ExifCmd represents the exif command
 var ExifCmd = &cobra.Command{
 	Use:   "exif",
@@ -36,193 +45,430 @@ Examples:
   # Output in JSON format
   pyrgear exif --image /path/to/image.jpg --format json
   
+  # Save the list of scanned files and replay exactly that set later
+  pyrgear exif --dir /path/to/images --recursive --list-out files.txt
+  pyrgear exif --from-file files.txt
+  
+  # Stream paths in and one JSON object per line out, e.g. from an R or Python pipeline
+  find . -name '*.jpg' | pyrgear exif --stdin --format ndjson
+  
+  # Identify records by file name only, e.g. to join them with a table in R or Python
+  pyrgear exif --dir /path/to/images --format json --path-key basename
+  
+  # Also read camera files without an extension, recognized by their content
+  pyrgear exif --dir /path/to/dump --detect-content
+  
+  # Turn JPEGs upright as their EXIF orientation says and strip GPS, in one pass
+  pyrgear exif --dir /path/to/images --fix-jpeg --in-place
+  
+  # Write photo.jpg.json next to every image, replacing sidecars of an earlier run
+  pyrgear exif --dir /path/to/images --recursive --format json --sidecar --sidecar-exists overwrite
+  
+  # Write one Parquet table for pandas.read_parquet or arrow::read_parquet in R
+  pyrgear exif --dir /path/to/images --recursive --format parquet > exif.parquet
+  
+  # Write rationals such as ExposureTime and FNumber as decimals for numeric analysis
+  pyrgear exif --dir /path/to/images --format csv --decimal-rationals
+  
+  # Add the lens and shutter count recorded in Canon and Nikon maker notes
+  pyrgear exif --image /path/to/image.jpg --decode-makernote
+  
+  # Read 8 images at a time, the output keeps the order of the files
+  pyrgear exif --dir /path/to/images --recursive --workers 8
+  
 Supported image formats: JPEG, TIFF`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if exifImagePath == "" && directory == "" {
-			fmt.Println("Error: either --image or --dir is required")
+		if exifImagePath == "" && directory == "" && exifFromFile == "" && !exifStdin {
+			fmt.Println("Error: either --image, --dir, --from-file or --stdin is required")
 			cmd.Help()
 			return
 		}
+		if exifSidecar && (exifStdin || exifFixJPEG) {
+			fmt.Println("Error: --sidecar cannot be combined with --stdin or --fix-jpeg")
+			return
+		}
+		if exifOutputFormat == "parquet" && !exifSidecar && term.IsTerminal(int(os.Stdout.Fd())) {
+			fmt.Println("Error: --format parquet writes a binary file, redirect the output, e.g. > exif.parquet")
+			return
+		}
+
+		// Collect errors and warnings for --errors-out
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		// Ctrl-C stops the scan but still closes the output, which is finished before the note
+		defer catchInterrupt()()
+
+		// Buffer output, large directory scans print tens of thousands of lines
+		out, finishOutput := startOutput(exifOutputFormat)
+		defer finishOutput()
+		// Errors and notes go to stderr for the structured formats, which stdout must hold intact
+		warnings := exifWarnings(out, exifOutputFormat)
+
+		finishLastRun, err := startSinceLastRun("exif", directory, "")
+		if err != nil {
+			fmt.Fprintf(warnings, "Error: %v\n", err)
+			return
+		}
+		ok := false
+		defer func() {
+			finishLastRun(ok)
+		}()
+
+		if exifStdin {
+			if err := streamExifFiles(os.Stdin, out, exifOutputFormat); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading paths from stdin: %v\n", err)
+				activeIssues.addError("exif", "stdin", err)
+			}
+			return
+		}
+
+		// Select the images to process
+		var images []string
+		switch {
+		case exifFromFile != "":
+			list, err := readFileList(exifFromFile)
+			if err != nil {
+				fmt.Fprintf(warnings, "Error reading file list: %v\n", err)
+				activeIssues.addError("exif", exifFromFile, err)
+				return
+			}
+			if list.Version != "" && list.Version != version {
+				fmt.Fprintf(
+					warnings, "Note: %s was written by pyrgear %s (options: %s), this is pyrgear %s\n",
+					exifFromFile, list.Version, list.Options, version,
+				)
+			}
+			images = list.Files
+		case exifImagePath != "":
+			images = []string{exifImagePath}
+		default:
+			var err error
+			images, err = collectExifImages(warnings, directory, exifRecursive)
+			if err != nil {
+				fmt.Fprintf(warnings, "Error processing directory: %v\n", err)
+				activeIssues.addError("exif", directory, err)
+				return
+			}
+		}
+
+		// Apply the selection filters
+		images = filterFiles(images)
+
+		if exifListOut != "" {
+			list := fileList{
+				Version: version,
+				Options: describeFlags(cmd.Flags(), "list-out", "from-file"),
+				Files:   images,
+			}
+			if err := writeFileList(exifListOut, list); err != nil {
+				fmt.Fprintf(warnings, "Error writing file list: %v\n", err)
+			}
+		}
+
+		if exifFixJPEG {
+			ok = fixJPEGs(out, images, exifInPlace)
+			return
+		}
+		if exifSidecar {
+			ok = writeExifSidecars(out, images, exifOutputFormat, exifSidecarExists)
+			return
+		}
 
 		if exifImagePath != "" {
+			if len(images) == 0 {
+				// The image does not match the filters
+				return
+			}
 			// Process single image
-			err := processImageExif(exifImagePath, exifOutputFormat)
+			err := processImageExif(out, exifImagePath, exifOutputFormat)
 			if err != nil {
-				fmt.Printf("Error processing image: %v\n", err)
+				fmt.Fprintf(warnings, "Error processing image: %v\n", err)
+				activeIssues.addError("exif", exifImagePath, err)
 			}
 		} else {
-			// Process directory
-			err := processDirectoryExif(directory, exifOutputFormat, exifRecursive)
+			succeeded, failed, err := writeExifScan(out, images, exifOutputFormat)
 			if err != nil {
-				fmt.Printf("Error processing directory: %v\n", err)
+				fmt.Fprintf(warnings, "Error: %v\n", err)
+				return
+			}
+			if !quiet {
+				writeExifCounts(warnings, succeeded, failed)
 			}
 		}
+		ok = true
 	},
 }
 
 func init() {
 	ExifCmd.Flags().StringVar(&exifImagePath, "image", "", "Path to a single image file")
+	addCSVFlags(ExifCmd)
 	ExifCmd.Flags().StringVar(&directory, "dir", "", "Directory containing image files")
-	ExifCmd.Flags().StringVar(&exifOutputFormat, "format", "text", "Output format: text or json")
+	ExifCmd.Flags().StringVar(
+		&exifOutputFormat, "format", "text",
+		"Output format: text, json (one array for directories), ndjson (one object per line), yaml, csv or parquet (one table)",
+	)
 	ExifCmd.Flags().BoolVar(&exifRecursive, "recursive", false, "Process subdirectories recursively")
+	ExifCmd.Flags().BoolVar(&showProgress, "progress", false, "Show a progress bar on stderr")
+	ExifCmd.Flags().StringVar(
+		&exifListOut, "list-out", "", "Write the list of processed files, with the version and options used, to a file",
+	)
+	ExifCmd.Flags().StringVar(&exifFromFile, "from-file", "", "Process exactly the files listed in a file")
+	ExifCmd.Flags().BoolVar(
+		&exifStdin, "stdin", false, "Read image paths from stdin, one per line, and write each record as soon as it is read",
+	)
+	ExifCmd.Flags().IntVar(&exifWorkers, "workers", 1, "Number of images read concurrently for --dir and --from-file")
+	ExifCmd.Flags().BoolVar(
+		&exifOrdered, "ordered", true,
+		"Write the records of concurrent reads in file order; --ordered=false writes them as they complete",
+	)
+	addFilterFlags(ExifCmd)
+	ExifCmd.Flags().StringVar(
+		&exifPathKeyMode, "path-key", "",
+		"How the SourceFile of each record is written: basename, relative or absolute (optional, defaults to the path as found)",
+	)
+	ExifCmd.Flags().StringVar(
+		&exifRelativeTo, "relative-to", "",
+		"Directory --path-key relative paths are taken from (optional, defaults to --dir or the working directory)",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifFixJPEG, "fix-jpeg", false,
+		"Turn JPEGs upright as their EXIF orientation says and strip their GPS tags in one pass (rotated images are re-encoded)",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifInPlace, "in-place", false, "Rewrite the images fixed by --fix-jpeg, without it only report what would be done",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifSidecar, "sidecar", false,
+		"Write the record of each image to its own file next to it, e.g. photo.jpg.json for --format json",
+	)
+	ExifCmd.Flags().StringVar(
+		&exifSidecarExists, "sidecar-exists", duplicateError,
+		"What to do when a --sidecar file already exists: error, skip or overwrite",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifDetectContent, "detect-content", false,
+		"Also read files without a JPEG or TIFF extension (e.g. camera dumps without suffix) if their content is one",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifDecimalRationals, "decimal-rationals", false,
+		"Write rational tag values as decimals (ExposureTime 0.004, FNumber 2.8) instead of fractions (1/250, 28/10)",
+	)
+	ExifCmd.Flags().BoolVar(
+		&exifDecodeMakerNote, "decode-makernote", false,
+		"Also decode the maker notes of Canon and Nikon cameras, e.g. Nikon.ShutterCount and Canon.LensModel",
+	)
+	ExifCmd.Flags().BoolVar(&exifUseCache, "cache", false, "Cache decoded EXIF data and reuse it for unchanged files")
+	ExifCmd.Flags().StringVar(
+		&exifCacheDir, "cache-dir", "", "Directory for the EXIF cache (optional, defaults to the user cache directory)",
+	)
 }
 
-// processImageExif processes a single image file and extracts EXIF data
-func processImageExif(imagePath string, format string) error {
-	// Check if file exists
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		return fmt.Errorf("image file does not exist: %s", imagePath)
+// exifResult is the outcome of reading the EXIF data of one image
+type exifResult struct {
+	Path   string
+	Record *pyrgear.Record
+	Err    error
+}
+
+// processImageExif processes a single image file and writes its EXIF data to w
+func processImageExif(w io.Writer, imagePath string, format string) error {
+	record, err := loadImageExif(imagePath)
+	if err != nil {
+		return err
 	}
+	return writeExifResults(w, []exifResult{{Path: imagePath, Record: record}}, format, false)
+}
 
-	// Check if it's a supported image format
-	ext := strings.ToLower(filepath.Ext(imagePath))
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".tiff" && ext != ".tif" {
-		return fmt.Errorf("unsupported image format: %s (supported: jpg, jpeg, tiff, tif)", ext)
+// loadImageExif checks that imagePath exists and decodes its EXIF data,
+// reusing cached results when --cache is set
+func loadImageExif(imagePath string) (*pyrgear.Record, error) {
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("image file does not exist: %s", imagePath)
 	}
+	return loadExifRecord(imagePath)
+}
 
-	// Open the image file
-	file, err := os.Open(imagePath)
+// writeExifResults writes the records of results to w in format, as a single document when
+// multi is set (see newExifFormatter), and reports the failed results as warnings
+func writeExifResults(w io.Writer, results []exifResult, format string, multi bool) error {
+	formatter, err := newExifFormatter(format, multi)
 	if err != nil {
-		return fmt.Errorf("failed to open image file: %v", err)
+		return err
 	}
-	defer file.Close()
+	warnings := exifWarnings(w, format)
 
-	// Decode EXIF data
-	exifData, err := exif.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode EXIF data: %v", err)
+	formatter.begin(w)
+	for i, result := range results {
+		writeExifResult(w, warnings, formatter, result)
+		if (i+1)%exifFlushInterval == 0 {
+			flushOutput(w)
+		}
 	}
+	formatter.end(w)
+	return nil
+}
 
-	// Display EXIF information
-	fmt.Printf("\n=== EXIF Information for %s ===\n", imagePath)
+// writeExifResult writes the record of result to w, or reports its failure to warnings.
+// It returns whether the result succeeded.
+func writeExifResult(w io.Writer, warnings io.Writer, formatter exifFormatter, result exifResult) bool {
+	if result.Err != nil {
+		fmt.Fprintf(warnings, "Warning: Failed to process %s: %v\n", result.Path, result.Err)
+		activeIssues.addError("exif", result.Path, result.Err)
+		return false
+	}
+	formatter.write(w, result.Path, result.Record)
+	return true
+}
 
-	if format == "json" {
-		return displayExifAsJSON(exifData)
-	} else {
-		return displayExifAsText(exifData)
+// exifWarnings returns where warnings about output in format go: to w for text, to stderr
+// otherwise so machine-readable output stays valid
+func exifWarnings(w io.Writer, format string) io.Writer {
+	if format != "text" {
+		return os.Stderr
 	}
+	return w
 }
 
-// processDirectoryExif processes all images in a directory
-func processDirectoryExif(dirPath string, format string, recursive bool) error {
+// streamExifFiles reads image paths from r, one per line, and writes the EXIF data of each to w
+// as soon as it is decoded, flushing after every record so a consumer reading the output line
+// by line is never blocked on a buffer. With ndjson every path gets exactly one line: files
+// that fail produce {"SourceFile": path, "Error": message}. Paths are filtered like --from-file.
+func streamExifFiles(r io.Reader, w io.Writer, format string) error {
+	formatter, err := newExifFormatter(format, false)
+	if err != nil {
+		return err
+	}
+	warnings := exifWarnings(w, format)
+
+	scanner := bufio.NewScanner(r)
+	// Allow paths longer than the default 64KB token limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" || len(filterFiles([]string{path})) == 0 {
+			continue
+		}
+		if stopRequested() {
+			break
+		}
+		stepProgress()
+
+		record, err := loadImageExif(path)
+		if err != nil {
+			activeIssues.addError("exif", path, err)
+			if format == "ndjson" {
+				fmt.Fprintf(
+					w, "{\"SourceFile\":%s,\"Error\":%s}\n", jsonString(exifPathKey(path)), jsonString(err.Error()),
+				)
+			} else {
+				fmt.Fprintf(warnings, "Warning: Failed to process %s: %v\n", path, err)
+			}
+		} else {
+			formatter.write(w, path, record)
+		}
+		flushOutput(w)
+	}
+	return scanner.Err()
+}
+
+// collectExifImages returns the supported images in dirPath. Subdirectories are only
+// descended into when recursive is set. The directory may be given with a trailing
+// slash, as a relative or absolute path, or as a symlink to a directory.
+func collectExifImages(w io.Writer, dirPath string, recursive bool) ([]string, error) {
+	root := filepath.Clean(dirPath)
+
 	// Check if directory exists
-	info, err := os.Stat(dirPath)
+	info, err := os.Stat(root)
 	if err != nil {
-		return fmt.Errorf("failed to access directory %s: %v", dirPath, err)
+		return nil, fmt.Errorf("failed to access directory %s: %v", dirPath, err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("%s is not a directory", dirPath)
+		return nil, fmt.Errorf("%s is not a directory", dirPath)
 	}
 
-	return filepath.Walk(
-		dirPath, func(path string, info os.FileInfo, err error) error {
+	var images []string
+	if !recursive {
+		// Only the top-level files
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %s: %v", dirPath, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isExifCandidate(filepath.Join(root, entry.Name())) {
+				images = append(images, filepath.Join(root, entry.Name()))
+			}
+		}
+		return images, nil
+	}
+
+	// filepath.Walk does not follow a symlinked root, so walk its target and
+	// report the paths relative to the directory the user gave
+	walkRoot := root
+	if linkInfo, err := os.Lstat(root); err == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+		if walkRoot, err = filepath.EvalSymlinks(root); err != nil {
+			return nil, fmt.Errorf("failed to resolve directory %s: %v", dirPath, err)
+		}
+	}
+
+	err = filepath.Walk(
+		walkRoot, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				fmt.Printf("Warning: Error accessing %s: %v\n", path, err)
+				fmt.Fprintf(w, "Warning: Error accessing %s: %v\n", path, err)
 				return nil
 			}
-
-			// Skip directories
-			if info.IsDir() {
-				if !recursive && path != dirPath {
-					return filepath.SkipDir
-				}
+			if info.IsDir() || !isExifCandidate(path) {
 				return nil
 			}
 
-			// Check if it's a supported image format
-			ext := strings.ToLower(filepath.Ext(path))
-			if ext == ".jpg" || ext == ".jpeg" || ext == ".tiff" || ext == ".tif" {
-				err := processImageExif(path, format)
-				if err != nil {
-					fmt.Printf("Warning: Failed to process %s: %v\n", path, err)
-				}
+			if rel, err := filepath.Rel(walkRoot, path); err == nil {
+				path = filepath.Join(root, rel)
 			}
-
+			images = append(images, path)
 			return nil
 		},
 	)
+	return images, err
 }
 
-// textWalker implements the Walker interface for text output
-type textWalker struct{}
-
-func (w textWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
-	// Get the tag value as a string
-	val, err := tag.StringVal()
-	if err != nil {
-		val = fmt.Sprintf("(error: %v)", err)
+// isExifCandidate reports whether the exif command reads the file at path: JPEG and TIFF
+// images by extension, and with --detect-content the other files whose content is one
+func isExifCandidate(path string) bool {
+	if pyrgear.IsEXIFImage(path) {
+		return true
 	}
-
-	// Display the tag name and value
-	fmt.Printf("%-30s: %s\n", string(name), val)
-	return nil
+	return exifDetectContent && pyrgear.IsEXIFContent(path)
 }
 
-// displayExifAsText displays EXIF data in human-readable text format
-func displayExifAsText(exifData *exif.Exif) error {
-	// Walk through all EXIF tags
-	walker := textWalker{}
-	err := exifData.Walk(walker)
-	if err != nil {
-		return err
-	}
+// manifestExifFields are the EXIF fields recorded by readExifMeta
+var manifestExifFields = []exif.FieldName{exif.DateTimeOriginal, exif.Make, exif.Model}
 
-	// Try to get some common GPS coordinates if available
-	lat, lon, err := exifData.LatLong()
-	if err == nil {
-		fmt.Printf("%-30s: %f, %f\n", "GPS Coordinates", lat, lon)
+// readExifMeta returns the date and camera EXIF values of an image, or nil if it has none
+func readExifMeta(imagePath string) map[string]string {
+	record, err := pyrgear.DecodeEXIFFile(imagePath)
+	if err != nil {
+		return nil
 	}
 
-	fmt.Println()
-	return nil
-}
-
-// jsonWalker implements the Walker interface for JSON output
-type jsonWalker struct {
-	first bool
-}
-
-func (w *jsonWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
-	if !w.first {
-		fmt.Print(",")
+	meta := make(map[string]string)
+	for _, name := range manifestExifFields {
+		if val, ok := record.Get(string(name)); ok {
+			meta[string(name)] = strings.TrimSpace(val)
+		}
 	}
-	fmt.Print("\n")
-
-	// Get the tag value as a string
-	val, err := tag.StringVal()
-	if err != nil {
-		val = fmt.Sprintf("error: %v", err)
+	if len(meta) == 0 {
+		return nil
 	}
-
-	// Escape quotes in the value
-	val = strings.ReplaceAll(val, "\"", "\\\"")
-
-	fmt.Printf("  \"%s\": \"%s\"", string(name), val)
-	w.first = false
-	return nil
+	return meta
 }
 
-// displayExifAsJSON displays EXIF data in JSON format
-func displayExifAsJSON(exifData *exif.Exif) error {
-	fmt.Println("{")
-
-	walker := &jsonWalker{first: true}
-	err := exifData.Walk(walker)
-	if err != nil {
-		return err
+// flushOutput flushes w if it is buffered
+func flushOutput(w io.Writer) {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		f.Flush()
 	}
-
-	// Try to get GPS coordinates if available
-	lat, lon, err := exifData.LatLong()
-	if err == nil {
-		if !walker.first {
-			fmt.Print(",")
-		}
-		fmt.Printf("\n  \"GPS_Latitude\": %f,", lat)
-		fmt.Printf("\n  \"GPS_Longitude\": %f", lon)
-	}
-
-	fmt.Println("\n}")
-	fmt.Println()
-	return nil
 }
@@ -1,12 +1,37 @@
 package comands
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"image"
+	"image/jpeg"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
+// scanExifDir writes the EXIF data of the images in dirPath to w as exif --dir does
+func scanExifDir(w io.Writer, dirPath string, format string, recursive bool) error {
+	images, err := collectExifImages(exifWarnings(w, format), dirPath, recursive)
+	if err != nil {
+		return err
+	}
+	_, _, err = writeExifScan(w, filterFiles(images), format)
+	return err
+}
+
 func TestProcessImageExif(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "exif_test")
@@ -20,7 +45,7 @@ func TestProcessImageExif(t *testing.T) {
 
 	// Test with non-existent file
 	nonExistentFile := filepath.Join(tempDir, "nonexistent.jpg")
-	err = processImageExif(nonExistentFile, "text")
+	err = processImageExif(io.Discard, nonExistentFile, "text")
 	if err == nil {
 		t.Error("Expected error for non-existent file, got nil")
 	}
@@ -34,13 +59,13 @@ func TestProcessImageExif(t *testing.T) {
 	err = f.Close()
 	assert.NoError(t, err)
 
-	err = processImageExif(txtFile, "text")
-	if err == nil {
-		t.Error("Expected error for unsupported file format, got nil")
+	err = processImageExif(io.Discard, txtFile, "text")
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected unsupported format error, got: %v", err)
 	}
 }
 
-func TestProcessDirectoryExif(t *testing.T) {
+func TestScanExifDir(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "exif_dir_test")
 	if err != nil {
@@ -53,13 +78,13 @@ func TestProcessDirectoryExif(t *testing.T) {
 
 	// Test with non-existent directory
 	nonExistentDir := filepath.Join(tempDir, "nonexistent")
-	err = processDirectoryExif(nonExistentDir, "text", false)
+	err = scanExifDir(io.Discard, nonExistentDir, "text", false)
 	if err == nil {
 		t.Error("Expected error for non-existent directory, got nil")
 	}
 
 	// Test with valid directory (empty)
-	err = processDirectoryExif(tempDir, "text", false)
+	err = scanExifDir(io.Discard, tempDir, "text", false)
 	if err != nil {
 		t.Errorf("Unexpected error for empty directory: %v", err)
 	}
@@ -74,7 +99,7 @@ func TestProcessDirectoryExif(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Test with directory containing non-image files
-	err = processDirectoryExif(tempDir, "text", false)
+	err = scanExifDir(io.Discard, tempDir, "text", false)
 	if err != nil {
 		t.Errorf("Unexpected error for directory with non-image files: %v", err)
 	}
@@ -102,9 +127,9 @@ func TestSupportedImageFormats(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NoError(t, f.Close())
 
-		err = processImageExif(testFile, "text")
+		err = processImageExif(io.Discard, testFile, "text")
 		// We expect an EXIF decode error, but not a format error
-		if err != nil && !containsString(err.Error(), "failed to decode EXIF data") {
+		if err != nil && !errors.Is(err, ErrNoEXIF) {
 			t.Errorf("Unexpected error type for supported format %s: %v", ext, err)
 		}
 	}
@@ -117,28 +142,1493 @@ func TestSupportedImageFormats(t *testing.T) {
 		err = f.Close()
 		assert.NoError(t, err)
 
-		err = processImageExif(testFile, "text")
-		if err == nil || !containsString(err.Error(), "unsupported image format") {
+		err = processImageExif(io.Discard, testFile, "text")
+		if !errors.Is(err, ErrUnsupportedFormat) {
 			t.Errorf("Expected unsupported format error for %s, got: %v", ext, err)
 		}
 	}
 }
 
-// Helper function to check if a string contains a substring
-func containsString(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsSubstring(
-		s, substr,
-	)))
+func TestScanExifDirWritesToWriter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_writer_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	badImage := filepath.Join(tempDir, "broken.jpg")
+	assert.NoError(t, os.WriteFile(badImage, []byte("fake image data"), 0644))
+
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	err = scanExifDir(out, tempDir, "text", false)
+	assert.NoError(t, err)
+
+	// Nothing reaches the underlying writer until the buffer is flushed
+	assert.Empty(t, buf.String())
+	assert.NoError(t, out.Flush())
+	assert.Contains(t, buf.String(), "Failed to process "+badImage)
+}
+
+func TestCollectExifImagesNonRecursive(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_collect_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// top.jpg, notes.txt, sub/nested.jpg, sub/deeper/deep.tif
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "sub", "deeper"), 0755))
+	for _, name := range []string{"top.jpg", "notes.txt", "sub/nested.jpg", "sub/deeper/deep.tif"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte("fake image data"), 0644))
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	assert.NoError(t, os.Symlink(tempDir, link))
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(tempDir))
+	defer func() {
+		assert.NoError(t, os.Chdir(wd))
+	}()
+
+	forms := map[string]string{
+		"absolute":       tempDir,
+		"trailing slash": tempDir + string(os.PathSeparator),
+		"dot":            ".",
+		"dot slash":      "." + string(os.PathSeparator),
+		"symlink":        link,
+	}
+	for form, dir := range forms {
+		images, err := collectExifImages(io.Discard, dir, false)
+		assert.NoError(t, err, form)
+		if assert.Len(t, images, 1, form) {
+			assert.Equal(t, "top.jpg", filepath.Base(images[0]), form)
+		}
+
+		images, err = collectExifImages(io.Discard, dir, true)
+		assert.NoError(t, err, form)
+		var names []string
+		for _, image := range images {
+			names = append(names, filepath.Base(image))
+		}
+		assert.ElementsMatch(t, []string{"top.jpg", "nested.jpg", "deep.tif"}, names, form)
+	}
+
+	// The directory scan itself must not touch subdirectory files when not recursive
+	var buf bytes.Buffer
+	assert.NoError(t, scanExifDir(&buf, tempDir, "text", false))
+	assert.Contains(t, buf.String(), "top.jpg")
+	assert.NotContains(t, buf.String(), "nested.jpg")
+	assert.NotContains(t, buf.String(), "deep.tif")
+}
+
+func TestFileListRoundTrip(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "file_list_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	listPath := filepath.Join(tempDir, "files.txt")
+	written := fileList{
+		Version: "v1.2.3",
+		Options: "--dir=/photos --format=json",
+		Files:   []string{"/photos/a.jpg", "/photos/sub/b.tif"},
+	}
+	assert.NoError(t, writeFileList(listPath, written))
+
+	data, err := os.ReadFile(listPath)
+	assert.NoError(t, err)
+	assert.Equal(
+		t, "# pyrgear v1.2.3\n# options: --dir=/photos --format=json\n/photos/a.jpg\n/photos/sub/b.tif\n",
+		string(data),
+	)
+
+	read, err := readFileList(listPath)
+	assert.NoError(t, err)
+	assert.Equal(t, written, read)
+
+	// Plain lists without a header are accepted too
+	assert.NoError(t, os.WriteFile(listPath, []byte("a.jpg\n\nb.jpg\n"), 0644))
+	read, err = readFileList(listPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.jpg", "b.jpg"}, read.Files)
+	assert.Empty(t, read.Version)
+}
+
+// writeTestJPEG writes a 1x1 JPEG carrying the given ASCII EXIF tags. Tags of the
+// Exif sub-IFD (0x9000 and above) are stored there, all others in IFD0.
+func writeTestJPEG(t *testing.T, path string, tags map[uint16]string) {
+	t.Helper()
+
+	ifd0 := map[uint16]string{}
+	exifIFD := map[uint16]string{}
+	for id, val := range tags {
+		if id >= 0x9000 {
+			exifIFD[id] = val
+		} else {
+			ifd0[id] = val
+		}
+	}
+
+	order := binary.LittleEndian
+	ifd0Size := 2 + 12*(len(ifd0)+1) + 4
+	exifSize := 2 + 12*len(exifIFD) + 4
+	exifOffset := 8 + ifd0Size
+	dataOffset := exifOffset + exifSize
+
+	var data []byte
+	writeIFD := func(buf *bytes.Buffer, entries map[uint16]string, extra func()) {
+		ids := make([]int, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+
+		count := len(ids)
+		if extra != nil {
+			count++
+		}
+		binary.Write(buf, order, uint16(count))
+		for _, id := range ids {
+			val := append([]byte(entries[uint16(id)]), 0)
+			binary.Write(buf, order, uint16(id))
+			binary.Write(buf, order, uint16(2))
+			binary.Write(buf, order, uint32(len(val)))
+			if len(val) <= 4 {
+				buf.Write(append(val, make([]byte, 4-len(val))...))
+			} else {
+				binary.Write(buf, order, uint32(dataOffset+len(data)))
+				data = append(data, val...)
+			}
+		}
+		if extra != nil {
+			extra()
+		}
+		binary.Write(buf, order, uint32(0))
+	}
+
+	var tiffData bytes.Buffer
+	tiffData.WriteString("II")
+	binary.Write(&tiffData, order, uint16(42))
+	binary.Write(&tiffData, order, uint32(8))
+	writeIFD(
+		&tiffData, ifd0, func() {
+			// ExifIFDPointer
+			binary.Write(&tiffData, order, uint16(0x8769))
+			binary.Write(&tiffData, order, uint16(4))
+			binary.Write(&tiffData, order, uint32(1))
+			binary.Write(&tiffData, order, uint32(exifOffset))
+		},
+	)
+	writeIFD(&tiffData, exifIFD, nil)
+	tiffData.Write(data)
+
+	var img bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 1, 1)), nil))
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(2+6+tiffData.Len()))
+	out.WriteString("Exif\x00\x00")
+	out.Write(tiffData.Bytes())
+	// Skip the SOI marker of the encoded image
+	out.Write(img.Bytes()[2:])
+
+	assert.NoError(t, os.WriteFile(path, out.Bytes(), 0644))
+}
+
+func TestReadExifMeta(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_meta_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	imagePath := filepath.Join(tempDir, "photo.jpg")
+	writeTestJPEG(
+		t, imagePath, map[uint16]string{
+			0x010F: "Canon",
+			0x0110: "EOS R5",
+			0x9003: "2023:06:15 14:30:22",
+		},
+	)
+
+	assert.Equal(
+		t, map[string]string{
+			"Make":             "Canon",
+			"Model":            "EOS R5",
+			"DateTimeOriginal": "2023:06:15 14:30:22",
+		}, readExifMeta(imagePath),
+	)
+
+	plain := filepath.Join(tempDir, "plain.jpg")
+	assert.NoError(t, os.WriteFile(plain, []byte("fake image data"), 0644))
+	assert.Nil(t, readExifMeta(plain))
+}
+
+func TestExifCache(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_cache_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	exifUseCache, exifCacheDir = true, filepath.Join(tempDir, "cache")
+	defer func() {
+		exifUseCache, exifCacheDir = false, ""
+	}()
+
+	imagePath := filepath.Join(tempDir, "photo.jpg")
+	writeTestJPEG(t, imagePath, map[uint16]string{0x010F: "Canon"})
+
+	record, err := loadExifRecord(imagePath)
+	assert.NoError(t, err)
+	assert.Contains(t, record.Tags, pyrgear.Tag{Name: "Make", Value: "Canon"})
+
+	cachePath, err := exifCachePath(imagePath)
+	assert.NoError(t, err)
+	assert.FileExists(t, cachePath)
+
+	// An unchanged file is served from the cache
+	data, err := os.ReadFile(cachePath)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(cachePath, bytes.ReplaceAll(data, []byte("Canon"), []byte("Cached")), 0644))
+	record, err = loadExifRecord(imagePath)
+	assert.NoError(t, err)
+	assert.Contains(t, record.Tags, pyrgear.Tag{Name: "Make", Value: "Cached"})
+
+	// A changed file is decoded again
+	writeTestJPEG(t, imagePath, map[uint16]string{0x010F: "Nikon Corporation"})
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(imagePath, later, later))
+	record, err = loadExifRecord(imagePath)
+	assert.NoError(t, err)
+	assert.Contains(t, record.Tags, pyrgear.Tag{Name: "Make", Value: "Nikon Corporation"})
+}
+
+func TestScanExifDirJSONIsValid(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_json_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// An empty directory is an empty array
+	var buf bytes.Buffer
+	assert.NoError(t, scanExifDir(&buf, tempDir, "json", false))
+	var files []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &files))
+	assert.Empty(t, files)
+
+	writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x010f: `Quote " and \ slash`})
+	writeTestJPEG(t, filepath.Join(tempDir, "b.jpg"), map[uint16]string{0x010f: "Nikon"})
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "broken.jpg"), []byte("fake image data"), 0644))
+
+	buf.Reset()
+	assert.NoError(t, scanExifDir(&buf, tempDir, "json", false))
+	assert.NotContains(t, buf.String(), "===")
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &files), buf.String())
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, filepath.Join(tempDir, "a.jpg"), files[0]["SourceFile"])
+		assert.Equal(t, `Quote " and \ slash`, files[0]["Make"])
+		assert.Equal(t, "Nikon", files[1]["Make"])
+	}
+
+	// A single image is a single object
+	buf.Reset()
+	assert.NoError(t, processImageExif(&buf, filepath.Join(tempDir, "b.jpg"), "json"))
+	var file map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &file), buf.String())
+	assert.Equal(t, "Nikon", file["Make"])
+
+	assert.Error(t, processImageExif(&buf, filepath.Join(tempDir, "b.jpg"), "xml"))
+}
+
+func TestExifDirJSONWithUnreadableSubdir(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_unreadable_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	locked := filepath.Join(tempDir, "locked")
+	defer func() {
+		assert.NoError(t, os.Chmod(locked, 0755))
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.Mkdir(locked, 0755))
+	writeTestJPEG(t, filepath.Join(locked, "b.jpg"), map[uint16]string{0x010f: "Nikon"})
+	assert.NoError(t, os.Chmod(locked, 0))
+	if _, err := os.ReadDir(locked); err == nil {
+		t.Skip("the directory is still readable, e.g. when running as root")
+	}
+
+	stdout, err := os.Create(filepath.Join(tempDir, "stdout"))
+	assert.NoError(t, err)
+	defer stdout.Close()
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() {
+		os.Stdout = realStdout
+		directory = ""
+		exifRecursive = false
+		exifOutputFormat = "text"
+	}()
+
+	// The warning about the subdirectory goes to stderr, stdout is one JSON document
+	directory, exifRecursive, exifOutputFormat = tempDir, true, "json"
+	ExifCmd.Run(ExifCmd, nil)
+	os.Stdout = realStdout
+	data, err := os.ReadFile(stdout.Name())
+	assert.NoError(t, err)
+	var records []map[string]string
+	assert.NoError(t, json.Unmarshal(data, &records), string(data))
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "Canon", records[0]["Make"])
+	}
+}
+
+func TestExifJSONCompactAndIndent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_json_indent_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x010f: "Canon", 0x0110: "EOS R5"})
+	writeTestJPEG(t, filepath.Join(tempDir, "b.jpg"), map[uint16]string{0x010f: "Nikon"})
+
+	var pretty bytes.Buffer
+	assert.NoError(t, scanExifDir(&pretty, tempDir, "json", false))
+
+	defer func() {
+		jsonCompact, jsonIndent = false, 2
+	}()
+
+	// Compact output is what encoding/json makes of the pretty output
+	jsonCompact = true
+	var compact, want bytes.Buffer
+	assert.NoError(t, scanExifDir(&compact, tempDir, "json", false))
+	assert.NoError(t, json.Compact(&want, pretty.Bytes()))
+	assert.Equal(t, want.String()+"\n", compact.String())
+	assert.Equal(t, 1, strings.Count(compact.String(), "\n"))
+
+	jsonCompact, jsonIndent = false, 4
+	var indented bytes.Buffer
+	want.Reset()
+	assert.NoError(t, scanExifDir(&indented, tempDir, "json", false))
+	assert.NoError(t, json.Indent(&want, compact.Bytes(), "", "    "))
+	assert.Equal(t, want.String(), indented.String())
+
+	// The same settings apply to the other JSON output
+	jsonCompact = true
+	data, err := marshalJSON(map[string]int{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(data))
+}
+
+func TestExifYAMLFormat(t *testing.T) {
+	record := &pyrgear.Record{
+		Tags: []pyrgear.Tag{
+			{Name: "Artist", Value: "yes"},
+			{Name: "ImageDescription", Value: "first line\nsecond: line"},
+			{Name: "Model", Value: "# 123"},
+			{Name: "Software", Value: "1.10"},
+		},
+		HasGPS: true, Lat: 35.5, Lon: -139.25,
+	}
+
+	// Special values come back unchanged and as strings
+	var single bytes.Buffer
+	f, err := newExifFormatter("yaml", false)
+	assert.NoError(t, err)
+	f.begin(&single)
+	f.write(&single, "a: b.jpg", record)
+	f.write(&single, "c.jpg", &pyrgear.Record{})
+	f.end(&single)
+	// YAML 1.1 parsers would read an unquoted yes as a boolean
+	assert.Contains(t, single.String(), `Artist: "yes"`)
+
+	dec := yaml.NewDecoder(&single)
+	var doc map[string]any
+	assert.NoError(t, dec.Decode(&doc))
+	assert.Equal(
+		t, map[string]any{
+			"SourceFile": "a: b.jpg", "Artist": "yes", "ImageDescription": "first line\nsecond: line",
+			"Model": "# 123", "Software": "1.10", "GPS_Latitude": 35.5, "GPS_Longitude": -139.25,
+		}, doc,
+	)
+	doc = nil
+	assert.NoError(t, dec.Decode(&doc))
+	assert.Equal(t, map[string]any{"SourceFile": "c.jpg"}, doc)
+	assert.ErrorIs(t, dec.Decode(&doc), io.EOF)
+
+	// Directories are one sequence of records, in file order
+	tempDir, err := os.MkdirTemp("", "exif_yaml_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+	writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x010f: "Canon", 0x010e: "line one\nline two"})
+	writeTestJPEG(t, filepath.Join(tempDir, "b.jpg"), map[uint16]string{0x010f: "Nikon"})
+
+	var out bytes.Buffer
+	assert.NoError(t, scanExifDir(&out, tempDir, "yaml", false))
+	var docs []map[string]string
+	assert.NoError(t, yaml.Unmarshal(out.Bytes(), &docs))
+	if assert.Len(t, docs, 2) {
+		assert.Equal(t, filepath.Join(tempDir, "a.jpg"), docs[0]["SourceFile"])
+		assert.Equal(t, "line one\nline two", docs[0]["ImageDescription"])
+		assert.Equal(t, "Nikon", docs[1]["Make"])
+	}
+
+	// An empty directory is an empty sequence
+	emptyDir := filepath.Join(tempDir, "empty")
+	assert.NoError(t, os.Mkdir(emptyDir, 0755))
+	out.Reset()
+	assert.NoError(t, scanExifDir(&out, emptyDir, "yaml", false))
+	assert.NoError(t, yaml.Unmarshal(out.Bytes(), &docs))
+	assert.Empty(t, docs)
+}
+
+// flushCounter is a writer that counts how often it was flushed, and the lines written by then
+type flushCounter struct {
+	bytes.Buffer
+	flushedLines []int
+}
+
+func (f *flushCounter) Flush() error {
+	f.flushedLines = append(f.flushedLines, strings.Count(f.String(), "\n"))
+	return nil
+}
+
+func TestStreamExifFilesNDJSON(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_stream_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	a := filepath.Join(tempDir, "a.jpg")
+	b := filepath.Join(tempDir, "b.jpg")
+	writeTestJPEG(t, a, map[uint16]string{0x010f: "Canon"})
+	writeTestJPEG(t, b, map[uint16]string{0x010f: "Nikon"})
+	missing := filepath.Join(tempDir, "missing.jpg")
+
+	// ndjson ignores the pretty-print settings
+	jsonCompact, jsonIndent = false, 2
+	input := strings.Join([]string{a, "", missing, b + "\r"}, "\n")
+	var out flushCounter
+	assert.NoError(t, streamExifFiles(strings.NewReader(input), &out, "ndjson"))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 3)
+	var records []map[string]string
+	for _, line := range lines {
+		var record map[string]string
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	assert.Equal(t, a, records[0]["SourceFile"])
+	assert.Equal(t, "Canon", records[0]["Make"])
+	assert.Equal(t, missing, records[1]["SourceFile"])
+	assert.Contains(t, records[1]["Error"], "does not exist")
+	assert.Equal(t, "Nikon", records[2]["Make"])
+
+	// Every record is flushed as soon as it is written
+	assert.Equal(t, []int{1, 2, 3}, out.flushedLines)
+}
+
+func TestJSONValidator(t *testing.T) {
+	check := func(format string, chunks ...string) error {
+		var buf bytes.Buffer
+		v := &jsonValidator{w: bufio.NewWriter(&buf), ndjson: format == "ndjson"}
+		for _, chunk := range chunks {
+			_, err := v.Write([]byte(chunk))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, v.Flush())
+		assert.Equal(t, strings.Join(chunks, ""), buf.String())
+		return v.check()
+	}
+
+	// Lines may arrive in pieces, the last one without a newline
+	assert.NoError(t, check("ndjson", `{"a":`, "1}\n\n", `{"b":2}`))
+	assert.EqualError(t, check("ndjson", "{\"a\":1}\n", "{\"b\":\"un\tescaped\"}\n"), "line 2 is not valid JSON")
+	assert.Error(t, check("ndjson", `{"a":1}`, "{"))
+
+	// JSON output may hold one document per image
+	assert.NoError(t, check("json", "[\n  {\"a\": 1}\n]\n", "{\"b\": 2}\n"))
+	assert.NoError(t, check("json"))
+	assert.Error(t, check("json", "[{\"a\": 1}\n"))
+	assert.Error(t, check("json", "[]\nWarning: Failed to process x.jpg\n"))
+}
+
+func TestExifCSVFormat(t *testing.T) {
+	records := []*pyrgear.Record{
+		{
+			Tags:   []pyrgear.Tag{{Name: "Make", Value: "Canon"}, {Name: "Artist", Value: `Ann "A"; Lee`}},
+			HasGPS: true, Lat: 35.5, Lon: -139.25,
+		},
+		{Tags: []pyrgear.Tag{{Name: "Model", Value: "X100V"}, {Name: "Make", Value: "Fujifilm"}}},
+	}
+	write := func() string {
+		var buf bytes.Buffer
+		f, err := newExifFormatter("csv", true)
+		assert.NoError(t, err)
+		f.begin(&buf)
+		f.write(&buf, "a.jpg", records[0])
+		f.write(&buf, "b,c.jpg", records[1])
+		f.end(&buf)
+		return buf.String()
+	}
+	defer func() {
+		csvDelimiter, csvCRLF, csvQuote = ",", false, csvQuoteMinimal
+	}()
+
+	// Columns are the tags in the order they first occur, missing tags leave the cell empty
+	csvDelimiter, csvCRLF, csvQuote = ",", false, csvQuoteMinimal
+	assert.Equal(
+		t, "SourceFile,Make,Artist,GPS_Latitude,GPS_Longitude,Model\n"+
+			`a.jpg,Canon,"Ann ""A""; Lee",35.500000,-139.250000,`+"\n"+
+			`"b,c.jpg",Fujifilm,,,,X100V`+"\n", write(),
+	)
+
+	// Semicolons for R's read.csv2 and European spreadsheets, fields with a semicolon are quoted
+	csvDelimiter = ";"
+	assert.Equal(
+		t, "SourceFile;Make;Artist;GPS_Latitude;GPS_Longitude;Model\n"+
+			`a.jpg;Canon;"Ann ""A""; Lee";35.500000;-139.250000;`+"\n"+
+			"b,c.jpg;Fujifilm;;;;X100V\n", write(),
+	)
+
+	csvDelimiter, csvCRLF = `\t`, true
+	assert.Equal(
+		t, "SourceFile\tMake\tArtist\tGPS_Latitude\tGPS_Longitude\tModel\r\n"+
+			"a.jpg\tCanon\t\"Ann \"\"A\"\"; Lee\"\t35.500000\t-139.250000\t\r\n"+
+			"b,c.jpg\tFujifilm\t\t\t\tX100V\r\n", write(),
+	)
+
+	csvDelimiter, csvCRLF, csvQuote = "tab", false, csvQuoteAll
+	assert.Equal(
+		t, `"SourceFile"	"Make"	"Artist"	"GPS_Latitude"	"GPS_Longitude"	"Model"`+"\n"+
+			`"a.jpg"	"Canon"	"Ann ""A""; Lee"	"35.500000"	"-139.250000"	""`+"\n"+
+			`"b,c.jpg"	"Fujifilm"	""	""	""	"X100V"`+"\n", write(),
+	)
+
+	// The output reads back with encoding/csv
+	r := csv.NewReader(strings.NewReader(write()))
+	r.Comma = '\t'
+	rows, err := r.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b,c.jpg", "Fujifilm", "", "", "", "X100V"}, rows[2])
+
+	for _, delimiter := range []string{"", ";;", `"`, "\n"} {
+		csvDelimiter = delimiter
+		_, err := newExifFormatter("csv", true)
+		assert.Error(t, err, delimiter)
+	}
+	csvDelimiter, csvQuote = ",", "always"
+	_, err = newExifFormatter("csv", true)
+	assert.Error(t, err)
+	csvQuote = csvQuoteMinimal
+	r2, err := parseCSVDelimiter("§")
+	assert.NoError(t, err)
+	assert.Equal(t, '§', r2)
+}
+
+func TestExifParquetFormat(t *testing.T) {
+	spillDir := t.TempDir()
+	t.Setenv("TMPDIR", spillDir)
+	record := &pyrgear.Record{Tags: []pyrgear.Tag{{Name: "Make", Value: "Canon"}, {Name: "ISOSpeedRatings", Value: "200"}}}
+	var buf bytes.Buffer
+	f, err := newExifFormatter("parquet", true)
+	assert.NoError(t, err)
+	f.begin(&buf)
+	f.write(&buf, "a.jpg", record)
+	f.write(&buf, "b.jpg", &pyrgear.Record{})
+	f.end(&buf)
+
+	// One table with the columns of the CSV output
+	data := buf.Bytes()
+	assert.True(t, bytes.HasPrefix(data, []byte("PAR1")))
+	assert.True(t, bytes.HasSuffix(data, []byte("PAR1")))
+	for _, column := range []string{"SourceFile", "Make", "ISOSpeedRatings", "a.jpg", "b.jpg", "Canon"} {
+		assert.Contains(t, string(data), column)
+	}
+
+	// The rows spilled to disk come back as written, and the spill file is removed
+	var want bytes.Buffer
+	assert.NoError(
+		t, pyrgear.WriteParquet(
+			&want, []string{"SourceFile", "Make", "ISOSpeedRatings"}, []map[string]string{
+				{"SourceFile": "a.jpg", "Make": "Canon", "ISOSpeedRatings": "200"}, {"SourceFile": "b.jpg"},
+			},
+		),
+	)
+	assert.Equal(t, want.Bytes(), data)
+	entries, err := os.ReadDir(spillDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	path, err := sidecarPath("a.jpg", "parquet")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.jpg.parquet", path)
+}
+
+func TestExifDecimalRationals(t *testing.T) {
+	record := &pyrgear.Record{
+		Tags: []pyrgear.Tag{
+			{Name: "ExposureTime", Value: "1/250", Decimal: "0.004"},
+			{Name: "FNumber", Value: "28/10", Decimal: "2.8"},
+			{Name: "Make", Value: "Canon"},
+			{Name: "ShutterSpeedValue", Value: "1/0"},
+		},
+	}
+	write := func() string {
+		var buf bytes.Buffer
+		f, err := newExifFormatter("ndjson", false)
+		assert.NoError(t, err)
+		f.begin(&buf)
+		f.write(&buf, "a.jpg", record)
+		f.end(&buf)
+		return buf.String()
+	}
+	defer func() {
+		exifDecimalRationals = false
+	}()
+
+	// Raw fractions by default
+	assert.Equal(
+		t, `{"SourceFile":"a.jpg","ExposureTime":"1/250","FNumber":"28/10","Make":"Canon","ShutterSpeedValue":"1/0"}`+"\n",
+		write(),
+	)
+
+	// Tags without a decimal value keep theirs, the record itself is left as it is
+	exifDecimalRationals = true
+	assert.Equal(
+		t, `{"SourceFile":"a.jpg","ExposureTime":"0.004","FNumber":"2.8","Make":"Canon","ShutterSpeedValue":"1/0"}`+"\n",
+		write(),
+	)
+	assert.Equal(t, "1/250", record.Tags[0].Value)
+}
+
+func TestExifResultsSummary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_results_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	good := filepath.Join(tempDir, "a.jpg")
+	broken := filepath.Join(tempDir, "b.jpg")
+	writeTestJPEG(t, good, map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.WriteFile(broken, []byte("fake image data"), 0644))
+
+	// Every image gets a result, in order, failures included
+	var results []exifResult
+	scanExif(
+		[]string{good, broken}, 2, true, func(result exifResult) {
+			results = append(results, result)
+		},
+	)
+	assert.Len(t, results, 2)
+	assert.Equal(t, good, results[0].Path)
+	assert.NoError(t, results[0].Err)
+	assert.NotNil(t, results[0].Record)
+	assert.Equal(t, broken, results[1].Path)
+	assert.Error(t, results[1].Err)
+
+	// Only the successful records are formatted
+	var buf bytes.Buffer
+	succeeded, failed, err := writeExifScan(&buf, []string{good, broken}, "json")
+	assert.NoError(t, err)
+	var records []map[string]string
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &records))
+	assert.Len(t, records, 1)
+	assert.Equal(t, "Canon", records[0]["Make"])
+	_, _, err = writeExifScan(&buf, []string{good, broken}, "xml")
+	assert.Error(t, err)
+
+	buf.Reset()
+	writeExifCounts(&buf, succeeded, failed)
+	assert.Equal(t, "EXIF read: 1 succeeded, 1 failed\n", buf.String())
+}
+
+func TestExifScanOrdered(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_scan_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+	defer func() {
+		exifWorkers = 0
+		exifOrdered = false
+	}()
+
+	var images []string
+	for i := 0; i < 40; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("img_%02d.jpg", i))
+		if i%7 == 3 {
+			assert.NoError(t, os.WriteFile(path, []byte("fake image data"), 0644))
+		} else {
+			writeTestJPEG(t, path, map[uint16]string{0x010f: fmt.Sprintf("Make %d", i)})
+		}
+		images = append(images, path)
+	}
+
+	// Ordered results come in input order whatever the number of workers
+	var ordered []string
+	scanExif(
+		images, 8, true, func(result exifResult) {
+			ordered = append(ordered, result.Path)
+		},
+	)
+	assert.Equal(t, images, ordered)
+
+	// Unordered results still cover every image once
+	var unordered []string
+	scanExif(
+		images, 8, false, func(result exifResult) {
+			unordered = append(unordered, result.Path)
+		},
+	)
+	assert.ElementsMatch(t, images, unordered)
+
+	// Concurrent output is identical to sequential output
+	var sequential, concurrent bytes.Buffer
+	exifWorkers, exifOrdered = 1, true
+	succeeded, failed, err := writeExifScan(&sequential, images, "ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, 34, succeeded)
+	assert.Equal(t, 6, failed)
+	exifWorkers = 8
+	_, _, err = writeExifScan(&concurrent, images, "ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, sequential.String(), concurrent.String())
+}
+
+func TestExifDetectContent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_detect_content_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+	defer func() {
+		exifDetectContent = false
+	}()
+
+	// A camera dump: a JPEG without extension, a mislabeled JPEG and a text file
+	dsc := filepath.Join(tempDir, "DSC")
+	writeTestJPEG(t, dsc, map[uint16]string{0x010f: "Nikon"})
+	mislabeled := filepath.Join(tempDir, "IMG_0001.dat")
+	writeTestJPEG(t, mislabeled, map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "README"), []byte("notes"), 0644))
+
+	// The extension gate stays the default
+	images, err := collectExifImages(io.Discard, tempDir, false)
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+	_, err = loadImageExif(dsc)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	exifDetectContent = true
+	images, err = collectExifImages(io.Discard, tempDir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dsc, mislabeled}, images)
+	var results []exifResult
+	scanExif(
+		images, 1, true, func(result exifResult) {
+			results = append(results, result)
+		},
+	)
+	if assert.Len(t, results, 2) {
+		assert.NoError(t, results[0].Err)
+		camera, _ := results[0].Record.Get("Make")
+		assert.Equal(t, "Nikon", camera)
+	}
+	_, err = loadImageExif(filepath.Join(tempDir, "README"))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
 }
 
-func containsSubstring(s, substr string) bool {
-	if len(substr) > len(s) {
-		return false
+func TestExifPathKey(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_path_key_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
 	}
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+	defer func() {
+		exifPathKeyMode, exifRelativeTo, directory = "", "", ""
+	}()
+
+	photo := filepath.Join(tempDir, "day1", "a.jpg")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(photo), 0755))
+	writeTestJPEG(t, photo, map[uint16]string{0x010f: "Canon"})
+
+	sourceFiles := func(mode string) []string {
+		exifPathKeyMode = mode
+		var buf bytes.Buffer
+		assert.NoError(t, scanExifDir(&buf, tempDir, "json", true))
+		var records []map[string]string
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &records))
+		var files []string
+		for _, record := range records {
+			files = append(files, record["SourceFile"])
 		}
+		return files
+	}
+
+	abs, err := filepath.Abs(photo)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{photo}, sourceFiles(""))
+	assert.Equal(t, []string{"a.jpg"}, sourceFiles("basename"))
+	assert.Equal(t, []string{abs}, sourceFiles("absolute"))
+
+	// Relative paths are taken from --dir unless --relative-to says otherwise
+	directory = tempDir
+	assert.Equal(t, []string{filepath.Join("day1", "a.jpg")}, sourceFiles("relative"))
+	exifRelativeTo = filepath.Join(tempDir, "day1")
+	assert.Equal(t, []string{"a.jpg"}, sourceFiles("relative"))
+
+	exifPathKeyMode = "full"
+	assert.Error(t, scanExifDir(io.Discard, tempDir, "json", true))
+}
+
+func TestStripImageKeepsSelectedTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "strip_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	imagePath := filepath.Join(tempDir, "photo.jpg")
+	tags := map[uint16]string{0x010F: "Canon", 0x0110: "EOS R5", 0x9003: "2024:05:06 07:08:09"}
+
+	// --remove with a glob pattern
+	writeTestJPEG(t, imagePath, tags)
+	assert.NoError(t, stripImage(imagePath, stripFilter(nil, []string{"Date*"}), false))
+	meta := readExifMeta(imagePath)
+	assert.Equal(t, map[string]string{"Make": "Canon", "Model": "EOS R5"}, meta)
+
+	// --keep
+	writeTestJPEG(t, imagePath, tags)
+	assert.NoError(t, stripImage(imagePath, stripFilter([]string{"Model"}, nil), false))
+	assert.Equal(t, map[string]string{"Model": "EOS R5"}, readExifMeta(imagePath))
+
+	// Dry-run leaves the file alone, no filter removes everything
+	assert.NoError(t, stripImage(imagePath, nil, true))
+	assert.NotNil(t, readExifMeta(imagePath))
+	assert.NoError(t, stripImage(imagePath, nil, false))
+	assert.Nil(t, readExifMeta(imagePath))
+
+	tiffPath := filepath.Join(tempDir, "scan.tiff")
+	assert.NoError(t, os.WriteFile(tiffPath, []byte("II*\x00"), 0644))
+	assert.ErrorIs(t, stripImage(tiffPath, nil, false), ErrUnsupportedFormat)
+}
+
+func TestFixJPEGs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fix_jpeg_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	located := filepath.Join(tempDir, "located.jpg")
+	writeTestJPEG(t, located, map[uint16]string{0x010F: "Canon"})
+	assert.NoError(t, pyrgear.UpdateEXIFFile(located, pyrgear.EXIFUpdate{GPS: true, Lat: 52.5, Lon: 13.4}))
+	plain := filepath.Join(tempDir, "plain.jpg")
+	writeTestJPEG(t, plain, map[uint16]string{0x010F: "Canon"})
+	scan := filepath.Join(tempDir, "scan.png")
+	writeTestPNG(t, scan, 1, 1)
+	images := []string{located, plain, scan}
+
+	// Without --in-place nothing is written
+	var out bytes.Buffer
+	assert.False(t, fixJPEGs(&out, images, false))
+	assert.Contains(t, out.String(), "Would fix: "+located+" (stripped GPS)")
+	assert.Contains(t, out.String(), "Unchanged: "+plain+" (nothing to fix)")
+	assert.Contains(t, out.String(), "Error fixing "+scan)
+	assert.Contains(t, out.String(), "Would fix 1 of 3 images")
+	record, err := pyrgear.DecodeEXIFFile(located)
+	assert.NoError(t, err)
+	assert.True(t, record.HasGPS)
+
+	out.Reset()
+	assert.True(t, fixJPEGs(&out, images[:2], true))
+	assert.Contains(t, out.String(), "Fixed: "+located+" (stripped GPS)")
+	record, err = pyrgear.DecodeEXIFFile(located)
+	assert.NoError(t, err)
+	assert.False(t, record.HasGPS)
+	camera, _ := record.Get("Make")
+	assert.Equal(t, "Canon", camera)
+}
+
+func TestExifSidecars(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_sidecar_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	photo := filepath.Join(tempDir, "photo.jpg")
+	writeTestJPEG(t, photo, map[uint16]string{0x010f: "Canon"})
+	existing := filepath.Join(tempDir, "old.jpg")
+	writeTestJPEG(t, existing, map[uint16]string{0x010f: "Nikon"})
+	assert.NoError(t, os.WriteFile(existing+".json", []byte("kept"), 0644))
+	images := []string{existing, photo}
+
+	// Existing sidecars are errors by default, the other images still get theirs
+	var out bytes.Buffer
+	assert.False(t, writeExifSidecars(&out, images, "json", duplicateError))
+	assert.Contains(t, out.String(), "Error writing sidecar of "+existing)
+	assert.Contains(t, out.String(), "Wrote: "+photo+".json")
+	data, err := os.ReadFile(photo + ".json")
+	assert.NoError(t, err)
+	var record map[string]string
+	assert.NoError(t, json.Unmarshal(data, &record))
+	assert.Equal(t, "Canon", record["Make"])
+	assert.Equal(t, photo, record["SourceFile"])
+
+	out.Reset()
+	assert.True(t, writeExifSidecars(&out, images, "json", duplicateSkip))
+	assert.Contains(t, out.String(), "Sidecars: 0 written, 2 skipped, 0 failed")
+	data, _ = os.ReadFile(existing + ".json")
+	assert.Equal(t, "kept", string(data))
+
+	out.Reset()
+	assert.True(t, writeExifSidecars(&out, images, "json", duplicateOverwrite))
+	data, _ = os.ReadFile(existing + ".json")
+	assert.Contains(t, string(data), "Nikon")
+
+	// The extension follows the format
+	assert.True(t, writeExifSidecars(&out, images[1:], "yaml", duplicateError))
+	assert.FileExists(t, photo+".yaml")
+	assert.False(t, writeExifSidecars(&out, images, "json", duplicateRename))
+}
+
+func TestRunScriptWithData(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "run_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		directory, runInterpreter, runLang, runDataFormat, runDataVia = "", "", "", "csv", "stdin"
+	}()
+
+	photos := filepath.Join(tempDir, "photos")
+	assert.NoError(t, os.MkdirAll(photos, 0755))
+	writeTestJPEG(t, filepath.Join(photos, "photo.jpg"), map[uint16]string{0x010f: "Canon"})
+
+	// The script copies the data it is handed, from stdin or the file named by its first argument
+	script := filepath.Join(tempDir, "copy.sh")
+	assert.NoError(t, os.WriteFile(script, []byte(`if [ "$1" = out ]; then cat; else cat "$1"; fi > "$2"`), 0644))
+	out := filepath.Join(tempDir, "data")
+	directory, runInterpreter, runDataFormat, runDataVia = photos, "sh", "csv", "stdin"
+
+	assert.NoError(t, runScriptWithData(script, []string{"out", out}))
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "SourceFile")
+	assert.Contains(t, string(data), "Canon")
+
+	runDataFormat, runDataVia = "json", "file"
+	assert.NoError(t, runScriptWithData(script, []string{out}))
+	data, err = os.ReadFile(out)
+	assert.NoError(t, err)
+	var records []map[string]string
+	assert.NoError(t, json.Unmarshal(data, &records))
+	assert.Equal(t, "Canon", records[0]["Make"])
+
+	// A failing script is an error
+	failing := filepath.Join(tempDir, "fail.sh")
+	assert.NoError(t, os.WriteFile(failing, []byte("exit 3"), 0644))
+	assert.ErrorContains(t, runScriptWithData(failing, nil), "exited with status 3")
+
+	// The interpreter follows the extension or --lang
+	runInterpreter = ""
+	_, err = scriptInterpreter("notes.txt")
+	assert.ErrorContains(t, err, "--lang")
+	runLang = "perl"
+	_, err = scriptInterpreter("notes.txt")
+	assert.ErrorContains(t, err, "unknown --lang")
+}
+
+func TestProgressJSON(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "progress_json_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		progressJSON, progressJSONInterval = "", time.Second
+	}()
+
+	progressJSON, progressJSONInterval = filepath.Join(tempDir, "progress.ndjson"), 10*time.Millisecond
+	startProgress(3, "Reading EXIF")
+	stepProgress()
+	time.Sleep(50 * time.Millisecond)
+	stepProgress()
+	stepProgress()
+	finishProgress()
+	assert.Nil(t, activeProgressJSON)
+
+	// Lines while the files are processed, and a last one once done
+	data, err := os.ReadFile(progressJSON)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Greater(t, len(lines), 1)
+	var first, last map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal(t, "Reading EXIF", first["stage"])
+	assert.Equal(t, float64(1), first["processed"])
+	assert.Nil(t, first["done"])
+	assert.Equal(t, "Reading EXIF", last["stage"])
+	assert.Equal(t, float64(3), last["processed"])
+	assert.Equal(t, float64(3), last["total"])
+	assert.Equal(t, true, last["done"])
+	assert.Greater(t, last["rate"], float64(0))
+
+	// An unknown total is left out
+	startProgress(-1, "Processing")
+	finishProgress()
+	data, _ = os.ReadFile(progressJSON)
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.NotContains(t, lines[len(lines)-1], "total")
+
+	_, err = startProgressStream("fd:x", 1, "", time.Second)
+	assert.Error(t, err)
+	_, err = startProgressStream("stderr", 1, "", 0)
+	assert.Error(t, err)
+}
+
+func TestProgressBar(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "progress_bar_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	stdout, err := os.Create(filepath.Join(tempDir, "stdout"))
+	assert.NoError(t, err)
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(tempDir, "stderr"))
+	assert.NoError(t, err)
+	defer stderr.Close()
+	realStdout, realStderr, realIsTerminal := os.Stdout, os.Stderr, stdoutIsTerminal
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() {
+		os.Stdout, os.Stderr, stdoutIsTerminal = realStdout, realStderr, realIsTerminal
+		showProgress, quiet = false, false
+	}()
+
+	// No bar when the output is redirected, whatever --progress says
+	showProgress = true
+	startProgress(2, "Renaming")
+	assert.Nil(t, progressBar)
+	finishProgress()
+
+	// Nor with --quiet
+	stdoutIsTerminal = func() bool { return true }
+	quiet = true
+	startProgress(2, "Renaming")
+	assert.Nil(t, progressBar)
+	finishProgress()
+
+	// The bar counts towards the total on stderr, stdout is left to the output
+	quiet = false
+	startProgress(2, "Renaming")
+	if assert.NotNil(t, progressBar) {
+		assert.Equal(t, int64(2), progressBar.GetMax64())
+	}
+	stepProgress()
+	stepProgress()
+	finishProgress()
+	assert.Nil(t, progressBar)
+	os.Stdout, os.Stderr = realStdout, realStderr
+
+	data, err := os.ReadFile(stderr.Name())
+	assert.NoError(t, err)
+	// Throttled, the bar is drawn for the first file, with a percentage and the count
+	assert.Contains(t, string(data), "Renaming  50%")
+	assert.Contains(t, string(data), "1/2")
+	data, err = os.ReadFile(stdout.Name())
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestValidateImages(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "validate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// A truncated copy still has a valid header, only a full decode finds it
+	good := filepath.Join(tempDir, "a.png")
+	writeTestPNG(t, good, 64, 64)
+	data, err := os.ReadFile(good)
+	assert.NoError(t, err)
+	truncated := filepath.Join(tempDir, "b.png")
+	assert.NoError(t, os.WriteFile(truncated, data[:len(data)-20], 0644))
+	_, err = readImageInfo(truncated)
+	assert.NoError(t, err)
+	writeTestJPEG(t, filepath.Join(tempDir, "c.jpg"), nil)
+
+	images, err := collectInfoImages(tempDir, false)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.Equal(t, 1, validateImages(&out, images, 4, "text"))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "OK: "+good, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "CORRUPT: "+truncated+": "), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "OK: "), lines[2])
+	assert.Equal(t, "Validated: 2 ok, 1 corrupt", lines[3])
+
+	out.Reset()
+	quiet = true
+	defer func() {
+		quiet = false
+	}()
+	assert.Equal(t, 1, validateImages(&out, images, 1, "json"))
+	var results []imageValidation
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	assert.Equal(t, []bool{true, false, true}, []bool{results[0].OK, results[1].OK, results[2].OK})
+	assert.NotEmpty(t, results[1].Error)
+
+	// The streamed array is what marshalling it whole gives, indented or compact
+	jsonIndent = 2
+	defer func() {
+		jsonIndent, jsonCompact = 0, false
+	}()
+	out.Reset()
+	validateImages(&out, images, 1, "json")
+	data, err = marshalJSON(results)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data)+"\n", out.String())
+	jsonCompact = true
+	out.Reset()
+	validateImages(&out, images, 1, "json")
+	data, err = json.Marshal(results)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data)+"\n", out.String())
+	jsonCompact = false
+	out.Reset()
+	validateImages(&out, nil, 1, "json")
+	assert.Equal(t, "[]\n", out.String())
+
+	// A failed write is reported and fails the run
+	activeIssues = &issueLog{}
+	defer func() {
+		activeIssues, runFailed = nil, false
+	}()
+	validateImages(failingWriter{}, images, 1, "json")
+	assert.True(t, runFailed)
+	if assert.Len(t, activeIssues.issues, 2) {
+		assert.Equal(t, "stdout", activeIssues.issues[1].Path)
+	}
+
+	// More images than the reorder window still come out once each, in order
+	var many []string
+	for i := 0; i < 30; i++ {
+		many = append(many, images[i%len(images)])
+	}
+	out.Reset()
+	assert.Equal(t, 10, validateImages(&out, many, 2, "json"))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	if assert.Len(t, results, len(many)) {
+		for i, result := range results {
+			assert.Equal(t, many[i], result.Path)
+		}
+	}
+}
+
+func TestMaxErrorsAbortsScan(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "max_errors_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	var images []string
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("broken_%02d.jpg", i))
+		assert.NoError(t, os.WriteFile(path, []byte("not a jpeg"), 0644))
+		images = append(images, path)
+	}
+
+	maxErrors = 3
+	defer func() {
+		maxErrors = 0
+		runFailed = false
+	}()
+	finishIssues := startIssues()
+	var out bytes.Buffer
+	_, failed, err := writeExifScan(&out, images, "text")
+	assert.NoError(t, err)
+	assert.True(t, activeErrorBudget.exceeded())
+	// Reads already queued when the limit was hit still finish
+	assert.Less(t, failed, 10)
+	assert.NoError(t, finishIssues())
+	assert.True(t, runFailed)
+	assert.Nil(t, activeErrorBudget)
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+// interruptingWriter sets runInterrupted once the first record was written to it
+type interruptingWriter struct {
+	bytes.Buffer
+}
+
+func (w *interruptingWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("SourceFile")) {
+		runInterrupted.Store(true)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestInterruptKeepsJSONValid(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "interrupt_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	var images []string
+	for i := 0; i < 30; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("photo_%02d.jpg", i))
+		writeTestJPEG(t, path, map[uint16]string{0x010f: "Canon"})
+		images = append(images, path)
+	}
+
+	defer func() {
+		runInterrupted.Store(false)
+		runFailed = false
+	}()
+	finishInterrupt := catchInterrupt()
+	var out interruptingWriter
+	succeeded, _, err := writeExifScan(&out, images, "json")
+	assert.NoError(t, err)
+	finishInterrupt()
+
+	// Reads already queued when interrupted still finish and the array is closed
+	var records []map[string]string
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &records))
+	assert.Len(t, records, succeeded)
+	assert.Less(t, succeeded, len(images))
+	assert.True(t, runFailed)
+}
+
+func TestMaxErrorRate(t *testing.T) {
+	maxErrorRate = 0.1
+	defer func() {
+		maxErrorRate = 0
+	}()
+	assert.NoError(t, checkErrorLimits())
+
+	// An early failure does not abort, the rate is checked once enough files were processed
+	budget := &errorBudget{}
+	budget.countFile()
+	budget.countError()
+	assert.False(t, budget.exceeded())
+	for i := 0; i < errorRateMinFiles; i++ {
+		budget.countFile()
+	}
+	budget.countError()
+	assert.False(t, budget.exceeded())
+	budget.countError()
+	budget.countError()
+	assert.True(t, budget.exceeded())
+
+	maxErrorRate = 1.5
+	assert.Error(t, checkErrorLimits())
+}
+
+func TestFailFastAndKeepGoing(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fail_fast_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	var images []string
+	for i := 0; i < 30; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("broken_%02d.jpg", i))
+		assert.NoError(t, os.WriteFile(path, []byte("not a jpeg"), 0644))
+		images = append(images, path)
+	}
+	defer func() {
+		failFast, keepGoing, runFailed = false, false, false
+	}()
+
+	// The first error stops the scan and is kept for the message
+	failFast = true
+	assert.NoError(t, checkErrorLimits())
+	finishIssues := startIssues()
+	_, failed, err := writeExifScan(io.Discard, images, "text")
+	assert.NoError(t, err)
+	assert.Less(t, failed, 10)
+	assert.ErrorContains(t, activeErrorBudget.first, images[0])
+	assert.NoError(t, finishIssues())
+	assert.True(t, runFailed)
+
+	keepGoing = true
+	assert.Error(t, checkErrorLimits())
+
+	// Keep-going processes every file and collects the errors for the summary
+	failFast, runFailed = false, false
+	finishIssues = startIssues()
+	_, failed, err = writeExifScan(io.Discard, images, "text")
+	assert.NoError(t, err)
+	assert.Equal(t, len(images), failed)
+	assert.Len(t, activeIssues.issues, len(images))
+	assert.NoError(t, finishIssues())
+	assert.False(t, runFailed)
+
+	var summary bytes.Buffer
+	writeErrorSummary(
+		&summary, []runIssue{
+			{Level: "warning", Operation: "read", Path: "sub", Message: "permission denied"},
+			{Level: "error", Operation: "exif", Path: "a.jpg", Message: "failed to decode EXIF data"},
+		},
+	)
+	assert.Equal(t, "Errors (1):\n  exif: a.jpg: failed to decode EXIF data\n", summary.String())
+}
+
+func TestEmbedFromSidecar(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "embed_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// A photo with EXIF data and a scan without
+	writeTestJPEG(t, filepath.Join(tempDir, "photo.jpg"), map[uint16]string{0x0110: "EOS R5"})
+	var scan bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&scan, image.NewGray(image.Rect(0, 0, 2, 2)), nil))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "scan.jpg"), scan.Bytes(), 0644))
+
+	sidecar := filepath.Join(tempDir, "dates.csv")
+	content := "File, Date, Make, Lat, Lon\n" +
+		"photo.jpg, 2024-05-06 07:08:09, Canon,,\n" +
+		"scan.jpg, 1987-07-14,, 48.8584, 2.2945\n"
+	assert.NoError(t, os.WriteFile(sidecar, []byte(content), 0644))
+
+	entries, err := readSidecar(sidecar, tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 3, entries[1].line)
+
+	// Dry-run leaves the files alone
+	assert.NoError(t, embedEXIF(entries[1], true))
+	assert.Nil(t, readExifMeta(filepath.Join(tempDir, "scan.jpg")))
+
+	for _, entry := range entries {
+		assert.NoError(t, embedEXIF(entry, false))
+	}
+	assert.Equal(
+		t, map[string]string{"Make": "Canon", "Model": "EOS R5", "DateTimeOriginal": "2024:05:06 07:08:09"},
+		readExifMeta(filepath.Join(tempDir, "photo.jpg")),
+	)
+	record, err := pyrgear.DecodeEXIFFile(filepath.Join(tempDir, "scan.jpg"))
+	assert.NoError(t, err)
+	value, _ := record.Get("DateTimeOriginal")
+	assert.Equal(t, "1987:07:14 00:00:00", value)
+	assert.InDelta(t, 48.8584, record.Lat, 1e-6)
+	assert.InDelta(t, 2.2945, record.Lon, 1e-6)
+
+	// Invalid rows name their line, other files are refused
+	for bad, want := range map[string]string{
+		"file,date\nscan.jpg,14.07.1987\n": "line 2: invalid date",
+		"file,lat\nscan.jpg,48.8\n":        "line 2: invalid longitude",
+		"file,date\nscan.jpg,\n":           "line 2: no values",
+		"file,camera\nscan.jpg,Canon\n":    "unknown column",
+		"date\n1987-07-14\n":               "missing file column",
+	} {
+		assert.NoError(t, os.WriteFile(sidecar, []byte(bad), 0644))
+		_, err := readSidecar(sidecar, tempDir)
+		assert.ErrorContains(t, err, want)
 	}
-	return false
+	entry := sidecarEntry{path: filepath.Join(tempDir, "scan.png"), update: entries[1].update}
+	assert.ErrorIs(t, embedEXIF(entry, false), ErrUnsupportedFormat)
 }
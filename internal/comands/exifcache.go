@@ -0,0 +1,113 @@
+package comands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	exifUseCache bool
+	exifCacheDir string
+	// exifDetectContent also reads files whose content, not extension, is a JPEG or TIFF image
+	exifDetectContent bool
+	// exifDecodeMakerNote adds the fields of Canon and Nikon maker notes to the records
+	exifDecodeMakerNote bool
+)
+
+// exifCacheFormat is the version of cached records, entries of another version are decoded
+// again. Version 2 adds the EXIF 2.31 offset tags, version 3 the values of numeric tags and
+// version 4 the decimal values of rational tags.
+const exifCacheFormat = 4
+
+// exifCacheEntry is the cached EXIF record of a single file. The entry is only valid while
+// the file keeps the recorded size and modification time, and for the same --decode-makernote.
+type exifCacheEntry struct {
+	Format     int             `json:"format"`
+	Path       string          `json:"path"`
+	Size       int64           `json:"size"`
+	ModTime    time.Time       `json:"mod_time"`
+	MakerNotes bool            `json:"maker_notes,omitempty"`
+	Record     *pyrgear.Record `json:"record"`
+}
+
+// loadExifRecord decodes the EXIF data of an image. With --cache, records of files
+// whose size and modification time are unchanged are read from the cache instead.
+func loadExifRecord(imagePath string) (*pyrgear.Record, error) {
+	if !exifUseCache {
+		return decodeExifFile(imagePath)
+	}
+
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return decodeExifFile(imagePath)
+	}
+
+	cachePath, err := exifCachePath(imagePath)
+	if err != nil {
+		return decodeExifFile(imagePath)
+	}
+
+	// Reuse the cached record if the file is unchanged
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var entry exifCacheEntry
+		if json.Unmarshal(data, &entry) == nil && entry.Record != nil && entry.Format == exifCacheFormat &&
+			entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) &&
+			entry.MakerNotes == exifDecodeMakerNote {
+			return entry.Record, nil
+		}
+	}
+
+	record, err := decodeExifFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the fresh record, replacing any stale entry. Failing to write the cache is not fatal.
+	entry := exifCacheEntry{
+		Format: exifCacheFormat, Path: imagePath, Size: info.Size(), ModTime: info.ModTime(),
+		MakerNotes: exifDecodeMakerNote, Record: record,
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		if os.MkdirAll(filepath.Dir(cachePath), 0755) == nil {
+			os.WriteFile(cachePath, data, 0644)
+		}
+	}
+	return record, nil
+}
+
+// decodeExifFile decodes the EXIF data of an image, with --detect-content also of files
+// without a JPEG or TIFF extension whose content is one, and with --decode-makernote also
+// the maker notes of Canon and Nikon cameras
+func decodeExifFile(imagePath string) (*pyrgear.Record, error) {
+	opts := pyrgear.DecodeOptions{MakerNotes: exifDecodeMakerNote}
+	if exifDetectContent {
+		return opts.DecodeFileContent(imagePath)
+	}
+	return opts.DecodeFile(imagePath)
+}
+
+// exifCachePath returns the cache file of an image, named after the hash of its absolute path
+func exifCachePath(imagePath string) (string, error) {
+	abs, err := filepath.Abs(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	dir := exifCacheDir
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userCache, "pyrgear", "exif")
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
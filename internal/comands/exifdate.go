@@ -0,0 +1,130 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// useSubsec appends the EXIF sub-second time to exif-date names and numbers remaining duplicates
+	useSubsec bool
+	// keepOriginal appends the original stem to exif-date names, YYYYMMDD_HHMMSS_<stem>
+	keepOriginal bool
+	// assumeTZ is the time zone of EXIF times without a recorded UTC offset
+	assumeTZ tzValue
+)
+
+// tzValue is a time zone flag accepting IANA names ("Asia/Tokyo") or UTC offsets ("+09:00")
+type tzValue struct {
+	loc *time.Location
+}
+
+func (z *tzValue) String() string {
+	if z.loc == nil {
+		return ""
+	}
+	return z.loc.String()
+}
+
+func (z *tzValue) Set(value string) error {
+	loc, err := pyrgear.ParseTimeZone(value)
+	if err != nil {
+		return err
+	}
+	z.loc = loc
+	return nil
+}
+
+func (z *tzValue) Type() string {
+	return "zone"
+}
+
+// location returns the zone, the local time zone if none was given
+func (z *tzValue) location() *time.Location {
+	if z.loc == nil {
+		return time.Local
+	}
+	return z.loc
+}
+
+// exifTime returns the time an image was taken, in --assume-tz unless the image records its UTC offset
+func exifTime(record *pyrgear.Record) (time.Time, bool) {
+	return record.DateTimeIn(assumeTZ.location())
+}
+
+// renameByExifDate renames the images of dir to the EXIF time they were taken. Images without
+// an EXIF time are skipped. Burst shots share a time down to the second, so with --use-subsec
+// the milliseconds of SubSecTimeOriginal are added, and images that would still get the same
+// name (e.g. without sub-second data) are numbered -1, -2, ... in filename order. With
+// --keep-original the original stem follows the date, cut to the filesystem name length limit.
+func renameByExifDate(dir string, entries []os.DirEntry, dryRun bool) error {
+	// Names given to earlier images of this run
+	taken := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !pyrgear.IsEXIFImage(entry.Name()) || alreadyApplied("exif-date", entry.Name()) {
+			continue
+		}
+		if keepOriginal && pyrgear.HasDatePrefix(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		record, err := loadExifRecord(path)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", path, err)
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+		t, ok := exifTime(record)
+		if !ok {
+			fmt.Printf("Skipping %s: no EXIF date\n", path)
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+
+		millis := false
+		if useSubsec {
+			if subsec, ok := record.SubSecond(); ok {
+				t, millis = t.Add(subsec), true
+			}
+		}
+
+		stem, ext := pyrgear.SplitExt(entry.Name())
+		dateName := func(n int) (string, bool) {
+			name := pyrgear.DateName(t, millis, n, ext)
+			if !keepOriginal {
+				return name, false
+			}
+			return pyrgear.AppendStem(name, stem, pyrgear.MaxNameBytes)
+		}
+
+		newName, truncated := dateName(0)
+		if useSubsec {
+			for n := 1; taken[newName] || exists(filepath.Join(dir, newName)); n++ {
+				newName, truncated = dateName(n)
+			}
+		}
+		if truncated {
+			err := fmt.Errorf("original name cut to fit the %d byte name limit: %s", pyrgear.MaxNameBytes, newName)
+			fmt.Printf("Warning: %s: %v\n", path, err)
+			activeIssues.addWarning("rename", path, err)
+		}
+		taken[newName] = true
+
+		renameFile(path, filepath.Join(dir, newName), dryRun)
+	}
+	return nil
+}
+
+// exists reports whether a file exists at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
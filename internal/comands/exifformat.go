@@ -0,0 +1,389 @@
+package comands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"gopkg.in/yaml.v3"
+)
+
+// exifDecimalRationals writes the values of rational tags as decimals instead of fractions
+var exifDecimalRationals bool
+
+// exifFormatter writes the EXIF records of a run as one document
+type exifFormatter interface {
+	// begin is called once before the first record
+	begin(w io.Writer)
+	// write writes the record of a single image
+	write(w io.Writer, path string, record *pyrgear.Record)
+	// end is called once after the last record
+	end(w io.Writer)
+}
+
+// newExifFormatter returns the formatter of format. multi is set when several
+// images are written, a JSON document then is an array of objects. Paths are
+// written as --path-key says, see exifPathKey, and rationals as --decimal-rationals says.
+func newExifFormatter(format string, multi bool) (exifFormatter, error) {
+	if err := checkPathKey(exifPathKeyMode); err != nil {
+		return nil, err
+	}
+
+	var formatter exifFormatter
+	switch format {
+	case "text":
+		formatter = &textExifFormatter{}
+	case "json":
+		formatter = &jsonExifFormatter{multi: multi}
+	case "ndjson":
+		formatter = &jsonExifFormatter{compact: true}
+	case "yaml":
+		formatter = &yamlExifFormatter{multi: multi}
+	case "csv":
+		if err := checkCSVOptions(); err != nil {
+			return nil, err
+		}
+		formatter = &csvExifFormatter{columns: []string{"SourceFile"}, index: map[string]int{"SourceFile": 0}}
+	case "parquet":
+		formatter = &parquetExifFormatter{
+			csvExifFormatter: &csvExifFormatter{columns: []string{"SourceFile"}, index: map[string]int{"SourceFile": 0}},
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format: %s (supported: text, json, ndjson, yaml, csv, parquet)", format)
+	}
+	if exifPathKeyMode != "" {
+		formatter = pathKeyFormatter{formatter}
+	}
+	if exifDecimalRationals {
+		formatter = decimalFormatter{formatter}
+	}
+	return formatter, nil
+}
+
+// pathKeyFormatter writes the records of another formatter with the paths given by --path-key
+type pathKeyFormatter struct {
+	exifFormatter
+}
+
+func (f pathKeyFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	f.exifFormatter.write(w, exifPathKey(path), record)
+}
+
+// decimalFormatter writes the records of another formatter with the values of rational tags
+// as decimals. Tags without a decimal value, e.g. strings or rationals over zero, keep theirs.
+type decimalFormatter struct {
+	exifFormatter
+}
+
+func (f decimalFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	decimal := *record
+	decimal.Tags = make([]pyrgear.Tag, len(record.Tags))
+	for i, tag := range record.Tags {
+		if tag.Decimal != "" {
+			tag.Value = tag.Decimal
+		}
+		decimal.Tags[i] = tag
+	}
+	f.exifFormatter.write(w, path, &decimal)
+}
+
+// textExifFormatter writes human-readable records, each with a header line
+type textExifFormatter struct{}
+
+func (f *textExifFormatter) begin(w io.Writer) {}
+
+func (f *textExifFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	fmt.Fprintf(w, "\n=== EXIF Information for %s ===\n", path)
+	for _, tag := range record.Tags {
+		fmt.Fprintf(w, "%-30s: %s\n", tag.Name, tag.Value)
+	}
+
+	if record.HasGPS {
+		fmt.Fprintf(w, "%-30s: %f, %f\n", "GPS Coordinates", record.Lat, record.Lon)
+	}
+
+	fmt.Fprintln(w)
+}
+
+func (f *textExifFormatter) end(w io.Writer) {}
+
+// jsonExifFormatter writes each record as an object of tag names to values, in the
+// order of the record, preceded by the "SourceFile" it was read from. Compact formatters
+// write one object per line (ndjson) whatever --compact and --indent say.
+type jsonExifFormatter struct {
+	multi   bool
+	compact bool
+	count   int
+}
+
+// newline returns the line break and indentation before an element at the given nesting level
+func (f *jsonExifFormatter) newline(level int) string {
+	if f.compact {
+		return ""
+	}
+	return jsonNewline(level)
+}
+
+// colon returns the separator between an object key and its value
+func (f *jsonExifFormatter) colon() string {
+	if f.compact {
+		return ":"
+	}
+	return jsonColon()
+}
+
+func (f *jsonExifFormatter) begin(w io.Writer) {
+	if f.multi {
+		fmt.Fprint(w, "[")
+	}
+}
+
+func (f *jsonExifFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	level := 0
+	if f.multi {
+		if f.count > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprint(w, f.newline(1))
+		level = 1
+	}
+	f.count++
+
+	fmt.Fprint(w, "{")
+	fmt.Fprintf(w, "%s\"SourceFile\"%s%s", f.newline(level+1), f.colon(), jsonString(path))
+	for _, tag := range record.Tags {
+		fmt.Fprintf(w, ",%s%s%s%s", f.newline(level+1), jsonString(tag.Name), f.colon(), jsonString(tag.Value))
+	}
+	if record.HasGPS {
+		fmt.Fprintf(w, ",%s\"GPS_Latitude\"%s%f", f.newline(level+1), f.colon(), record.Lat)
+		fmt.Fprintf(w, ",%s\"GPS_Longitude\"%s%f", f.newline(level+1), f.colon(), record.Lon)
+	}
+	fmt.Fprintf(w, "%s}", f.newline(level))
+
+	if !f.multi {
+		fmt.Fprintln(w)
+	}
+}
+
+func (f *jsonExifFormatter) end(w io.Writer) {
+	if f.multi {
+		if f.count > 0 {
+			fmt.Fprint(w, f.newline(0))
+		}
+		fmt.Fprintln(w, "]")
+	}
+}
+
+// yamlExifFormatter writes each record as a mapping of tag names to values, in the order of
+// the record, preceded by the "SourceFile" it was read from. With multi the records are the
+// items of one sequence, otherwise each record is a document of its own.
+type yamlExifFormatter struct {
+	multi bool
+	count int
+}
+
+// yaml11Bools are the plain scalars YAML 1.1 parsers (e.g. PyYAML) read as booleans while
+// YAML 1.2 does not, so the encoder leaves them unquoted
+var yaml11Bools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true, "n": true, "N": true, "no": true, "No": true,
+	"NO": true, "on": true, "On": true, "ON": true, "off": true, "Off": true, "OFF": true,
+}
+
+func (f *yamlExifFormatter) begin(w io.Writer) {}
+
+func (f *yamlExifFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key, tag, value string) {
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+		if yaml11Bools[value] {
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, node)
+	}
+	// Values are always strings, the encoder quotes those that would read as numbers or
+	// booleans. YAML must be valid UTF-8, which raw EXIF strings are not always.
+	add("SourceFile", "!!str", strings.ToValidUTF8(path, "\uFFFD"))
+	for _, tag := range record.Tags {
+		add(tag.Name, "!!str", strings.ToValidUTF8(tag.Value, "\uFFFD"))
+	}
+	if record.HasGPS {
+		add("GPS_Latitude", "!!float", fmt.Sprintf("%f", record.Lat))
+		add("GPS_Longitude", "!!float", fmt.Sprintf("%f", record.Lon))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		fmt.Fprintf(w, "# %s: %v\n", path, err)
+		return
+	}
+	enc.Close()
+
+	if !f.multi {
+		if f.count > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		f.count++
+		buf.WriteTo(w)
+		return
+	}
+
+	// Indent the mapping as an item of the sequence
+	f.count++
+	for i, line := range strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if i == 0 {
+			fmt.Fprint(w, "- ", line)
+		} else if line == "\n" {
+			fmt.Fprint(w, line)
+		} else {
+			fmt.Fprint(w, "  ", line)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func (f *yamlExifFormatter) end(w io.Writer) {
+	if f.multi && f.count == 0 {
+		fmt.Fprintln(w, "[]")
+	}
+}
+
+// csvExifFormatter writes the records as the rows of one CSV table, with a "SourceFile" column
+// and a column per tag in the order the tags first occur. Images without a tag leave its cell
+// empty. As the columns are only known once every record was read, the table is written at the end.
+type csvExifFormatter struct {
+	columns []string
+	index   map[string]int
+	rows    []map[string]string
+}
+
+func (f *csvExifFormatter) begin(w io.Writer) {}
+
+func (f *csvExifFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	f.rows = append(f.rows, f.row(path, record))
+}
+
+// row returns the cells of the record of path by column, adding the columns it is the first with
+func (f *csvExifFormatter) row(path string, record *pyrgear.Record) map[string]string {
+	row := map[string]string{"SourceFile": path}
+	add := func(name, value string) {
+		if _, ok := f.index[name]; !ok {
+			f.index[name] = len(f.columns)
+			f.columns = append(f.columns, name)
+		}
+		row[name] = value
+	}
+	for _, tag := range record.Tags {
+		add(tag.Name, tag.Value)
+	}
+	if record.HasGPS {
+		add("GPS_Latitude", fmt.Sprintf("%f", record.Lat))
+		add("GPS_Longitude", fmt.Sprintf("%f", record.Lon))
+	}
+	return row
+}
+
+func (f *csvExifFormatter) end(w io.Writer) {
+	// The options were checked when the formatter was created
+	cw, _ := newCSVWriter(w)
+	cw.Write(f.columns)
+	for _, row := range f.rows {
+		fields := make([]string, len(f.columns))
+		for i, column := range f.columns {
+			fields[i] = row[column]
+		}
+		cw.Write(fields)
+	}
+	if err := cw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to write CSV: %v\n", err)
+	}
+}
+
+// parquetExifFormatter writes the records as the rows of one Parquet table, with the columns
+// of the CSV table. Numeric columns keep their type, see pyrgear.ParquetSchema, and images
+// without a tag leave its cell null. As the columns and their types are only known once every
+// record was read, the rows are spilled to a temporary file and written from it at the end,
+// one row group at a time, so large scans are not held in memory.
+type parquetExifFormatter struct {
+	*csvExifFormatter
+	schema *pyrgear.ParquetSchema
+	spill  *os.File
+	buf    *bufio.Writer
+	err    error
+}
+
+func (f *parquetExifFormatter) begin(w io.Writer) {
+	f.schema = pyrgear.NewParquetSchema()
+	if f.spill, f.err = os.CreateTemp("", "pyrgear-parquet-*.ndjson"); f.err == nil {
+		f.buf = bufio.NewWriter(f.spill)
+	}
+}
+
+func (f *parquetExifFormatter) write(w io.Writer, path string, record *pyrgear.Record) {
+	if f.err != nil {
+		return
+	}
+	row := f.row(path, record)
+	f.schema.Observe(row)
+	f.err = json.NewEncoder(f.buf).Encode(row)
+}
+
+func (f *parquetExifFormatter) end(w io.Writer) {
+	if f.spill != nil {
+		defer os.Remove(f.spill.Name())
+		defer f.spill.Close()
+	}
+	if f.err == nil {
+		f.err = f.writeTable(w)
+	}
+	if f.err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to write Parquet: %v\n", f.err)
+		activeIssues.addError("exif", "parquet", f.err)
+		runFailed = true
+	}
+}
+
+// writeTable writes the spilled rows to w as a Parquet table
+func (f *parquetExifFormatter) writeTable(w io.Writer) error {
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	pw := pyrgear.NewParquetWriter(w, f.columns, f.schema)
+	dec := json.NewDecoder(bufio.NewReader(f.spill))
+	rows := make([]map[string]string, 0, pyrgear.ParquetRowGroupRows)
+	for {
+		var row map[string]string
+		err := dec.Decode(&row)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read back rows: %v", err)
+		}
+		if err == nil {
+			rows = append(rows, row)
+		}
+		if len(rows) > 0 && (err == io.EOF || len(rows) == pyrgear.ParquetRowGroupRows) {
+			if err := pw.WriteRowGroup(rows); err != nil {
+				return err
+			}
+			rows = rows[:0]
+		}
+		if err == io.EOF {
+			return pw.Close()
+		}
+	}
+}
+
+// jsonString returns s as a quoted and escaped JSON string
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,128 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	// exifWorkers is the number of images whose EXIF data is read concurrently
+	exifWorkers int
+	// exifOrdered emits the results of concurrent reads in input order
+	exifOrdered bool
+)
+
+// reorderWindow is how many images per worker may be read ahead of the next result to emit,
+// in scanExif and validateImages. It bounds the reorder buffer when an early image is slow.
+const reorderWindow = 4
+
+// exifSequenced is a result tagged with the position of its image in the input
+type exifSequenced struct {
+	seq    int
+	result exifResult
+}
+
+// scanExif reads the EXIF data of images with up to workers concurrent reads and calls emit
+// with every result, always from the calling goroutine. Without ordered results are emitted
+// as they complete. With ordered they are emitted in the order of images: a result that
+// completes early waits in a reorder buffer until the results before it are emitted. Reads
+// never get more than reorderWindow images per worker ahead of the next result to emit,
+// so the buffer stays bounded however many images are scanned. On Ctrl-C or too many errors no
+// new images are read, the reads in progress are still emitted.
+func scanExif(images []string, workers int, ordered bool, emit func(exifResult)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// A slot is taken for each image sent to the workers and given back once its result
+	// is emitted, so at most window results are in flight or buffered
+	window := workers * reorderWindow
+	slots := make(chan struct{}, window)
+	queue := make(chan int)
+	results := make(chan exifSequenced, workers)
+	go func() {
+		for i := range images {
+			if stopRequested() {
+				break
+			}
+			slots <- struct{}{}
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range queue {
+				record, err := loadImageExif(images[seq])
+				results <- exifSequenced{seq: seq, result: exifResult{Path: images[seq], Record: record, Err: err}}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]exifResult, window)
+	next := 0
+	for sequenced := range results {
+		stepProgress()
+		if !ordered {
+			emit(sequenced.result)
+			<-slots
+			continue
+		}
+
+		pending[sequenced.seq] = sequenced.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(result)
+			<-slots
+			next++
+		}
+	}
+}
+
+// writeExifScan reads the EXIF data of images with --workers concurrent reads and writes the
+// records to w in format as they are emitted, see scanExif, instead of holding all of them
+// first. Failed images are reported as warnings. It returns how many images succeeded and failed.
+func writeExifScan(w io.Writer, images []string, format string) (succeeded int, failed int, err error) {
+	formatter, err := newExifFormatter(format, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	warnings := exifWarnings(w, format)
+
+	startProgress(len(images), "Reading EXIF")
+	defer finishProgress()
+
+	formatter.begin(w)
+	scanExif(
+		images, exifWorkers, exifOrdered, func(result exifResult) {
+			if writeExifResult(w, warnings, formatter, result) {
+				succeeded++
+			} else {
+				failed++
+			}
+			if (succeeded+failed)%exifFlushInterval == 0 {
+				flushOutput(w)
+			}
+		},
+	)
+	formatter.end(w)
+	return succeeded, failed, nil
+}
+
+// writeExifCounts writes how many images succeeded and failed
+func writeExifCounts(w io.Writer, succeeded int, failed int) {
+	fmt.Fprintf(w, "EXIF read: %d succeeded, %d failed\n", succeeded, failed)
+}
@@ -0,0 +1,85 @@
+package comands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// fileList is a list of files touched by a run, along with the version and options that produced it
+type fileList struct {
+	Version string
+	Options string
+	Files   []string
+}
+
+// writeFileList writes the list with a small header so that replays can be compared across versions:
+//
+//	# pyrgear <version>
+//	# options: <flags>
+//	/path/to/file
+func writeFileList(path string, list fileList) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file list %s: %v", path, err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "# pyrgear %s\n", list.Version)
+	fmt.Fprintf(w, "# options: %s\n", list.Options)
+	for _, f := range list.Files {
+		fmt.Fprintln(w, f)
+	}
+	return w.Flush()
+}
+
+// readFileList reads a list written by writeFileList. Plain lists of paths without
+// a header are accepted as well; blank lines are ignored.
+func readFileList(path string) (fileList, error) {
+	var list fileList
+
+	file, err := os.Open(path)
+	if err != nil {
+		return list, fmt.Errorf("failed to open file list %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# pyrgear "):
+			list.Version = strings.TrimPrefix(line, "# pyrgear ")
+		case strings.HasPrefix(line, "# options:"):
+			list.Options = strings.TrimSpace(strings.TrimPrefix(line, "# options:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			list.Files = append(list.Files, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return list, fmt.Errorf("failed to read file list %s: %v", path, err)
+	}
+	return list, nil
+}
+
+// describeFlags returns the flags set on the command line as "--name=value" pairs, leaving out skip
+func describeFlags(flags *pflag.FlagSet, skip ...string) string {
+	var parts []string
+	flags.Visit(
+		func(f *pflag.Flag) {
+			for _, name := range skip {
+				if f.Name == name {
+					return
+				}
+			}
+			parts = append(parts, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+		},
+	)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,376 @@
+package comands
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Image dimension filters, zero means unset
+var (
+	minWidth  int
+	minHeight int
+	maxWidth  int
+	maxHeight int
+)
+
+// EXIF tag presence filters by tag name, and tag value conditions; empty means unset
+var (
+	hasTags     []string
+	withoutTags []string
+	whereConds  conditionsValue
+)
+
+// File selection filters shared by the commands, zero values mean unset
+var (
+	includeGlobs   []string
+	excludeGlobs   []string
+	minSize        sizeValue
+	maxSize        sizeValue
+	modifiedAfter  dateValue
+	modifiedBefore dateValue
+	skipHidden     bool
+)
+
+// addFilterFlags registers the file selection filter flags on cmd
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(
+		&includeGlobs, "include", nil, "Only select files whose name matches this glob (repeatable, e.g. '*.jpg')",
+	)
+	cmd.Flags().StringSliceVar(&excludeGlobs, "exclude", nil, "Skip files whose name matches this glob (repeatable)")
+	cmd.Flags().Var(&minSize, "min-size", "Only select files of at least this size (e.g. 500KB, 1MB)")
+	cmd.Flags().Var(&maxSize, "max-size", "Only select files of at most this size (e.g. 500KB, 1MB)")
+	cmd.Flags().Var(
+		&modifiedAfter, "modified-after", "Only select files modified at or after this date (YYYY-MM-DD or RFC 3339)",
+	)
+	cmd.Flags().Var(
+		&modifiedBefore, "modified-before", "Only select files modified before this date (YYYY-MM-DD or RFC 3339)",
+	)
+	cmd.Flags().BoolVar(&skipHidden, "skip-hidden", false, "Skip hidden files and directories (names starting with '.')")
+	cmd.Flags().BoolVar(
+		&sinceLastRun, "since-last-run", false,
+		"Only select files modified since the last successful run of this command on the directory",
+	)
+	cmd.Flags().StringVar(
+		&lastRunFile, "last-run-file", "",
+		"File recording the last runs for --since-last-run (optional, defaults to pyrgear/last-run.json in the user config directory)",
+	)
+	cmd.Flags().IntVar(&minWidth, "min-width", 0, "Only select images at least this many pixels wide")
+	cmd.Flags().IntVar(&minHeight, "min-height", 0, "Only select images at least this many pixels high")
+	cmd.Flags().IntVar(&maxWidth, "max-width", 0, "Only select images at most this many pixels wide")
+	cmd.Flags().IntVar(&maxHeight, "max-height", 0, "Only select images at most this many pixels high")
+	cmd.Flags().StringSliceVar(
+		&hasTags, "has-tag", nil,
+		"Only select images that have this EXIF tag, e.g. LensModel (repeatable, all must be present)",
+	)
+	cmd.Flags().StringSliceVar(&withoutTags, "without-tag", nil, "Skip images that have this EXIF tag (repeatable)")
+	cmd.Flags().Var(
+		&whereConds, "where",
+		"Only select images whose EXIF tag value satisfies this condition, e.g. 'Make=Canon' or 'ISO>800' "+
+			"(=, !=, <, <=, >, >=, contains; repeatable, all must hold)",
+	)
+}
+
+// conditionsValue is a repeatable flag of EXIF tag value conditions, see pyrgear.ParseCondition
+type conditionsValue []pyrgear.Condition
+
+func (c *conditionsValue) String() string {
+	conds := make([]string, len(*c))
+	for i, cond := range *c {
+		conds[i] = cond.String()
+	}
+	return "[" + strings.Join(conds, ",") + "]"
+}
+
+func (c *conditionsValue) Set(value string) error {
+	cond, err := pyrgear.ParseCondition(value)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, cond)
+	return nil
+}
+
+func (c *conditionsValue) Type() string {
+	return "condition"
+}
+
+// sizeValue is a file size flag accepting units such as KB, MB or GB (powers of 1024)
+type sizeValue int64
+
+func (s *sizeValue) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeValue) Set(value string) error {
+	n, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = sizeValue(n)
+	return nil
+}
+
+func (s *sizeValue) Type() string {
+	return "size"
+}
+
+// parseSize parses a size such as "1500", "500KB", "1.5MB" or "2GiB". Units are powers of 1024.
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	factor := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * factor), nil
+}
+
+// dateValue is a date flag accepting YYYY-MM-DD (local time) or RFC 3339
+type dateValue time.Time
+
+func (d *dateValue) String() string {
+	if time.Time(*d).IsZero() {
+		return ""
+	}
+	return time.Time(*d).Format(time.RFC3339)
+}
+
+func (d *dateValue) Set(value string) error {
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC 3339)", value)
+		}
+	}
+	*d = dateValue(t)
+	return nil
+}
+
+func (d *dateValue) Type() string {
+	return "date"
+}
+
+// dimensionFilterSet reports whether any image dimension filter is active
+func dimensionFilterSet() bool {
+	return minWidth > 0 || minHeight > 0 || maxWidth > 0 || maxHeight > 0
+}
+
+// filterSet reports whether any selection filter is active
+func filterSet() bool {
+	return len(includeGlobs) > 0 || len(excludeGlobs) > 0 || minSize > 0 || maxSize > 0 ||
+		!time.Time(modifiedAfter).IsZero() || !time.Time(modifiedBefore).IsZero() || skipHidden ||
+		!lastRunCutoff.IsZero() || dimensionFilterSet() || tagFilterSet()
+}
+
+// tagFilterSet reports whether any EXIF tag presence or value filter is active
+func tagFilterSet() bool {
+	return len(hasTags) > 0 || len(withoutTags) > 0 || len(whereConds) > 0
+}
+
+// matchesTags reports whether the file at path has all --has-tag tags, none of the
+// --without-tag tags and satisfies every --where condition. Tag names are those shown by the
+// exif command. Files without EXIF data have no tags, so they only match --without-tag.
+func matchesTags(path string) bool {
+	if !tagFilterSet() {
+		return true
+	}
+
+	var record *pyrgear.Record
+	if pyrgear.IsEXIFImage(path) {
+		record, _ = loadExifRecord(path)
+	}
+	has := func(name string) bool {
+		if record == nil {
+			return false
+		}
+		_, ok := record.Get(name)
+		return ok
+	}
+
+	for _, name := range hasTags {
+		if !has(name) {
+			return false
+		}
+	}
+	for _, name := range withoutTags {
+		if has(name) {
+			return false
+		}
+	}
+	for _, cond := range whereConds {
+		if !cond.Match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesDimensions reports whether the image at path satisfies the dimension filters.
+// Only the image header is read. While a filter is active, files whose header
+// cannot be decoded (including non-images) never match.
+func matchesDimensions(path string) bool {
+	if !dimensionFilterSet() {
+		return true
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return false
+	}
+
+	return (minWidth == 0 || cfg.Width >= minWidth) &&
+		(minHeight == 0 || cfg.Height >= minHeight) &&
+		(maxWidth == 0 || cfg.Width <= maxWidth) &&
+		(maxHeight == 0 || cfg.Height <= maxHeight)
+}
+
+// isHidden reports whether name is a hidden file or directory
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// matchesGlobs reports whether name matches any of globs
+func matchesGlobs(name string, globs []string) bool {
+	for _, glob := range globs {
+		if matched, _ := filepath.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilters reports whether the file at path with the given info satisfies the selection filters
+func matchesFilters(path string, info os.FileInfo) bool {
+	name := filepath.Base(path)
+	if skipHidden && isHidden(name) {
+		return false
+	}
+	if len(includeGlobs) > 0 && !matchesGlobs(name, includeGlobs) {
+		return false
+	}
+	if matchesGlobs(name, excludeGlobs) {
+		return false
+	}
+	if (minSize > 0 && info.Size() < int64(minSize)) || (maxSize > 0 && info.Size() > int64(maxSize)) {
+		return false
+	}
+	if after := time.Time(modifiedAfter); !after.IsZero() && info.ModTime().Before(after) {
+		return false
+	}
+	if before := time.Time(modifiedBefore); !before.IsZero() && !info.ModTime().Before(before) {
+		return false
+	}
+	if !modifiedSinceLastRun(info) {
+		return false
+	}
+	return matchesDimensions(path) && matchesTags(path)
+}
+
+// filterFiles returns the files of paths that match the selection filters
+func filterFiles(paths []string) []string {
+	if !filterSet() {
+		return paths
+	}
+
+	var selected []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && matchesFilters(path, info) {
+			selected = append(selected, path)
+		}
+	}
+	return selected
+}
+
+// filterEntries returns the directories of entries and the files that match the selection filters.
+// With --skip-hidden hidden directories are left out too. The lock file of --lock is never selected.
+func filterEntries(dir string, entries []os.DirEntry) []os.DirEntry {
+	entries = withoutLockFile(entries)
+	if !filterSet() {
+		return entries
+	}
+
+	var selected []os.DirEntry
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if !skipHidden || !isHidden(entry.Name()) {
+				selected = append(selected, entry)
+			}
+			continue
+		}
+		if info, err := entry.Info(); err == nil && matchesFilters(filepath.Join(dir, entry.Name()), info) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// walkSelectedFiles calls fn for each selected file of dir, in directory order,
+// descending into subdirectories when recursive is set
+func walkSelectedFiles(dir string, recursive bool, fn func(path string, entry os.DirEntry)) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to access directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %v", dir, err)
+	}
+	for _, entry := range filterEntries(dir, entries) {
+		if activeErrorBudget.exceeded() {
+			break
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if recursive {
+				if err := walkSelectedFiles(path, recursive, fn); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("read", path, err)
+				}
+			}
+			continue
+		}
+		if activeState.isStateFile(path) {
+			continue
+		}
+		fn(path, entry)
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package comands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestPNG writes a blank PNG of the given size
+func writeTestPNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.NoError(t, png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))))
+}
+
+func TestMatchesDimensions(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "dimensions_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	full := filepath.Join(tempDir, "full.png")
+	thumb := filepath.Join(tempDir, "thumb.png")
+	notes := filepath.Join(tempDir, "notes.txt")
+	writeTestPNG(t, full, 400, 300)
+	writeTestPNG(t, thumb, 40, 30)
+	assert.NoError(t, os.WriteFile(notes, []byte("not an image"), 0644))
+
+	defer func() {
+		minWidth, minHeight, maxWidth, maxHeight = 0, 0, 0, 0
+	}()
+
+	// Without filters everything matches
+	assert.True(t, matchesDimensions(notes))
+
+	minWidth = 100
+	assert.True(t, matchesDimensions(full))
+	assert.False(t, matchesDimensions(thumb))
+	assert.False(t, matchesDimensions(notes))
+
+	minWidth, maxHeight = 0, 100
+	assert.False(t, matchesDimensions(full))
+	assert.True(t, matchesDimensions(thumb))
+
+	minHeight, maxHeight = 30, 300
+	assert.Equal(t, []string{full, thumb}, filterFiles([]string{full, thumb, notes}))
+}
+
+func TestRenameRespectsDimensionFilters(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "dimensions_rename_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	writeTestPNG(t, filepath.Join(tempDir, "full.png"), 400, 300)
+	writeTestPNG(t, filepath.Join(tempDir, "thumb.png"), 40, 30)
+
+	minWidth, prefixName = 100, "hires_"
+	defer func() {
+		minWidth, prefixName = 0, ""
+	}()
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "prefix", false, false))
+	assert.Equal(t, []string{"hires_full.png", "thumb.png"}, listNames(t, tempDir))
+}
+
+func TestTagFilters(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tag_filter_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	lens := filepath.Join(tempDir, "lens.jpg")
+	plain := filepath.Join(tempDir, "plain.jpg")
+	notes := filepath.Join(tempDir, "notes.txt")
+	writeTestJPEG(t, lens, map[uint16]string{0x010F: "Canon", 0xA434: "RF24-70mm F2.8 L IS USM"})
+	writeTestJPEG(t, plain, map[uint16]string{0x010F: "Canon"})
+	assert.NoError(t, os.WriteFile(notes, []byte("no EXIF"), 0644))
+	all := []string{lens, notes, plain}
+
+	defer func() {
+		hasTags, withoutTags, prefixName = nil, nil, ""
+	}()
+	hasTags = []string{"LensModel"}
+	assert.Equal(t, []string{lens}, filterFiles(all))
+	hasTags = []string{"Make", "LensModel"}
+	assert.Equal(t, []string{lens}, filterFiles(all))
+	hasTags = []string{"Make"}
+	assert.Equal(t, []string{lens, plain}, filterFiles(all))
+
+	// Set difference: images with a Make but without a LensModel
+	withoutTags = []string{"LensModel"}
+	assert.Equal(t, []string{plain}, filterFiles(all))
+	hasTags = nil
+	assert.Equal(t, []string{notes, plain}, filterFiles(all))
+
+	// Rename selects with the same predicate
+	hasTags, withoutTags, prefixName = []string{"LensModel"}, nil, "lens_"
+	assert.NoError(t, processDirectoryWithRule(tempDir, "prefix", false, false))
+	assert.Equal(t, []string{"lens_lens.jpg", "notes.txt", "plain.jpg"}, listNames(t, tempDir))
+}
+
+func TestWhereFilter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "where_filter_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	canon := filepath.Join(tempDir, "canon.jpg")
+	nikon := filepath.Join(tempDir, "nikon.jpg")
+	notes := filepath.Join(tempDir, "notes.txt")
+	writeTestJPEG(t, canon, map[uint16]string{0x010F: "Canon", 0x0110: "Canon EOS R5"})
+	writeTestJPEG(t, nikon, map[uint16]string{0x010F: "NIKON CORPORATION"})
+	assert.NoError(t, os.WriteFile(notes, []byte("no EXIF"), 0644))
+	all := []string{canon, nikon, notes}
+
+	defer func() {
+		whereConds, prefixName = nil, ""
+	}()
+	assert.NoError(t, whereConds.Set("Make=canon"))
+	assert.Equal(t, []string{canon}, filterFiles(all))
+
+	// Files without the tag match no condition, not even !=
+	whereConds = nil
+	assert.NoError(t, whereConds.Set("Make!=Canon"))
+	assert.Equal(t, []string{nikon}, filterFiles(all))
+
+	// All conditions must hold
+	whereConds = nil
+	assert.NoError(t, whereConds.Set("Make contains o"))
+	assert.Equal(t, []string{canon, nikon}, filterFiles(all))
+	assert.NoError(t, whereConds.Set("Model contains R5"))
+	assert.Equal(t, []string{canon}, filterFiles(all))
+
+	assert.Error(t, whereConds.Set("Make"))
+	assert.Error(t, whereConds.Set("Make~Canon"))
+
+	// Rename selects with the same predicate
+	whereConds, prefixName = nil, "nikon_"
+	assert.NoError(t, whereConds.Set("Make contains nikon"))
+	assert.NoError(t, processDirectoryWithRule(tempDir, "prefix", false, false))
+	assert.Equal(t, []string{"canon.jpg", "nikon_nikon.jpg", "notes.txt"}, listNames(t, tempDir))
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"1500":  1500,
+		"500KB": 500 << 10,
+		"1mb":   1 << 20,
+		"1.5M":  3 << 19,
+		"2GiB":  2 << 30,
+		"10 B":  10,
+	} {
+		got, err := parseSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, got, value)
+	}
+
+	for _, value := range []string{"", "MB", "-1KB", "lots"} {
+		_, err := parseSize(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestListFilesAppliesSharedFilters(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "list_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	old := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	write := func(rel string, size int, mtime time.Time) string {
+		path := filepath.Join(tempDir, rel)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+		return path
+	}
+	big := write("big.jpg", 2048, time.Now())
+	write("small.jpg", 10, time.Now())
+	write("notes.txt", 4096, time.Now())
+	nested := write(filepath.Join("sub", "nested.jpg"), 4096, time.Now())
+	write(filepath.Join("sub", "old.jpg"), 4096, old)
+	write(filepath.Join(".hidden", "secret.jpg"), 4096, time.Now())
+
+	defer func() {
+		includeGlobs, minSize, modifiedAfter, skipHidden = nil, 0, dateValue{}, false
+	}()
+	includeGlobs = []string{"*.jpg"}
+	assert.NoError(t, minSize.Set("1KB"))
+	assert.NoError(t, modifiedAfter.Set("2021-01-01"))
+	skipHidden = true
+
+	files, err := listFiles(tempDir, true)
+	assert.NoError(t, err)
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{big, nested}, paths)
+
+	files, err = listFiles(tempDir, false)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	var out bytes.Buffer
+	assert.NoError(t, writeListedFiles(&out, files, "json"))
+	var decoded []map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, big, decoded[0]["path"])
+	assert.Equal(t, float64(2048), decoded[0]["size"])
+
+	out.Reset()
+	assert.NoError(t, writeListedFiles(&out, files, "csv"))
+	assert.Contains(t, out.String(), "path,size,mtime\n"+big+",2048,")
+
+	assert.Error(t, writeListedFiles(&out, files, "xml"))
+}
+
+func TestSinceLastRun(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "since_last_run_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	dir := filepath.Join(tempDir, "inbox")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	old := filepath.Join(dir, "old.txt")
+	assert.NoError(t, os.WriteFile(old, nil, 0644))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(old, past, past))
+
+	sinceLastRun, lastRunFile = true, filepath.Join(tempDir, "state", "last-run.json")
+	defer func() {
+		sinceLastRun, lastRunFile, dryRun = false, "", false
+	}()
+	listed := func(rule string, ok bool) []string {
+		finish, err := startSinceLastRun("list", dir, rule)
+		assert.NoError(t, err)
+		files, err := listFiles(dir, false)
+		assert.NoError(t, err)
+		finish(ok)
+		var names []string
+		for _, f := range files {
+			names = append(names, filepath.Base(f.Path))
+		}
+		return names
+	}
+
+	// The first run selects everything and is recorded
+	assert.Equal(t, []string{"old.txt"}, listed("", true))
+	assert.FileExists(t, lastRunFile)
+	assert.True(t, lastRunCutoff.IsZero())
+
+	// Later runs only select files modified since, failed and dry runs are not recorded
+	added := filepath.Join(dir, "new.txt")
+	assert.NoError(t, os.WriteFile(added, nil, 0644))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(added, future, future))
+	assert.Equal(t, []string{"new.txt"}, listed("", false))
+	dryRun = true
+	assert.Equal(t, []string{"new.txt"}, listed("", true))
+	dryRun = false
+	assert.Equal(t, []string{"new.txt"}, listed("", true))
+
+	// Another rule starts over
+	assert.Equal(t, []string{"new.txt", "old.txt"}, listed("other", true))
+
+	_, err = startSinceLastRun("list", "", "")
+	assert.Error(t, err)
+}
+
+func TestCollectFileStatsReportsMislabeledFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "stats_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	sub := filepath.Join(tempDir, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	writeTestPNG(t, filepath.Join(tempDir, "real.png"), 10, 10)
+	writeTestPNG(t, filepath.Join(sub, "fake.jpg"), 10, 10)
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("some notes"), 0644))
+
+	stats, err := collectFileStats(tempDir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Files)
+	assert.Empty(t, stats.Mislabeled)
+
+	stats, err = collectFileStats(tempDir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Files)
+	assert.Equal(t, map[string]int{"image/png": 2, "text/plain": 1}, stats.ByContent)
+	assert.Equal(t, map[string]int{"image/png": 1}, stats.ByExtension[".jpg"].Content)
+	if assert.Len(t, stats.Mislabeled, 1) {
+		assert.Equal(t, filepath.Join(sub, "fake.jpg"), stats.Mislabeled[0].Path)
+		assert.Equal(t, "image/jpeg", stats.Mislabeled[0].Expected)
+		assert.Equal(t, "image/png", stats.Mislabeled[0].Content)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, stats.write(&out, "text"))
+	assert.Contains(t, out.String(), "Mislabeled files (1):")
+	assert.Regexp(t, `\.jpg\s+1\s+\S+ \S+\s+image/png 1`, out.String())
+
+	out.Reset()
+	assert.NoError(t, stats.write(&out, "json"))
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, float64(3), decoded["files"])
+}
+
+func TestImageInfo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "info_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	sub := filepath.Join(tempDir, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	gray := filepath.Join(tempDir, "gray.png")
+	writeTestPNG(t, gray, 40, 30)
+	photo := filepath.Join(sub, "photo.jpg")
+	writeTestJPEG(t, photo, map[uint16]string{0x010f: "Canon"})
+	broken := filepath.Join(tempDir, "broken.jpg")
+	assert.NoError(t, os.WriteFile(broken, []byte("fake image data"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("some notes"), 0644))
+
+	images, err := collectInfoImages(tempDir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{broken, gray}, images)
+	images, err = collectInfoImages(tempDir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{broken, gray, photo}, images)
+
+	// Unreadable images are reported and left out
+	var warnings bytes.Buffer
+	infos := readImageInfos(&warnings, images)
+	assert.Contains(t, warnings.String(), "Warning: Failed to read "+broken)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(
+			t, imageInfo{Path: gray, Format: "PNG", Width: 40, Height: 30, ColorModel: "Gray", Size: infos[0].Size},
+			infos[0],
+		)
+		assert.Equal(t, "JPEG", infos[1].Format)
+		assert.Equal(t, "Gray", infos[1].ColorModel)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeImageInfos(&out, infos[:1], "text"))
+	assert.Equal(t, fmt.Sprintf("%s: 40x30 PNG Gray, %s\n", gray, formatSize(infos[0].Size)), out.String())
+
+	out.Reset()
+	assert.NoError(t, writeImageInfos(&out, infos, "json"))
+	var decoded []map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Len(t, decoded, 2)
+	assert.Equal(t, float64(40), decoded[0]["width"])
+	assert.Equal(t, "Gray", decoded[0]["color_model"])
+	assert.Error(t, writeImageInfos(&out, infos, "xml"))
+}
+
+func TestDedupeKeepPolicy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_dedupe_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// b.jpg is the oldest copy, c.jpg the newest, other.jpg has different content
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"a.jpg": 2, "b.jpg": 3, "c.jpg": 1, "other.jpg": 4} {
+		path := filepath.Join(tempDir, name)
+		content := "photo"
+		if name == "other.jpg" {
+			content = "other"
+		}
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		assert.NoError(t, os.Chtimes(path, now.Add(-age*time.Hour), now.Add(-age*time.Hour)))
+	}
+	path := func(name string) string { return filepath.Join(tempDir, name) }
+
+	for policy, keep := range map[string]string{"oldest": "b.jpg", "newest": "c.jpg", "first": "a.jpg"} {
+		groups, err := findDuplicates(tempDir, false, policy, 4)
+		assert.NoError(t, err)
+		if assert.Len(t, groups, 1, policy) {
+			assert.Equal(t, path(keep), groups[0].Keep, policy)
+			assert.Len(t, groups[0].Duplicates, 2, policy)
+		}
+	}
+	assert.Error(t, checkKeepPolicy("biggest"))
+
+	// Every algorithm finds the same set
+	defer func() {
+		hashAlgo = pyrgear.HashSHA256
+	}()
+	for _, algo := range pyrgear.HashAlgos {
+		hashAlgo = algo
+		groups, err := findDuplicates(tempDir, false, "oldest", 2)
+		assert.NoError(t, err)
+		if assert.Len(t, groups, 1, algo) {
+			assert.Len(t, groups[0].Duplicates, 2, algo)
+		}
+	}
+	assert.ErrorIs(t, checkHashAlgo("crc32"), pyrgear.ErrUnknownHashAlgo)
+
+	// Dry runs change nothing
+	groups, err := findDuplicates(tempDir, false, "oldest", 1)
+	assert.NoError(t, err)
+	removeDuplicates(groups, false, true)
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg", "other.jpg"}, listNames(t, tempDir))
+
+	removeDuplicates(groups, true, false)
+	assert.True(t, pyrgear.IsSameFile(path("b.jpg"), path("a.jpg")))
+	assert.True(t, pyrgear.IsSameFile(path("b.jpg"), path("c.jpg")))
+
+	removeDuplicates(groups, false, false)
+	assert.Equal(t, []string{"b.jpg", "other.jpg"}, listNames(t, tempDir))
+}
+
+// BenchmarkHashFiles hashes a directory of 32 files of 4 MB each, as dedupe does for files of
+// equal size, with an increasing number of workers
+func BenchmarkHashFiles(b *testing.B) {
+	dir := b.TempDir()
+	data := make([]byte, 4<<20)
+	var members []dedupeMember
+	for i := 0; i < 32; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("img_%02d.jpg", i))
+		data[0] = byte(i)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			b.Fatalf("Failed to create file: %v", err)
+		}
+		members = append(members, dedupeMember{path: path})
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(
+			fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+				b.SetBytes(int64(len(members) * len(data)))
+				for i := 0; i < b.N; i++ {
+					hashFiles(members, workers)
+				}
+			},
+		)
+	}
+}
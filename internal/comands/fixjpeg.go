@@ -0,0 +1,70 @@
+package comands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// exifFixJPEG turns images upright and strips their GPS tags instead of reading EXIF
+	exifFixJPEG bool
+	// exifInPlace rewrites the fixed images, without it --fix-jpeg only reports what it would do
+	exifInPlace bool
+)
+
+// fixJPEGs turns each JPEG of images upright and strips its GPS tags in one pass, see
+// pyrgear.FixJPEG, reporting what was done per file. Without inPlace nothing is written.
+// It returns false if any image failed.
+func fixJPEGs(w io.Writer, images []string, inPlace bool) bool {
+	ok := true
+	fixed := 0
+	for _, image := range images {
+		result, err := fixJPEG(image, inPlace)
+		if err != nil {
+			fmt.Fprintf(w, "Error fixing %s: %v\n", image, err)
+			activeIssues.addError("fix-jpeg", image, err)
+			ok = false
+			continue
+		}
+		switch {
+		case !result.Changed():
+			fmt.Fprintf(w, "Unchanged: %s (%s)\n", image, result)
+		case inPlace:
+			fmt.Fprintf(w, "Fixed: %s (%s)\n", image, result)
+			fixed++
+		default:
+			fmt.Fprintf(w, "Would fix: %s (%s)\n", image, result)
+			fixed++
+		}
+	}
+
+	if !quiet {
+		verb := "Fixed"
+		if !inPlace {
+			verb = "Would fix"
+		}
+		fmt.Fprintf(w, "%s %d of %d images\n", verb, fixed, len(images))
+	}
+	return ok
+}
+
+// fixJPEG fixes a single image, or only works out what would be done unless inPlace
+func fixJPEG(imagePath string, inPlace bool) (pyrgear.FixResult, error) {
+	if ext := strings.ToLower(filepath.Ext(imagePath)); ext != ".jpg" && ext != ".jpeg" {
+		return pyrgear.FixResult{}, fmt.Errorf("%w: %s (supported: jpg, jpeg)", ErrUnsupportedFormat, imagePath)
+	}
+	if inPlace {
+		return pyrgear.FixJPEGFile(imagePath)
+	}
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return pyrgear.FixResult{}, err
+	}
+	return pyrgear.FixJPEG(bytes.NewReader(data), io.Discard)
+}
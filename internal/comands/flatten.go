@@ -0,0 +1,58 @@
+package comands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// flattenTree moves the selected files of the subdirectories of root into root. Files keep
+// their name unless it is used by another file of root or of the tree, then their path
+// relative to root is joined into the name, see relativeName. Empty directories are left.
+func flattenTree(root string, dryRun bool) error {
+	// Names are compared like case-insensitive filesystems do, so they collide there too
+	counts := make(map[string]int)
+	rootEntries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range rootEntries {
+		counts[pyrgear.CollisionKey(entry.Name())]++
+	}
+
+	var files []string
+	err = walkSelectedFiles(
+		root, true, func(path string, entry os.DirEntry) {
+			if filepath.Dir(path) == filepath.Clean(root) {
+				return
+			}
+			files = append(files, path)
+			counts[pyrgear.CollisionKey(entry.Name())]++
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		name := filepath.Base(path)
+		if counts[pyrgear.CollisionKey(name)] > 1 {
+			name = relativeName(root, path)
+		}
+		renameFileWithin(path, filepath.Join(root, name), root, dryRun)
+	}
+	return nil
+}
+
+// relativeName returns the name of path with its directory relative to base joined in
+// front by underscores, so base/sub/folder/file.jpg becomes sub_folder_file.jpg. Using the
+// relative rather than the absolute path keeps the names short and readable.
+func relativeName(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+}
@@ -0,0 +1,25 @@
+package comands
+
+import (
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+// hashAlgo is the algorithm files are hashed with to compare their content
+var hashAlgo string
+
+// addHashAlgoFlag registers the --hash-algo flag on cmd
+func addHashAlgoFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().StringVar(
+		&hashAlgo, "hash-algo", pyrgear.HashSHA256,
+		usage+": "+strings.Join(pyrgear.HashAlgos, ", ")+" (md5 and sha1 are faster but not collision resistant)",
+	)
+}
+
+// checkHashAlgo returns an error if --hash-algo is not a supported algorithm
+func checkHashAlgo(algo string) error {
+	_, err := pyrgear.NewHasher(algo)
+	return err
+}
@@ -0,0 +1,62 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// incrementBy is added to the trailing numbers of names by the increment-existing rule
+var incrementBy int
+
+// numberedEntry is a file whose stem ends in a number
+type numberedEntry struct {
+	name string
+	n    int
+}
+
+// renameIncrementExisting adds --by to the number at the end of the stem of every file of dir,
+// see pyrgear.IncrementName. Files are renamed from the highest number down when incrementing
+// and from the lowest up when decrementing, so every file moves to a name the previous rename
+// of the chain has vacated: shot_9 -> shot_10 runs before shot_8 -> shot_9.
+func renameIncrementExisting(dir string, entries []os.DirEntry, dryRun bool) error {
+	if incrementBy == 0 {
+		return fmt.Errorf("--by must not be 0 for increment-existing rule")
+	}
+
+	var files []numberedEntry
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if n, ok := pyrgear.TrailingNumber(entry.Name()); ok {
+			files = append(files, numberedEntry{name: entry.Name(), n: n})
+		}
+	}
+
+	// Equal numbers (shot_1.jpg and shot_1.png) keep the name order
+	sort.SliceStable(
+		files, func(i, j int) bool {
+			if incrementBy > 0 {
+				return files[i].n > files[j].n
+			}
+			return files[i].n < files[j].n
+		},
+	)
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		newName, ok := pyrgear.IncrementName(file.name, incrementBy)
+		if !ok {
+			fmt.Printf("Skipping %s: its number would drop below zero\n", path)
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+		renameFile(path, filepath.Join(dir, newName), dryRun)
+	}
+	return nil
+}
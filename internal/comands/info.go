@@ -0,0 +1,199 @@
+package comands
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	infoImagePath string
+	infoRecursive bool
+	infoFormat    string
+)
+
+// infoExtensions are the extensions of the formats whose header the info command decodes
+var infoExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".tif": true, ".tiff": true,
+}
+
+// imageInfo is what the header of an image tells about it
+type imageInfo struct {
+	Path       string `json:"path"`
+	Format     string `json:"format"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	ColorModel string `json:"color_model"`
+	Size       int64  `json:"size"`
+}
+
+// InfoCmd represents the info command
+var InfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show the dimensions, format, color model and size of images",
+	Long: `Show the dimensions, format, color model and file size of images, one line per image.
+Only the image header is read, so this is much faster than the exif command for quick
+surveys of a folder. JPEG, PNG, GIF, WebP and TIFF images are supported.
+
+Examples:
+  # One image
+  pyrgear info --image photo.jpg
+
+  # All images of a folder and its subfolders, as JSON
+  pyrgear info --dir ./photos --recursive --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if infoImagePath == "" && directory == "" {
+			fmt.Println("Error: either --image or --dir is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		images := []string{infoImagePath}
+		if infoImagePath == "" {
+			var err error
+			if images, err = collectInfoImages(directory, infoRecursive); err != nil {
+				fmt.Printf("Error processing directory: %v\n", err)
+				activeIssues.addError("info", directory, err)
+				return
+			}
+		}
+
+		out, finishOutput := startOutput(infoFormat)
+		defer finishOutput()
+		if err := writeImageInfos(out, readImageInfos(exifWarnings(out, infoFormat), images), infoFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	InfoCmd.Flags().StringVar(&infoImagePath, "image", "", "Path to a single image file")
+	InfoCmd.Flags().StringVar(&directory, "dir", "", "Directory containing image files")
+	InfoCmd.Flags().BoolVar(&infoRecursive, "recursive", false, "Process subdirectories recursively")
+	InfoCmd.Flags().StringVar(&infoFormat, "format", "text", "Output format: text or json")
+	addFilterFlags(InfoCmd)
+}
+
+// collectInfoImages returns the selected images of dir the info command supports
+func collectInfoImages(dir string, recursive bool) ([]string, error) {
+	var images []string
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			if infoExtensions[strings.ToLower(filepath.Ext(path))] {
+				images = append(images, path)
+			}
+		},
+	)
+	return images, err
+}
+
+// readImageInfos reads the header of every image, reporting the images that cannot be read
+// to warnings
+func readImageInfos(warnings io.Writer, images []string) []imageInfo {
+	infos := []imageInfo{}
+	for _, path := range images {
+		info, err := readImageInfo(path)
+		if err != nil {
+			fmt.Fprintf(warnings, "Warning: Failed to read %s: %v\n", path, err)
+			activeIssues.addWarning("info", path, err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// readImageInfo decodes the header of the image at path, without decoding its pixels
+func readImageInfo(path string) (imageInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return imageInfo{}, err
+	}
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	return imageInfo{
+		Path:       path,
+		Format:     strings.ToUpper(format),
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		ColorModel: colorModelName(cfg.ColorModel),
+		Size:       stat.Size(),
+	}, nil
+}
+
+// colorModelName returns the name of one of the color models of the image/color package
+func colorModelName(model color.Model) string {
+	switch model {
+	case color.RGBAModel:
+		return "RGBA"
+	case color.RGBA64Model:
+		return "RGBA64"
+	case color.NRGBAModel:
+		return "NRGBA"
+	case color.NRGBA64Model:
+		return "NRGBA64"
+	case color.AlphaModel:
+		return "Alpha"
+	case color.Alpha16Model:
+		return "Alpha16"
+	case color.GrayModel:
+		return "Gray"
+	case color.Gray16Model:
+		return "Gray16"
+	case color.CMYKModel:
+		return "CMYK"
+	case color.YCbCrModel:
+		return "YCbCr"
+	case color.NYCbCrAModel:
+		return "NYCbCrA"
+	}
+	if palette, ok := model.(color.Palette); ok {
+		return fmt.Sprintf("Paletted (%d colors)", len(palette))
+	}
+	return "unknown"
+}
+
+// writeImageInfos writes infos to w in the given format, a line per image for text:
+// photo.jpg: 4032x3024 JPEG YCbCr, 3.2 MiB
+func writeImageInfos(w io.Writer, infos []imageInfo, format string) error {
+	switch format {
+	case "text":
+		for _, info := range infos {
+			fmt.Fprintf(
+				w, "%s: %dx%d %s %s, %s\n",
+				info.Path, info.Width, info.Height, info.Format, info.ColorModel, formatSize(info.Size),
+			)
+		}
+		return nil
+	case "json":
+		data, err := marshalJSON(infos)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+}
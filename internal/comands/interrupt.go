@@ -0,0 +1,47 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// runInterrupted is set once the run received SIGINT, processors then stop like on too many errors
+var runInterrupted atomic.Bool
+
+// catchInterrupt makes the first SIGINT (Ctrl-C) stop the run gracefully instead of killing it:
+// processors take no new files, the files in progress are finished and the output is closed and
+// flushed as usual, so a JSON array still gets its closing bracket. A second SIGINT kills the
+// process. The returned function stops catching SIGINT and, if one was received, writes a note
+// about the truncated output to stderr and marks the run as failed.
+func catchInterrupt() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			runInterrupted.Store(true)
+			// Restore the default handling so a second Ctrl-C exits at once
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\nInterrupted, finishing the files in progress (press Ctrl-C again to quit at once)")
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		if runInterrupted.Load() {
+			fmt.Fprintln(os.Stderr, "Interrupted: the output is truncated, files not read before the interrupt are missing")
+			runFailed = true
+		}
+	}
+}
+
+// stopRequested reports whether processors should stop before the next file: the run was
+// interrupted or aborted for too many errors
+func stopRequested() bool {
+	return runInterrupted.Load() || activeErrorBudget.exceeded()
+}
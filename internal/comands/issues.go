@@ -0,0 +1,118 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// errorsOut is the file the issues of a run are written to
+var errorsOut string
+
+// activeIssues collects the errors and warnings of the current run when --errors-out is set
+var activeIssues *issueLog
+
+// runFailed is set when a run with --errors-out reported issues, a run with --report had
+// failures, its output failed --validate-output or could not be written, Execute then exits non-zero
+var runFailed bool
+
+// runIssue is an error or warning reported during a run
+type runIssue struct {
+	Level     string `json:"level"`
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Message   string `json:"message"`
+}
+
+// issueLog accumulates the issues of a run, it is safe for concurrent use
+type issueLog struct {
+	mu     sync.Mutex
+	issues []runIssue
+}
+
+// add records an issue, it is a no-op on a nil log
+func (l *issueLog) add(level string, operation string, path string, err error) {
+	if l == nil || err == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.issues = append(l.issues, runIssue{Level: level, Operation: operation, Path: path, Message: err.Error()})
+}
+
+// addError records an error, it is a no-op on a nil log. The error also counts
+// against --max-errors and --max-error-rate and is listed in the --report summary.
+func (l *issueLog) addError(operation string, path string, err error) {
+	if err != nil {
+		activeErrorBudget.countFailure(path, err)
+		activeReport.addError(operation, path, err)
+	}
+	l.add("error", operation, path, err)
+}
+
+// addWarning records a warning, it is a no-op on a nil log
+func (l *issueLog) addWarning(operation string, path string, err error) {
+	l.add("warning", operation, path, err)
+}
+
+// startIssues starts collecting issues if --errors-out or --keep-going is set, and counting
+// errors if --max-errors, --max-error-rate or --fail-fast is. The returned function lists the
+// errors on stderr for --keep-going, and for --errors-out writes the issues as a JSON array and
+// marks the run as failed if there were any.
+func startIssues() func() error {
+	finishBudget := startErrorBudget()
+	if errorsOut == "" && !keepGoing {
+		return func() error {
+			finishBudget()
+			return nil
+		}
+	}
+
+	activeIssues = &issueLog{}
+	return func() error {
+		finishBudget()
+		issues := activeIssues.issues
+		if issues == nil {
+			issues = []runIssue{}
+		}
+		activeIssues = nil
+		if keepGoing {
+			writeErrorSummary(os.Stderr, issues)
+		}
+		if errorsOut == "" {
+			return nil
+		}
+		if len(issues) > 0 {
+			runFailed = true
+		}
+
+		data, err := marshalJSON(issues)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(errorsOut, append(data, '\n'), 0644)
+	}
+}
+
+// writeErrorSummary lists the errors among issues, so a --keep-going run that printed them
+// between thousands of other lines still ends with all of them. Nothing is written without errors.
+func writeErrorSummary(w io.Writer, issues []runIssue) {
+	var errs []runIssue
+	for _, issue := range issues {
+		if issue.Level == "error" {
+			errs = append(errs, issue)
+		}
+	}
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Errors (%d):\n", len(errs))
+	for _, issue := range errs {
+		if issue.Path != "" {
+			fmt.Fprintf(w, "  %s: %s: %s\n", issue.Operation, issue.Path, issue.Message)
+		} else {
+			fmt.Fprintf(w, "  %s: %s\n", issue.Operation, issue.Message)
+		}
+	}
+}
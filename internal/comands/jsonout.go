@@ -0,0 +1,208 @@
+package comands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// JSON output style, see the --compact and --indent flags
+var (
+	jsonCompact bool
+	jsonIndent  int
+)
+
+// validateOutput re-parses JSON and ndjson written to stdout and fails the run if it is malformed
+var validateOutput bool
+
+// jsonIndentUnit returns the indentation of one JSON nesting level, empty for compact output
+func jsonIndentUnit() string {
+	if jsonCompact || jsonIndent <= 0 {
+		return ""
+	}
+	return strings.Repeat(" ", jsonIndent)
+}
+
+// marshalJSON encodes v as pretty-printed or compact JSON, following --compact and --indent
+func marshalJSON(v any) ([]byte, error) {
+	if unit := jsonIndentUnit(); unit != "" {
+		return json.MarshalIndent(v, "", unit)
+	}
+	return json.Marshal(v)
+}
+
+// jsonArray writes a JSON array to w one element at a time, as the elements come, formatted
+// as marshalJSON formats the whole array, so a long list is never held in memory. It keeps
+// the first write or encoding error, returned by end.
+type jsonArray struct {
+	w     io.Writer
+	count int
+	err   error
+}
+
+// begin writes the opening bracket
+func (a *jsonArray) begin() {
+	a.write("[")
+}
+
+// add encodes v and writes it as the next element
+func (a *jsonArray) add(v any) {
+	unit := jsonIndentUnit()
+	var data []byte
+	var err error
+	if unit != "" {
+		data, err = json.MarshalIndent(v, unit, unit)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		if a.err == nil {
+			a.err = err
+		}
+		return
+	}
+	if a.count > 0 {
+		a.write(",")
+	}
+	a.count++
+	a.write(jsonNewline(1) + string(data))
+}
+
+// end writes the closing bracket and returns the first error
+func (a *jsonArray) end() error {
+	if a.count > 0 {
+		a.write(jsonNewline(0))
+	}
+	a.write("]\n")
+	return a.err
+}
+
+// write writes s unless a write failed before
+func (a *jsonArray) write(s string) {
+	if a.err != nil {
+		return
+	}
+	_, a.err = io.WriteString(a.w, s)
+}
+
+// jsonNewline returns the line break and indentation before an element at the given
+// nesting level of hand-written JSON, nothing for compact output
+func jsonNewline(level int) string {
+	unit := jsonIndentUnit()
+	if unit == "" {
+		return ""
+	}
+	return "\n" + strings.Repeat(unit, level)
+}
+
+// jsonColon returns the separator between an object key and its value
+func jsonColon() string {
+	if jsonIndentUnit() == "" {
+		return ":"
+	}
+	return ": "
+}
+
+// outputWriter is the buffered stdout of a command
+type outputWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// startOutput returns a buffered writer to stdout for output in format, and the function to
+// call when the command is done. It flushes the output, reporting an error and failing the run
+// if that fails, and, with --validate-output and a
+// JSON format, checks that everything written parses, reporting an error and failing the run
+// otherwise.
+func startOutput(format string) (outputWriter, func()) {
+	out := bufio.NewWriter(os.Stdout)
+	flush := func() {
+		if err := out.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to write output: %v\n", err)
+			activeIssues.addError("write", "stdout", err)
+			runFailed = true
+		}
+	}
+	if !validateOutput || (format != "json" && format != "ndjson") {
+		return out, flush
+	}
+
+	v := &jsonValidator{w: out, ndjson: format == "ndjson"}
+	return v, func() {
+		flush()
+		if err := v.check(); err != nil {
+			err = fmt.Errorf("invalid %s output: %v", format, err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			activeIssues.addError("validate-output", "stdout", err)
+			runFailed = true
+		}
+	}
+}
+
+// jsonValidator passes output through to w and keeps what is needed to check it: every
+// line of ndjson is checked once complete, JSON is checked as a whole by check
+type jsonValidator struct {
+	w      outputWriter
+	ndjson bool
+	// buf is the output so far for JSON, the incomplete last line for ndjson
+	buf  []byte
+	line int
+	err  error
+}
+
+func (v *jsonValidator) Write(p []byte) (int, error) {
+	n, err := v.w.Write(p)
+	v.buf = append(v.buf, p[:n]...)
+	if v.ndjson {
+		for {
+			i := bytes.IndexByte(v.buf, '\n')
+			if i < 0 {
+				break
+			}
+			v.checkLine(v.buf[:i])
+			v.buf = append(v.buf[:0], v.buf[i+1:]...)
+		}
+	}
+	return n, err
+}
+
+func (v *jsonValidator) Flush() error {
+	return v.w.Flush()
+}
+
+// checkLine checks the next ndjson line, empty lines are allowed
+func (v *jsonValidator) checkLine(line []byte) {
+	v.line++
+	if v.err == nil && len(bytes.TrimSpace(line)) > 0 && !json.Valid(line) {
+		v.err = fmt.Errorf("line %d is not valid JSON", v.line)
+	}
+}
+
+// check returns the first problem found in the output. JSON output may hold several
+// documents, e.g. one per image, but each must be complete and valid.
+func (v *jsonValidator) check() error {
+	if v.ndjson {
+		if len(v.buf) > 0 {
+			v.checkLine(v.buf)
+			v.buf = nil
+		}
+		return v.err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(v.buf))
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
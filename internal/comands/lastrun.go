@@ -0,0 +1,127 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	sinceLastRun bool
+	lastRunFile  string
+)
+
+// lastRunCutoff is the start of the last successful run while --since-last-run is set,
+// files modified before it are not selected. It is zero otherwise.
+var lastRunCutoff time.Time
+
+// lastRunState remembers when each command last ran successfully on each directory
+type lastRunState struct {
+	path string
+	// Dirs maps absolute directories to the last run of each command on them
+	Dirs map[string]map[string]*lastRun `json:"dirs"`
+}
+
+// lastRun is the last successful run of a command on a directory
+type lastRun struct {
+	// Rule tells apart the runs of a command, e.g. the rename rule, a run with another rule starts over
+	Rule string    `json:"rule,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// defaultLastRunFile returns the last run file used without --last-run-file
+func defaultLastRunFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the user config directory, use --last-run-file: %v", err)
+	}
+	return filepath.Join(dir, "pyrgear", "last-run.json"), nil
+}
+
+// loadLastRunState reads the last run file at path, a missing file yields an empty state
+func loadLastRunState(path string) (*lastRunState, error) {
+	st := &lastRunState{path: path, Dirs: map[string]map[string]*lastRun{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last run file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse last run file %s: %v", path, err)
+	}
+	if st.Dirs == nil {
+		st.Dirs = map[string]map[string]*lastRun{}
+	}
+	return st, nil
+}
+
+// save writes the state back to the file it was loaded from, creating its directory
+func (s *lastRunState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// startSinceLastRun restricts the selection to the files of dir modified since the last
+// successful run of command with rule, when --since-last-run is set. The returned function
+// must be called at the end of the run with whether it succeeded: it records successful
+// runs, except dry runs, with the time this run started so files that change during the
+// run are picked up next time.
+func startSinceLastRun(command string, dir string, rule string) (func(ok bool), error) {
+	if !sinceLastRun {
+		return func(bool) {}, nil
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("--since-last-run requires a directory")
+	}
+
+	path := lastRunFile
+	if path == "" {
+		var err error
+		if path, err = defaultLastRunFile(); err != nil {
+			return nil, err
+		}
+	}
+	st, err := loadLastRunState(path)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	started := time.Now()
+	if run := st.Dirs[dir][command]; run != nil && run.Rule == rule {
+		lastRunCutoff = run.Time
+	}
+
+	return func(ok bool) {
+		lastRunCutoff = time.Time{}
+		if !ok || dryRun {
+			return
+		}
+		if st.Dirs[dir] == nil {
+			st.Dirs[dir] = map[string]*lastRun{}
+		}
+		st.Dirs[dir][command] = &lastRun{Rule: rule, Time: started}
+		if err := st.save(); err != nil {
+			fmt.Printf("Error writing last run file: %v\n", err)
+		}
+	}, nil
+}
+
+// modifiedSinceLastRun reports whether a file with the given info was modified since the
+// last successful run, always true without --since-last-run or a recorded run
+func modifiedSinceLastRun(info os.FileInfo) bool {
+	return lastRunCutoff.IsZero() || !info.ModTime().Before(lastRunCutoff)
+}
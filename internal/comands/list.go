@@ -0,0 +1,128 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	listRecursive bool
+	listFormat    string
+)
+
+// listedFile is a file selected by the list command
+type listedFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// ListCmd represents the list command
+var ListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the files the selection filters would select",
+	Long: `List the files of a directory that the selection filters select, without touching them.
+The filters are the same as those of the rename and exif commands, so a filter can be
+previewed here before running it.
+
+Examples:
+  # List the JPEGs of at least 1MB
+  pyrgear list --dir . --include '*.jpg' --min-size 1MB --recursive
+
+  # List the files modified in 2024 as CSV
+  pyrgear list --dir ./photos --modified-after 2024-01-01 --modified-before 2025-01-01 --format csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if directory == "" {
+			fmt.Println("Error: --dir is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		finishLastRun, err := startSinceLastRun("list", directory, "")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		files, err := listFiles(directory, listRecursive)
+		finishLastRun(err == nil)
+		if err != nil {
+			fmt.Printf("Error listing directory: %v\n", err)
+			activeIssues.addError("list", directory, err)
+			return
+		}
+
+		out, finishOutput := startOutput(listFormat)
+		defer finishOutput()
+		if err := writeListedFiles(out, files, listFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	ListCmd.Flags().StringVar(&directory, "dir", "", "Directory to list files from")
+	ListCmd.Flags().BoolVar(&listRecursive, "recursive", false, "List subdirectories recursively")
+	ListCmd.Flags().StringVar(&listFormat, "format", "text", "Output format: text, json or csv")
+	addCSVFlags(ListCmd)
+	addFilterFlags(ListCmd)
+}
+
+// listFiles returns the files of dir selected by the filters
+func listFiles(dir string, recursive bool) ([]listedFile, error) {
+	var files []listedFile
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			info, err := entry.Info()
+			if err != nil {
+				fmt.Printf("Warning: Failed to stat %s: %v\n", path, err)
+				activeIssues.addWarning("list", path, err)
+				return
+			}
+			files = append(files, listedFile{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+		},
+	)
+	return files, err
+}
+
+// writeListedFiles writes files to w in the given format
+func writeListedFiles(w io.Writer, files []listedFile, format string) error {
+	switch format {
+	case "text":
+		for _, f := range files {
+			fmt.Fprintf(w, "%s  %10s  %s\n", f.ModTime.Format("2006-01-02 15:04:05"), formatSize(f.Size), f.Path)
+		}
+		return nil
+	case "json":
+		if files == nil {
+			files = []listedFile{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", jsonIndentUnit())
+		return enc.Encode(files)
+	case "csv":
+		cw, err := newCSVWriter(w)
+		if err != nil {
+			return err
+		}
+		cw.Write([]string{"path", "size", "mtime"})
+		for _, f := range files {
+			cw.Write([]string{f.Path, strconv.FormatInt(f.Size, 10), f.ModTime.Format(time.RFC3339)})
+		}
+		return cw.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json, csv)", format)
+	}
+}
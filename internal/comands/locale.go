@@ -0,0 +1,34 @@
+package comands
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+// caseLocale is the language whose case rules the lowercase, uppercase and title-case rules apply
+var caseLocale string
+
+// smallWords are the words the title-case rule keeps lowercase within a name
+var smallWords []string
+
+// defaultSmallWords are the --small-words of English titles
+var defaultSmallWords = []string{"a", "an", "the", "of", "and", "in"}
+
+// parseLocale returns the language tag of locale, an empty locale selects locale-independent rules
+func parseLocale(locale string) (language.Tag, error) {
+	if locale == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, fmt.Errorf("invalid locale %s: %v", locale, err)
+	}
+	return tag, nil
+}
+
+// caseLanguage returns the language tag of --locale, falling back to locale-independent rules
+func caseLanguage() language.Tag {
+	tag, _ := parseLocale(caseLocale)
+	return tag
+}
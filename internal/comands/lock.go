@@ -0,0 +1,227 @@
+package comands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// useLock keeps other pyrgear runs out of the target directory while renaming
+	useLock bool
+	// lockWait is how long --lock waits for the lock of another run to be released
+	lockWait time.Duration
+	// lockStale is the age after which the lock of a run that is no longer seen is taken over
+	lockStale time.Duration
+)
+
+// lockFileName is the lock file --lock creates in the target directory. It is never renamed
+// with the files of the directory, see withoutLockFile.
+const lockFileName = ".pyrgear.lock"
+
+// lockPollInterval is how often a run waiting for --lock-wait checks the lock again
+const lockPollInterval = 500 * time.Millisecond
+
+// lockTakeoverSuffix names the file a run creates next to the lock file while it takes over a
+// stale lock, so that only one run at a time removes it, see takeOverDirLock
+const lockTakeoverSuffix = ".takeover"
+
+// lockTakeoverTimeout is the age after which the takeover file of a run that crashed while
+// taking over a lock is removed. Taking over takes milliseconds.
+const lockTakeoverTimeout = 10 * time.Second
+
+// lockInfo is the content of the lock file, to tell the user who holds the lock
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// dirLock is a lock held on a directory with acquireDirLock
+type dirLock struct {
+	path string
+	file *os.File
+	done chan struct{}
+}
+
+// acquireDirLock locks dir against other pyrgear runs by creating its lock file exclusively,
+// which works on network filesystems where os.Rename is not atomic, and holding an flock on
+// it where the platform supports one. If another run holds the lock, acquireDirLock waits up
+// to wait for it. A lock whose holder is gone is taken over: one not flocked and written by
+// a process of this host that no longer exists, or one that has not been refreshed for stale,
+// see takeOverDirLock.
+// The holder refreshes the modification time of the lock file while it runs, so long runs on
+// filesystems without flock are not taken for stale.
+func acquireDirLock(dir string, wait, stale time.Duration) (*dirLock, error) {
+	path := filepath.Join(dir, lockFileName)
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := createDirLock(path, stale)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+
+		info, judged, isStale := inspectDirLock(path, stale)
+		if isStale {
+			if err := takeOverDirLock(path, judged, stale); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf(
+				"%s is locked by another pyrgear run (pid %d on %s, since %s); wait for it to finish or use "+
+					"--lock-wait, and remove %s if that run is gone",
+				dir, info.PID, info.Host, info.Started.Format(time.DateTime), path,
+			)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// createDirLock creates the lock file at path, failing with fs.ErrExist if it exists
+func createDirLock(path string, stale time.Duration) (*dirLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Filesystems without flock only have the lock file itself
+	_ = flockFile(file)
+
+	host, _ := os.Hostname()
+	info := lockInfo{PID: os.Getpid(), Host: host, Started: time.Now()}
+	if err := json.NewEncoder(file).Encode(info); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	lock := &dirLock{path: path, file: file, done: make(chan struct{})}
+	go lock.refresh(stale / 3)
+	return lock, nil
+}
+
+// takeOverDirLock removes the stale lock file at path, judged the file inspectDirLock found
+// stale. Removing it only while holding the takeover file, and only if path still is that
+// file and still stale, keeps two waiters from both taking over: the second would otherwise
+// remove the fresh lock the first created after removing the stale one. If another run holds
+// the takeover file, takeOverDirLock leaves the lock to it and returns, to try again.
+func takeOverDirLock(path string, judged os.FileInfo, stale time.Duration) error {
+	guard := path + lockTakeoverSuffix
+	file, err := os.OpenFile(guard, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		if info, err := os.Stat(guard); err == nil && time.Since(info.ModTime()) > lockTakeoverTimeout {
+			os.Remove(guard)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create lock file %s: %v", guard, err)
+	}
+	file.Close()
+	defer os.Remove(guard)
+
+	info, current, isStale := inspectDirLock(path, stale)
+	if !isStale || !os.SameFile(judged, current) {
+		// Released or taken over by another run since it was inspected
+		return nil
+	}
+	fmt.Printf(
+		"Warning: Removing the stale lock of pyrgear (pid %d on %s) in %s\n", info.PID, info.Host, filepath.Dir(path),
+	)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale lock file %s: %v", path, err)
+	}
+	return nil
+}
+
+// refresh touches the lock file every interval until the lock is released
+func (l *dirLock) refresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			os.Chtimes(l.path, now, now)
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// release removes the lock file, unless it was taken over by another run in the meantime.
+// It is a no-op on a nil lock.
+func (l *dirLock) release() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	held, err := l.file.Stat()
+	current, currentErr := os.Stat(l.path)
+	if err == nil && currentErr == nil && os.SameFile(held, current) {
+		os.Remove(l.path)
+	}
+	l.file.Close()
+}
+
+// inspectDirLock reads the lock file at path and reports whether it is stale, see acquireDirLock,
+// along with the file it read. A lock being written or otherwise unreadable is stale only by age.
+func inspectDirLock(path string, stale time.Duration) (lockInfo, os.FileInfo, bool) {
+	var info lockInfo
+	file, err := os.Open(path)
+	if err != nil {
+		// Released in the meantime, the next attempt creates it
+		return info, nil, false
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return info, nil, false
+	}
+	readErr := json.NewDecoder(file).Decode(&info)
+	if flockHeld(file) {
+		return info, stat, false
+	}
+	if stale > 0 && time.Since(stat.ModTime()) > stale {
+		return info, stat, true
+	}
+	host, _ := os.Hostname()
+	return info, stat, readErr == nil && info.Host == host && info.PID != os.Getpid() && !processAlive(info.PID)
+}
+
+// withoutLockFile returns entries without the lock file of --lock and its takeover file
+func withoutLockFile(entries []os.DirEntry) []os.DirEntry {
+	kept := entries[:0:0]
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (name != lockFileName && name != lockFileName+lockTakeoverSuffix) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// renameLockDir returns the directory --lock locks for the rename command: the output directory
+// of wx-exporter, otherwise --dir or --pdir
+func renameLockDir() string {
+	switch {
+	case strings.EqualFold(ruleType, "wx-exporter"):
+		return outputDir
+	case directory != "":
+		return directory
+	default:
+		return parentDir
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package comands
+
+import "os"
+
+// flockFile does nothing where flock is not available, the lock file alone is the lock
+func flockFile(file *os.File) error {
+	return nil
+}
+
+// flockHeld reports false where flock is not available
+func flockHeld(file *os.File) bool {
+	return false
+}
+
+// processAlive reports true where processes cannot be checked, the lock then only goes stale by age
+func processAlive(pid int) bool {
+	return true
+}
+
+// lockFileAppend does nothing where flock is not available, appends rely on O_APPEND alone
+func lockFileAppend(file *os.File) func() {
+	return func() {}
+}
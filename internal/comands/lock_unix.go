@@ -0,0 +1,44 @@
+//go:build unix
+
+package comands
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flockFile takes an exclusive flock on file, held until it is closed
+func flockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// flockHeld reports whether another open file holds an flock on the file of file
+func flockHeld(file *os.File) bool {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return false
+	}
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+// processAlive reports whether a process with pid exists on this host
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// lockFileAppend blocks until it holds an exclusive flock on file and returns the function
+// releasing it. Filesystems without flock rely on O_APPEND alone.
+func lockFileAppend(file *os.File) func() {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return func() {}
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}
+}
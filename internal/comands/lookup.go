@@ -0,0 +1,100 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// readLookupTable reads the key,name pairs of the --mapping CSV file of the lookup rule.
+// A first row of key,name (or old_name,new_name) is taken as a header and skipped. Keys
+// listed twice and empty names are errors, as the table could not be applied as intended.
+func readLookupTable(path string) (map[string]string, error) {
+	entries, err := readRenameMapping(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 && entries[0].line == 1 &&
+		strings.EqualFold(entries[0].oldName, "key") && strings.EqualFold(entries[0].newName, "name") {
+		entries = entries[1:]
+	}
+
+	table := make(map[string]string, len(entries))
+	lines := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		if line, ok := lines[entry.oldName]; ok {
+			return nil, fmt.Errorf("%s line %d: key %q is already listed on line %d", path, entry.line, entry.oldName, line)
+		}
+		if strings.TrimSpace(entry.newName) == "" {
+			return nil, fmt.Errorf("%s line %d: empty name for key %q", path, entry.line, entry.oldName)
+		}
+		lines[entry.oldName] = entry.line
+		table[entry.oldName] = entry.newName
+	}
+	return table, nil
+}
+
+// lookupKey returns the key --pattern extracts from stem: the first capturing group, or the
+// whole match for patterns without groups. It reports false if the stem does not match.
+func lookupKey(re *regexp.Regexp, stem string) (string, bool) {
+	match := re.FindStringSubmatch(stem)
+	if match == nil {
+		return "", false
+	}
+	if len(match) > 1 {
+		return match[1], true
+	}
+	return match[0], true
+}
+
+// renameByLookup renames the files of dir to the name the --mapping table lists for the key
+// --pattern extracts from their stem, keeping their extension: with --pattern 'ID(\d+)' and a
+// row 1234,Jane Doe, scan_ID1234.jpg becomes Jane Doe.jpg. Files the pattern does not match
+// are left alone, files whose key is not in the table are skipped with a warning.
+func renameByLookup(dir string, entries []os.DirEntry, dryRun bool) error {
+	if pattern == "" {
+		return fmt.Errorf("--pattern is required for lookup rule")
+	}
+	if mappingPath == "" {
+		return fmt.Errorf("--mapping is required for lookup rule")
+	}
+	re, err := compilePattern(pattern, ignoreCase)
+	if err != nil {
+		return fmt.Errorf("invalid --pattern: %v", err)
+	}
+	table, err := readLookupTable(mappingPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		oldPath := filepath.Join(dir, entry.Name())
+		stem, ext := pyrgear.SplitExt(entry.Name())
+		key, ok := lookupKey(re, stem)
+		if !ok {
+			continue
+		}
+		name, ok := table[key]
+		if !ok {
+			err := fmt.Errorf("key %q is not in %s", key, mappingPath)
+			fmt.Printf("Warning: skipping %s: %v\n", oldPath, err)
+			activeIssues.addWarning("rename", oldPath, err)
+			continue
+		}
+
+		// Files renamed by an earlier run already carry the name of their key
+		newName := name + ext
+		if newName == entry.Name() {
+			continue
+		}
+		renameFile(oldPath, filepath.Join(dir, newName), dryRun)
+	}
+	return nil
+}
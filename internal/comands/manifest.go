@@ -0,0 +1,116 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// activeManifest collects the renames of the current run when --manifest is set
+var activeManifest *renameManifest
+
+// manifestIncludeExif embeds the EXIF values of each renamed image in the manifest
+var manifestIncludeExif bool
+
+// manifestEntry is a single performed rename
+type manifestEntry struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Meta holds the EXIF values of the file, recorded with --manifest-include-exif
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// renameManifest records performed renames so that a run can be undone
+type renameManifest struct {
+	Rule    string          `json:"rule,omitempty"`
+	Created time.Time       `json:"created"`
+	Entries []manifestEntry `json:"entries"`
+}
+
+// newRenameManifest creates an empty manifest for the given rule
+func newRenameManifest(rule string) *renameManifest {
+	return &renameManifest{
+		Rule:    rule,
+		Created: time.Now(),
+		Entries: []manifestEntry{},
+	}
+}
+
+// record adds a rename to the manifest, it is a no-op on a nil manifest
+func (m *renameManifest) record(oldPath, newPath string, meta map[string]string) {
+	if m == nil {
+		return
+	}
+
+	// Store absolute paths so the manifest can be undone from any working directory
+	if abs, err := filepath.Abs(oldPath); err == nil {
+		oldPath = abs
+	}
+	if abs, err := filepath.Abs(newPath); err == nil {
+		newPath = abs
+	}
+	m.Entries = append(m.Entries, manifestEntry{Old: oldPath, New: newPath, Meta: meta})
+}
+
+// save writes the manifest as JSON to path
+func (m *renameManifest) save(path string) error {
+	data, err := marshalJSON(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadRenameManifest reads a manifest written by save
+func loadRenameManifest(path string) (*renameManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %v", path, err)
+	}
+
+	var m renameManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// processUndo restores the original names recorded in a manifest
+func processUndo(path string, dryRun bool) error {
+	m, err := loadRenameManifest(path)
+	if err != nil {
+		return err
+	}
+
+	// Undo in reverse order so chained renames are restored correctly
+	for i := len(m.Entries) - 1; i >= 0; i-- {
+		entry := m.Entries[i]
+
+		if _, err := os.Stat(entry.New); err != nil {
+			fmt.Printf("Warning: %s no longer exists, skipping\n", entry.New)
+			continue
+		}
+		if pyrgear.CheckCollision(entry.New, entry.Old) != nil {
+			fmt.Printf("Warning: %s already exists, skipping\n", entry.Old)
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("Would restore: %s\n", renameArrow(entry.New, entry.Old))
+		} else {
+			fmt.Printf("Restoring: %s -> %s\n", entry.New, entry.Old)
+			if err := pyrgear.Rename(entry.New, entry.Old); err != nil {
+				fmt.Printf("Error restoring %s: %v\n", entry.New, err)
+				activeIssues.addError("undo", entry.New, err)
+				continue
+			}
+			activeRenameLog.record(entry.New, entry.Old)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package comands
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mappingPath is the CSV file of old_name,new_name pairs applied by the from-csv rule
+var mappingPath string
+
+// mappingEntry is a rename of the --mapping file
+type mappingEntry struct {
+	line    int
+	oldName string
+	newName string
+}
+
+// readRenameMapping reads the old_name,new_name pairs of the CSV file at path.
+// A first row of old_name,new_name is taken as a header and skipped.
+func readRenameMapping(path string) ([]mappingEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mapping file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	var entries []mappingEntry
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mapping file %s: %v", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(entries) == 0 && line == 1 &&
+			strings.EqualFold(row[0], "old_name") && strings.EqualFold(row[1], "new_name") {
+			continue
+		}
+		entries = append(entries, mappingEntry{line: line, oldName: row[0], newName: row[1]})
+	}
+	return entries, nil
+}
+
+// renameFromMapping applies the renames listed in --mapping, in file order. Relative paths
+// are relative to dir. All entries are checked before anything is renamed: entries whose
+// source is missing, whose source or target is listed twice, or whose new name is empty are
+// reported and skipped. Targets that already exist are reported when the entry is applied.
+func renameFromMapping(dir string, dryRun bool) error {
+	if mappingPath == "" {
+		return fmt.Errorf("--mapping is required for from-csv rule")
+	}
+	entries, err := readRenameMapping(mappingPath)
+	if err != nil {
+		return err
+	}
+
+	resolve := func(name string) string {
+		if filepath.IsAbs(name) {
+			return filepath.Clean(name)
+		}
+		return filepath.Join(dir, name)
+	}
+
+	type rename struct{ oldPath, newPath string }
+	var renames []rename
+	sources := make(map[string]int)
+	targets := make(map[string]int)
+	for _, entry := range entries {
+		oldPath, newPath := resolve(entry.oldName), resolve(entry.newName)
+
+		var err error
+		if line, ok := sources[oldPath]; ok {
+			err = fmt.Errorf("%s is already renamed on line %d", oldPath, line)
+		} else if line, ok := targets[newPath]; ok {
+			err = fmt.Errorf("%w: %s is also the target of line %d", ErrCollision, newPath, line)
+		} else if strings.TrimSpace(entry.newName) == "" {
+			err = fmt.Errorf("empty new name for %s", oldPath)
+		} else if _, statErr := os.Lstat(oldPath); statErr != nil {
+			err = fmt.Errorf("source does not exist: %s", oldPath)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s line %d: %w", mappingPath, entry.line, err)
+			fmt.Printf("Error: %v\n", err)
+			activeIssues.addError("rename", oldPath, err)
+			activePlan.reject()
+			continue
+		}
+
+		sources[oldPath] = entry.line
+		targets[newPath] = entry.line
+		renames = append(renames, rename{oldPath, newPath})
+	}
+
+	for _, r := range renames {
+		renameFile(r.oldPath, r.newPath, dryRun)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// maxFiles is the most files a run may process, 0 for no limit
+	maxFiles int
+	// force runs even if more files than --max-files match
+	force bool
+)
+
+// checkMaxFiles returns ErrTooManyFiles if count is over --max-files, unless --force is set
+func checkMaxFiles(count int) error {
+	if maxFiles <= 0 || count <= maxFiles || force {
+		return nil
+	}
+	return fmt.Errorf(
+		"%w: %d files match, more than --max-files %d, nothing was changed (use --force to process them anyway)",
+		ErrTooManyFiles, count, maxFiles,
+	)
+}
+
+// checkRenameLimit counts the files a rename would process in dirs before anything is renamed,
+// see countMatchedFiles, and reports whether they are within --max-files. It reports the error otherwise.
+// The count is returned as the total for startProgress, -1 if neither --max-files nor the
+// progress needed it.
+func checkRenameLimit(dirs []string, recursive bool, re *regexp.Regexp) (int, bool) {
+	limited := maxFiles > 0 && !force
+	if !limited && !progressEnabled() {
+		return -1, true
+	}
+
+	count := 0
+	for _, dir := range dirs {
+		n, err := countMatchedFiles(dir, recursive, re)
+		if err != nil {
+			// The rename reports the directory itself
+			continue
+		}
+		count += n
+	}
+	if !limited {
+		return count, true
+	}
+	if err := checkMaxFiles(count); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		activeIssues.addError("rename", dirs[0], err)
+		return count, false
+	}
+	return count, true
+}
+
+// countMatchedFiles counts the selected files of dir, only those whose name re matches
+// (or whose stem with --stem-only) if re is not nil
+func countMatchedFiles(dir string, recursive bool, re *regexp.Regexp) (int, error) {
+	count := 0
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			name := entry.Name()
+			if stemOnly {
+				name, _ = pyrgear.SplitExt(name)
+			}
+			if re == nil || re.MatchString(name) {
+				count++
+			}
+		},
+	)
+	return count, err
+}
@@ -0,0 +1,36 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// nameDateOrder is the order date-from-filename reads ambiguous day and month in: dmy or mdy
+var nameDateOrder string
+
+// renameByNameDate rewrites the date in the name of each file of dir to YYYY-MM-DD, keeping the
+// rest of the name, so an archive named 20230615, 2023_06_15 and 15.06.2023 sorts as one, see
+// pyrgear.NormalizeNameDate. Files without a date in their name, or with one already written
+// that way, are skipped.
+func renameByNameDate(dir string, entries []os.DirEntry, dryRun bool) error {
+	if nameDateOrder != "dmy" && nameDateOrder != "mdy" {
+		return fmt.Errorf("unknown --name-date-order: %s (supported: dmy, mdy)", nameDateOrder)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || alreadyApplied("date-from-filename", entry.Name()) {
+			continue
+		}
+		newName, ok := pyrgear.NormalizeNameDate(entry.Name(), nameDateOrder == "dmy")
+		if !ok {
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+		renameFile(filepath.Join(dir, entry.Name()), filepath.Join(dir, newName), dryRun)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package comands
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// datedFile is a file with the time it was taken or last modified
+type datedFile struct {
+	path string
+	time time.Time
+}
+
+// renameNumberedByDate numbers the files of dir, and of its subdirectories when recursive,
+// as one set ordered by EXIF date (modification time for files without one). The numbers
+// are zero-padded to the number of digits of the total count. Files numbered by a previous
+// run are kept, new files continue after the highest number.
+func renameNumberedByDate(dir string, recursive bool, dryRun bool) error {
+	// First collect every file, numbering needs the whole set
+	var files []datedFile
+	last, width := 0, 0
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			if n, ok := pyrgear.NumberedIndex(sequenceName, entry.Name()); ok {
+				last = max(last, n)
+				width = max(width, len(entry.Name())-len(filepath.Ext(entry.Name()))-len(numberedPrefix()))
+				return
+			}
+			files = append(files, datedFile{path: path, time: fileDate(path, entry)})
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Equal times keep the path order of the walk
+	sort.SliceStable(
+		files, func(i, j int) bool {
+			return files[i].time.Before(files[j].time)
+		},
+	)
+
+	width = max(width, len(strconv.Itoa(last+len(files))))
+	for i, file := range files {
+		newName := pyrgear.NumberedName(sequenceName, last+i+1, width, filepath.Ext(file.path))
+		renameFile(file.path, filepath.Join(filepath.Dir(file.path), newName), dryRun)
+	}
+	return nil
+}
+
+// numberedPrefix returns the part of a numbered-by-date name before the number
+func numberedPrefix() string {
+	if sequenceName == "" {
+		return ""
+	}
+	return sequenceName + "_"
+}
+
+// fileDate returns the EXIF date of an image, or the modification time of other files
+func fileDate(path string, entry os.DirEntry) time.Time {
+	if pyrgear.IsEXIFImage(path) {
+		if record, err := loadExifRecord(path); err == nil {
+			if taken, ok := exifTime(record); ok {
+				return taken
+			}
+		}
+	}
+	if info, err := entry.Info(); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,126 @@
+package comands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// ocrCmd is the command the ocr-date rule runs to get the text of a scan, {} is the file
+	ocrCmd string
+	// ocrDateOrder is the order of day and month in numeric dates of the text: dmy or mdy
+	ocrDateOrder string
+)
+
+// ocrTimeout is how long the --ocr-cmd of a single file may run
+const ocrTimeout = 2 * time.Minute
+
+// ocrExtensions are the extensions of the scans the ocr-date rule reads
+var ocrExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".tif": true, ".tiff": true, ".bmp": true, ".gif": true,
+	".webp": true, ".pdf": true,
+}
+
+// ocrCommand returns the program and arguments of --ocr-cmd for path: {} is replaced with
+// path, which is appended if the command has no {}. The command is split at spaces, without
+// a shell, so paths with spaces or quotes are passed as they are.
+func ocrCommand(command string, path string) (string, []string) {
+	fields := strings.Fields(command)
+	replaced := false
+	for i, field := range fields {
+		if strings.Contains(field, "{}") {
+			fields[i] = strings.ReplaceAll(field, "{}", path)
+			replaced = true
+		}
+	}
+	if !replaced {
+		fields = append(fields, path)
+	}
+	return fields[0], fields[1:]
+}
+
+// ocrText returns the text --ocr-cmd writes to stdout for the file at path
+func ocrText(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+
+	name, args := ocrCommand(ocrCmd, path)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("--ocr-cmd did not finish within %s", ocrTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("--ocr-cmd failed: %v: %s", err, msg)
+		}
+		return "", fmt.Errorf("--ocr-cmd failed: %v", err)
+	}
+	return stdout.String(), nil
+}
+
+// renameByOCRDate renames the scans of dir to the first date written in them, YYYYMMDD_000000,
+// for documents such as receipts that have no EXIF date. The text of each scan is read with
+// --ocr-cmd, e.g. tesseract, which is run once per file and therefore slow. Scans whose text
+// has no date are named after their modification time instead, with a warning. Scans that get
+// the same name are numbered -1, -2, ... in filename order. Files already named by date are
+// left alone.
+func renameByOCRDate(dir string, entries []os.DirEntry, dryRun bool) error {
+	if len(strings.Fields(ocrCmd)) == 0 {
+		return fmt.Errorf("--ocr-cmd is required for ocr-date rule, e.g. --ocr-cmd 'tesseract {} stdout'")
+	}
+	if ocrDateOrder != "dmy" && ocrDateOrder != "mdy" {
+		return fmt.Errorf("unknown --ocr-date-order: %s (supported: dmy, mdy)", ocrDateOrder)
+	}
+
+	// Names given to earlier scans of this run
+	taken := make(map[string]bool)
+
+	for _, entry := range entries {
+		_, ext := pyrgear.SplitExt(entry.Name())
+		if entry.IsDir() || !ocrExtensions[strings.ToLower(ext)] || alreadyApplied("ocr-date", entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		text, err := ocrText(path)
+		if err != nil {
+			fmt.Printf("Error reading the text of %s: %v\n", path, err)
+			activeIssues.addError("ocr", path, err)
+			stepProgress()
+			continue
+		}
+		t, ok := pyrgear.FindDate(text, ocrDateOrder == "dmy", time.Local)
+		if !ok {
+			info, err := entry.Info()
+			if err != nil {
+				fmt.Printf("Skipping %s: %v\n", path, err)
+				activeReport.countSkipped()
+				stepProgress()
+				continue
+			}
+			t = info.ModTime()
+			err = fmt.Errorf("no date found in the text of %s, named after its modification time", entry.Name())
+			fmt.Printf("Warning: %v\n", err)
+			activeIssues.addWarning("rename", path, err)
+		}
+
+		newName := pyrgear.DateName(t, false, 0, ext)
+		for n := 1; taken[newName] || exists(filepath.Join(dir, newName)); n++ {
+			newName = pyrgear.DateName(t, false, n, ext)
+		}
+		taken[newName] = true
+
+		renameFile(path, filepath.Join(dir, newName), dryRun)
+	}
+	return nil
+}
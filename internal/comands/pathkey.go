@@ -0,0 +1,57 @@
+package comands
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+var (
+	// exifPathKeyMode is how the path of each record is written: basename, relative or
+	// absolute, empty for the path as it was found
+	exifPathKeyMode string
+	// exifRelativeTo is the directory relative paths are taken from
+	exifRelativeTo string
+)
+
+// checkPathKey returns an error for unknown --path-key values
+func checkPathKey(mode string) error {
+	switch mode {
+	case "", "basename", "relative", "absolute":
+		return nil
+	default:
+		return fmt.Errorf("unknown path key: %s (supported: basename, relative, absolute)", mode)
+	}
+}
+
+// exifPathKey returns path as --path-key says. Relative paths are taken from --relative-to,
+// defaulting to the scanned --dir, or the working directory without one. Paths that cannot be
+// made relative, e.g. on another Windows drive, are written absolute.
+func exifPathKey(path string) string {
+	switch exifPathKeyMode {
+	case "basename":
+		return filepath.Base(path)
+	case "relative", "absolute":
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return path
+		}
+		if exifPathKeyMode == "absolute" {
+			return abs
+		}
+
+		base := exifRelativeTo
+		if base == "" {
+			base = directory
+		}
+		if base, err = filepath.Abs(base); err != nil {
+			return abs
+		}
+		rel, err := filepath.Rel(base, abs)
+		if err != nil {
+			return abs
+		}
+		return rel
+	default:
+		return path
+	}
+}
@@ -0,0 +1,42 @@
+package comands
+
+// activePlan collects the renames computed by the rule processors instead of
+// performing them, see collectRenamePlan
+var activePlan *renamePlan
+
+// plannedRename is a single rename computed by a rule
+type plannedRename struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// renamePlan is the list of renames a run would perform
+type renamePlan struct {
+	Renames []plannedRename `json:"renames"`
+	// failed counts the renames that were rejected while collecting the plan
+	failed int
+}
+
+// add appends a rename to the plan
+func (p *renamePlan) add(oldPath, newPath string) {
+	p.Renames = append(p.Renames, plannedRename{Old: oldPath, New: newPath})
+}
+
+// collectRenamePlan runs process with renameFile only recording the renames it is asked for
+func collectRenamePlan(process func() error) (*renamePlan, error) {
+	plan := &renamePlan{Renames: []plannedRename{}}
+	activePlan = plan
+	defer func() {
+		activePlan = nil
+	}()
+
+	err := process()
+	return plan, err
+}
+
+// reject counts a rename that could not be planned, it is a no-op on a nil plan
+func (p *renamePlan) reject() {
+	if p != nil {
+		p.failed++
+	}
+}
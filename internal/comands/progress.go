@@ -0,0 +1,219 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+var (
+	showProgress bool
+	quiet        bool
+	// progressJSON is where --progress-json writes progress lines: a file, stderr or fd:N
+	progressJSON string
+	// progressJSONInterval is how often --progress-json writes a line while files are processed
+	progressJSONInterval time.Duration
+)
+
+// progressStep is called once per processed file while a progress bar is shown
+var progressStep func()
+
+// progressBar is the bar started by startProgress
+var progressBar *progressbar.ProgressBar
+
+// activeProgressJSON is the stream started by startProgress for --progress-json
+var activeProgressJSON *progressStream
+
+// stdoutIsTerminal reports whether stdout is a terminal, replaced in tests
+var stdoutIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// progressBarEnabled reports whether startProgress shows a bar: --progress is set, --quiet is
+// not and stdout is a terminal, so a redirected or piped output gets no bar
+func progressBarEnabled() bool {
+	return showProgress && !quiet && stdoutIsTerminal()
+}
+
+// progressEnabled reports whether startProgress shows any progress, a bar or --progress-json,
+// so callers only count the files for the total when it is shown
+func progressEnabled() bool {
+	return progressJSON != "" || progressBarEnabled()
+}
+
+// startProgress starts a progress bar on stderr for total files, -1 if the total is unknown.
+// The bar is only shown if progressBarEnabled, it never mixes with the output on stdout.
+// With --progress-json, progress is also written as JSON lines, whatever the terminal.
+func startProgress(total int, description string) {
+	if progressJSON != "" {
+		stream, err := startProgressStream(progressJSON, total, description, progressJSONInterval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: --progress-json disabled: %v\n", err)
+		} else {
+			activeProgressJSON = stream
+		}
+	}
+	if !progressBarEnabled() {
+		return
+	}
+
+	progressBar = progressbar.NewOptions(
+		total,
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("files"),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionClearOnFinish(),
+	)
+	progressStep = func() {
+		progressBar.Add(1)
+	}
+}
+
+// stepProgress advances the progress bar by one file, if one is shown, and counts the file
+// for --max-error-rate
+func stepProgress() {
+	activeErrorBudget.countFile()
+	activeReport.countFile()
+	activeProgressJSON.step()
+	if progressStep != nil {
+		progressStep()
+	}
+}
+
+// finishProgress completes and removes the progress bar, if one is shown, and writes the
+// last line of --progress-json
+func finishProgress() {
+	if progressBar != nil {
+		progressBar.Finish()
+	}
+	progressBar = nil
+	progressStep = nil
+	activeProgressJSON.finish()
+	activeProgressJSON = nil
+}
+
+// progressLine is a line of --progress-json
+type progressLine struct {
+	Stage     string  `json:"stage"`
+	Processed int64   `json:"processed"`
+	Total     *int    `json:"total,omitempty"`
+	Rate      float64 `json:"rate"`
+	Elapsed   float64 `json:"elapsed"`
+	Done      bool    `json:"done,omitempty"`
+}
+
+// progressStream writes the progress of a stage as JSON lines, one every interval and one when
+// the stage is finished, for a program running pyrgear to show its own progress. Its methods
+// are no-ops on a nil stream.
+type progressStream struct {
+	file      *os.File
+	close     bool
+	stage     string
+	total     int
+	started   time.Time
+	processed atomic.Int64
+	failed    bool
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// startProgressStream opens dest and writes the progress of the stage description of total
+// files (-1 if unknown) to it every interval. dest is stderr, fd:N for an inherited file
+// descriptor, e.g. the write end of a pipe, or a file, which is appended to.
+func startProgressStream(dest string, total int, description string, interval time.Duration) (*progressStream, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid --progress-interval %s: must be positive", interval)
+	}
+	s := &progressStream{
+		stage: description, total: total, started: time.Now(),
+		done: make(chan struct{}), stopped: make(chan struct{}),
+	}
+	switch {
+	case dest == "stderr":
+		s.file = os.Stderr
+	case strings.HasPrefix(dest, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor: %s", dest)
+		}
+		s.file = os.NewFile(uintptr(fd), dest)
+	default:
+		file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.file, s.close = file, true
+	}
+
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.write(false)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// step counts a processed file
+func (s *progressStream) step() {
+	if s == nil {
+		return
+	}
+	s.processed.Add(1)
+}
+
+// finish stops the interval lines and writes the last one, with done set
+func (s *progressStream) finish() {
+	if s == nil {
+		return
+	}
+	close(s.done)
+	<-s.stopped
+	s.write(true)
+	if s.close {
+		s.file.Close()
+	}
+}
+
+// write writes the current progress as a line. After a failed write nothing more is written.
+// The last line is only written once the interval lines stopped, so writes never overlap.
+func (s *progressStream) write(done bool) {
+	if s.failed {
+		return
+	}
+
+	elapsed := time.Since(s.started).Seconds()
+	line := progressLine{
+		Stage: s.stage, Processed: s.processed.Load(), Elapsed: math.Round(elapsed*10) / 10, Done: done,
+	}
+	if s.total >= 0 {
+		line.Total = &s.total
+	}
+	if elapsed > 0 {
+		line.Rate = math.Round(float64(line.Processed)/elapsed*10) / 10
+	}
+	data, _ := json.Marshal(line)
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to write progress: %v\n", err)
+		s.failed = true
+	}
+}
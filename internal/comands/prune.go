@@ -0,0 +1,88 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// pruneEmpty removes the directories under --dir that are empty after the renames
+	pruneEmpty bool
+	// pruneHidden also removes directories that only hold hidden files, with those files
+	pruneHidden bool
+)
+
+// pruneEmptyDirs removes the subdirectories of root that are empty once the renames are done,
+// such as the folders flatten or date-tree --move took all files out of. The tree is walked
+// bottom-up, so a directory whose only entries are empty directories goes too. root itself is
+// kept. Directories holding hidden files, such as .DS_Store, are kept unless --prune-hidden
+// is set; symlinks and all other files always keep their directory.
+// A dry-run reports the directories its renames would empty. It returns the number removed.
+func pruneEmptyDirs(root string, dryRun bool) int {
+	root = filepath.Clean(root)
+	removed := 0
+
+	// prune reports whether dir was (or in a dry-run would be) removed
+	var prune func(dir string) bool
+	prune = func(dir string) bool {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			fmt.Printf("Warning: not pruning %s: %v\n", dir, err)
+			activeIssues.addWarning("prune", dir, err)
+			return false
+		}
+
+		empty := true
+		var hidden []string
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			switch {
+			case entry.IsDir():
+				if !prune(path) {
+					empty = false
+				}
+			case dryRun && vacatedPaths[path]:
+				// Moved away by an earlier rename of the dry-run
+			case pruneHidden && isHidden(entry.Name()):
+				hidden = append(hidden, path)
+			default:
+				empty = false
+			}
+		}
+		if !empty || dir == root {
+			return false
+		}
+
+		if dryRun {
+			fmt.Printf("Would remove empty directory: %s\n", dir)
+			removed++
+			return true
+		}
+		fmt.Printf("Removing empty directory: %s\n", dir)
+		for _, path := range hidden {
+			if err := os.Remove(path); err != nil {
+				fmt.Printf("Error removing %s: %v\n", path, err)
+				activeIssues.addError("prune", path, err)
+				return false
+			}
+		}
+		if err := os.Remove(dir); err != nil {
+			fmt.Printf("Error removing %s: %v\n", dir, err)
+			activeIssues.addError("prune", dir, err)
+			return false
+		}
+		removed++
+		return true
+	}
+
+	prune(root)
+	if !quiet {
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d empty directories\n", verb, removed)
+	}
+	return removed
+}
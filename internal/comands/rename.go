@@ -1,25 +1,31 @@
 package comands
 
 import (
+	"encoding/hex"
 	"fmt"
-	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
 	"github.com/spf13/cobra"
 )
 
 var (
 	pattern     string
 	replacement string
-	recursive   bool
-	dryRun      bool
-	directory   string
-	ruleType    string
-	sourcePath  string
-	outputDir   string
+	ignoreCase  bool
+	// stemOnly applies the pattern to the filename without its extension
+	stemOnly   bool
+	recursive  bool
+	dryRun     bool
+	directory  string
+	ruleType   string
+	sourcePath string
+	outputDir  string
 	// preName 指定复制文件前缀名,用于rule wx-exporter
 	preName string
 	// foldername-rename rule params
@@ -28,6 +34,38 @@ var (
 	prefixName string
 	// sequenceName for sequence rule - custom name prefix
 	sequenceName string
+	// sanitize rule params
+	targetFS    string
+	replaceChar string
+	// replace-char rule params, replaceInExt also replaces in the extension
+	replaceFrom  string
+	replaceTo    string
+	replaceInExt bool
+	// randomize rule params
+	randomSeed   int64
+	randomFormat string
+	// manifestPath records performed renames so they can be undone
+	manifestPath string
+	undoManifest string
+	// allowEscape permits renames that move files out of renameBase
+	allowEscape bool
+	// renameBase is the directory new names must stay in without --allow-escape: the --dir of
+	// the run, so recursive renames may move files between its subdirectories. When it is not
+	// set each file must stay in its own directory.
+	renameBase string
+	// truncateNames shortens new names over the filesystem limit instead of reporting them,
+	// truncateHash appends a hash of the full name to the shortened stem
+	truncateNames bool
+	truncateHash  bool
+	// explainRuleFlag describes --rule, including how it treats --recursive, without renaming
+	explainRuleFlag bool
+	// replaceEmptyWith names files whose new name has an empty stem, see replaceEmptyName
+	replaceEmptyWith string
+	// emptyNamesTaken are the fallback names given in this run, see replaceEmptyName
+	emptyNamesTaken map[string]bool
+
+	// randomTokens generates tokens for the randomize rule, see nextRandomToken
+	randomTokens *rand.Rand
 )
 
 // renameCmd represents the rename command
@@ -43,20 +81,253 @@ Example:
   pyrgear rename --rule "wx-exporter" --source-path "/path/to/source" --output-dir "./output"
   pyrgear rename --rule "wx-exporter" --source-path "/path/to/source" --output-dir "./output" --pre-name "my_prefix"
   pyrgear rename --dir ./my_files --rule "prefix" --prefix "photo_"
+  pyrgear rename --dir ./my_files --rule "randomize" --manifest ./names.json --seed 42
+  pyrgear rename --undo ./names.json
+  pyrgear rename --dir ./my_files --rule "burst" --gap 2s
+  pyrgear rename --dir ./my_files --rule "deburst-keep-best" --keep-best size
+  pyrgear rename --dir ./my_files --rule "lowercase" --locale tr
+  pyrgear rename --dir ./my_files --rule "title-case" --small-words a,an,the,of,and,in,on
+  pyrgear rename --dir ./shoot --rule "numbered-by-date" --recursive
+  pyrgear rename --dir ./albums --rule "flatten" --prune-empty
+  pyrgear rename --dir ./wedding --rule "event-seq" --recursive
+  pyrgear rename --dir ./shots --rule "increment-existing" --by 1
+  pyrgear rename --dir ./my_files --rule "sanitize" --target-fs windows --recursive
+  pyrgear rename --dir ./my_files --rule "replace-char" --from "#" --to "_"
+  pyrgear rename --dir ~/Downloads --rule "strip-dup-suffix" --on-conflict larger --dry-run
+  pyrgear rename --dir ./scans --rule "lookup" --pattern "(ID\d+)" --mapping ids.csv
+  pyrgear rename --dir ./receipts --rule "ocr-date" --ocr-cmd "tesseract {} stdout"
+  pyrgear rename --dir ./swatches --rule "color-tag" --color-format hex
+  pyrgear rename --dir ./archive --rule "date-from-filename" --recursive --dry-run
   
 This will rename all files matching the pattern "file_(\d+)" to "document_$1" in the ./my_files directory.
-If --recursive is specified, it will also process files in subdirectories.
+If --recursive is specified, it will also process files in subdirectories. Rules for which that makes
+no sense refuse it (foldername-rename) or warn that it has no effect; --explain shows what a rule does.
+With --deterministic, rules and options whose names depend on modification times, randomness or the
+order concurrent copies complete in are refused, so dry-run plans can be golden-tested across machines.
 If --rule is specified, it will use a predefined renaming rule instead of pattern/replacement.
 For wx-exporter rule, it will extract images from path2/assets/ folders in the specified source directory (path1)
 and copy them to the output directory with names like "path2_001".
-For prefix rule, it will add the specified prefix to all files/directories in the target directory. `,
+For prefix rule, it will add the specified prefix to all files/directories in the target directory.
+For randomize rule, it will rename files to random tokens and record the mapping in the --manifest file,
+which can later be restored with --undo.
+For burst rule, images taken within --gap of each other are grouped by their EXIF time and
+renamed to burst01_001, burst01_002, burst02_001, ...
+For deburst-keep-best rule, images are grouped into bursts the same way and only the best frame
+of each burst (by --keep-best, e.g. the largest file) is kept, the others are moved to rejected/.
+For exif-date rule, images are renamed to the EXIF time they were taken, YYYYMMDD_HHMMSS.jpg.
+With --use-subsec the milliseconds of SubSecTimeOriginal are appended (YYYYMMDD_HHMMSS_123.jpg)
+and images that still share a name get a -1, -2, ... suffix. With --keep-original the original
+name follows the date (YYYYMMDD_HHMMSS_IMG_0042.jpg), cut if the name would exceed 255 bytes.
+For ocr-date rule, scans are renamed to the first date in the text --ocr-cmd prints for them,
+YYYYMMDD_000000.jpg, numbered -1, -2, ... per day; scans without a date get their modification time.
+For date-from-filename rule, the first date in each filename (20230615, 2023_06_15, 15.06.2023, ...)
+is rewritten to 2023-06-15, keeping the rest of the name; files without a date are left alone.
+Numeric dates that are valid both ways, such as 04.07.2023, are read day first unless --name-date-order mdy.
+For color-tag rule, images are prefixed with the name of their dominant color (red_swatch.png),
+or with --color-format hex its hex code (dc141e_swatch.png); other files are left alone.
+For numbered-by-date rule, all files (of all subdirectories with --recursive) are ordered by
+their EXIF date or modification time and numbered 01, 02, ... as one set, padded to the total count.
+For event-seq rule, files are renamed to <parent folder>_<YYYYMMDD>_NNN.jpg, with the EXIF date
+(modification time for files without one) and a sequence shared by all directories in the order taken.
+For increment-existing rule, --by is added to the number ending each filename stem, keeping its
+zero padding (shot_09.jpg becomes shot_10.jpg). Files are renamed in the order that never overwrites.
+For date-tree rule, files are copied into --output-dir under the subdirectory --output-dir-template
+renders from their EXIF data, {{.ExifYear}}/{{.ExifMonth}} by default; files without an EXIF date go
+to unknown/. The originals are left alone unless --move is set.
+For flatten rule, the files of all subdirectories are moved into --dir. Names used more than
+once get their path relative to --dir prepended, sub/folder/file.jpg becomes sub_folder_file.jpg.
+With --prune-empty the directories left empty are removed afterwards.
+For sanitize rule, characters that are illegal on --target-fs (e.g. ':' or '?' on Windows) are
+replaced with --replace-char, and reserved Windows names such as CON or NUL are rewritten.
+For replace-char rule, every --from in the filename stems is replaced with --to, taken literally
+rather than as a pattern; with --include-ext the extensions are included.
+For strip-dup-suffix rule, the " (1)", "-copy" and " - Copy" markers that browsers and file managers
+append to duplicates are removed from the stems, photo (1).jpg becomes photo.jpg. Where that name is
+taken, a file with the same content is removed; otherwise --on-conflict keeps the newer (default) or
+larger file and removes the other, or skips it. Removed files cannot be restored with --undo.
+For from-csv rule, the old_name,new_name pairs of the --mapping CSV file are applied in file order,
+with relative paths taken relative to --dir.
+For lookup rule, --pattern extracts a key from each filename stem (its first group, or the whole
+match) and the file is renamed to the name the key,name pairs of the --mapping CSV file list for it,
+keeping the extension. Files whose key is not in the table are skipped with a warning. `,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkDuplicatePolicy(onDuplicate); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := checkHashAlgo(hashAlgo); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		// Describe the rule instead of running it
+		if explainRuleFlag {
+			if ruleType == "" {
+				fmt.Println("Error: --explain requires --rule")
+				return
+			}
+			if err := explainRule(os.Stdout, ruleType); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			return
+		}
+		if err := checkRuleRecursion(ruleType, recursive); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if undoManifest == "" {
+			if err := checkRuleFlags(cmd.Flags(), ruleType); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+		if err := checkReview(ruleType); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := checkDeterministic(cmd.Flags(), ruleType); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if pruneHidden && !pruneEmpty {
+			fmt.Println("Error: --prune-hidden requires --prune-empty")
+			return
+		}
+
+		// Append the renames of this run to the audit trail of --rename-log
+		if !dryRun {
+			logRule := ruleType
+			if undoManifest != "" {
+				logRule = "undo"
+			}
+			finishRenameLog, err := startRenameLog(logRule)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			defer finishRenameLog()
+		}
+
+		// Restore a previous run from its manifest
+		if undoManifest != "" {
+			err := processUndo(undoManifest, dryRun)
+			if err != nil {
+				fmt.Printf("Error undoing renames: %v\n", err)
+				activeIssues.addError("undo", undoManifest, err)
+			}
+			return
+		}
+
+		// Collect errors and warnings for --errors-out
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		// Keep other pyrgear runs out of the directory until this one is done
+		if useLock && !dryRun {
+			lockDir := renameLockDir()
+			if strings.EqualFold(ruleType, "wx-exporter") {
+				if err := os.MkdirAll(lockDir, 0755); err != nil {
+					fmt.Printf("Error creating output directory: %v\n", err)
+					activeIssues.addError("lock", lockDir, err)
+					return
+				}
+			}
+			lock, err := acquireDirLock(lockDir, lockWait, lockStale)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				activeIssues.addError("lock", lockDir, err)
+				return
+			}
+			defer lock.release()
+		}
+
+		// Load the numbering state of previous runs
+		if stateFile != "" {
+			if resetState && !dryRun {
+				if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+					fmt.Printf("Error resetting state file: %v\n", err)
+					return
+				}
+			}
+			st, err := loadSequenceState(stateFile)
+			if err != nil {
+				fmt.Printf("Error loading state: %v\n", err)
+				return
+			}
+			if resetState {
+				st.Dirs = map[string]*dirState{}
+			}
+			activeState = st
+			defer func() {
+				if !dryRun {
+					if err := activeState.save(); err != nil {
+						fmt.Printf("Error writing state file: %v\n", err)
+					}
+				}
+				activeState = nil
+			}()
+		}
+
+		// Record performed renames when a manifest is requested
+		if manifestPath != "" && !dryRun {
+			activeManifest = newRenameManifest(ruleType)
+			defer func() {
+				if err := activeManifest.save(manifestPath); err != nil {
+					fmt.Printf("Error writing manifest: %v\n", err)
+				}
+				activeManifest = nil
+			}()
+		}
+
+		// Count the renames of each directory for the summary
+		activeRenameStats = newRenameStats()
+		defer func() {
+			if !quiet {
+				activeRenameStats.print(dryRun)
+			}
+			activeRenameStats = nil
+		}()
+
+		// Only select files modified since the last successful run with the same rule
+		lastRunDir, lastRunRule := directory, ruleType
+		switch {
+		case strings.ToLower(ruleType) == "wx-exporter":
+			lastRunDir = sourcePath
+			if lastRunDir == "" {
+				lastRunDir = "."
+			}
+		case directory == "":
+			lastRunDir = parentDir
+		}
+		if ruleType == "" {
+			lastRunRule = "pattern " + pattern + " -> " + replacement
+		}
+		finishLastRun, err := startSinceLastRun("rename", lastRunDir, lastRunRule)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		runOK := false
+		defer func() {
+			finishLastRun(runOK)
+		}()
+
+		// The progress starts once the files were counted, with them as the total
+		defer finishProgress()
+
 		// Special handling for wx-exporter rule
 		if strings.ToLower(ruleType) == "wx-exporter" {
+			startProgress(-1, "Processing")
 			err := processWxExporter(sourcePath, outputDir, dryRun)
 			if err != nil {
 				fmt.Printf("Error processing wx-exporter: %v\n", err)
+				activeIssues.addError("copy", sourcePath, err)
 			}
+			runOK = err == nil
 			return
 		}
 
@@ -67,25 +338,44 @@ For prefix rule, it will add the specified prefix to all files/directories in th
 				return
 			}
 			if directory != "" {
+				total, ok := checkRenameLimit([]string{directory}, false, nil)
+				if !ok {
+					return
+				}
+				startProgress(total, "Processing")
 				err := processFoldernameRename(directory, dryRun)
 				if err != nil {
 					fmt.Printf("Error processing foldername-rename: %v\n", err)
+					activeIssues.addError("rename", directory, err)
 				}
+				runOK = err == nil
 				return
 			}
 			if parentDir != "" {
 				entries, err := os.ReadDir(parentDir)
 				if err != nil {
 					fmt.Printf("Error reading parent directory: %v\n", err)
+					activeIssues.addError("rename", parentDir, err)
 					return
 				}
+				var dirs []string
 				for _, entry := range entries {
 					if entry.IsDir() {
-						dirPath := filepath.Join(parentDir, entry.Name())
-						err := processFoldernameRename(dirPath, dryRun)
-						if err != nil {
-							fmt.Printf("Error processing %s: %v\n", dirPath, err)
-						}
+						dirs = append(dirs, filepath.Join(parentDir, entry.Name()))
+					}
+				}
+				total, ok := checkRenameLimit(dirs, false, nil)
+				if !ok {
+					return
+				}
+				startProgress(total, "Processing")
+				runOK = true
+				for _, dirPath := range dirs {
+					err := processFoldernameRename(dirPath, dryRun)
+					if err != nil {
+						fmt.Printf("Error processing %s: %v\n", dirPath, err)
+						activeIssues.addError("rename", dirPath, err)
+						runOK = false
 					}
 				}
 				return
@@ -97,13 +387,34 @@ For prefix rule, it will add the specified prefix to all files/directories in th
 			cmd.Help()
 			return
 		}
+		renameBase = directory
+		defer func() {
+			renameBase = ""
+		}()
 
 		// If a rule is specified, use that instead of pattern/replacement
+		if strings.EqualFold(ruleType, "date-tree") && !cmd.Flags().Changed("output-dir") {
+			fmt.Println("Error: --output-dir is required for date-tree rule")
+			return
+		}
 		if ruleType != "" {
-			err := processDirectoryWithRule(directory, ruleType, recursive, dryRun)
+			// The flatten rule always moves the files of the whole tree
+			total, ok := checkRenameLimit([]string{directory}, recursive || strings.EqualFold(ruleType, "flatten"), nil)
+			if !ok {
+				return
+			}
+			startProgress(total, "Processing")
+			err := runRenames(func() error {
+				return processDirectoryWithRule(directory, ruleType, recursive, dryRun)
+			})
 			if err != nil {
 				fmt.Printf("Error processing directory with rule: %v\n", err)
+				activeIssues.addError("rename", directory, err)
 			}
+			if pruneEmpty {
+				pruneEmptyDirs(directory, dryRun)
+			}
+			runOK = err == nil
 			return
 		}
 
@@ -115,181 +426,269 @@ For prefix rule, it will add the specified prefix to all files/directories in th
 		}
 
 		// Compile the regular expression
-		re, err := regexp.Compile(pattern)
+		re, err := compilePattern(pattern, ignoreCase)
 		if err != nil {
 			fmt.Printf("Error compiling regular expression: %v\n", err)
+			activeIssues.addError("rename", directory, err)
+			return
+		}
+		total, ok := checkRenameLimit([]string{directory}, recursive, re)
+		if !ok {
 			return
 		}
+		startProgress(total, "Processing")
 
 		// Process the directory
-		err = processDirectory(directory, re, replacement, recursive, dryRun)
+		err = runRenames(func() error {
+			return processDirectory(directory, re, replacement, recursive, dryRun)
+		})
 		if err != nil {
 			fmt.Printf("Error processing directory: %v\n", err)
+			activeIssues.addError("rename", directory, err)
+		}
+		if pruneEmpty {
+			pruneEmptyDirs(directory, dryRun)
 		}
+		runOK = err == nil
 	},
 }
 
 func init() {
 	RenameCmd.Flags().StringVar(&directory, "dir", "", "Directory to process (required for most operations)")
-	RenameCmd.Flags().StringVar(&pattern, "pattern", "", "Regular expression pattern to match filenames")
+	RenameCmd.Flags().StringVar(
+		&pattern, "pattern", "",
+		"Regular expression pattern to match filenames (for lookup rule, captures the key from the filename stems)",
+	)
 	RenameCmd.Flags().StringVar(&replacement, "replacement", "", "Replacement pattern for new filenames")
-	RenameCmd.Flags().BoolVar(&recursive, "recursive", false, "Process subdirectories recursively")
+	RenameCmd.Flags().BoolVar(
+		&ignoreCase, "ignore-case", false, "Match the whole --pattern case-insensitively",
+	)
+	RenameCmd.Flags().BoolVar(
+		&stemOnly, "stem-only", false,
+		"Apply --pattern to the filename without its extension and keep the extension unchanged",
+	)
+	RenameCmd.Flags().BoolVar(
+		&recursive, "recursive", false,
+		"Process subdirectories recursively (for wx-exporter rule, find path2 directories with assets/ at any depth)",
+	)
 	RenameCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be renamed without actually renaming")
+	RenameCmd.Flags().BoolVar(
+		&explainRuleFlag, "explain", false,
+		"Describe --rule and how it treats --recursive instead of renaming",
+	)
+	RenameCmd.Flags().BoolVar(
+		&strictFlags, "strict", false,
+		"Fail instead of warning when options are given that --rule (or --pattern without it) does not use",
+	)
+	RenameCmd.Flags().BoolVar(
+		&deterministic, "deterministic", false,
+		"Refuse rules and options whose names depend on modification times, randomness or concurrency, "+
+			"and turn off progress and colors, so dry-run plans are reproducible byte for byte",
+	)
+	RenameCmd.Flags().BoolVar(
+		&pruneEmpty, "prune-empty", false,
+		"After renaming, remove the directories under --dir left empty, e.g. by flatten or date-tree --move",
+	)
+	RenameCmd.Flags().BoolVar(
+		&pruneHidden, "prune-hidden", false,
+		"With --prune-empty, also remove directories that only hold hidden files such as .DS_Store, with those files",
+	)
+	RenameCmd.Flags().BoolVar(
+		&useLock, "lock", false,
+		"Hold a lock file ("+lockFileName+") in the target directory while renaming, so concurrent pyrgear runs "+
+			"on a shared directory wait or fail instead of interfering",
+	)
+	RenameCmd.Flags().DurationVar(
+		&lockWait, "lock-wait", 0, "How long --lock waits for another run to release the directory, e.g. 30s (default: fail at once)",
+	)
+	RenameCmd.Flags().DurationVar(
+		&lockStale, "lock-stale", 10*time.Minute,
+		"Take over a --lock lock file not refreshed for this long, left by a run that crashed on another machine",
+	)
+	RenameCmd.Flags().BoolVar(
+		&atomicRename, "atomic", false,
+		"Apply all renames or none: stage them under temporary names and roll back if any rename fails",
+	)
+	RenameCmd.Flags().BoolVar(
+		&reviewRenames, "tui", false,
+		"Review the renames in an interactive list, deselect the unwanted ones and apply the rest all-or-nothing",
+	)
 	RenameCmd.Flags().StringVar(
 		&ruleType, "rule", "",
-		"Predefined rule for renaming (e.g., 'timestamp', 'sequence', 'lowercase', 'wx-exporter', 'prefix')",
+		"Predefined rule for renaming (e.g., 'timestamp', 'sequence', 'lowercase', 'uppercase', 'title-case', 'wx-exporter', 'prefix', 'randomize', 'reverse', 'exif-date', 'ocr-date', 'date-from-filename', 'color-tag', 'burst', 'deburst-keep-best', 'numbered-by-date', 'event-seq', 'increment-existing', 'date-tree', 'flatten', 'sanitize', 'replace-char', 'strip-dup-suffix', 'from-csv', 'lookup')",
 	)
 	RenameCmd.Flags().StringVar(
 		&sourcePath, "source-path", "", "Source path for wx-exporter rule (optional, defaults to current directory)",
 	)
-	RenameCmd.Flags().StringVar(&outputDir, "output-dir", "wx-export", "Output directory for wx-exporter rule")
+	RenameCmd.Flags().StringVar(
+		&outputDir, "output-dir", "wx-export", "Output directory for wx-exporter and date-tree rules",
+	)
+	RenameCmd.Flags().StringVar(
+		&outputDirTemplate, "output-dir-template", "{{.ExifYear}}/{{.ExifMonth}}",
+		"Subdirectory of --output-dir for each file of date-tree rule, a Go template with .ExifYear, .ExifMonth, "+
+			".ExifDay, .Make, .Model and .Ext",
+	)
+	RenameCmd.Flags().BoolVar(&moveFiles, "move", false, "Move the files of date-tree rule instead of copying them")
 	RenameCmd.Flags().StringVar(
 		&preName, "pre-name", "", "Predefined name for wx-exporter rule exporter file optional,defaults to source-path",
 	)
+	RenameCmd.Flags().StringSliceVar(
+		&wxAlsoCopy, "also-copy", nil,
+		"Also copy files matching this glob from each path2 directory for wx-exporter rule (repeatable, e.g. '*.md')",
+	)
+	RenameCmd.Flags().BoolVar(
+		&verifyCopy, "verify-copy", false,
+		"Compare the hash of each copy with its source and copy again once on mismatch",
+	)
+	addHashAlgoFlag(RenameCmd, "Algorithm --verify-copy compares copies with")
+	RenameCmd.Flags().StringVar(
+		&wxSeparator, "separator", "_", "Separator between source name, path2 name and number for wx-exporter rule",
+	)
+	RenameCmd.Flags().BoolVar(
+		&wxEscapeSeparator, "escape-separator", false,
+		"Replace the separator inside source and path2 names for wx-exporter rule, so names can be parsed back",
+	)
+	RenameCmd.Flags().BoolVar(
+		&wxSlugOutput, "slug-output", false,
+		"Write web-safe names for wx-exporter rule: lowercase a-z, 0-9, '_' and '-' only, e.g. cafe-menu_home_001.png",
+	)
+	RenameCmd.Flags().StringVar(
+		&onDuplicate, "on-duplicate", duplicateError,
+		"What to do when a rename or copy target already exists: error, skip, overwrite or rename (to name-1.ext, ...)",
+	)
+	RenameCmd.Flags().StringVar(
+		&wxOutputManifest, "output-manifest", "",
+		"Write a JSON file mapping each exported asset to its output name and SHA-256 for wx-exporter rule",
+	)
+	RenameCmd.Flags().BoolVar(
+		&wxResume, "resume", false,
+		"Continue an interrupted wx-exporter run, skipping the copies it completed and redoing unfinished ones",
+	)
+	RenameCmd.Flags().BoolVar(&wxShowSummary, "summary", false, "Print the totals of the run for wx-exporter rule")
+	RenameCmd.Flags().IntVar(&wxWorkers, "workers", 1, "Number of concurrent copies for wx-exporter rule")
+	RenameCmd.Flags().StringVar(
+		&copyBufferSizeFlag, "copy-buffer-size", "",
+		"Copy buffer size for wx-exporter rule, e.g. 1MB (optional, defaults to the system copy)",
+	)
+	RenameCmd.Flags().StringVar(
+		&sortBy, "sort-by", "name",
+		"Order in which sequence and foldername-rename rules number files: name, mtime (oldest first) or size (smallest first)",
+	)
 	RenameCmd.Flags().StringVar(&parentDir, "pdir", "", "Parent directory for foldername-rename rule (batch mode)")
+	RenameCmd.Flags().IntVar(
+		&incrementBy, "by", 1, "Amount added to the trailing numbers for increment-existing rule (negative to decrement)",
+	)
+	RenameCmd.Flags().StringVar(
+		&onConflict, "on-conflict", conflictNewer,
+		"File strip-dup-suffix rule keeps when the stripped name is taken by a file with other content: newer, larger or skip",
+	)
 	RenameCmd.Flags().StringVar(&prefixName, "prefix", "", "Prefix string for prefix rule")
 	RenameCmd.Flags().StringVar(
 		&sequenceName, "sequence-name", "", "Custom name prefix for sequence rule (optional, defaults to 'file')",
 	)
-}
-
-// processWxExporter processes the wx-exporter rule
-func processWxExporter(sourcePath string, outputDir string, dryRun bool) error {
-	// If sourcePath is not specified, use current directory
-	if sourcePath == "" {
-		var err error
-		sourcePath, err = os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get current directory: %v", err)
-		}
-	}
-
-	// Create output directory if it doesn't exist
-	if !dryRun {
-		err := os.MkdirAll(outputDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create output directory %s: %v", outputDir, err)
-		}
-	}
-
-	// Map to track sequence numbers for each path2
-	sequenceMap := make(map[string]int)
-
-	// First, find all subdirectories (path2) in the source directory (path1)
-	path2Dirs, err := findPath2Directories(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed to find subdirectories in %s: %v", sourcePath, err)
-	}
-
-	if len(path2Dirs) == 0 {
-		fmt.Printf("Warning: No subdirectories found in %s\n", sourcePath)
-	}
-
-	sourceName := filepath.Base(sourcePath)
-	// If preName is specified, use it as the prefix
-	if preName != "" {
-		sourceName = preName
-	}
-
-	// Process each path2 directory
-	for _, path2Dir := range path2Dirs {
-		// Get the path2 name (just the directory name, not the full path)
-		path2Name := filepath.Base(path2Dir)
-
-		// Check if assets directory exists
-		assetsDir := filepath.Join(path2Dir, "assets")
-		assetsInfo, err := os.Stat(assetsDir)
-		if err != nil || !assetsInfo.IsDir() {
-			// Skip if assets directory doesn't exist
-			continue
-		}
-
-		// Process all files in the assets directory
-		assetFiles, err := os.ReadDir(assetsDir)
-		if err != nil {
-			fmt.Printf("Warning: Failed to read assets directory %s: %v\n", assetsDir, err)
-			continue
-		}
-
-		// Process each file in the assets directory
-		for _, file := range assetFiles {
-			if file.IsDir() {
-				// Skip subdirectories in assets
-				continue
-			}
-
-			filePath := filepath.Join(assetsDir, file.Name())
-
-			// Check if the file is an image (simple check by extension)
-			ext := strings.ToLower(filepath.Ext(file.Name()))
-			if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" {
-				continue
-			}
-
-			// Increment sequence number for this path2
-			sequenceMap[path2Name]++
-			sequence := sequenceMap[path2Name]
-
-			// Create new filename: path2_sequence with original extension
-			newName := fmt.Sprintf("%s_%s_%03d%s", sourceName, path2Name, sequence, ext)
-			newPath := filepath.Join(outputDir, newName)
-
-			if dryRun {
-				fmt.Printf("Would copy: %s -> %s\n", filePath, newPath)
-			} else {
-				fmt.Printf("Copying: %s -> %s\n", filePath, newPath)
-				err := copyFile(filePath, newPath)
-				if err != nil {
-					fmt.Printf("Error copying %s: %v\n", filePath, err)
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-// findPath2Directories finds all immediate subdirectories in the given path1 directory
-func findPath2Directories(path1 string) ([]string, error) {
-	entries, err := os.ReadDir(path1)
-	if err != nil {
-		return nil, err
-	}
-
-	var dirs []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			dirs = append(dirs, filepath.Join(path1, entry.Name()))
-		}
-	}
-
-	return dirs, nil
-}
-
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	RenameCmd.Flags().Int64Var(
+		&randomSeed, "seed", 0, "Random seed for randomize rule (optional, 0 picks a random seed)",
+	)
+	RenameCmd.Flags().StringVar(&randomFormat, "random-format", "hex", "Token format for randomize rule: hex or uuid")
+	RenameCmd.Flags().StringVar(
+		&caseLocale, "locale", "",
+		"Language whose case rules lowercase/uppercase/title-case rules apply, e.g. 'tr' or 'de' (optional, defaults to locale-independent rules)",
+	)
+	RenameCmd.Flags().StringSliceVar(
+		&smallWords, "small-words", defaultSmallWords,
+		"Words title-case rule keeps lowercase unless they start or end the name (comma-separated or repeatable)",
+	)
+	RenameCmd.Flags().StringVar(&replaceFrom, "from", "", "Literal character or string to replace for replace-char rule")
+	RenameCmd.Flags().StringVar(&replaceTo, "to", "", "Replacement for --from for replace-char rule (may be empty)")
+	RenameCmd.Flags().BoolVar(
+		&replaceInExt, "include-ext", false, "Also replace in the extension for replace-char rule (default: stem only)",
+	)
+	RenameCmd.Flags().StringVar(
+		&targetFS, "target-fs", "windows", "Filesystem whose naming rules sanitize rule applies: windows, mac or linux",
+	)
+	RenameCmd.Flags().StringVar(
+		&replaceChar, "replace-char", "_", "Replacement for illegal characters for sanitize rule (may be empty)",
+	)
+	RenameCmd.Flags().BoolVar(
+		&useSubsec, "use-subsec", false,
+		"Append the EXIF milliseconds for exif-date rule and number images that still share a name",
+	)
+	RenameCmd.Flags().StringVar(
+		&mappingPath, "mapping", "",
+		"CSV file of old_name,new_name pairs for from-csv rule, or of key,name pairs for lookup rule",
+	)
+	RenameCmd.Flags().BoolVar(
+		&keepOriginal, "keep-original", false,
+		"Keep the original name after the date for exif-date rule, e.g. 20230615_143022_IMG_0042.jpg",
+	)
+	RenameCmd.Flags().StringVar(
+		&ocrCmd, "ocr-cmd", "",
+		"Command printing the text of a scan for ocr-date rule, {} is the file, e.g. 'tesseract {} stdout'",
+	)
+	RenameCmd.Flags().StringVar(
+		&ocrDateOrder, "ocr-date-order", "dmy", "Order of numeric dates in the text for ocr-date rule: dmy or mdy",
+	)
+	RenameCmd.Flags().StringVar(
+		&nameDateOrder, "name-date-order", "dmy",
+		"Order date-from-filename rule reads dates such as 04.07.2023 in when both are valid: dmy or mdy",
+	)
+	RenameCmd.Flags().StringVar(
+		&colorFormat, "color-format", "name",
+		"How color-tag rule writes the dominant color of images: name (e.g. red_) or hex (e.g. dc141e_)",
+	)
+	RenameCmd.Flags().Var(
+		&assumeTZ, "assume-tz",
+		"Time zone of EXIF times without a recorded offset, e.g. Asia/Tokyo or +09:00 (optional, defaults to the local zone)",
+	)
+	RenameCmd.Flags().DurationVar(
+		&burstGap, "gap", 2*time.Second,
+		"Maximum time between two images of the same burst for burst and deburst-keep-best rules",
+	)
+	RenameCmd.Flags().StringVar(
+		&keepBest, "keep-best", "size",
+		"Heuristic choosing the frame of a burst to keep for deburst-keep-best rule: size (largest file)",
+	)
+	RenameCmd.Flags().StringVar(&manifestPath, "manifest", "", "Write performed renames to this JSON manifest file")
+	RenameCmd.Flags().StringVar(&undoManifest, "undo", "", "Restore the original names recorded in a manifest file")
+	RenameCmd.Flags().BoolVar(
+		&manifestIncludeExif, "manifest-include-exif", false,
+		"Record the EXIF date and camera of each renamed image in the manifest",
+	)
+	RenameCmd.Flags().BoolVar(&showProgress, "progress", false, "Show a progress bar on stderr")
+	RenameCmd.Flags().StringVar(
+		&stateFile, "state-file", "",
+		"Remember numbered files across runs so sequence/foldername-rename only number new files",
+	)
+	addFilterFlags(RenameCmd)
+	RenameCmd.Flags().BoolVar(&resetState, "reset-state", false, "Forget the numbering recorded in --state-file")
+	RenameCmd.Flags().IntVar(
+		&maxFiles, "max-files", 0,
+		"Abort before changing anything if more than this many files match (optional, 0 for no limit)",
+	)
+	RenameCmd.Flags().BoolVar(&force, "force", false, "Process the files even if more than --max-files match")
+	RenameCmd.Flags().BoolVar(
+		&allowEscape, "allow-escape", false, "Allow new names that move files outside of --dir (e.g. '../')",
+	)
+	RenameCmd.Flags().BoolVar(
+		&truncateNames, "truncate", false,
+		"Shorten new names over the 255 byte filesystem limit, keeping the extension, instead of reporting them",
+	)
+	RenameCmd.Flags().StringVar(
+		&replaceEmptyWith, "replace-empty-with", "unnamed",
+		"Name for files a rule would leave with an empty name, followed by an index, e.g. unnamed_1.jpg",
+	)
+	RenameCmd.Flags().BoolVar(
+		&truncateHash, "truncate-hash", false, "Append a short hash of the full name to names shortened by --truncate",
+	)
 }
 
 // processDirectoryWithRule processes files in the given directory using a predefined rule
 func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bool) error {
+	// Subdirectories are not read once the run stopped, e.g. at the first error with --fail-fast
+	if stopRequested() {
+		return nil
+	}
+
 	// Check if directory exists
 	info, err := os.Stat(dir)
 	if err != nil {
@@ -304,6 +703,7 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 	if err != nil {
 		return fmt.Errorf("failed to read directory %s: %v", dir, err)
 	}
+	entries = filterEntries(dir, entries)
 
 	// Process each entry based on the rule
 	switch strings.ToLower(rule) {
@@ -316,32 +716,31 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
 					); err != nil {
 						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
 					}
 				}
 				continue
 			}
 
+			// Skip files that already carry a timestamp prefix
+			if alreadyApplied(rule, entry.Name()) {
+				continue
+			}
+
 			// Get file info to access modification time
 			fileInfo, err := entry.Info()
 			if err != nil {
 				fmt.Printf("Error getting file info for %s: %v\n", entry.Name(), err)
+				activeIssues.addError("rename", filepath.Join(dir, entry.Name()), err)
 				continue
 			}
 
-			// Format timestamp as YYYYMMDD_HHMMSS
-			timestamp := fileInfo.ModTime().Format("20060102_150405")
-			newName := fmt.Sprintf("%s_%s", timestamp, entry.Name())
+			// Prefix with the timestamp as YYYYMMDD_HHMMSS
+			newName := pyrgear.TimestampName(entry.Name(), fileInfo.ModTime())
 			oldPath := filepath.Join(dir, entry.Name())
 			newPath := filepath.Join(dir, newName)
 
-			if dryRun {
-				fmt.Printf("Would rename: %s -> %s\n", oldPath, newPath)
-			} else {
-				fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
-				if err := os.Rename(oldPath, newPath); err != nil {
-					fmt.Printf("Error renaming %s: %v\n", oldPath, err)
-				}
-			}
+			renameFile(oldPath, newPath, dryRun)
 		}
 
 	case "sequence":
@@ -351,6 +750,19 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 		if sequenceName != "" {
 			namePrefix = sequenceName
 		}
+		entries, err = sortEntries(dir, entries, sortBy)
+		if err != nil {
+			return err
+		}
+		// Numbering state kept across runs, nil without --state-file
+		ds := activeState.forDir(dir, rule)
+		// Names of files numbered by a previous run, they are kept and their numbers are not reused
+		used := make(map[string]bool)
+		for _, entry := range entries {
+			if !entry.IsDir() && alreadyApplied(rule, entry.Name()) {
+				used[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = true
+			}
+		}
 		for i, entry := range entries {
 			if entry.IsDir() {
 				if recursive {
@@ -358,30 +770,49 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
 					); err != nil {
 						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
 					}
 				}
 				continue
 			}
 
+			oldPath := filepath.Join(dir, entry.Name())
+			if alreadyApplied(rule, entry.Name()) || activeState.isStateFile(oldPath) {
+				continue
+			}
+
+			// With a state file, files numbered by a previous run are skipped and
+			// new arrivals continue the sequence
+			seq := i + 1
+			hash, seen := ds.check(oldPath)
+			if seen {
+				continue
+			}
+			if ds != nil {
+				seq = ds.Last + 1
+			}
+
 			// Get file extension
 			ext := filepath.Ext(entry.Name())
-			// Create new name with sequence number
-			newName := fmt.Sprintf("%s_%03d%s", namePrefix, i+1, ext)
-			oldPath := filepath.Join(dir, entry.Name())
+			// Create new name with sequence number, skipping numbers already in use
+			for used[fmt.Sprintf("%s_%03d", namePrefix, seq)] {
+				seq++
+			}
+			used[fmt.Sprintf("%s_%03d", namePrefix, seq)] = true
+			newName := pyrgear.SequenceName(namePrefix, seq, ext)
 			newPath := filepath.Join(dir, newName)
 
-			if dryRun {
-				fmt.Printf("Would rename: %s -> %s\n", oldPath, newPath)
-			} else {
-				fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
-				if err := os.Rename(oldPath, newPath); err != nil {
-					fmt.Printf("Error renaming %s: %v\n", oldPath, err)
-				}
+			if renameFile(oldPath, newPath, dryRun) == nil {
+				ds.record(hash, newName, seq)
 			}
 		}
 
-	case "lowercase":
-		// Convert all filenames to lowercase
+	case "lowercase", "uppercase", "title-case":
+		// Convert all filenames to lowercase, uppercase or title case, with the case rules of --locale
+		lang, err := parseLocale(caseLocale)
+		if err != nil {
+			return err
+		}
 		for _, entry := range entries {
 			if entry.IsDir() {
 				if recursive {
@@ -389,29 +820,58 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
 					); err != nil {
 						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
 					}
 				}
 				continue
 			}
 
-			// Convert name to lowercase
-			newName := strings.ToLower(entry.Name())
-			if newName == entry.Name() {
-				// Skip if name is already lowercase
+			// Skip if name is already in the target case
+			if alreadyApplied(rule, entry.Name()) {
 				continue
 			}
 
+			// Convert the name
+			var newName string
+			switch strings.ToLower(rule) {
+			case "uppercase":
+				newName = pyrgear.UppercaseName(entry.Name(), lang)
+			case "title-case":
+				newName = pyrgear.TitleCaseName(entry.Name(), smallWords, lang)
+			default:
+				newName = pyrgear.LowercaseName(entry.Name(), lang)
+			}
+
 			oldPath := filepath.Join(dir, entry.Name())
 			newPath := filepath.Join(dir, newName)
 
-			if dryRun {
-				fmt.Printf("Would rename: %s -> %s\n", oldPath, newPath)
-			} else {
-				fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
-				if err := os.Rename(oldPath, newPath); err != nil {
-					fmt.Printf("Error renaming %s: %v\n", oldPath, err)
+			renameFile(oldPath, newPath, dryRun)
+		}
+
+	case "replace-char":
+		// Replace a literal character or string in the filenames
+		if replaceFrom == "" {
+			return fmt.Errorf("--from is required for replace-char rule")
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if recursive {
+					if err := processDirectoryWithRule(
+						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+					); err != nil {
+						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
+					}
 				}
+				continue
+			}
+
+			if alreadyApplied(rule, entry.Name()) {
+				continue
 			}
+
+			newName := pyrgear.ReplaceInName(entry.Name(), replaceFrom, replaceTo, replaceInExt)
+			renameFile(filepath.Join(dir, entry.Name()), filepath.Join(dir, newName), dryRun)
 		}
 
 	case "prefix":
@@ -428,24 +888,18 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
 					); err != nil {
 						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
 					}
 				}
 				continue
 			}
 
 			// Add prefix to the name
-			newName := prefixName + entry.Name()
+			newName := pyrgear.PrefixName(prefixName, entry.Name())
 			oldPath := filepath.Join(dir, entry.Name())
 			newPath := filepath.Join(dir, newName)
 
-			if dryRun {
-				fmt.Printf("Would rename: %s -> %s\n", oldPath, newPath)
-			} else {
-				fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
-				if err := os.Rename(oldPath, newPath); err != nil {
-					fmt.Printf("Error renaming %s: %v\n", oldPath, err)
-				}
-			}
+			renameFile(oldPath, newPath, dryRun)
 
 			// Process subdirectories recursively if needed
 			if entry.IsDir() && recursive {
@@ -458,17 +912,422 @@ func processDirectoryWithRule(dir string, rule string, recursive bool, dryRun bo
 					dirPath, rule, recursive, dryRun,
 				); err != nil {
 					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
 				}
 			}
 		}
 
+	case "randomize":
+		// Rename files to random tokens, keeping the extension
+		if manifestPath == "" && !dryRun {
+			return fmt.Errorf("manifest is required for randomize rule, use --manifest flag")
+		}
+		if randomFormat != "hex" && randomFormat != "uuid" {
+			return fmt.Errorf("unknown random format: %s (supported: hex, uuid)", randomFormat)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if recursive {
+					if err := processDirectoryWithRule(
+						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+					); err != nil {
+						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
+					}
+				}
+				continue
+			}
+
+			newName := nextRandomToken() + filepath.Ext(entry.Name())
+			oldPath := filepath.Join(dir, entry.Name())
+			newPath := filepath.Join(dir, newName)
+
+			renameFile(oldPath, newPath, dryRun)
+		}
+
+	case "reverse":
+		// Reverse the characters of the filename stems, keeping the extensions
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if recursive {
+					if err := processDirectoryWithRule(
+						filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+					); err != nil {
+						fmt.Printf("Warning: %v\n", err)
+						activeIssues.addWarning("rename", dir, err)
+					}
+				}
+				continue
+			}
+
+			// Palindromes stay as they are
+			newName := pyrgear.ReverseName(entry.Name())
+			if newName == entry.Name() {
+				continue
+			}
+			renameFile(filepath.Join(dir, entry.Name()), filepath.Join(dir, newName), dryRun)
+		}
+
+	case "sanitize":
+		// Make file and directory names valid on --target-fs
+		if _, err := pyrgear.SanitizeName("", targetFS, replaceChar); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			oldPath := filepath.Join(dir, entry.Name())
+			newName, _ := pyrgear.SanitizeName(entry.Name(), targetFS, replaceChar)
+
+			if newName != entry.Name() {
+				renameFile(oldPath, filepath.Join(dir, newName), dryRun)
+			}
+
+			// Process subdirectories recursively if needed
+			if entry.IsDir() && recursive {
+				// Use old path for dry-run or when the rename failed, new path otherwise
+				dirPath := filepath.Join(dir, newName)
+				if _, err := os.Stat(dirPath); err != nil {
+					dirPath = oldPath
+				}
+				if err := processDirectoryWithRule(dirPath, rule, recursive, dryRun); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+
+	case "numbered-by-date":
+		// Number the files of all directories as one set
+		return renameNumberedByDate(dir, recursive, dryRun)
+
+	case "increment-existing":
+		// Shift the trailing numbers of the files of each directory by --by
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameIncrementExisting(dir, entries, dryRun)
+
+	case "event-seq":
+		// Name the files of all directories after their folder and date, as one sequence
+		return renameEventSeq(dir, recursive, dryRun)
+
+	case "date-tree":
+		// Copy the files of all directories into a tree under --output-dir
+		return copyToDateTree(dir, recursive, outputDir, dryRun)
+
+	case "flatten":
+		// Move the files of all subdirectories into dir
+		return flattenTree(dir, dryRun)
+
+	case "exif-date":
+		// Name images after the EXIF time they were taken
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameByExifDate(dir, entries, dryRun)
+
+	case "ocr-date":
+		// Name scans after the date written in them
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameByOCRDate(dir, entries, dryRun)
+
+	case "date-from-filename":
+		// Rewrite the dates in filenames to YYYY-MM-DD
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameByNameDate(dir, entries, dryRun)
+
+	case "color-tag":
+		// Prefix images with their dominant color
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameByColorTag(dir, entries, dryRun)
+
+	case "burst":
+		// Group images taken within --gap of each other
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameBursts(dir, entries, dryRun)
+
+	case "deburst-keep-best":
+		// Keep the best frame of each burst, the rejected folders are not culled again
+		for _, entry := range entries {
+			if entry.IsDir() && recursive && entry.Name() != rejectedDirName {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return deburstKeepBest(dir, entries, dryRun)
+
+	case "strip-dup-suffix":
+		// Strip the duplicate markers of the files of each directory
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameStripDupSuffix(dir, entries, dryRun)
+
+	case "from-csv":
+		// Apply the renames of the mapping file, which may span subdirectories
+		return renameFromMapping(dir, dryRun)
+
+	case "lookup":
+		// Rename the files of each directory to the names their keys map to
+		for _, entry := range entries {
+			if entry.IsDir() && recursive {
+				if err := processDirectoryWithRule(
+					filepath.Join(dir, entry.Name()), rule, recursive, dryRun,
+				); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
+				}
+			}
+		}
+		return renameByLookup(dir, entries, dryRun)
+
 	default:
-		return fmt.Errorf("unknown rule type: %s", rule)
+		return fmt.Errorf("%w: %s", ErrUnknownRule, rule)
+	}
+
+	return nil
+}
+
+// renameFile renames oldPath to newPath, or only reports the rename in dry-run mode.
+// Successful renames are recorded in the active manifest, if any. Without --allow-escape
+// newPath must be in renameBase, see renameFileWithin.
+func renameFile(oldPath, newPath string, dryRun bool) error {
+	base := renameBase
+	if base == "" {
+		base = filepath.Dir(oldPath)
+	}
+	return renameFileWithin(oldPath, newPath, base, dryRun)
+}
+
+// renameFileWithin is renameFile for rules that move files within base, such as flatten
+// moving them up into the scanned directory. Without --allow-escape newPath must be in base.
+func renameFileWithin(oldPath, newPath, base string, dryRun bool) error {
+	if activeErrorBudget.exceeded() {
+		return ErrTooManyErrors
+	}
+	defer stepProgress()
+
+	// A rule that strips the whole stem would leave a hidden ".jpg" or no name at all
+	newPath = replaceEmptyName(oldPath, newPath)
+
+	// Names over the filesystem limit would make the rename fail with an opaque error
+	name := filepath.Base(newPath)
+	if err := pyrgear.CheckNameLength(name); err != nil {
+		if !truncateNames {
+			err = fmt.Errorf("%w, use --truncate to shorten it", err)
+			fmt.Printf("Error renaming %s: %v\n", oldPath, err)
+			activeIssues.addError("rename", oldPath, err)
+			activePlan.reject()
+			return err
+		}
+		newPath = filepath.Join(filepath.Dir(newPath), pyrgear.TruncateName(name, pyrgear.MaxNameBytes, truncateHash))
+		err = fmt.Errorf("new name cut to the %d byte limit: %s", pyrgear.MaxNameBytes, filepath.Base(newPath))
+		fmt.Printf("Warning: %s: %v\n", oldPath, err)
+		activeIssues.addWarning("rename", oldPath, err)
+	}
+
+	// Names built from user input (e.g. a replacement containing "../") must not
+	// move files out of base unless explicitly allowed
+	if !allowEscape {
+		if err := checkWithinBase(base, newPath); err != nil {
+			fmt.Printf("Error renaming %s: %v\n", oldPath, err)
+			activeIssues.addError("rename", oldPath, err)
+			activePlan.reject()
+			return err
+		}
+	}
+
+	// Existing targets are only replaced with --on-duplicate overwrite. Only dry-runs and
+	// plans leave vacated files in place, real renames see the filesystem as it is.
+	if activePlan == nil && !dryRun {
+		vacatedPaths = nil
+	}
+	resolved, err := resolveDestination(oldPath, newPath, onDuplicate, nil)
+	if err != nil {
+		fmt.Printf("Error renaming %s: %v\n", oldPath, err)
+		activeIssues.addError("rename", oldPath, err)
+		activePlan.reject()
+		return err
+	}
+	if resolved == "" {
+		fmt.Printf("Skipping %s: target already exists: %s\n", oldPath, newPath)
+		activeReport.countSkipped()
+		return nil
+	}
+	replace := resolved == newPath && !vacatedPaths[newPath] && pyrgear.CheckCollision(oldPath, newPath) != nil
+	newPath = resolved
+
+	// Only record the rename when a plan is being collected
+	if activePlan != nil {
+		activePlan.add(oldPath, newPath)
+		vacate(oldPath, newPath)
+		return nil
+	}
+
+	if dryRun {
+		vacate(oldPath, newPath)
+		if replace {
+			fmt.Printf("Would rename: %s (replacing the existing file)\n", renameArrow(oldPath, newPath))
+		} else {
+			fmt.Printf("Would rename: %s\n", renameArrow(oldPath, newPath))
+		}
+		activeRenameStats.record(oldPath)
+		return nil
+	}
+
+	// Read the EXIF values for the manifest before the file moves
+	var meta map[string]string
+	if activeManifest != nil && manifestIncludeExif && pyrgear.IsEXIFImage(oldPath) {
+		meta = readExifMeta(oldPath)
+	}
+
+	fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
+	rename := pyrgear.Rename
+	if replace {
+		rename = pyrgear.ReplaceFile
+	}
+	if err := rename(oldPath, newPath); err != nil {
+		fmt.Printf("Error renaming %s: %v\n", oldPath, err)
+		activeIssues.addError("rename", oldPath, err)
+		return err
 	}
+	activeManifest.record(oldPath, newPath, meta)
+	activeRenameLog.record(oldPath, newPath)
+	activeRenameStats.record(oldPath)
+	return nil
+}
 
+// checkWithinBase returns an error if target is not located inside the base directory
+func checkWithinBase(base, target string) error {
+	if !pyrgear.IsWithinBase(base, target) {
+		return fmt.Errorf("%s is outside of %s, use --allow-escape to allow it", target, base)
+	}
 	return nil
 }
 
+// replaceEmptyName returns newPath, or a fallback if the rule that computed it left the name
+// without a stem (see pyrgear.IsEmptyStem): --replace-empty-with with the first index that
+// neither exists in the directory nor was given to another file in this run.
+func replaceEmptyName(oldPath, newPath string) string {
+	dir, name := filepath.Split(newPath)
+	if filepath.Clean(newPath) == filepath.Dir(oldPath) {
+		// The rule produced an empty name, joining it left the directory
+		dir, name = filepath.Dir(oldPath), ""
+	}
+	if !pyrgear.IsEmptyStem(filepath.Base(oldPath), name) {
+		return newPath
+	}
+
+	ext := filepath.Ext(name)
+	if strings.Trim(name, ". ") == "" {
+		ext = filepath.Ext(oldPath)
+	}
+	if emptyNamesTaken == nil {
+		emptyNamesTaken = make(map[string]bool)
+	}
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, pyrgear.FallbackName(replaceEmptyWith, n, ext))
+		if emptyNamesTaken[candidate] || pyrgear.CheckCollision(oldPath, candidate) != nil {
+			continue
+		}
+		emptyNamesTaken[candidate] = true
+		err := fmt.Errorf("the new name %q is empty, using %s", name, filepath.Base(candidate))
+		fmt.Printf("Warning: %s: %v\n", oldPath, err)
+		activeIssues.addWarning("rename", oldPath, err)
+		return candidate
+	}
+}
+
+// nextRandomToken returns the next token for the randomize rule.
+// The generator is seeded from --seed on first use so runs are reproducible.
+func nextRandomToken() string {
+	if randomTokens == nil {
+		seed := randomSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		randomTokens = rand.New(rand.NewSource(seed))
+	}
+
+	b := make([]byte, 16)
+	randomTokens.Read(b)
+	if randomFormat == "uuid" {
+		// Format as a version 4 UUID
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	}
+	return hex.EncodeToString(b[:8])
+}
+
+// compilePattern compiles the rename pattern, optionally matching it case-insensitively
+func compilePattern(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
 // processDirectory processes files in the given directory
 func processDirectory(dir string, re *regexp.Regexp, repl string, recursive bool, dryRun bool) error {
 	// Check if directory exists
@@ -485,33 +1344,39 @@ func processDirectory(dir string, re *regexp.Regexp, repl string, recursive bool
 	if err != nil {
 		return fmt.Errorf("failed to read directory %s: %v", dir, err)
 	}
+	entries = filterEntries(dir, entries)
 
 	// Process each entry
 	for _, entry := range entries {
+		if stopRequested() {
+			break
+		}
 		path := filepath.Join(dir, entry.Name())
 
 		if entry.IsDir() {
 			if recursive {
 				if err := processDirectory(path, re, repl, recursive, dryRun); err != nil {
 					fmt.Printf("Warning: %v\n", err)
+					activeIssues.addWarning("rename", dir, err)
 				}
 			}
 			continue
 		}
 
-		// Process file
-		if re.MatchString(entry.Name()) {
-			newName := re.ReplaceAllString(entry.Name(), repl)
+		// Process file, with --stem-only the extension is kept out of the match and re-appended
+		name, ext := entry.Name(), ""
+		if stemOnly {
+			name, ext = pyrgear.SplitExt(name)
+		}
+		if newName, ok := pyrgear.PatternName(re, repl, name); ok {
+			newName += ext
 			newPath := filepath.Join(dir, newName)
-
-			if dryRun {
-				fmt.Printf("Would rename: %s -> %s\n", path, newPath)
-			} else {
-				fmt.Printf("Renaming: %s -> %s\n", path, newPath)
-				if err := os.Rename(path, newPath); err != nil {
-					fmt.Printf("Error renaming %s: %v\n", path, err)
-				}
+			if filepath.IsAbs(newName) {
+				// An absolute replacement names the target itself, which must then be in the base
+				newPath = filepath.Clean(newName)
 			}
+
+			renameFile(path, newPath, dryRun)
 		}
 	}
 
@@ -532,23 +1397,33 @@ func processFoldernameRename(targetDir string, dryRun bool) error {
 	if err != nil {
 		return fmt.Errorf("failed to read directory %s: %v", targetDir, err)
 	}
+	entries, err = sortEntries(targetDir, filterEntries(targetDir, entries), sortBy)
+	if err != nil {
+		return err
+	}
+	// Numbering state kept across runs, nil without --state-file
+	ds := activeState.forDir(targetDir, "foldername-rename")
 	seq := 1
+	if ds != nil {
+		seq = ds.Last + 1
+	}
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
 		oldPath := filepath.Join(targetDir, entry.Name())
+		if activeState.isStateFile(oldPath) {
+			continue
+		}
+		hash, seen := ds.check(oldPath)
+		if seen {
+			continue
+		}
 		ext := filepath.Ext(entry.Name())
-		newName := fmt.Sprintf("%s_%03d%s", folderName, seq, ext)
+		newName := pyrgear.SequenceName(folderName, seq, ext)
 		newPath := filepath.Join(targetDir, newName)
-		if dryRun {
-			fmt.Printf("Would rename: %s -> %s\n", oldPath, newPath)
-		} else {
-			fmt.Printf("Renaming: %s -> %s\n", oldPath, newPath)
-			err := os.Rename(oldPath, newPath)
-			if err != nil {
-				fmt.Printf("Error renaming %s: %v\n", oldPath, err)
-			}
+		if renameFile(oldPath, newPath, dryRun) == nil {
+			ds.record(hash, newName, seq)
 		}
 		seq++
 	}
@@ -1,7 +1,26 @@
 package comands
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestName(t *testing.T) {
@@ -14,3 +33,2754 @@ func TestName(t *testing.T) {
 	println(ps)
 
 }
+
+func TestRandomizeRuleWithUndo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "randomize_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	originals := []string{"alice.jpg", "bob.png", "carol.txt"}
+	runRandomize := func(dir string) []string {
+		for _, name := range originals {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+		}
+
+		randomSeed, randomFormat, randomTokens = 42, "hex", nil
+		manifestPath = filepath.Join(tempDir, filepath.Base(dir)+".json")
+		activeManifest = newRenameManifest("randomize")
+		defer func() {
+			randomSeed, randomTokens, manifestPath, activeManifest = 0, nil, "", nil
+		}()
+
+		err := processDirectoryWithRule(dir, "randomize", false, false)
+		assert.NoError(t, err)
+		assert.NoError(t, activeManifest.save(manifestPath))
+
+		return listNames(t, dir)
+	}
+
+	first := filepath.Join(tempDir, "first")
+	second := filepath.Join(tempDir, "second")
+	assert.NoError(t, os.Mkdir(first, 0755))
+	assert.NoError(t, os.Mkdir(second, 0755))
+
+	names := runRandomize(first)
+	assert.Len(t, names, len(originals))
+	for _, name := range names {
+		assert.NotContains(t, originals, name)
+	}
+	assert.ElementsMatch(t, []string{".jpg", ".png", ".txt"}, []string{
+		filepath.Ext(names[0]), filepath.Ext(names[1]), filepath.Ext(names[2]),
+	})
+
+	// The same seed must produce the same names
+	assert.Equal(t, names, runRandomize(second))
+
+	// Undo restores the original names
+	err = processUndo(filepath.Join(tempDir, "first.json"), false)
+	assert.NoError(t, err)
+	for _, name := range originals {
+		data, err := os.ReadFile(filepath.Join(first, name))
+		assert.NoError(t, err)
+		assert.Equal(t, name, string(data))
+	}
+}
+
+func TestRandomizeRuleRequiresManifest(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "randomize_manifest_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	err = processDirectoryWithRule(tempDir, "randomize", false, false)
+	assert.Error(t, err)
+}
+
+func TestIgnoreCasePattern(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "ignore_case_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"a.JPG", "b.jpg", "c.png"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644))
+	}
+
+	re, err := compilePattern(`^(\w+)\.jpg$`, true)
+	assert.NoError(t, err)
+	assert.NoError(t, processDirectory(tempDir, re, "photo_$1.jpg", false, false))
+
+	assert.ElementsMatch(t, []string{"photo_a.jpg", "photo_b.jpg", "c.png"}, listNames(t, tempDir))
+
+	// Without the flag the pattern stays case-sensitive
+	re, err = compilePattern(`\.jpg$`, false)
+	assert.NoError(t, err)
+	assert.False(t, re.MatchString("a.JPG"))
+}
+
+// listNames returns the sorted entry names of dir
+func listNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestTimestampRuleIsIdempotent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "timestamp_rerun_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "file.jpg"), nil, 0644))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "timestamp", false, false))
+	first := listNames(t, tempDir)
+	assert.Len(t, first, 1)
+	assert.Regexp(t, `^\d{8}_\d{6}_file\.jpg$`, first[0])
+
+	// Running the rule again must not add a second prefix
+	assert.NoError(t, processDirectoryWithRule(tempDir, "timestamp", false, false))
+	assert.Equal(t, first, listNames(t, tempDir))
+}
+
+func TestSequenceRuleIsIdempotent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sequence_rerun_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"b.jpg", "c.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644))
+	}
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sequence", false, false))
+	first := listNames(t, tempDir)
+	assert.Equal(t, []string{"file_001.jpg", "file_002.jpg"}, first)
+
+	// Running the rule again must not change anything
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sequence", false, false))
+	assert.Equal(t, first, listNames(t, tempDir))
+
+	// A new file sorting before the numbered ones must not take an existing number
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "a.jpg"), []byte("a.jpg"), 0644))
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sequence", false, false))
+	assert.Equal(t, []string{"file_001.jpg", "file_002.jpg", "file_003.jpg"}, listNames(t, tempDir))
+	data, err := os.ReadFile(filepath.Join(tempDir, "file_001.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b.jpg", string(data))
+}
+
+func TestPatternReplacementCannotEscapeDirectory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "escape_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	baseDir := filepath.Join(tempDir, "base")
+	outsideDir := filepath.Join(tempDir, "outside")
+	assert.NoError(t, os.Mkdir(baseDir, 0755))
+	assert.NoError(t, os.Mkdir(outsideDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(baseDir, "file_1.txt"), nil, 0644))
+
+	re, err := compilePattern(`^file_(\d+)\.txt$`, false)
+	assert.NoError(t, err)
+
+	// Relative traversal is rejected
+	assert.NoError(t, processDirectory(baseDir, re, "../escaped_$1.txt", false, false))
+	assert.NoFileExists(t, filepath.Join(tempDir, "escaped_1.txt"))
+	assert.FileExists(t, filepath.Join(baseDir, "file_1.txt"))
+
+	// An absolute replacement outside the base directory is rejected, also in a dry run
+	activeIssues = &issueLog{}
+	defer func() {
+		activeIssues = nil
+	}()
+	assert.NoError(t, processDirectory(baseDir, re, filepath.Join(outsideDir, "escaped_$1.txt"), false, true))
+	assert.NoError(t, processDirectory(baseDir, re, filepath.Join(outsideDir, "escaped_$1.txt"), false, false))
+	if assert.Len(t, activeIssues.issues, 2) {
+		for _, issue := range activeIssues.issues {
+			assert.Contains(t, issue.Message, filepath.Join(outsideDir, "escaped_1.txt")+" is outside of")
+			assert.Contains(t, issue.Message, "--allow-escape")
+		}
+	}
+	assert.FileExists(t, filepath.Join(baseDir, "file_1.txt"))
+	activeIssues = nil
+
+	// Moving into a subdirectory is still allowed
+	assert.NoError(t, os.Mkdir(filepath.Join(baseDir, "sub"), 0755))
+	assert.NoError(t, processDirectory(baseDir, re, "sub/moved_$1.txt", false, false))
+	assert.FileExists(t, filepath.Join(baseDir, "sub", "moved_1.txt"))
+
+	// In a recursive run the base is --dir, files may move up from its subdirectories but not out
+	renameBase = baseDir
+	defer func() {
+		renameBase = ""
+	}()
+	assert.NoError(t, os.WriteFile(filepath.Join(baseDir, "sub", "file_3.txt"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(baseDir, "file_4.txt"), nil, 0644))
+	assert.NoError(t, processDirectory(baseDir, re, "../up_$1.txt", true, false))
+	assert.FileExists(t, filepath.Join(baseDir, "up_3.txt"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "up_4.txt"))
+	assert.FileExists(t, filepath.Join(baseDir, "file_4.txt"))
+	renameBase = ""
+
+	// --allow-escape lifts the restriction
+	assert.NoError(t, os.WriteFile(filepath.Join(baseDir, "file_2.txt"), nil, 0644))
+	allowEscape = true
+	defer func() {
+		allowEscape = false
+	}()
+	assert.NoError(t, processDirectory(baseDir, re, "../escaped_$1.txt", false, false))
+	assert.FileExists(t, filepath.Join(tempDir, "escaped_2.txt"))
+}
+
+func TestCheckWithinBase(t *testing.T) {
+	base := filepath.Join("photos", "2023")
+	assert.NoError(t, checkWithinBase(base, filepath.Join(base, "a.jpg")))
+	assert.NoError(t, checkWithinBase(base, filepath.Join(base, "sub", "a.jpg")))
+	assert.NoError(t, checkWithinBase(base, filepath.Join(base, "..a.jpg")))
+	assert.Error(t, checkWithinBase(base, filepath.Join(base, "..", "a.jpg")))
+	assert.Error(t, checkWithinBase(base, filepath.Join(base, "..", "..", "a.jpg")))
+	assert.Error(t, checkWithinBase(base, base))
+}
+
+func TestStateFileContinuesNumbering(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "state_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	shootDir := filepath.Join(tempDir, "shoot")
+	assert.NoError(t, os.Mkdir(shootDir, 0755))
+	for _, name := range []string{"b.jpg", "c.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(shootDir, name), []byte(name), 0644))
+	}
+
+	statePath := filepath.Join(tempDir, "state.json")
+	run := func() {
+		st, err := loadSequenceState(statePath)
+		assert.NoError(t, err)
+		activeState = st
+		defer func() {
+			activeState = nil
+		}()
+		assert.NoError(t, processFoldernameRename(shootDir, false))
+		assert.NoError(t, st.save())
+	}
+
+	run()
+	assert.Equal(t, []string{"shoot_001.jpg", "shoot_002.jpg"}, listNames(t, shootDir))
+
+	// A new arrival sorting first only gets the next number, existing files keep theirs
+	assert.NoError(t, os.WriteFile(filepath.Join(shootDir, "a.jpg"), []byte("a.jpg"), 0644))
+	run()
+	assert.Equal(t, []string{"shoot_001.jpg", "shoot_002.jpg", "shoot_003.jpg"}, listNames(t, shootDir))
+	for name, content := range map[string]string{
+		"shoot_001.jpg": "b.jpg", "shoot_002.jpg": "c.jpg", "shoot_003.jpg": "a.jpg",
+	} {
+		data, err := os.ReadFile(filepath.Join(shootDir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+
+	// Nothing changes when no new files arrived
+	run()
+	assert.Equal(t, []string{"shoot_001.jpg", "shoot_002.jpg", "shoot_003.jpg"}, listNames(t, shootDir))
+}
+
+func TestStateFileIdenticalFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "state_identical_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	shootDir := filepath.Join(tempDir, "shoot")
+	assert.NoError(t, os.Mkdir(shootDir, 0755))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(shootDir, name), []byte("same"), 0644))
+	}
+
+	statePath := filepath.Join(tempDir, "state.json")
+	run := func() {
+		st, err := loadSequenceState(statePath)
+		assert.NoError(t, err)
+		activeState = st
+		defer func() {
+			activeState = nil
+		}()
+		assert.NoError(t, processDirectoryWithRule(shootDir, "sequence", false, false))
+		assert.NoError(t, st.save())
+	}
+
+	// Files with the same content are each numbered, also when they arrive later
+	run()
+	assert.Equal(t, []string{"file_001.txt", "file_002.txt"}, listNames(t, shootDir))
+	assert.NoError(t, os.WriteFile(filepath.Join(shootDir, "c.txt"), []byte("same"), 0644))
+	run()
+	assert.Equal(t, []string{"file_001.txt", "file_002.txt", "file_003.txt"}, listNames(t, shootDir))
+	run()
+	assert.Equal(t, []string{"file_001.txt", "file_002.txt", "file_003.txt"}, listNames(t, shootDir))
+
+	// State files keyed by content hash are read by name
+	legacy := `{"dirs":{"/shoot":{"rule":"sequence","last":1,"files":{"abc":"file_001.txt"}}}}`
+	assert.NoError(t, os.WriteFile(statePath, []byte(legacy), 0644))
+	st, err := loadSequenceState(statePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"file_001.txt": "abc"}, st.Dirs["/shoot"].Numbered)
+	assert.Nil(t, st.Dirs["/shoot"].Files)
+}
+
+func TestStateFileIsNotRenamed(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "state_self_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	statePath := filepath.Join(tempDir, "state.json")
+	assert.NoError(t, os.WriteFile(statePath, []byte(`{"dirs":{}}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "photo.jpg"), nil, 0644))
+
+	st, err := loadSequenceState(statePath)
+	assert.NoError(t, err)
+	activeState = st
+	defer func() {
+		activeState = nil
+	}()
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sequence", false, false))
+	assert.ElementsMatch(t, []string{"file_001.jpg", "state.json"}, listNames(t, tempDir))
+}
+
+func TestManifestIncludesExif(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "manifest_exif_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	writeTestJPEG(t, filepath.Join(tempDir, "IMG_0001.JPG"), map[uint16]string{0x9003: "2023:06:15 14:30:22"})
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "NOTES.TXT"), nil, 0644))
+
+	activeManifest = newRenameManifest("lowercase")
+	manifestIncludeExif = true
+	defer func() {
+		activeManifest = nil
+		manifestIncludeExif = false
+	}()
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "lowercase", false, false))
+	if assert.Len(t, activeManifest.Entries, 2) {
+		assert.Equal(t, "img_0001.jpg", filepath.Base(activeManifest.Entries[0].New))
+		assert.Equal(t, map[string]string{"DateTimeOriginal": "2023:06:15 14:30:22"}, activeManifest.Entries[0].Meta)
+		assert.Nil(t, activeManifest.Entries[1].Meta)
+	}
+}
+
+func TestRenameCollisionIsReported(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "collision_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "A.txt"), []byte("upper"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "a.txt"), []byte("lower"), 0644))
+
+	err = renameFile(filepath.Join(tempDir, "A.txt"), filepath.Join(tempDir, "a.txt"), false)
+	assert.ErrorIs(t, err, ErrCollision)
+	err = renameFile(filepath.Join(tempDir, "A.txt"), filepath.Join(tempDir, "a.txt"), true)
+	assert.ErrorIs(t, err, ErrCollision)
+
+	// Both files survive the lowercase rule
+	assert.NoError(t, processDirectoryWithRule(tempDir, "lowercase", false, false))
+	data, err := os.ReadFile(filepath.Join(tempDir, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lower", string(data))
+	assert.FileExists(t, filepath.Join(tempDir, "A.txt"))
+
+	assert.ErrorIs(t, processDirectoryWithRule(tempDir, "no-such-rule", false, false), ErrUnknownRule)
+}
+
+func TestWxExporterDryRunHasNoSideEffects(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_dry_run_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "page", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), []byte("png"), 0644))
+	output := filepath.Join(tempDir, "out", "nested")
+
+	assert.NoError(t, processWxExporter(source, output, true))
+	assert.NoDirExists(t, filepath.Join(tempDir, "out"))
+	assert.Equal(t, []string{"app"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"a.png"}, listNames(t, assets))
+
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, []string{"app_page_001.png"}, listNames(t, output))
+}
+
+func TestBurstRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "burst_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	shots := map[string]string{
+		"c.jpg": "2024:05:01 10:00:01",
+		"a.jpg": "2024:05:01 10:00:00",
+		"b.jpg": "2024:05:01 10:00:02",
+		"d.jpg": "2024:05:01 10:00:30",
+		"e.jpg": "2024:05:01 10:00:31",
+	}
+	for name, taken := range shots {
+		writeTestJPEG(t, filepath.Join(tempDir, name), map[uint16]string{0x9003: taken})
+	}
+	writeTestJPEG(t, filepath.Join(tempDir, "undated.jpg"), map[uint16]string{0x010f: "Canon"})
+
+	burstGap = 2 * time.Second
+	defer func() {
+		burstGap = 0
+	}()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "burst", false, false))
+	assert.Equal(
+		t, []string{
+			"burst01_001.jpg", "burst01_002.jpg", "burst01_003.jpg", "burst02_001.jpg", "burst02_002.jpg",
+			"undated.jpg",
+		}, listNames(t, tempDir),
+	)
+	data, err := os.ReadFile(filepath.Join(tempDir, "burst01_002.jpg"))
+	assert.NoError(t, err)
+	writeTestJPEG(t, filepath.Join(tempDir, "check.jpg"), map[uint16]string{0x9003: shots["c.jpg"]})
+	expected, err := os.ReadFile(filepath.Join(tempDir, "check.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data)
+	assert.NoError(t, os.Remove(filepath.Join(tempDir, "check.jpg")))
+
+	// New arrivals start a new group instead of renumbering
+	writeTestJPEG(t, filepath.Join(tempDir, "f.jpg"), map[uint16]string{0x9003: "2024:05:01 09:00:00"})
+	assert.NoError(t, processDirectoryWithRule(tempDir, "burst", false, false))
+	assert.Contains(t, listNames(t, tempDir), "burst03_001.jpg")
+	assert.Len(t, listNames(t, tempDir), 7)
+}
+
+func TestDeburstKeepBest(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "deburst_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// A longer Make tag makes a larger file
+	shots := []struct {
+		name, taken, make string
+	}{
+		{"a.jpg", "2024:05:01 10:00:00", "Canon"},
+		{"b.jpg", "2024:05:01 10:00:01", "Canon EOS R5 with more detail"},
+		{"c.jpg", "2024:05:01 10:00:02", "Canon EOS"},
+		{"d.jpg", "2024:05:01 10:00:30", "Nikon"},
+		{"e.jpg", "2024:05:01 10:00:31", "Nikon"},
+		{"f.jpg", "2024:05:01 11:00:00", "Sony"},
+	}
+	for _, shot := range shots {
+		writeTestJPEG(t, filepath.Join(tempDir, shot.name), map[uint16]string{0x9003: shot.taken, 0x010f: shot.make})
+	}
+
+	burstGap, keepBest = 2*time.Second, "size"
+	defer func() {
+		burstGap, keepBest = 0, ""
+	}()
+
+	// A dry run moves nothing and creates no folder
+	assert.NoError(t, processDirectoryWithRule(tempDir, "deburst-keep-best", false, true))
+	assert.Len(t, listNames(t, tempDir), 6)
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "deburst-keep-best", false, false))
+	assert.Equal(t, []string{"b.jpg", "d.jpg", "f.jpg", "rejected"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"a.jpg", "c.jpg", "e.jpg"}, listNames(t, filepath.Join(tempDir, rejectedDirName)))
+
+	// The rejected folder is not culled again
+	assert.NoError(t, processDirectoryWithRule(tempDir, "deburst-keep-best", true, false))
+	assert.Len(t, listNames(t, filepath.Join(tempDir, rejectedDirName)), 3)
+
+	keepBest = "sharpness"
+	assert.ErrorContains(t, processDirectoryWithRule(tempDir, "deburst-keep-best", false, false), "use one of: size")
+}
+
+// infoCounter is a directory entry that counts the calls of Info
+type infoCounter struct {
+	os.DirEntry
+	calls *int
+}
+
+func (e infoCounter) Info() (os.FileInfo, error) {
+	*e.calls++
+	return e.DirEntry.Info()
+}
+
+func TestSortEntries(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sort_entries_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, file := range []struct {
+		name string
+		size int
+	}{{"a.txt", 30}, {"b.txt", 10}, {"c.txt", 20}, {"d.txt", 10}} {
+		path := filepath.Join(tempDir, file.name)
+		assert.NoError(t, os.WriteFile(path, make([]byte, file.size), 0644))
+		// The last file is the oldest
+		taken := base.Add(time.Duration(i) * time.Minute)
+		if i == 3 {
+			taken = base.Add(-time.Hour)
+		}
+		assert.NoError(t, os.Chtimes(path, taken, taken))
+	}
+
+	sorted := func(key string) ([]string, int) {
+		entries, err := os.ReadDir(tempDir)
+		assert.NoError(t, err)
+		calls := 0
+		for i := range entries {
+			entries[i] = infoCounter{entries[i], &calls}
+		}
+		// Start from reverse name order
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+		entries, err = sortEntries(tempDir, entries, key)
+		assert.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names, calls
+	}
+
+	// Sorting by name never reads file info
+	names, calls := sorted("name")
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt", "d.txt"}, names)
+	assert.Equal(t, 0, calls)
+
+	names, calls = sorted("mtime")
+	assert.Equal(t, []string{"d.txt", "a.txt", "b.txt", "c.txt"}, names)
+	assert.Equal(t, 4, calls)
+
+	// Equal sizes keep name order
+	names, _ = sorted("size")
+	assert.Equal(t, []string{"b.txt", "d.txt", "c.txt", "a.txt"}, names)
+
+	_, err = sortEntries(tempDir, nil, "color")
+	assert.Error(t, err)
+
+	// The sequence rule numbers in the chosen order
+	sortBy = "mtime"
+	defer func() {
+		sortBy = ""
+	}()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sequence", false, false))
+	data, err := os.ReadFile(filepath.Join(tempDir, "file_001.txt"))
+	assert.NoError(t, err)
+	assert.Len(t, data, 10)
+	data, err = os.ReadFile(filepath.Join(tempDir, "file_002.txt"))
+	assert.NoError(t, err)
+	assert.Len(t, data, 30)
+}
+
+// BenchmarkSortEntries sorts a directory of 100k files by each key, reading the directory
+// again for every sort as the rules do. Sorting by name does not stat the files.
+func BenchmarkSortEntries(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 100000; i++ {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("img_%06d.jpg", i)))
+		if err != nil {
+			b.Fatalf("Failed to create file: %v", err)
+		}
+		file.Close()
+	}
+
+	for _, key := range []string{"name", "mtime", "size"} {
+		b.Run(
+			key, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					entries, err := os.ReadDir(dir)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := sortEntries(dir, entries, key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestStemOnlyPattern(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "stem_only_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"photo.jpg", "archive.tar.gz", ".hidden", "README"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644))
+	}
+
+	stemOnly = true
+	defer func() {
+		stemOnly = false
+	}()
+	re, err := compilePattern(`^(.+)$`, false)
+	assert.NoError(t, err)
+	assert.NoError(t, processDirectory(tempDir, re, "${1}_edit", false, false))
+	assert.ElementsMatch(
+		t, []string{"photo_edit.jpg", "archive.tar_edit.gz", ".hidden_edit", "README_edit"}, listNames(t, tempDir),
+	)
+
+	// A pattern anchored to the end of the stem never sees the extension
+	re, err = compilePattern(`_edit$`, false)
+	assert.NoError(t, err)
+	assert.NoError(t, processDirectory(tempDir, re, "", false, false))
+	assert.ElementsMatch(t, []string{"photo.jpg", "archive.tar.gz", ".hidden", "README"}, listNames(t, tempDir))
+}
+
+func TestCaseRulesWithLocale(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "locale_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "izmir.jpg"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "straße.png"), nil, 0644))
+
+	caseLocale = "tr"
+	defer func() {
+		caseLocale = ""
+	}()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "uppercase", false, false))
+	assert.ElementsMatch(t, []string{"İZMİR.JPG", "STRASSE.PNG"}, listNames(t, tempDir))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "lowercase", false, false))
+	assert.ElementsMatch(t, []string{"izmir.jpg", "strasse.png"}, listNames(t, tempDir))
+
+	caseLocale = "not a locale!"
+	assert.Error(t, processDirectoryWithRule(tempDir, "lowercase", false, false))
+}
+
+func TestTitleCaseRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "title_case_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		smallWords = defaultSmallWords
+	}()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "the lord of the rings.jpg"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "walk_in_the_park.png"), nil, 0644))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "title-case", false, false))
+	assert.ElementsMatch(t, []string{"The Lord of the Rings.jpg", "Walk_in_the_Park.png"}, listNames(t, tempDir))
+	assert.True(t, alreadyApplied("title-case", "The Lord of the Rings.jpg"))
+
+	smallWords = []string{"the"}
+	assert.NoError(t, processDirectoryWithRule(tempDir, "title-case", false, false))
+	assert.ElementsMatch(t, []string{"The Lord Of the Rings.jpg", "Walk_In_the_Park.png"}, listNames(t, tempDir))
+}
+
+func TestWxExporterWorkersKeepNumbering(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_workers_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	for _, page := range []string{"home", "about"} {
+		assets := filepath.Join(source, page, "assets")
+		assert.NoError(t, os.MkdirAll(assets, 0755))
+		for i := 0; i < 20; i++ {
+			name := fmt.Sprintf("img%02d.png", i)
+			assert.NoError(t, os.WriteFile(filepath.Join(assets, name), []byte(page+"/"+name), 0644))
+		}
+	}
+
+	wxWorkers = 4
+	defer func() {
+		wxWorkers = 1
+	}()
+	output := filepath.Join(tempDir, "out")
+	assert.NoError(t, processWxExporter(source, output, false))
+
+	names := listNames(t, output)
+	assert.Len(t, names, 40)
+	for i := 0; i < 20; i++ {
+		data, err := os.ReadFile(filepath.Join(output, fmt.Sprintf("app_home_%03d.png", i+1)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("home/img%02d.png", i), string(data))
+	}
+
+	summary := &wxSummary{}
+	copied := runWxCopies(
+		[]wxCopyJob{{src: filepath.Join(tempDir, "missing.png"), dst: filepath.Join(output, "x.png")}}, 2, summary,
+	)
+	assert.Empty(t, copied)
+	assert.Equal(t, 0, summary.copied)
+	assert.Equal(t, 1, summary.failed)
+}
+
+func TestWxExporterSummary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_summary_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(source, "no-assets"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), make([]byte, 1000), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "b.jpg"), make([]byte, 24), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "notes.md"), nil, 0644))
+
+	jobs, summary, err := planWxExport(source, filepath.Join(tempDir, "out"))
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, 1, summary.dirs)
+	assert.Equal(t, 1, summary.skipped)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "out"), 0755))
+	runWxCopies(jobs, 1, summary)
+	assert.Equal(t, 2, summary.copied)
+	assert.Equal(t, 0, summary.failed)
+	assert.Equal(t, int64(1024), summary.bytes)
+
+	assert.Equal(t, "1023 B", formatSize(1023))
+	assert.Equal(t, "1.0 KiB", formatSize(1024))
+	assert.Equal(t, "1.5 MiB", formatSize(3<<19))
+}
+
+func TestWxExporterAlsoCopy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_also_copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(source, "about"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "home", "index.md"), []byte("home"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "home", "data.json"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "about", "index.md"), []byte("about"), 0644))
+
+	// Off by default
+	output := filepath.Join(tempDir, "out")
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, []string{"app_home_001.png"}, listNames(t, output))
+
+	wxAlsoCopy = []string{"*.md"}
+	defer func() {
+		wxAlsoCopy = nil
+	}()
+	output = filepath.Join(tempDir, "out2")
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, []string{"app_about_index.md", "app_home_001.png", "app_home_index.md"}, listNames(t, output))
+	data, err := os.ReadFile(filepath.Join(output, "app_about_index.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "about", string(data))
+
+	wxAlsoCopy = []string{"[bad"}
+	assert.Error(t, processWxExporter(source, output, true))
+}
+
+func TestWxExporterVerifyCopy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_verify_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), []byte("png data"), 0644))
+
+	verifyCopy = true
+	defer func() {
+		verifyCopy = false
+	}()
+	output := filepath.Join(tempDir, "out")
+	assert.NoError(t, processWxExporter(source, output, false))
+	data, err := os.ReadFile(filepath.Join(output, "app_home_001.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "png data", string(data))
+
+	assert.Error(t, copyFile(filepath.Join(assets, "missing.png"), filepath.Join(output, "x.png")))
+}
+
+func TestOnDuplicate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "on_duplicate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		onDuplicate = ""
+	}()
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(tempDir, name))
+		assert.NoError(t, err)
+		return string(data)
+	}
+	setup := func() {
+		for _, name := range listNames(t, tempDir) {
+			assert.NoError(t, os.RemoveAll(filepath.Join(tempDir, name)))
+		}
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "new.txt"), []byte("new"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "old.txt"), []byte("existing"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "old-1.txt"), []byte("existing too"), 0644))
+	}
+	oldPath, newPath := filepath.Join(tempDir, "new.txt"), filepath.Join(tempDir, "old.txt")
+
+	// The default refuses and leaves both files alone
+	setup()
+	assert.ErrorIs(t, renameFile(oldPath, newPath, false), ErrCollision)
+	assert.Equal(t, "new", read("new.txt"))
+	assert.Equal(t, "existing", read("old.txt"))
+
+	onDuplicate = duplicateSkip
+	setup()
+	assert.NoError(t, renameFile(oldPath, newPath, false))
+	assert.Equal(t, "new", read("new.txt"))
+	assert.Equal(t, "existing", read("old.txt"))
+
+	onDuplicate = duplicateOverwrite
+	setup()
+	assert.NoError(t, renameFile(oldPath, newPath, true))
+	assert.Equal(t, "existing", read("old.txt"))
+	assert.NoError(t, renameFile(oldPath, newPath, false))
+	assert.Equal(t, []string{"old-1.txt", "old.txt"}, listNames(t, tempDir))
+	assert.Equal(t, "new", read("old.txt"))
+
+	onDuplicate = duplicateRename
+	setup()
+	assert.NoError(t, renameFile(oldPath, newPath, false))
+	assert.Equal(t, []string{"old-1.txt", "old-2.txt", "old.txt"}, listNames(t, tempDir))
+	assert.Equal(t, "new", read("old-2.txt"))
+	assert.Equal(t, "existing", read("old.txt"))
+
+	// Directories are never overwritten
+	onDuplicate = duplicateOverwrite
+	setup()
+	assert.NoError(t, os.Mkdir(filepath.Join(tempDir, "dir.txt"), 0755))
+	assert.ErrorIs(t, renameFile(oldPath, filepath.Join(tempDir, "dir.txt"), false), ErrCollision)
+
+	// Paths claimed by the same run count as existing
+	dst, err := resolveDestination("", filepath.Join(tempDir, "a.txt"), duplicateRename, map[string]bool{
+		filepath.Join(tempDir, "a.txt"): true, filepath.Join(tempDir, "a-1.txt"): true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, "a-2.txt"), dst)
+
+	assert.NoError(t, checkDuplicatePolicy("rename"))
+	assert.Error(t, checkDuplicatePolicy("replace"))
+}
+
+func TestWxExporterOnDuplicate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_duplicate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		onDuplicate = ""
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), []byte("png data"), 0644))
+	output := filepath.Join(tempDir, "out")
+	assert.NoError(t, os.MkdirAll(output, 0755))
+	existing := filepath.Join(output, "app_home_001.png")
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	// Existing copies are no longer truncated silently
+	for _, policy := range []string{"", duplicateSkip} {
+		onDuplicate = policy
+		assert.NoError(t, os.WriteFile(existing, []byte("edited"), 0644))
+		assert.NoError(t, processWxExporter(source, output, false))
+		assert.Equal(t, "edited", read(existing))
+		assert.Equal(t, []string{"app_home_001.png"}, listNames(t, output))
+	}
+
+	onDuplicate = duplicateRename
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, "edited", read(existing))
+	assert.Equal(t, "png data", read(filepath.Join(output, "app_home_001-1.png")))
+
+	onDuplicate = duplicateOverwrite
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, "png data", read(existing))
+	assert.Len(t, listNames(t, output), 2)
+}
+
+func TestWxExporterResume(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_resume_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "app")
+	assets := filepath.Join(source, "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(assets, name), []byte(name+" data"), 0644))
+	}
+	output := filepath.Join(tempDir, "out")
+	assert.NoError(t, os.MkdirAll(output, 0755))
+
+	// An interrupted run: the first copy completed, the second was cut off and the
+	// third completed but was changed afterwards
+	jobs, _, err := planWxExport(source, output)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 3)
+	journal, err := openWxJournal(output, false, false)
+	assert.NoError(t, err)
+	for i, job := range jobs {
+		assert.NoError(t, journal.start(job))
+		if i == 1 {
+			assert.NoError(t, os.WriteFile(job.dst, []byte("b.p"), 0644))
+			continue
+		}
+		assert.NoError(t, copyFile(job.src, job.dst))
+		assert.NoError(t, journal.done(job))
+	}
+	assert.NoError(t, journal.file.Close())
+	assert.NoError(t, os.WriteFile(jobs[2].dst, []byte("changed"), 0644))
+
+	journal, err = openWxJournal(output, true, true)
+	assert.NoError(t, err)
+	summary := &wxSummary{}
+	remaining := journal.resume(jobs, summary)
+	assert.Equal(t, 1, summary.resumed)
+	if assert.Len(t, remaining, 2) {
+		assert.Equal(t, jobs[1].dst, remaining[0].dst)
+		assert.True(t, remaining[0].redo)
+		assert.True(t, remaining[1].redo)
+	}
+
+	wxResume = true
+	defer func() {
+		wxResume = false
+	}()
+	assert.NoError(t, processWxExporter(source, output, false))
+	for _, job := range jobs {
+		data, err := os.ReadFile(job.dst)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Base(job.src)+" data", string(data))
+	}
+	// The journal is removed once everything is copied
+	assert.Equal(t, []string{"app_home_001.png", "app_home_002.png", "app_home_003.png"}, listNames(t, output))
+}
+
+func TestResolveCopyBufferSize(t *testing.T) {
+	assert.Equal(t, 0, resolveCopyBufferSize(""))
+	assert.Equal(t, 1<<20, resolveCopyBufferSize("1MB"))
+	assert.Equal(t, 64<<10, resolveCopyBufferSize("64k"))
+
+	// Invalid and out of range sizes fall back to the default copy
+	assert.Equal(t, 0, resolveCopyBufferSize("fast"))
+	assert.Equal(t, 0, resolveCopyBufferSize("0"))
+	assert.Equal(t, 0, resolveCopyBufferSize("2GB"))
+}
+
+func TestNumberedByDateRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "numbered_by_date_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// EXIF dates interleave the two folders, the text file only has its modification time
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "day1"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "day2"), 0755))
+	writeTestJPEG(t, filepath.Join(tempDir, "day1", "a.jpg"), map[uint16]string{0x9003: "2024:05:01 10:00:00"})
+	writeTestJPEG(t, filepath.Join(tempDir, "day1", "b.jpg"), map[uint16]string{0x9003: "2024:05:01 12:00:00"})
+	writeTestJPEG(t, filepath.Join(tempDir, "day2", "c.jpg"), map[uint16]string{0x9003: "2024:05:01 11:00:00"})
+	notes := filepath.Join(tempDir, "day2", "notes.txt")
+	assert.NoError(t, os.WriteFile(notes, nil, 0644))
+	modTime := time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)
+	assert.NoError(t, os.Chtimes(notes, modTime, modTime))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "numbered-by-date", true, false))
+	assert.Equal(t, []string{"2.jpg", "4.jpg"}, listNames(t, filepath.Join(tempDir, "day1")))
+	assert.Equal(t, []string{"1.txt", "3.jpg"}, listNames(t, filepath.Join(tempDir, "day2")))
+
+	// Re-runs keep the numbers and continue after the highest one
+	writeTestJPEG(t, filepath.Join(tempDir, "day1", "d.jpg"), map[uint16]string{0x9003: "2024:05:02 10:00:00"})
+	assert.NoError(t, processDirectoryWithRule(tempDir, "numbered-by-date", true, false))
+	assert.Equal(t, []string{"2.jpg", "4.jpg", "5.jpg"}, listNames(t, filepath.Join(tempDir, "day1")))
+
+	// The padding is sized to the total count
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("new%d.txt", i)), nil, 0644))
+	}
+	sequenceName = "shoot"
+	defer func() {
+		sequenceName = ""
+	}()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "numbered-by-date", false, false))
+	names := listNames(t, tempDir)
+	assert.Len(t, names, 12)
+	assert.Equal(t, []string{"day1", "day2", "shoot_01.txt"}, names[:3])
+	assert.Equal(t, "shoot_10.txt", names[11])
+}
+
+func TestEventSeqRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "event_seq_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// The sequence runs across both folders, the text file falls back to its modification time
+	ceremony := filepath.Join(tempDir, "ceremony")
+	party := filepath.Join(tempDir, "party")
+	assert.NoError(t, os.MkdirAll(ceremony, 0755))
+	assert.NoError(t, os.MkdirAll(party, 0755))
+	writeTestJPEG(t, filepath.Join(ceremony, "a.jpg"), map[uint16]string{0x9003: "2024:06:01 14:00:00"})
+	writeTestJPEG(t, filepath.Join(party, "b.jpg"), map[uint16]string{0x9003: "2024:06:01 21:00:00"})
+	writeTestJPEG(t, filepath.Join(ceremony, "c.jpg"), map[uint16]string{0x9003: "2024:06:01 15:00:00"})
+	notes := filepath.Join(party, "notes.txt")
+	assert.NoError(t, os.WriteFile(notes, nil, 0644))
+	modTime := time.Date(2024, 6, 2, 1, 0, 0, 0, time.Local)
+	assert.NoError(t, os.Chtimes(notes, modTime, modTime))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "event-seq", true, false))
+	assert.Equal(t, []string{"ceremony_20240601_001.jpg", "ceremony_20240601_002.jpg"}, listNames(t, ceremony))
+	assert.Equal(t, []string{"party_20240601_003.jpg", "party_20240602_004.txt"}, listNames(t, party))
+
+	// Re-runs keep the names and continue after the highest number
+	writeTestJPEG(t, filepath.Join(ceremony, "d.jpg"), map[uint16]string{0x9003: "2024:06:01 13:00:00"})
+	assert.NoError(t, processDirectoryWithRule(tempDir, "event-seq", true, false))
+	assert.Equal(
+		t, []string{"ceremony_20240601_001.jpg", "ceremony_20240601_002.jpg", "ceremony_20240601_005.jpg"},
+		listNames(t, ceremony),
+	)
+}
+
+func TestIncrementExistingRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "increment_existing_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for i := 1; i <= 9; i++ {
+		name := fmt.Sprintf("shot_%d.jpg", i)
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "cover.jpg"), nil, 0644))
+	incrementBy = 1
+	defer func() {
+		incrementBy, vacatedPaths = 0, nil
+	}()
+
+	// Planning sees the chain, every target is vacated by the rename before it
+	plan, err := collectRenamePlan(func() error {
+		return processDirectoryWithRule(tempDir, "increment-existing", false, true)
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, plan.failed)
+	if assert.Len(t, plan.Renames, 9) {
+		assert.Equal(t, filepath.Join(tempDir, "shot_10.jpg"), plan.Renames[0].New)
+		assert.Equal(t, filepath.Join(tempDir, "shot_2.jpg"), plan.Renames[8].New)
+	}
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "increment-existing", false, false))
+	names := listNames(t, tempDir)
+	assert.NotContains(t, names, "shot_1.jpg")
+	assert.Contains(t, names, "shot_10.jpg")
+	assert.Len(t, names, 10)
+	content, err := os.ReadFile(filepath.Join(tempDir, "shot_10.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "shot_9.jpg", string(content))
+
+	// Decrementing runs from the lowest number up
+	incrementBy = -1
+	assert.NoError(t, processDirectoryWithRule(tempDir, "increment-existing", false, false))
+	content, err = os.ReadFile(filepath.Join(tempDir, "shot_1.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "shot_1.jpg", string(content))
+	assert.NotContains(t, listNames(t, tempDir), "shot_10.jpg")
+
+	incrementBy = 0
+	assert.Error(t, processDirectoryWithRule(tempDir, "increment-existing", false, false))
+}
+
+func TestFlattenRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "flatten_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, path := range []string{
+		"cover.jpg",
+		filepath.Join("2023", "trip", "cover.jpg"),
+		filepath.Join("2023", "trip", "unique.jpg"),
+		filepath.Join("2024", "IMG_1.jpg"),
+		filepath.Join("2024", "deep", "nested", "img_1.JPG"),
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, filepath.Dir(path)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, path), []byte(path), 0644))
+	}
+
+	// Dry-run moves nothing
+	assert.NoError(t, processDirectoryWithRule(tempDir, "flatten", false, true))
+	assert.Equal(t, []string{"2023", "2024", "cover.jpg"}, listNames(t, tempDir))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "flatten", false, false))
+	assert.Equal(
+		t, []string{
+			"2023", "2023_trip_cover.jpg", "2024", "2024_IMG_1.jpg", "2024_deep_nested_img_1.JPG", "cover.jpg", "unique.jpg",
+		},
+		listNames(t, tempDir),
+	)
+	data, err := os.ReadFile(filepath.Join(tempDir, "2023_trip_cover.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("2023", "trip", "cover.jpg"), string(data))
+	assert.Empty(t, listNames(t, filepath.Join(tempDir, "2023", "trip")))
+
+	root := filepath.Join("scan", "root")
+	assert.Equal(t, "sub_folder_file.jpg", relativeName(root, filepath.Join(root, "sub", "folder", "file.jpg")))
+}
+
+func TestPruneEmptyDirs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "prune_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		vacatedPaths = nil
+		pruneHidden = false
+	}()
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "a", "b"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "a", "x.jpg"), []byte("x"), 0644))
+
+	// A dry-run counts the files its renames move away as gone
+	assert.NoError(t, processDirectoryWithRule(tempDir, "flatten", false, true))
+	assert.Equal(t, 2, pruneEmptyDirs(tempDir, true))
+	assert.Equal(t, []string{"a"}, listNames(t, tempDir))
+	vacatedPaths = nil
+
+	// Nested empty directories go, the one holding a hidden file is kept
+	assert.NoError(t, processDirectoryWithRule(tempDir, "flatten", false, false))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "c"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "c", ".DS_Store"), nil, 0644))
+	assert.Equal(t, 2, pruneEmptyDirs(tempDir, false))
+	assert.Equal(t, []string{"c", "x.jpg"}, listNames(t, tempDir))
+
+	pruneHidden = true
+	assert.Equal(t, 1, pruneEmptyDirs(tempDir, false))
+	assert.Equal(t, []string{"x.jpg"}, listNames(t, tempDir))
+	assert.Equal(t, 0, pruneEmptyDirs(tempDir, false))
+}
+
+func TestRenameLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rename_log_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		renameLogPath = ""
+	}()
+
+	photos := filepath.Join(tempDir, "photos")
+	assert.NoError(t, os.MkdirAll(photos, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(photos, "A.JPG"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(photos, "b.jpg"), nil, 0644))
+	renameLogPath = filepath.Join(tempDir, "renames.log")
+
+	// Each run appends to the lines of the earlier ones
+	for _, rule := range []string{"lowercase", "uppercase"} {
+		finish, err := startRenameLog(rule)
+		assert.NoError(t, err)
+		assert.NoError(t, processDirectoryWithRule(photos, rule, false, false))
+		finish()
+	}
+	assert.Nil(t, activeRenameLog)
+
+	data, err := os.ReadFile(renameLogPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Len(t, lines, 3)
+	var entries []renameLogLine
+	for _, line := range lines {
+		var entry renameLogLine
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, currentUser(), entry.User)
+		assert.False(t, entry.Time.IsZero())
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, filepath.Join(photos, "A.JPG"), entries[0].Old)
+	assert.Equal(t, filepath.Join(photos, "a.jpg"), entries[0].New)
+	assert.Equal(t, "lowercase", entries[0].Rule)
+	assert.Equal(t, "uppercase", entries[2].Rule)
+
+	// Without --rename-log nothing is logged
+	renameLogPath = ""
+	finish, err := startRenameLog("lowercase")
+	assert.NoError(t, err)
+	assert.Nil(t, activeRenameLog)
+	finish()
+}
+
+func TestSanitizeRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sanitize_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "what?"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "what?", "a:b.jpg"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "nul.txt"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "fine.txt"), nil, 0644))
+
+	targetFS, replaceChar = "windows", "_"
+	defer func() {
+		targetFS, replaceChar = "", ""
+	}()
+
+	// Dry-run leaves everything in place but still descends into directories
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sanitize", true, true))
+	assert.Equal(t, []string{"a:b.jpg"}, listNames(t, filepath.Join(tempDir, "what?")))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "sanitize", true, false))
+	assert.Equal(t, []string{"fine.txt", "nul_.txt", "what_"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"a_b.jpg"}, listNames(t, filepath.Join(tempDir, "what_")))
+
+	targetFS = "amiga"
+	assert.Error(t, processDirectoryWithRule(tempDir, "sanitize", false, false))
+}
+
+func TestReplaceCharRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "replace_char_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "a.b"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "a.b", "x.y.txt"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "2024.05.01.jpg"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "plain.jpg"), nil, 0644))
+
+	replaceFrom, replaceTo = ".", "-"
+	defer func() {
+		replaceFrom, replaceTo, replaceInExt = "", "", false
+	}()
+
+	// Directories keep their names, the extension is kept
+	assert.NoError(t, processDirectoryWithRule(tempDir, "replace-char", true, false))
+	assert.Equal(t, []string{"2024-05-01.jpg", "a.b", "plain.jpg"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"x-y.txt"}, listNames(t, filepath.Join(tempDir, "a.b")))
+
+	replaceInExt = true
+	assert.NoError(t, processDirectoryWithRule(tempDir, "replace-char", false, false))
+	assert.Equal(t, []string{"2024-05-01-jpg", "a.b", "plain-jpg"}, listNames(t, tempDir))
+
+	replaceFrom = ""
+	assert.Error(t, processDirectoryWithRule(tempDir, "replace-char", false, false))
+}
+
+func TestErrorsOut(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "errors_out_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	errorsOut = filepath.Join(tempDir, "errors.json")
+	defer func() {
+		errorsOut, runFailed = "", false
+	}()
+
+	// A clean run writes an empty array
+	finish := startIssues()
+	assert.NoError(t, finish())
+	data, err := os.ReadFile(errorsOut)
+	assert.NoError(t, err)
+	assert.JSONEq(t, "[]", string(data))
+	assert.False(t, runFailed)
+
+	files := filepath.Join(tempDir, "files")
+	assert.NoError(t, os.Mkdir(files, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(files, "A.txt"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(files, "a.txt"), nil, 0644))
+
+	finish = startIssues()
+	assert.NoError(t, processDirectoryWithRule(files, "lowercase", false, false))
+	assert.NoError(t, finish())
+	assert.Nil(t, activeIssues)
+	assert.True(t, runFailed)
+
+	var issues []runIssue
+	data, err = os.ReadFile(errorsOut)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &issues))
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, "error", issues[0].Level)
+		assert.Equal(t, "rename", issues[0].Operation)
+		assert.Equal(t, filepath.Join(files, "A.txt"), issues[0].Path)
+		assert.Contains(t, issues[0].Message, "target already exists")
+	}
+}
+
+func TestReport(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "report_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	reportFile = filepath.Join(tempDir, "report.json")
+	defer func() {
+		reportFile, runFailed, onDuplicate = "", false, duplicateError
+	}()
+
+	cmd := &cobra.Command{Use: "rename"}
+	cmd.Flags().String("rule", "", "")
+	cmd.Flags().Bool("dry-run", false, "")
+	assert.NoError(t, cmd.Flags().Set("rule", "lowercase"))
+
+	files := filepath.Join(tempDir, "files")
+	assert.NoError(t, os.Mkdir(files, 0755))
+	for _, name := range []string{"A.txt", "a.txt", "B.txt", "C.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(files, name), nil, 0644))
+	}
+
+	readReport := func() reportSummary {
+		var summary reportSummary
+		data, err := os.ReadFile(reportFile)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &summary))
+		return summary
+	}
+
+	startReport(cmd)
+	assert.NoError(t, processDirectoryWithRule(files, "lowercase", false, false))
+	assert.NoError(t, finishReport(nil))
+	assert.Nil(t, activeReport)
+	assert.True(t, runFailed)
+
+	summary := readReport()
+	assert.Equal(t, "rename", summary.Command)
+	assert.Equal(t, map[string]string{"rule": "lowercase"}, summary.Options)
+	assert.Equal(t, 3, summary.Processed)
+	assert.Equal(t, 2, summary.Succeeded)
+	assert.Equal(t, 0, summary.Skipped)
+	assert.Equal(t, 1, summary.Failed)
+	assert.False(t, summary.Success)
+	if assert.Len(t, summary.Errors, 1) {
+		assert.Equal(t, "rename", summary.Errors[0].Operation)
+		assert.Equal(t, filepath.Join(files, "A.txt"), summary.Errors[0].Path)
+	}
+
+	// Skipped files are no failures
+	runFailed, onDuplicate = false, duplicateSkip
+	startReport(cmd)
+	assert.NoError(t, processDirectoryWithRule(files, "lowercase", false, false))
+	assert.NoError(t, finishReport(nil))
+	assert.False(t, runFailed)
+	summary = readReport()
+	assert.Equal(t, 1, summary.Processed)
+	assert.Equal(t, 0, summary.Succeeded)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Empty(t, summary.Errors)
+	assert.True(t, summary.Success)
+
+	// The error of the command fails the run
+	startReport(cmd)
+	assert.NoError(t, finishReport(ErrUnknownRule))
+	assert.True(t, runFailed)
+	summary = readReport()
+	assert.Equal(t, 1, summary.Failed)
+	assert.False(t, summary.Success)
+
+	// Nothing is written without --report
+	assert.NoError(t, os.Remove(reportFile))
+	reportFile = ""
+	startReport(cmd)
+	assert.Nil(t, activeReport)
+	assert.NoError(t, finishReport(nil))
+	assert.NoFileExists(t, filepath.Join(tempDir, "report.json"))
+}
+
+func TestWxExporterSeparator(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_separator_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "my_app")
+	assets := filepath.Join(source, "page_one", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.png"), nil, 0644))
+
+	wxSeparator = "__"
+	defer func() {
+		wxSeparator, wxEscapeSeparator = "_", false
+	}()
+	jobs, _, err := planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, filepath.Join("out", "my_app__page_one__001.png"), jobs[0].dst)
+	}
+
+	wxSeparator, wxEscapeSeparator = "_", true
+	jobs, _, err = planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, filepath.Join("out", "my-app_page-one_001.png"), jobs[0].dst)
+	}
+
+	wxSeparator = "-"
+	assert.Equal(t, "page_one", wxNamePart("page-one"))
+}
+
+func TestWxExporterSlugOutput(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_slug_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "My Shop")
+	assets := filepath.Join(source, "首页 Banner", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "a.PNG"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "首页 Banner", "Read Me.md"), nil, 0644))
+
+	// Raw names are kept by default
+	wxAlsoCopy = []string{"*.md"}
+	defer func() {
+		wxAlsoCopy, wxSlugOutput = nil, false
+	}()
+	jobs, _, err := planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, filepath.Join("out", "My Shop_首页 Banner_Read Me.md"), jobs[0].dst)
+		assert.Equal(t, filepath.Join("out", "My Shop_首页 Banner_001.png"), jobs[1].dst)
+	}
+
+	wxSlugOutput = true
+	jobs, _, err = planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, filepath.Join("out", "my-shop_banner_read-me.md"), jobs[0].dst)
+		assert.Equal(t, filepath.Join("out", "my-shop_banner_001.png"), jobs[1].dst)
+	}
+}
+
+func TestWxExporterRecursive(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_recursive_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "site")
+	for _, dir := range []string{"home", filepath.Join("blog", "2023", "post"), filepath.Join("home", "assets", "icons")} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(source, dir, "assets"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(source, dir, "assets", "a.png"), nil, 0644))
+	}
+
+	// By default only the first level is searched
+	jobs, _, err := planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, filepath.Join("out", "site_home_001.png"), jobs[0].dst)
+	}
+
+	recursive = true
+	defer func() {
+		recursive, wxSeparator = false, "_"
+	}()
+	jobs, summary, err := planWxExport(source, "out")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.dirs)
+	var names []string
+	for _, job := range jobs {
+		names = append(names, filepath.Base(job.dst))
+	}
+	assert.Equal(t, []string{"site_blog-2023-post_001.png", "site_home_001.png"}, names)
+
+	wxSeparator = "-"
+	jobs, _, err = planWxExport(source, "out")
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, filepath.Join("out", "site-blog_2023_post-001.png"), jobs[0].dst)
+	}
+}
+
+func TestWxExporterOutputManifest(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "wx_manifest_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	source := filepath.Join(tempDir, "src")
+	assets := filepath.Join(source, "post", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "x.png"), []byte("x"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(assets, "y.jpg"), []byte("y"), 0644))
+
+	output := filepath.Join(tempDir, "out")
+	wxOutputManifest = filepath.Join(tempDir, "manifest.json")
+	defer func() {
+		wxOutputManifest = ""
+	}()
+	readManifest := func() []wxManifestEntry {
+		data, err := os.ReadFile(wxOutputManifest)
+		assert.NoError(t, err)
+		var entries []wxManifestEntry
+		assert.NoError(t, json.Unmarshal(data, &entries))
+		return entries
+	}
+	want := []wxManifestEntry{
+		{
+			Source: filepath.Join(assets, "x.png"), Path2: "post", Output: "src_post_001.png",
+			SHA256: "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881",
+		},
+		{
+			Source: filepath.Join(assets, "y.jpg"), Path2: "post", Output: "src_post_002.jpg",
+			SHA256: "a1fce4363854ff888cff4b8e7875d600c2682390412a8cf79b37d0b11148b0fa",
+		},
+	}
+
+	// Dry-run writes the planned mapping
+	assert.NoError(t, processWxExporter(source, output, true))
+	assert.Equal(t, want, readManifest())
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, processWxExporter(source, output, false))
+	assert.Equal(t, want, readManifest())
+
+	// Copies that fail are left out
+	jobs := []wxCopyJob{
+		{src: filepath.Join(assets, "x.png"), dst: filepath.Join(output, "a.png"), path2: "post"},
+		{src: filepath.Join(assets, "gone.png"), dst: filepath.Join(output, "b.png"), path2: "post"},
+	}
+	copied := runWxCopies(jobs, 2, &wxSummary{})
+	assert.NoError(t, writeWxManifest(wxOutputManifest, copied, false))
+	if entries := readManifest(); assert.Len(t, entries, 1) {
+		assert.Equal(t, "a.png", entries[0].Output)
+	}
+}
+
+func TestDirLock(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "lock_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+	lockPath := filepath.Join(tempDir, lockFileName)
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "a.jpg"), nil, 0644))
+
+	lock, err := acquireDirLock(tempDir, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.FileExists(t, lockPath)
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, filterEntries(tempDir, entries), 1)
+
+	// A second run fails at once, or gets the lock once the first releases it
+	_, err = acquireDirLock(tempDir, 0, time.Minute)
+	assert.ErrorContains(t, err, fmt.Sprintf("locked by another pyrgear run (pid %d", os.Getpid()))
+	go func(first *dirLock) {
+		time.Sleep(100 * time.Millisecond)
+		first.release()
+	}(lock)
+	second, err := acquireDirLock(tempDir, 5*time.Second, time.Minute)
+	assert.NoError(t, err)
+	second.release()
+	assert.NoFileExists(t, lockPath)
+
+	// Locks of a crashed run are taken over, by age if written on another machine
+	host, _ := os.Hostname()
+	writeLock := func(info lockInfo, age time.Duration) {
+		data, _ := json.Marshal(info)
+		assert.NoError(t, os.WriteFile(lockPath, data, 0644))
+		modified := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(lockPath, modified, modified))
+	}
+	writeLock(lockInfo{PID: 1 << 30, Host: host}, 0)
+	lock, err = acquireDirLock(tempDir, 0, time.Minute)
+	assert.NoError(t, err)
+	lock.release()
+
+	writeLock(lockInfo{PID: 1 << 30, Host: "elsewhere"}, 0)
+	_, err = acquireDirLock(tempDir, 0, time.Minute)
+	assert.ErrorContains(t, err, "on elsewhere")
+	writeLock(lockInfo{PID: 1 << 30, Host: "elsewhere"}, time.Hour)
+	lock, err = acquireDirLock(tempDir, 0, time.Minute)
+	assert.NoError(t, err)
+	lock.release()
+
+	// Runs waiting on the same stale lock take it over one at a time
+	writeLock(lockInfo{PID: 1 << 30, Host: host}, 0)
+	var held, maxHeld atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := acquireDirLock(tempDir, 10*time.Second, time.Minute)
+			if !assert.NoError(t, err) {
+				return
+			}
+			n := held.Add(1)
+			for {
+				m := maxHeld.Load()
+				if n <= m || maxHeld.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			held.Add(-1)
+			lock.release()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), maxHeld.Load())
+	assert.NoFileExists(t, lockPath)
+	assert.NoFileExists(t, lockPath+lockTakeoverSuffix)
+}
+
+func TestAtomicRenameAppliesAllOrNothing(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "atomic_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	atomicRename = true
+	defer func() {
+		atomicRename = false
+	}()
+
+	// Nested directories are renamed together with their files
+	nested := filepath.Join(tempDir, "a:b")
+	assert.NoError(t, os.MkdirAll(nested, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(nested, "c?.txt"), []byte("c"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "d|e.txt"), []byte("d"), 0644))
+	targetFS, replaceChar = "windows", "_"
+	assert.NoError(t, runRenames(func() error {
+		return processDirectoryWithRule(tempDir, "sanitize", true, false)
+	}))
+	assert.Equal(t, []string{"a_b", "d_e.txt"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"c_.txt"}, listNames(t, filepath.Join(tempDir, "a_b")))
+
+	// A rename that cannot be planned leaves every file untouched
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "X.txt"), []byte("x"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "Y.txt"), []byte("y"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "y.txt"), []byte("other"), 0644))
+	err = runRenames(func() error {
+		return processDirectoryWithRule(tempDir, "lowercase", false, false)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"X.txt", "Y.txt", "a_b", "d_e.txt", "y.txt"}, listNames(t, tempDir))
+
+	// A failure while applying rolls back the renames already made
+	plan := &renamePlan{Renames: []plannedRename{
+		{Old: filepath.Join(tempDir, "X.txt"), New: filepath.Join(tempDir, "x.txt")},
+		{Old: filepath.Join(tempDir, "d_e.txt"), New: filepath.Join(tempDir, "x.txt")},
+	}}
+	assert.ErrorIs(t, applyAtomic(plan), ErrCollision)
+	assert.Equal(t, []string{"X.txt", "Y.txt", "a_b", "d_e.txt", "y.txt"}, listNames(t, tempDir))
+}
+
+func TestExifDateRuleUseSubsec(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_date_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	const taken = "2024:05:06 07:08:09"
+	writeImages := func() {
+		writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x9003: taken, 0x9291: "123"})
+		writeTestJPEG(t, filepath.Join(tempDir, "b.jpg"), map[uint16]string{0x9003: taken, 0x9291: "5"})
+		writeTestJPEG(t, filepath.Join(tempDir, "c.jpg"), map[uint16]string{0x9003: taken})
+		writeTestJPEG(t, filepath.Join(tempDir, "d.jpg"), map[uint16]string{0x9003: taken})
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("notes"), 0644))
+	}
+
+	// Without --use-subsec the shared second collides
+	writeImages()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "exif-date", false, false))
+	assert.Equal(t, []string{"20240506_070809.jpg", "b.jpg", "c.jpg", "d.jpg", "notes.txt"}, listNames(t, tempDir))
+	assert.NoError(t, os.Remove(filepath.Join(tempDir, "20240506_070809.jpg")))
+
+	// With it the milliseconds tell the shots apart, and a suffix the shots without sub-second data
+	useSubsec = true
+	defer func() {
+		useSubsec = false
+	}()
+	for _, name := range []string{"b.jpg", "c.jpg", "d.jpg"} {
+		assert.NoError(t, os.Remove(filepath.Join(tempDir, name)))
+	}
+	writeImages()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "exif-date", false, false))
+	assert.Equal(
+		t, []string{
+			"20240506_070809-1.jpg", "20240506_070809.jpg", "20240506_070809_123.jpg", "20240506_070809_500.jpg",
+			"notes.txt",
+		}, listNames(t, tempDir),
+	)
+
+	// Renamed images are left alone, new ones continue the numbering
+	writeTestJPEG(t, filepath.Join(tempDir, "e.jpg"), map[uint16]string{0x9003: taken})
+	assert.NoError(t, processDirectoryWithRule(tempDir, "exif-date", false, false))
+	assert.FileExists(t, filepath.Join(tempDir, "20240506_070809-2.jpg"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "e.jpg"))
+}
+
+func TestExifDateKeepOriginal(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "exif_date_keep_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	keepOriginal = true
+	defer func() {
+		keepOriginal = false
+	}()
+
+	// The stem of a long name is cut so the new name stays within the limit
+	long := strings.Repeat("x", 250) + ".jpg"
+	writeTestJPEG(t, filepath.Join(tempDir, "IMG_0042.jpg"), map[uint16]string{0x9003: "2023:06:15 14:30:22"})
+	writeTestJPEG(t, filepath.Join(tempDir, long), map[uint16]string{0x9003: "2023:06:15 14:30:23"})
+	assert.NoError(t, processDirectoryWithRule(tempDir, "exif-date", false, false))
+
+	names := listNames(t, tempDir)
+	assert.Len(t, names, 2)
+	assert.Equal(t, "20230615_143022_IMG_0042.jpg", names[0])
+	assert.Equal(t, "20230615_143023_"+strings.Repeat("x", 235)+".jpg", names[1])
+	assert.Len(t, names[1], pyrgear.MaxNameBytes)
+
+	// Names that already carry the date are left alone
+	assert.NoError(t, processDirectoryWithRule(tempDir, "exif-date", false, false))
+	assert.Equal(t, names, listNames(t, tempDir))
+}
+
+func TestOCRDateRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "ocr_date_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		ocrCmd, ocrDateOrder = "", "dmy"
+	}()
+
+	// cat stands in for the OCR command, the scans hold their text
+	scans := map[string]string{
+		"scan 1.png": "SUPERMARKT\nDatum: 14.07.2023 10:31\nSumme 12,50",
+		"scan 2.png": "Receipt 14 Jul 2023",
+		"scan 3.pdf": "no date here",
+		"notes.txt":  "01.01.2020",
+	}
+	for name, text := range scans {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(text), 0644))
+	}
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	assert.NoError(t, os.Chtimes(filepath.Join(tempDir, "scan 3.pdf"), modified, modified))
+
+	assert.ErrorContains(t, processDirectoryWithRule(tempDir, "ocr-date", false, false), "--ocr-cmd is required")
+
+	ocrCmd = "cat {}"
+	assert.NoError(t, processDirectoryWithRule(tempDir, "ocr-date", false, false))
+	assert.Equal(
+		t, []string{"20210304_050607.pdf", "20230714_000000-1.png", "20230714_000000.png", "notes.txt"},
+		listNames(t, tempDir),
+	)
+
+	// A failing command leaves the file alone
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "scan 4.jpg"), nil, 0644))
+	ocrCmd = "false"
+	assert.NoError(t, processDirectoryWithRule(tempDir, "ocr-date", false, false))
+	assert.FileExists(t, filepath.Join(tempDir, "scan 4.jpg"))
+
+	name, args := ocrCommand("tesseract {} stdout", "a b.png")
+	assert.Equal(t, "tesseract", name)
+	assert.Equal(t, []string{"a b.png", "stdout"}, args)
+	_, args = ocrCommand("ocr --lang deu", "a.png")
+	assert.Equal(t, []string{"--lang", "deu", "a.png"}, args)
+}
+
+func TestColorTagRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "color_tag_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		colorFormat = "name"
+	}()
+
+	swatch := func(name string, c color.RGBA) {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		file, err := os.Create(filepath.Join(tempDir, name))
+		assert.NoError(t, err)
+		defer file.Close()
+		assert.NoError(t, png.Encode(file, img))
+	}
+	swatch("sky.png", color.RGBA{R: 30, G: 80, B: 220, A: 255})
+	swatch("red_done.png", color.RGBA{R: 30, G: 80, B: 220, A: 255})
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "broken.png"), []byte("not a png"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "notes.txt"), nil, 0644))
+
+	// Tagged files are skipped, files that are not images left alone
+	assert.NoError(t, processDirectoryWithRule(tempDir, "color-tag", false, false))
+	assert.Equal(t, []string{"blue_sky.png", "broken.png", "notes.txt", "red_done.png"}, listNames(t, tempDir))
+
+	swatch("sun.png", color.RGBA{R: 255, G: 140, B: 0, A: 255})
+	colorFormat = "hex"
+	assert.NoError(t, processDirectoryWithRule(tempDir, "color-tag", false, false))
+	assert.Contains(t, listNames(t, tempDir), "ff8c00_sun.png")
+
+	colorFormat = "rgb"
+	assert.ErrorContains(t, processDirectoryWithRule(tempDir, "color-tag", false, false), "--color-format")
+}
+
+func TestDateFromFilenameRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "name_date_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+		nameDateOrder = "dmy"
+	}()
+
+	for _, name := range []string{"IMG_20230615_1200.jpg", "scan 04.07.2023.pdf", "2023-06-15 done.jpg", "notes.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644))
+	}
+
+	// Ambiguous dates are read day first, files without a date and canonical ones are left alone
+	assert.NoError(t, processDirectoryWithRule(tempDir, "date-from-filename", false, false))
+	assert.Equal(
+		t, []string{"2023-06-15 done.jpg", "IMG_2023-06-15_1200.jpg", "notes.txt", "scan 2023-07-04.pdf"},
+		listNames(t, tempDir),
+	)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "receipt 04-07-2023.png"), nil, 0644))
+	nameDateOrder = "mdy"
+	assert.NoError(t, processDirectoryWithRule(tempDir, "date-from-filename", false, false))
+	assert.Contains(t, listNames(t, tempDir), "receipt 2023-04-07.png")
+
+	nameDateOrder = "ymd"
+	assert.ErrorContains(t, processDirectoryWithRule(tempDir, "date-from-filename", false, false), "--name-date-order")
+}
+
+func TestRenameNameLengthLimit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rename_truncate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"a1.txt", "a2.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644))
+	}
+	// The distinguishing number ends up beyond the limit
+	re := regexp.MustCompile(`^a(\d)\.txt$`)
+	repl := strings.Repeat("x", 300) + "$1.txt"
+
+	// Over-length names are reported, not renamed
+	assert.ErrorIs(t, renameFile(filepath.Join(tempDir, "a1.txt"), filepath.Join(tempDir, repl), false), ErrNameTooLong)
+	assert.NoError(t, processDirectory(tempDir, re, repl, false, false))
+	assert.Equal(t, []string{"a1.txt", "a2.txt"}, listNames(t, tempDir))
+
+	// --truncate cuts the stem, --truncate-hash keeps the names distinct
+	truncateNames, truncateHash = true, true
+	defer func() {
+		truncateNames, truncateHash = false, false
+	}()
+	assert.NoError(t, processDirectory(tempDir, re, repl, false, false))
+	names := listNames(t, tempDir)
+	assert.Len(t, names, 2)
+	for _, name := range names {
+		assert.Len(t, name, pyrgear.MaxNameBytes)
+		assert.Regexp(t, `^x+~[0-9a-f]{8}\.txt$`, name)
+	}
+}
+
+func TestReplaceEmptyWith(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rename_empty_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"###.jpg", "#.png", "unnamed_1.jpg", ".gitignore"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644))
+	}
+	replaceFrom, replaceTo, replaceEmptyWith = "#", "", "unnamed"
+	defer func() {
+		replaceFrom, replaceTo, replaceEmptyWith, emptyNamesTaken = "", "", "", nil
+	}()
+
+	// Names left without a stem get the fallback and the first free index
+	assert.NoError(t, processDirectoryWithRule(tempDir, "replace-char", false, false))
+	assert.Equal(
+		t, []string{".gitignore", "unnamed_1.jpg", "unnamed_1.png", "unnamed_2.jpg"}, listNames(t, tempDir),
+	)
+	content, err := os.ReadFile(filepath.Join(tempDir, "unnamed_2.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "###.jpg", string(content))
+
+	// A pattern replacing the whole name keeps the extension of the file
+	replaceEmptyWith = "photo"
+	assert.NoError(t, processDirectory(tempDir, regexp.MustCompile(`^unnamed_1\.jpg$`), "", false, false))
+	assert.Contains(t, listNames(t, tempDir), "photo_1.jpg")
+}
+
+func TestDiffNames(t *testing.T) {
+	for _, tc := range []struct {
+		old, new       string
+		prefix, suffix int
+	}{
+		{"photo_1.jpg", "photo_01.jpg", 6, 5},
+		{"IMG_001.JPG", "img_001.jpg", 0, 0},
+		{"aa", "aaa", 2, 0},
+		{"café.jpg", "cafe.jpg", 3, 4},
+		{"same.txt", "same.txt", 8, 0},
+	} {
+		prefix, suffix := diffNames(tc.old, tc.new)
+		assert.Equal(t, [2]int{tc.prefix, tc.suffix}, [2]int{prefix, suffix}, tc.old)
+	}
+
+	assert.Equal(
+		t, "\x1b[2mphoto_\x1b[0m\x1b[2m1.jpg\x1b[0m -> \x1b[2mphoto_\x1b[0m\x1b[32m0\x1b[0m\x1b[2m1.jpg\x1b[0m",
+		colorDiff("photo_1.jpg", "photo_01.jpg"),
+	)
+	// Output that is not a terminal is never colored
+	assert.Equal(t, "a.jpg -> b.jpg", renameArrow("a.jpg", "b.jpg"))
+}
+
+func TestMaxFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "max_files_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	assets := filepath.Join(tempDir, "source", "home", "assets")
+	assert.NoError(t, os.MkdirAll(assets, 0755))
+	for _, name := range []string{"a.png", "b.png", "c.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(assets, name), nil, 0644))
+	}
+	defer func() {
+		maxFiles, force = 0, false
+	}()
+
+	// Only the files that would be processed count
+	maxFiles = 2
+	total, ok := checkRenameLimit([]string{assets}, false, nil)
+	assert.False(t, ok)
+	assert.Equal(t, 3, total)
+	total, ok = checkRenameLimit([]string{assets}, false, regexp.MustCompile(`\.png$`))
+	assert.True(t, ok)
+	assert.Equal(t, 2, total)
+	_, ok = checkRenameLimit([]string{filepath.Join(tempDir, "source")}, true, nil)
+	assert.False(t, ok)
+	_, ok = checkRenameLimit([]string{filepath.Join(tempDir, "source")}, false, nil)
+	assert.True(t, ok)
+
+	// Nothing is done, not even creating the output directory
+	output := filepath.Join(tempDir, "out")
+	assert.ErrorIs(t, processWxExporter(filepath.Join(tempDir, "source"), output, false), ErrTooManyFiles)
+	assert.NoDirExists(t, output)
+
+	force = true
+	total, ok = checkRenameLimit([]string{assets}, false, nil)
+	assert.True(t, ok)
+	// Without a progress to show, the files are not counted
+	assert.Equal(t, -1, total)
+	assert.NoError(t, processWxExporter(filepath.Join(tempDir, "source"), output, false))
+	assert.Len(t, listNames(t, output), 3)
+}
+
+func TestRenameProgressTotal(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rename_progress_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"a.txt", "b.txt", "c.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644))
+	}
+	stdout, err := os.Create(filepath.Join(tempDir, "stdout"))
+	assert.NoError(t, err)
+	defer stdout.Close()
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() {
+		os.Stdout = realStdout
+		directory, pattern, replacement, dryRun = "", "", "", false
+		progressJSON, progressJSONInterval = "", time.Second
+	}()
+
+	// The bar and --progress-json get the files the pattern matches as the total
+	progressJSON = filepath.Join(t.TempDir(), "progress.ndjson")
+	directory, pattern, replacement, dryRun = tempDir, `\.txt$`, ".md", true
+	RenameCmd.Run(RenameCmd, nil)
+	os.Stdout = realStdout
+	data, err := os.ReadFile(progressJSON)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal(t, float64(2), last["total"])
+	assert.Equal(t, float64(2), last["processed"])
+	assert.Equal(t, true, last["done"])
+}
+
+func TestFromCSVRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "from_csv_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	dir := filepath.Join(tempDir, "photos")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "taken.jpg", "sub/d.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	mappingPath = filepath.Join(tempDir, "map.csv")
+	defer func() {
+		mappingPath = ""
+	}()
+	mapping := "old_name,new_name\n" +
+		"a.jpg,alpha.jpg\n" +
+		filepath.Join(dir, "sub", "d.jpg") + ",sub/delta.jpg\n" +
+		"missing.jpg,x.jpg\n" +
+		"b.jpg,alpha.jpg\n" +
+		"\"c.jpg\", taken.jpg\n"
+	assert.NoError(t, os.WriteFile(mappingPath, []byte(mapping), 0644))
+
+	// Missing sources, duplicate targets and existing targets are reported and skipped
+	activeIssues = &issueLog{}
+	defer func() {
+		activeIssues = nil
+	}()
+	assert.NoError(t, processDirectoryWithRule(dir, "from-csv", false, false))
+	assert.Equal(t, []string{"alpha.jpg", "b.jpg", "c.jpg", "sub", "taken.jpg"}, listNames(t, dir))
+	assert.Equal(t, []string{"delta.jpg"}, listNames(t, filepath.Join(dir, "sub")))
+	var messages []string
+	for _, issue := range activeIssues.issues {
+		messages = append(messages, issue.Message)
+	}
+	assert.Len(t, messages, 3)
+	assert.Contains(t, messages[0], "line 4: source does not exist")
+	assert.Contains(t, messages[1], "line 5: target already exists")
+	assert.Contains(t, messages[2], "target already exists: "+filepath.Join(dir, "taken.jpg"))
+
+	// A mapping is required
+	mappingPath = ""
+	assert.Error(t, processDirectoryWithRule(dir, "from-csv", false, false))
+}
+
+func TestLookupRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "lookup_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	dir := filepath.Join(tempDir, "scans")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	names := []string{"scan_ID1234.jpg", "scan_ID5678.png", "scan_ID9999.jpg", "notes.txt", "sub/ID1234.tif"}
+	for _, name := range names {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	mappingPath = filepath.Join(tempDir, "ids.csv")
+	defer func() {
+		mappingPath, pattern = "", ""
+	}()
+	assert.NoError(t, os.WriteFile(mappingPath, []byte("key,name\nID1234,jane-doe\nID5678,john-roe\n"), 0644))
+	pattern = `(ID\d+)`
+
+	// Unknown keys are skipped with a warning, names the pattern does not match are left alone
+	activeIssues = &issueLog{}
+	defer func() {
+		activeIssues = nil
+	}()
+	assert.NoError(t, processDirectoryWithRule(dir, "lookup", true, false))
+	assert.Equal(
+		t, []string{"jane-doe.jpg", "john-roe.png", "notes.txt", "scan_ID9999.jpg", "sub"}, listNames(t, dir),
+	)
+	assert.Equal(t, []string{"jane-doe.tif"}, listNames(t, filepath.Join(dir, "sub")))
+	assert.Len(t, activeIssues.issues, 1)
+	assert.Contains(t, activeIssues.issues[0].Message, `key "ID9999" is not in`)
+
+	// Without groups the whole match is the key
+	key, ok := lookupKey(regexp.MustCompile(`ID\d+`), "scan_ID42")
+	assert.True(t, ok)
+	assert.Equal(t, "ID42", key)
+
+	// Keys listed twice fail the run before anything is renamed
+	assert.NoError(t, os.WriteFile(mappingPath, []byte("ID9999,a\nID9999,b\n"), 0644))
+	assert.ErrorContains(t, processDirectoryWithRule(dir, "lookup", false, false), "already listed on line 1")
+	assert.FileExists(t, filepath.Join(dir, "scan_ID9999.jpg"))
+
+	pattern = ""
+	assert.Error(t, processDirectoryWithRule(dir, "lookup", false, false))
+}
+
+func TestNumberedByDateTimeZones(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "numbered_tz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// Shot in New York at 09:00 (14:00 UTC) and in Tokyo at 20:00 (11:00 UTC) of the same day,
+	// the third camera records no offset
+	writeTestJPEG(t, filepath.Join(tempDir, "a.jpg"), map[uint16]string{0x9003: "2024:05:01 09:00:00", 0x9011: "-05:00"})
+	writeTestJPEG(t, filepath.Join(tempDir, "b.jpg"), map[uint16]string{0x9003: "2024:05:01 20:00:00", 0x9011: "+09:00"})
+	writeTestJPEG(t, filepath.Join(tempDir, "c.jpg"), map[uint16]string{0x9003: "2024:05:01 13:00:00"})
+
+	record, err := pyrgear.DecodeEXIFFile(filepath.Join(tempDir, "a.jpg"))
+	assert.NoError(t, err)
+	offset, _ := record.Get("OffsetTimeOriginal")
+	assert.Equal(t, "-05:00", offset)
+
+	// Taken as UTC the untagged shot falls between the two
+	assert.NoError(t, assumeTZ.Set("UTC"))
+	defer func() {
+		assumeTZ = tzValue{}
+	}()
+	date := func(name string) time.Time {
+		entries, err := os.ReadDir(tempDir)
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			if entry.Name() == name {
+				return fileDate(filepath.Join(tempDir, name), entry)
+			}
+		}
+		t.Fatalf("%s not found", name)
+		return time.Time{}
+	}
+	assert.True(t, date("b.jpg").Before(date("c.jpg")))
+	assert.True(t, date("c.jpg").Before(date("a.jpg")))
+
+	// Taken as Tokyo time it is the first one
+	assert.NoError(t, assumeTZ.Set("Asia/Tokyo"))
+	assert.True(t, date("c.jpg").Before(date("b.jpg")))
+	assert.NoError(t, assumeTZ.Set("UTC"))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "numbered-by-date", false, false))
+	for i, offset := range []string{"+09:00", "", "-05:00"} {
+		record, err := pyrgear.DecodeEXIFFile(filepath.Join(tempDir, fmt.Sprintf("%d.jpg", i+1)))
+		assert.NoError(t, err)
+		got, _ := record.Get("OffsetTimeOriginal")
+		assert.Equal(t, offset, got)
+	}
+	assert.Error(t, assumeTZ.Set("+25:00"))
+}
+
+func TestRenameStatsByDirectory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rename_stats_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	sub := filepath.Join(tempDir, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	for _, path := range []string{
+		filepath.Join(tempDir, "A.txt"), filepath.Join(tempDir, "B.txt"), filepath.Join(tempDir, "c.txt"),
+		filepath.Join(sub, "D.txt"),
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	}
+
+	activeRenameStats = newRenameStats()
+	defer func() {
+		activeRenameStats = nil
+	}()
+
+	// Dry runs count what would be renamed, files already in lowercase are not counted
+	assert.NoError(t, processDirectoryWithRule(tempDir, "lowercase", true, true))
+	assert.Equal(t, map[string]int{tempDir: 2, sub: 1}, activeRenameStats.dirs)
+	assert.Equal(t, 3, activeRenameStats.total)
+
+	activeRenameStats = newRenameStats()
+	assert.NoError(t, processDirectoryWithRule(tempDir, "lowercase", true, false))
+	assert.Equal(t, map[string]int{tempDir: 2, sub: 1}, activeRenameStats.dirs)
+	activeRenameStats.print(false)
+}
+
+func TestReverseRuleIsItsOwnInverse(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "reverse_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	sub := filepath.Join(tempDir, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	for _, path := range []string{
+		filepath.Join(tempDir, "abc.jpg"), filepath.Join(tempDir, "anna.txt"), filepath.Join(sub, "日本.png"),
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	}
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "reverse", true, false))
+	assert.Equal(t, []string{"anna.txt", "cba.jpg", "sub"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"本日.png"}, listNames(t, sub))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "reverse", true, false))
+	assert.Equal(t, []string{"abc.jpg", "anna.txt", "sub"}, listNames(t, tempDir))
+	assert.Equal(t, []string{"日本.png"}, listNames(t, sub))
+}
+
+func TestFindNameCollisions(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "name_collisions_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	sub := filepath.Join(tempDir, "Sub")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "sub"), 0755))
+	for _, path := range []string{
+		filepath.Join(tempDir, "Photo.jpg"), filepath.Join(tempDir, "photo.JPG"), filepath.Join(tempDir, "other.jpg"),
+		filepath.Join(sub, "café.txt"), filepath.Join(sub, "café.txt"), filepath.Join(sub, "CAFÉ.txt"),
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	}
+
+	groups, err := findNameCollisions(tempDir, false)
+	assert.NoError(t, err)
+	assert.Equal(
+		t, []nameCollision{
+			{Dir: tempDir, Names: []string{"Photo.jpg", "photo.JPG"}, Kinds: []string{"case"}},
+			{Dir: tempDir, Names: []string{"Sub", "sub"}, Kinds: []string{"case"}},
+		}, groups,
+	)
+
+	groups, err = findNameCollisions(tempDir, true)
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 3) {
+		assert.Equal(t, sub, groups[2].Dir)
+		assert.Len(t, groups[2].Names, 3)
+		assert.Equal(t, []string{"case", "normalization"}, groups[2].Kinds)
+	}
+
+	// Nothing was renamed
+	assert.Equal(t, []string{"Photo.jpg", "Sub", "other.jpg", "photo.JPG", "sub"}, listNames(t, tempDir))
+
+	var out bytes.Buffer
+	assert.NoError(t, writeNameCollisions(&out, groups, "text"))
+	assert.Contains(t, out.String(), `"café.txt" (NFD)`)
+	assert.Contains(t, out.String(), "Found 3 groups of colliding names")
+}
+
+func TestRuleRecursion(t *testing.T) {
+	assert.Error(t, checkRuleRecursion("foldername-rename", true))
+	assert.NoError(t, checkRuleRecursion("foldername-rename", false))
+	assert.NoError(t, checkRuleRecursion("flatten", true))
+	assert.NoError(t, checkRuleRecursion("timestamp", true))
+	assert.NoError(t, checkRuleRecursion("", true))
+
+	var buf bytes.Buffer
+	assert.NoError(t, explainRule(&buf, "Event-Seq"))
+	assert.Contains(t, buf.String(), "event-seq: ")
+	assert.Contains(t, buf.String(), "--recursive: all subdirectories are processed as one set")
+	assert.Contains(t, buf.String(), "Options: --assume-tz")
+	assert.ErrorIs(t, explainRule(&buf, "no-such-rule"), ErrUnknownRule)
+}
+
+func TestDateTreeRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "date_tree_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	src := filepath.Join(tempDir, "dump")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "card2"), 0755))
+	writeTestJPEG(t, filepath.Join(src, "a.jpg"), map[uint16]string{0x9003: "2024:05:06 07:08:09", 0x010f: "Canon"})
+	writeTestJPEG(t, filepath.Join(src, "card2", "b.jpg"), map[uint16]string{0x9003: "2023:12:31 23:59:59"})
+	writeTestJPEG(t, filepath.Join(src, "undated.jpg"), map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "notes.txt"), []byte("notes"), 0644))
+
+	// The output directory is inside the scanned tree, its files are not sorted again
+	outputDir = filepath.Join(src, "sorted")
+	outputDirTemplate = "{{.ExifYear}}/{{.ExifMonth}}"
+	defer func() {
+		outputDir, outputDirTemplate, moveFiles = "wx-export", "{{.ExifYear}}/{{.ExifMonth}}", false
+	}()
+	exists := func(path ...string) bool {
+		_, err := os.Stat(filepath.Join(append([]string{outputDir}, path...)...))
+		return err == nil
+	}
+
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", true, true))
+	assert.NoDirExists(t, outputDir)
+
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", true, false))
+	assert.True(t, exists("2024", "05", "a.jpg"))
+	assert.True(t, exists("2023", "12", "b.jpg"))
+	assert.True(t, exists("unknown", "undated.jpg"))
+	assert.True(t, exists("unknown", "notes.txt"))
+	// Copies leave the originals alone
+	assert.FileExists(t, filepath.Join(src, "a.jpg"))
+
+	// Existing copies are errors by default, a second run changes nothing
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", true, false))
+	assert.False(t, exists("2024", "05", "a-1.jpg"))
+
+	outputDir = filepath.Join(tempDir, "by-camera")
+	outputDirTemplate = "{{.Make}}/{{.ExifYear}}"
+	moveFiles = true
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", false, false))
+	assert.True(t, exists("Canon", "2024", "a.jpg"))
+	assert.True(t, exists("unknown", "undated.jpg"))
+	assert.NoFileExists(t, filepath.Join(src, "a.jpg"))
+
+	// Templates must stay within the output directory
+	writeTestJPEG(t, filepath.Join(src, "c.jpg"), map[uint16]string{0x9003: "2022:01:01 00:00:00"})
+	outputDirTemplate = "../{{.ExifYear}}"
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", false, false))
+	assert.FileExists(t, filepath.Join(src, "c.jpg"))
+	assert.NoDirExists(t, filepath.Join(tempDir, "2022"))
+
+	outputDirTemplate = "{{.Year}}"
+	assert.NoError(t, processDirectoryWithRule(src, "date-tree", false, false))
+	assert.FileExists(t, filepath.Join(src, "c.jpg"))
+	outputDirTemplate = "{{.ExifYear"
+	assert.Error(t, processDirectoryWithRule(src, "date-tree", false, false))
+}
+
+func TestStripDupSuffixRule(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "strip_dup_suffix_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	now := time.Now()
+	write := func(name string, content string, age time.Duration) {
+		path := filepath.Join(tempDir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		assert.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(tempDir, name))
+		assert.NoError(t, err)
+		return string(data)
+	}
+	// a: a copy of the original; b: no original; c: a newer download than the original;
+	// d: two downloads without an original, the second one older
+	write("a.jpg", "same", time.Hour)
+	write("a (1).jpg", "same", 0)
+	write("b - Copy.jpg", "b", 0)
+	write("c.jpg", "old", 2*time.Hour)
+	write("c (1).jpg", "newer", time.Hour)
+	write("d (1).jpg", "first", time.Hour)
+	write("d (2).jpg", "second!", 2*time.Hour)
+
+	defer func() {
+		onConflict = conflictNewer
+	}()
+	onConflict = conflictNewer
+	all := []string{"a (1).jpg", "a.jpg", "b - Copy.jpg", "c (1).jpg", "c.jpg", "d (1).jpg", "d (2).jpg"}
+	assert.NoError(t, processDirectoryWithRule(tempDir, "strip-dup-suffix", false, true))
+	assert.Equal(t, all, listNames(t, tempDir))
+
+	assert.NoError(t, processDirectoryWithRule(tempDir, "strip-dup-suffix", false, false))
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}, listNames(t, tempDir))
+	assert.Equal(t, "newer", read("c.jpg"))
+	assert.Equal(t, "first", read("d.jpg"))
+
+	// --on-conflict larger keeps the larger file, skip leaves both
+	write("d (3).jpg", "the largest", 3*time.Hour)
+	write("c (2).jpg", "tiny", 0)
+	onConflict = conflictLarger
+	assert.NoError(t, processDirectoryWithRule(tempDir, "strip-dup-suffix", false, false))
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}, listNames(t, tempDir))
+	assert.Equal(t, "newer", read("c.jpg"))
+	assert.Equal(t, "the largest", read("d.jpg"))
+
+	write("a (1).jpg", "other", 0)
+	onConflict = conflictSkip
+	assert.NoError(t, processDirectoryWithRule(tempDir, "strip-dup-suffix", false, false))
+	assert.Equal(t, []string{"a (1).jpg", "a.jpg", "b.jpg", "c.jpg", "d.jpg"}, listNames(t, tempDir))
+
+	onConflict = "oldest"
+	assert.Error(t, processDirectoryWithRule(tempDir, "strip-dup-suffix", false, false))
+}
+
+func TestReviewModel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "review_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"A.txt", "B.txt", "C.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644))
+	}
+	plan, err := collectRenamePlan(func() error {
+		return processDirectoryWithRule(tempDir, "lowercase", false, false)
+	})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Renames, 3)
+
+	key := func(m *reviewModel, keys ...tea.KeyMsg) {
+		for _, k := range keys {
+			m.Update(k)
+		}
+	}
+	runes := func(s string) tea.KeyMsg {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+	}
+	space, down, up := tea.KeyMsg{Type: tea.KeySpace}, tea.KeyMsg{Type: tea.KeyDown}, tea.KeyMsg{Type: tea.KeyUp}
+
+	// Everything is accepted at first, space toggles the row under the cursor and moves on
+	m := newReviewModel(plan, tempDir)
+	assert.Len(t, m.acceptedRenames(), 3)
+	key(m, down, space)
+	assert.Equal(t, 2, m.cursor)
+	assert.Equal(t, []bool{true, false, true}, m.accepted)
+	assert.Contains(t, m.View(), "2 of 3 renames accepted")
+	assert.Contains(t, m.View(), "> [x] C.txt -> c.txt")
+
+	// The cursor stays on the list
+	key(m, down, down, up, up, up, up)
+	assert.Equal(t, 0, m.cursor)
+	key(m, runes("n"))
+	assert.Empty(t, m.acceptedRenames())
+	key(m, runes("a"), runes("G"), space)
+	assert.Equal(t, []bool{true, true, false}, m.accepted)
+
+	// The list scrolls with the cursor
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 5})
+	key(m, runes("g"), down, down)
+	assert.Equal(t, 1, m.offset)
+	assert.NotContains(t, m.View(), "A.txt")
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.NotNil(t, cmd)
+	assert.True(t, m.done)
+
+	// Only the accepted renames are applied
+	assert.NoError(t, applyAtomic(&renamePlan{Renames: m.acceptedRenames()}))
+	assert.Equal(t, []string{"C.txt", "a.txt", "b.txt"}, listNames(t, tempDir))
+
+	m = newReviewModel(plan, tempDir)
+	key(m, runes("q"))
+	assert.True(t, m.cancelled)
+	assert.False(t, m.done)
+
+	defer func() {
+		reviewRenames, dryRun = false, false
+	}()
+	reviewRenames, dryRun = true, true
+	assert.Error(t, checkReview("lowercase"))
+	dryRun = false
+	assert.NoError(t, checkReview("lowercase"))
+	assert.Error(t, checkReview("wx-exporter"))
+}
+
+func TestCheckRuleFlags(t *testing.T) {
+	defer func() {
+		strictFlags = false
+	}()
+	check := func(rule string, args ...string) error {
+		cmd := &cobra.Command{Use: "rename"}
+		cmd.Flags().String("pattern", "", "")
+		cmd.Flags().String("pre-name", "", "")
+		cmd.Flags().String("mapping", "", "")
+		cmd.Flags().String("dir", "", "")
+		assert.NoError(t, cmd.Flags().Parse(args))
+		return checkRuleFlags(cmd.Flags(), rule)
+	}
+
+	// Ignored flags are only a warning by default
+	assert.NoError(t, check("lowercase", "--pattern", "x"))
+
+	strictFlags = true
+	assert.EqualError(
+		t, check("Lowercase", "--pattern", "x", "--pre-name", "y", "--dir", "."),
+		"--pattern, --pre-name not used by lowercase rule",
+	)
+	assert.NoError(t, check("lookup", "--pattern", "x", "--mapping", "ids.csv"))
+	assert.NoError(t, check("wx-exporter", "--pre-name", "y"))
+	assert.EqualError(t, check("", "--pattern", "x", "--mapping", "ids.csv"), "--mapping not used by renames by --pattern")
+
+	// Every declared flag is a flag of the rename command
+	for _, r := range renameRules {
+		for _, name := range r.flags {
+			assert.NotNil(t, RenameCmd.Flags().Lookup(name), "--%s of %s rule", name, r.name)
+		}
+	}
+	for _, name := range patternFlags {
+		assert.NotNil(t, RenameCmd.Flags().Lookup(name), name)
+	}
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	defer func() {
+		deterministic, sortBy, onConflict, wxWorkers = false, "name", conflictNewer, 1
+		showProgress, noColor = false, false
+	}()
+	check := func(rule string, args ...string) error {
+		cmd := &cobra.Command{Use: "rename"}
+		cmd.Flags().StringVar(&sortBy, "sort-by", "name", "")
+		cmd.Flags().Int64("seed", 0, "")
+		cmd.Flags().Bool("since-last-run", false, "")
+		assert.NoError(t, cmd.Flags().Parse(args))
+		return checkDeterministic(cmd.Flags(), rule)
+	}
+
+	// Nothing is checked without --deterministic
+	assert.NoError(t, check("timestamp"))
+
+	deterministic, showProgress = true, true
+	assert.NoError(t, check(""))
+	assert.NoError(t, check("lowercase"))
+	assert.False(t, showProgress)
+	assert.True(t, noColor)
+
+	assert.ErrorContains(t, check("timestamp"), "modification times")
+	assert.ErrorContains(t, check("randomize"), "--seed")
+	assert.NoError(t, check("randomize", "--seed", "42"))
+	assert.ErrorContains(t, check("sequence"), "--sort-by")
+	assert.NoError(t, check("sequence", "--sort-by", "name"))
+	assert.ErrorContains(t, check("sequence", "--sort-by", "mtime"), "--sort-by mtime")
+	assert.ErrorContains(t, check("lowercase", "--since-last-run"), "--since-last-run")
+
+	assert.Error(t, check("strip-dup-suffix"))
+	onConflict = conflictLarger
+	assert.NoError(t, check("strip-dup-suffix"))
+	wxWorkers = 4
+	assert.ErrorContains(t, check("wx-exporter"), "--workers")
+}
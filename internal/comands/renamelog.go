@@ -0,0 +1,100 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// renameLogPath is the --rename-log file every performed rename is appended to
+var renameLogPath string
+
+// activeRenameLog appends the renames of the current run to --rename-log, nil without it
+var activeRenameLog *renameLog
+
+// renameLogLine is a line of the rename log, one JSON object per performed rename
+type renameLogLine struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	Old  string    `json:"old"`
+	New  string    `json:"new"`
+	Rule string    `json:"rule"`
+}
+
+// renameLog is the audit trail of --rename-log. Unlike the manifest of a run, which is
+// written at its end for --undo, the log is never overwritten: each rename is appended as
+// soon as it is done, by every run that is given the log. Its methods are no-ops on a nil log.
+type renameLog struct {
+	mu   sync.Mutex
+	file *os.File
+	user string
+	rule string
+}
+
+// startRenameLog opens --rename-log for appending the renames of rule, "pattern" for renames
+// by --pattern. The returned function closes it. Without --rename-log nothing is logged.
+func startRenameLog(rule string) (func(), error) {
+	if renameLogPath == "" {
+		return func() {}, nil
+	}
+	file, err := os.OpenFile(renameLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rename log: %v", err)
+	}
+	if rule == "" {
+		rule = "pattern"
+	}
+	activeRenameLog = &renameLog{file: file, user: currentUser(), rule: rule}
+	return func() {
+		activeRenameLog.file.Close()
+		activeRenameLog = nil
+	}, nil
+}
+
+// record appends the rename of oldPath to newPath, with absolute paths. Each line is written
+// with a single write under an exclusive flock, so the lines of concurrent runs sharing the
+// log never interleave. A rename that cannot be logged is reported as an error; it is not
+// undone.
+func (l *renameLog) record(oldPath, newPath string) {
+	if l == nil {
+		return
+	}
+	if abs, err := filepath.Abs(oldPath); err == nil {
+		oldPath = abs
+	}
+	if abs, err := filepath.Abs(newPath); err == nil {
+		newPath = abs
+	}
+	data, err := json.Marshal(renameLogLine{Time: time.Now(), User: l.user, Old: oldPath, New: newPath, Rule: l.rule})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	unlock := lockFileAppend(l.file)
+	_, err = l.file.Write(append(data, '\n'))
+	unlock()
+	if err != nil {
+		err = fmt.Errorf("failed to write rename log %s: %v", renameLogPath, err)
+		fmt.Printf("Error: %v\n", err)
+		activeIssues.addError("rename-log", oldPath, err)
+	}
+}
+
+// currentUser returns the name of the user running pyrgear
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(env); name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,54 @@
+package comands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// activeRenameStats counts the renames of the current run by directory
+var activeRenameStats *renameStats
+
+// renameStats is the number of renamed files of each directory
+type renameStats struct {
+	dirs  map[string]int
+	total int
+}
+
+// newRenameStats creates empty rename statistics
+func newRenameStats() *renameStats {
+	return &renameStats{dirs: make(map[string]int)}
+}
+
+// record counts a rename of oldPath, it is a no-op on nil statistics
+func (s *renameStats) record(oldPath string) {
+	if s == nil {
+		return
+	}
+	s.dirs[filepath.Dir(oldPath)]++
+	s.total++
+}
+
+// print writes the number of renames of each directory, sorted by directory, and the totals.
+// Nothing is printed for runs without renames.
+func (s *renameStats) print(dryRun bool) {
+	if s == nil || s.total == 0 {
+		return
+	}
+
+	dirs := make([]string, 0, len(s.dirs))
+	for dir := range s.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	title, verb := "Renamed by directory:", "renamed"
+	if dryRun {
+		title, verb = "Would rename by directory:", "would be renamed"
+	}
+	fmt.Println(title)
+	for _, dir := range dirs {
+		fmt.Printf("  %6d  %s\n", s.dirs[dir], dir)
+	}
+	fmt.Printf("Total: %d files %s in %d directories\n", s.total, verb, len(dirs))
+}
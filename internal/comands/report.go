@@ -0,0 +1,140 @@
+package comands
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// reportFile is the file the summary of a run is written to on exit
+var reportFile string
+
+// activeReport counts the files of the current run when --report is set
+var activeReport *runReport
+
+// runReport is the summary of a run written by --report, it is safe for concurrent use
+type runReport struct {
+	mu      sync.Mutex
+	command string
+	options map[string]string
+	started time.Time
+	files   int
+	skipped int
+	// failed is the set of paths errors were reported for
+	failed map[string]bool
+	errors []runIssue
+}
+
+// reportSummary is the JSON document written by --report
+type reportSummary struct {
+	Command   string            `json:"command"`
+	Options   map[string]string `json:"options"`
+	Started   time.Time         `json:"started"`
+	Duration  float64           `json:"duration_seconds"`
+	Processed int               `json:"processed"`
+	Succeeded int               `json:"succeeded"`
+	Skipped   int               `json:"skipped"`
+	Failed    int               `json:"failed"`
+	Errors    []runIssue        `json:"errors"`
+	Success   bool              `json:"success"`
+}
+
+// startReport starts counting the run of cmd if --report is set. The options are the flags
+// given on the command line.
+func startReport(cmd *cobra.Command) {
+	if reportFile == "" {
+		return
+	}
+
+	options := make(map[string]string)
+	cmd.Flags().Visit(
+		func(f *pflag.Flag) {
+			options[f.Name] = f.Value.String()
+		},
+	)
+	activeReport = &runReport{
+		command: cmd.Name(),
+		options: options,
+		started: time.Now(),
+		failed:  make(map[string]bool),
+	}
+}
+
+// countFile counts a processed file, it is a no-op on a nil report
+func (r *runReport) countFile() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files++
+}
+
+// countSkipped counts a file that was left alone, e.g. because its target exists.
+// It is a no-op on a nil report.
+func (r *runReport) countSkipped() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped++
+}
+
+// addError records an error of the run, it is a no-op on a nil report
+func (r *runReport) addError(operation string, path string, err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed[path] = true
+	r.errors = append(r.errors, runIssue{Level: "error", Operation: operation, Path: path, Message: err.Error()})
+}
+
+// finishReport writes the summary of the run to --report. err is the error the command
+// returned, if any. Runs with failures are marked as failed, so Execute exits non-zero.
+// It is a no-op when --report is not set.
+func finishReport(err error) error {
+	r := activeReport
+	if r == nil {
+		return nil
+	}
+	activeReport = nil
+	if err != nil {
+		r.addError("run", "", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := reportSummary{
+		Command:   r.command,
+		Options:   r.options,
+		Started:   r.started,
+		Duration:  time.Since(r.started).Seconds(),
+		Processed: r.files,
+		Skipped:   r.skipped,
+		Failed:    len(r.failed),
+		Errors:    r.errors,
+	}
+	if summary.Errors == nil {
+		summary.Errors = []runIssue{}
+	}
+	// Errors about directories or the run itself are not counted as processed files, count them
+	// so the numbers add up
+	summary.Processed = max(summary.Processed, summary.Skipped+summary.Failed)
+	summary.Succeeded = summary.Processed - summary.Skipped - summary.Failed
+	if summary.Failed > 0 {
+		runFailed = true
+	}
+	summary.Success = !runFailed
+
+	data, err := marshalJSON(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reportFile, append(data, '\n'), 0644)
+}
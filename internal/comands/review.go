@@ -0,0 +1,231 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"golang.org/x/term"
+)
+
+// reviewRenames shows the computed renames in a terminal UI before they are applied, see reviewModel
+var reviewRenames bool
+
+// reviewChromeLines are the lines of the review screen around the list: title, blank line and help
+const reviewChromeLines = 3
+
+// reviewModel is the state of the --tui review screen: the planned renames, which of them
+// are accepted (all at first), and the visible window of the list
+type reviewModel struct {
+	renames  []plannedRename
+	accepted []bool
+	// base is the directory the names are shown relative to
+	base   string
+	cursor int
+	offset int
+	width  int
+	height int
+	// failed is the number of renames that could not be planned and are not listed
+	failed int
+	// done is set when the accepted renames are to be applied, cancelled when none are
+	done      bool
+	cancelled bool
+}
+
+// newReviewModel returns the review screen of plan with every rename accepted
+func newReviewModel(plan *renamePlan, base string) *reviewModel {
+	m := &reviewModel{
+		renames:  plan.Renames,
+		accepted: make([]bool, len(plan.Renames)),
+		base:     base,
+		width:    80,
+		height:   24,
+		failed:   plan.failed,
+	}
+	for i := range m.accepted {
+		m.accepted[i] = true
+	}
+	return m
+}
+
+func (m *reviewModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update moves the cursor, toggles renames and ends the review on enter (apply) or q (cancel)
+func (m *reviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.cursor--
+		case "down", "j":
+			m.cursor++
+		case "pgup", "b":
+			m.cursor -= m.rows()
+		case "pgdown", "f":
+			m.cursor += m.rows()
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = len(m.renames) - 1
+		case " ", "x":
+			if len(m.renames) > 0 {
+				m.accepted[m.cursor] = !m.accepted[m.cursor]
+				m.cursor++
+			}
+		case "a":
+			m.setAll(true)
+		case "n":
+			m.setAll(false)
+		case "enter":
+			m.done = true
+			return m, tea.Quit
+		case "q", "esc", "ctrl+c":
+			m.cancelled = true
+			return m, tea.Quit
+		}
+	}
+	m.scroll()
+	return m, nil
+}
+
+// setAll accepts or rejects every rename
+func (m *reviewModel) setAll(accepted bool) {
+	for i := range m.accepted {
+		m.accepted[i] = accepted
+	}
+}
+
+// rows returns the number of renames that fit on the screen
+func (m *reviewModel) rows() int {
+	return max(m.height-reviewChromeLines, 1)
+}
+
+// scroll keeps the cursor within the list and the list window around the cursor
+func (m *reviewModel) scroll() {
+	m.cursor = max(min(m.cursor, len(m.renames)-1), 0)
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.rows() {
+		m.offset = m.cursor - m.rows() + 1
+	}
+}
+
+// View renders the visible part of the list, one "[x] old -> new" line per rename
+func (m *reviewModel) View() string {
+	var b strings.Builder
+	title := fmt.Sprintf("%d of %d renames accepted", len(m.acceptedRenames()), len(m.renames))
+	if m.failed > 0 {
+		title += fmt.Sprintf(", %d could not be planned and are not listed", m.failed)
+	}
+	b.WriteString(ansi.Truncate(title, m.width, "…") + "\n\n")
+
+	end := min(m.offset+m.rows(), len(m.renames))
+	for i := m.offset; i < end; i++ {
+		cursor, mark := " ", "[ ]"
+		if i == m.cursor {
+			cursor = ">"
+		}
+		if m.accepted[i] {
+			mark = "[x]"
+		}
+		line := fmt.Sprintf(
+			"%s %s %s", cursor, mark, renameArrow(m.display(m.renames[i].Old), m.display(m.renames[i].New)),
+		)
+		b.WriteString(ansi.Truncate(line, m.width, "…") + "\n")
+	}
+	b.WriteString(
+		ansi.Truncate(
+			"space: toggle  a: accept all  n: reject all  ↑/↓ pgup/pgdown: move  enter: apply  q: cancel",
+			m.width, "…",
+		),
+	)
+	return b.String()
+}
+
+// display returns path relative to the reviewed directory
+func (m *reviewModel) display(path string) string {
+	if rel, err := filepath.Rel(m.base, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// acceptedRenames returns the renames that are accepted, in plan order
+func (m *reviewModel) acceptedRenames() []plannedRename {
+	accepted := []plannedRename{}
+	for i, rename := range m.renames {
+		if m.accepted[i] {
+			accepted = append(accepted, rename)
+		}
+	}
+	return accepted
+}
+
+// reviewPlan shows plan in the review screen and returns the plan of the accepted renames,
+// or nil when the review was cancelled
+func reviewPlan(plan *renamePlan, base string) (*renamePlan, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, fmt.Errorf("--tui needs an interactive terminal")
+	}
+	m := newReviewModel(plan, base)
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+		return nil, err
+	}
+	if !m.done {
+		return nil, nil
+	}
+	return &renamePlan{Renames: m.acceptedRenames()}, nil
+}
+
+// checkReview returns an error when --tui is combined with options it cannot review
+func checkReview(rule string) error {
+	if !reviewRenames {
+		return nil
+	}
+	switch {
+	case dryRun:
+		return fmt.Errorf("--tui already shows the renames before applying them, drop --dry-run")
+	case stateFile != "":
+		return fmt.Errorf("--tui cannot be used with --state-file")
+	case strings.EqualFold(rule, "wx-exporter"), strings.EqualFold(rule, "foldername-rename"):
+		return fmt.Errorf("--tui is not supported for %s rule", rule)
+	}
+	return nil
+}
+
+// runReviewedRenames collects the renames of process, shows them in the review screen and
+// applies the accepted ones all-or-nothing, like --atomic
+func runReviewedRenames(process func() error) error {
+	plan, err := collectRenamePlan(process)
+	if err != nil {
+		return err
+	}
+	if len(plan.Renames) == 0 {
+		fmt.Println("Nothing to rename")
+		return nil
+	}
+
+	// The review screen takes over the terminal
+	finishProgress()
+	reviewed, err := reviewPlan(plan, directory)
+	if err != nil {
+		return err
+	}
+	switch {
+	case reviewed == nil:
+		fmt.Println("Review cancelled, nothing was renamed")
+		return nil
+	case len(reviewed.Renames) == 0:
+		fmt.Println("No renames accepted, nothing was renamed")
+		return nil
+	}
+	return applyAtomic(reviewed)
+}
@@ -3,17 +3,30 @@ package comands
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// version is the pyrgear version, set at build time with
+// -ldflags "-X github.com/pyronn/pyrgear/internal/comands.version=v1.2.3"
+var version = "dev"
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
-	Use:   "pyrgear",
-	Short: "PyRGear - A powerful tool for Python and R integration",
+	Use:     "pyrgear",
+	Version: version,
+	Short:   "PyRGear - A powerful tool for Python and R integration",
 	Long: `PyRGear is a command-line tool that helps you seamlessly integrate Python and R workflows.
 It provides various utilities to manage Python and R environments, execute scripts,
 and handle data transfer between the two languages.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkErrorLimits(); err != nil {
+			return err
+		}
+		startReport(cmd)
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommands are provided, print help
 		cmd.Help()
@@ -23,14 +36,90 @@ and handle data transfer between the two languages.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the RootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+	err := RootCmd.Execute()
+	if reportErr := finishReport(err); reportErr != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", reportErr)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	// A run stopped by Ctrl-C exits with the status shells give to SIGINT
+	if runInterrupted.Load() {
+		os.Exit(130)
+	}
+	// With --errors-out, runs that reported errors or warnings fail, and so do runs with
+	// failures under --report and runs whose output failed --validate-output
+	if runFailed {
+		os.Exit(1)
+	}
 }
 
 func init() {
+	RootCmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "Suppress progress and summary output")
+	RootCmd.PersistentFlags().StringVar(
+		&errorsOut, "errors-out", "",
+		"Write all errors and warnings of the run to this file as JSON and exit non-zero if there were any",
+	)
+
+	RootCmd.PersistentFlags().StringVar(
+		&reportFile, "report", "",
+		"Write a JSON summary of the run (options, counts, duration, errors) to this file on exit and exit non-zero on failures",
+	)
+
+	RootCmd.PersistentFlags().IntVar(
+		&maxErrors, "max-errors", 0, "Abort once more than this many files failed (0 for no limit)",
+	)
+	RootCmd.PersistentFlags().Float64Var(
+		&maxErrorRate, "max-error-rate", 0,
+		"Abort once more than this fraction of the files failed, e.g. 0.1, checked after 20 files (0 for no limit)",
+	)
+
+	RootCmd.PersistentFlags().BoolVar(
+		&failFast, "fail-fast", false, "Stop at the first file that fails and exit non-zero",
+	)
+	RootCmd.PersistentFlags().BoolVar(
+		&keepGoing, "keep-going", false,
+		"Process all files whatever fails (the default) and list every error on stderr at the end",
+	)
+
+	RootCmd.PersistentFlags().StringVar(
+		&renameLogPath, "rename-log", "",
+		"Append a line per performed rename (time, user, old and new path, rule) to this file, across runs",
+	)
+
+	RootCmd.PersistentFlags().StringVar(
+		&progressJSON, "progress-json", "",
+		"Write progress as JSON lines to this file, stderr or fd:N, for programs showing their own progress",
+	)
+	RootCmd.PersistentFlags().DurationVar(
+		&progressJSONInterval, "progress-interval", time.Second, "How often --progress-json writes a line",
+	)
+
+	RootCmd.PersistentFlags().BoolVar(&jsonCompact, "compact", false, "Write JSON output on a single line")
+	RootCmd.PersistentFlags().IntVar(
+		&jsonIndent, "indent", 2, "Number of spaces to indent JSON output with (0 writes compact JSON)",
+	)
+	RootCmd.PersistentFlags().BoolVar(
+		&validateOutput, "validate-output", false,
+		"Re-parse JSON and ndjson output after writing it and exit non-zero if it is not valid",
+	)
+	RootCmd.PersistentFlags().BoolVar(
+		&noColor, "no-color", false, "Disable colored output (also disabled by NO_COLOR and when stdout is not a terminal)",
+	)
+
 	// Add subcommands
 	RootCmd.AddCommand(RenameCmd)
 	RootCmd.AddCommand(ExifCmd)
+	RootCmd.AddCommand(ServeCmd)
+	RootCmd.AddCommand(ListCmd)
+	RootCmd.AddCommand(StripCmd)
+	RootCmd.AddCommand(EmbedCmd)
+	RootCmd.AddCommand(StatsCmd)
+	RootCmd.AddCommand(InfoCmd)
+	RootCmd.AddCommand(CollisionsCmd)
+	RootCmd.AddCommand(DedupeCmd)
+	RootCmd.AddCommand(DoctorCmd)
+	RootCmd.AddCommand(RunCmd)
+	RootCmd.AddCommand(ValidateCmd)
 }
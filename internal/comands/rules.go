@@ -0,0 +1,392 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/pflag"
+)
+
+// renameRule describes a predefined rule of the rename command
+type renameRule struct {
+	name        string
+	description string
+	// flags are the rule-specific flags the rule reads, those of other rules are ignored
+	// with it, see checkRuleFlags
+	flags []string
+	// applied reports whether a filename already carries the result of this rule.
+	// Such files are skipped, so running a rule twice does not rename them again.
+	applied func(name string) bool
+	// recursion is what --recursive does for this rule, note explains it where it is not obvious
+	recursion recursionUse
+	note      string
+	// unstable returns why the names the rule gives with flags could differ between machines
+	// or runs, "" if they cannot. --deterministic refuses such runs, see checkDeterministic.
+	unstable func(flags *pflag.FlagSet) string
+}
+
+// recursionUse describes how a rule treats --recursive
+type recursionUse int
+
+const (
+	// recursionPerDir applies the rule to each subdirectory on its own
+	recursionPerDir recursionUse = iota
+	// recursionTree treats the files of all subdirectories as one set
+	recursionTree
+	// recursionIgnored rules have a scope of their own, --recursive changes nothing
+	recursionIgnored
+	// recursionRejected rules refuse --recursive, recursing them would surprise users
+	recursionRejected
+)
+
+// String returns how the rule treats --recursive, for --explain
+func (u recursionUse) String() string {
+	switch u {
+	case recursionTree:
+		return "all subdirectories are processed as one set"
+	case recursionIgnored:
+		return "ignored"
+	case recursionRejected:
+		return "not supported"
+	default:
+		return "each subdirectory is processed on its own"
+	}
+}
+
+// renameRules is the registry of predefined rename rules
+var renameRules = map[string]*renameRule{
+	"timestamp": {
+		name:        "timestamp",
+		description: "Add the modification time as a YYYYMMDD_HHMMSS_ prefix",
+		applied:     pyrgear.HasTimestamp,
+		unstable: func(flags *pflag.FlagSet) string {
+			return "its names are modification times, which differ between checkouts"
+		},
+	},
+	"sequence": {
+		name:        "sequence",
+		description: "Rename files to <name>_001, <name>_002, ...",
+		flags:       []string{"sequence-name", "sort-by", "state-file", "reset-state"},
+		applied:     isSequenceName,
+		unstable:    unstableNumbering,
+	},
+	"lowercase": {
+		name:        "lowercase",
+		description: "Convert filenames to lowercase",
+		flags:       []string{"locale"},
+		applied: func(name string) bool {
+			return pyrgear.LowercaseName(name, caseLanguage()) == name
+		},
+	},
+	"uppercase": {
+		name:        "uppercase",
+		description: "Convert filenames to uppercase",
+		flags:       []string{"locale"},
+		applied: func(name string) bool {
+			return pyrgear.UppercaseName(name, caseLanguage()) == name
+		},
+	},
+	"title-case": {
+		name:        "title-case",
+		description: "Capitalize the words of the filename stems, keeping --small-words such as 'of' lowercase within them",
+		flags:       []string{"locale", "small-words"},
+		applied: func(name string) bool {
+			return pyrgear.TitleCaseName(name, smallWords, caseLanguage()) == name
+		},
+	},
+	"prefix": {
+		name:        "prefix",
+		description: "Add --prefix to all files and directories",
+		flags:       []string{"prefix"},
+		applied: func(name string) bool {
+			return strings.HasPrefix(name, prefixName)
+		},
+	},
+	"randomize": {
+		name:        "randomize",
+		description: "Rename files to random tokens, recording the mapping in --manifest",
+		flags:       []string{"seed", "random-format"},
+		unstable: func(flags *pflag.FlagSet) string {
+			if !flags.Changed("seed") {
+				return "its names are random unless --seed is given"
+			}
+			return ""
+		},
+	},
+	"reverse": {
+		name:        "reverse",
+		description: "Reverse the characters of the filename stems, keeping the extensions (applying it twice restores the names)",
+	},
+	"exif-date": {
+		name:        "exif-date",
+		description: "Rename images to the EXIF time they were taken, YYYYMMDD_HHMMSS (with --use-subsec YYYYMMDD_HHMMSS_mmm)",
+		flags:       []string{"use-subsec", "keep-original", "assume-tz"},
+		applied:     pyrgear.IsDateName,
+	},
+	"ocr-date": {
+		name:        "ocr-date",
+		description: "Rename scans to the first date in the text --ocr-cmd reads from them, YYYYMMDD_000000",
+		flags:       []string{"ocr-cmd", "ocr-date-order"},
+		applied:     pyrgear.IsDateName,
+		unstable: func(flags *pflag.FlagSet) string {
+			return "scans without a date in their text are named after their modification times"
+		},
+	},
+	"date-from-filename": {
+		name:        "date-from-filename",
+		description: "Rewrite the date in filenames (20230615, 15.06.2023, ...) to YYYY-MM-DD, keeping the rest of the name",
+		flags:       []string{"name-date-order"},
+		applied: func(name string) bool {
+			normalized, ok := pyrgear.NormalizeNameDate(name, nameDateOrder != "mdy")
+			return ok && normalized == name
+		},
+	},
+	"color-tag": {
+		name:        "color-tag",
+		description: "Prefix images with the name (red_) or hex code (dc141e_) of their dominant color",
+		flags:       []string{"color-format"},
+		applied:     pyrgear.HasColorTag,
+	},
+	"burst": {
+		name:        "burst",
+		description: "Group images taken within --gap of each other and rename them to burstGG_NNN",
+		flags:       []string{"gap", "assume-tz"},
+		applied: func(name string) bool {
+			_, ok := pyrgear.BurstGroup(name)
+			return ok
+		},
+	},
+	"deburst-keep-best": {
+		name:        "deburst-keep-best",
+		description: "Keep the best frame (by --keep-best) of each burst and move the others to rejected/",
+		flags:       []string{"gap", "keep-best", "assume-tz"},
+	},
+	"numbered-by-date": {
+		name:        "numbered-by-date",
+		description: "Number all files, across directories, in the order they were taken",
+		flags:       []string{"sequence-name", "assume-tz"},
+		applied: func(name string) bool {
+			_, ok := pyrgear.NumberedIndex(sequenceName, name)
+			return ok
+		},
+		recursion: recursionTree,
+		unstable: func(flags *pflag.FlagSet) string {
+			return "files without an EXIF date are numbered in the order of their modification times"
+		},
+	},
+	"increment-existing": {
+		name:        "increment-existing",
+		description: "Add --by to the number ending each filename stem, shot_9 becomes shot_10",
+		flags:       []string{"by"},
+	},
+	"event-seq": {
+		name:        "event-seq",
+		description: "Rename files to <parent folder>_<date taken>_NNN, numbered across directories in the order they were taken",
+		flags:       []string{"assume-tz"},
+		recursion:   recursionTree,
+		unstable: func(flags *pflag.FlagSet) string {
+			return "files without an EXIF date are named and numbered after their modification times"
+		},
+	},
+	"date-tree": {
+		name:        "date-tree",
+		description: "Copy files into the subdirectories of --output-dir rendered by --output-dir-template, e.g. 2024/05",
+		flags:       []string{"output-dir", "output-dir-template", "move", "assume-tz", "copy-buffer-size"},
+		recursion:   recursionTree,
+	},
+	"flatten": {
+		name:        "flatten",
+		description: "Move the files of all subdirectories into --dir, names used more than once get their relative path",
+		recursion:   recursionIgnored,
+		note:        "the files of all subdirectories are always moved",
+	},
+	"sanitize": {
+		name:        "sanitize",
+		description: "Replace characters and names that are illegal on --target-fs",
+		flags:       []string{"target-fs", "replace-char"},
+		applied: func(name string) bool {
+			sanitized, err := pyrgear.SanitizeName(name, targetFS, replaceChar)
+			return err == nil && sanitized == name
+		},
+	},
+	"replace-char": {
+		name:        "replace-char",
+		description: "Replace every --from in the filename stems with --to, literally (--include-ext for the extensions too)",
+		flags:       []string{"from", "to", "include-ext"},
+		applied: func(name string) bool {
+			return pyrgear.ReplaceInName(name, replaceFrom, replaceTo, replaceInExt) == name
+		},
+	},
+	"strip-dup-suffix": {
+		name:        "strip-dup-suffix",
+		description: "Remove the \" (1)\", \"-copy\" and \" - Copy\" of duplicate downloads, keeping one file by --on-conflict where names collide",
+		flags:       []string{"on-conflict", "hash-algo"},
+		applied: func(name string) bool {
+			return pyrgear.StripDupSuffix(name) == name
+		},
+		unstable: func(flags *pflag.FlagSet) string {
+			if onConflict == conflictNewer {
+				return "--on-conflict newer compares modification times, use larger or skip"
+			}
+			return ""
+		},
+	},
+	"from-csv": {
+		name:        "from-csv",
+		description: "Apply the old_name,new_name pairs of the --mapping CSV file",
+		flags:       []string{"mapping"},
+		recursion:   recursionIgnored,
+		note:        "the mapping file names the files to rename, in any subdirectory",
+	},
+	"lookup": {
+		name:        "lookup",
+		description: "Rename files to the name the --mapping CSV file lists for the key --pattern extracts from their stem",
+		flags:       []string{"mapping", "pattern", "ignore-case"},
+	},
+	"wx-exporter": {
+		name:        "wx-exporter",
+		description: "Export images from path2/assets/ folders of a WeChat mini program",
+		note:        "path2 directories with assets/ are found at any depth of --source, not only its direct children",
+		unstable: func(flags *pflag.FlagSet) string {
+			if wxWorkers > 1 {
+				return "with --workers above 1 copies are reported in the order they complete"
+			}
+			return ""
+		},
+		flags: []string{
+			"source-path", "output-dir", "pre-name", "also-copy", "verify-copy", "hash-algo", "separator",
+			"escape-separator", "slug-output", "output-manifest", "resume", "summary", "workers",
+			"copy-buffer-size",
+		},
+	},
+	"foldername-rename": {
+		name:        "foldername-rename",
+		description: "Rename files to <folder name>_001, <folder name>_002, ...",
+		flags:       []string{"pdir", "sort-by", "state-file", "reset-state"},
+		recursion:   recursionRejected,
+		note: "it would renumber the files of every subfolder after that subfolder's name, " +
+			"use --pdir to rename the folders of a parent directory one level deep",
+		unstable: unstableNumbering,
+	},
+}
+
+// lookupRule returns the registered rule with the given name, or nil
+func lookupRule(name string) *renameRule {
+	return renameRules[strings.ToLower(name)]
+}
+
+// checkRuleRecursion returns an error when --recursive is given for a rule that rejects it,
+// and warns when it has no effect
+func checkRuleRecursion(name string, recursive bool) error {
+	r := lookupRule(name)
+	if r == nil || !recursive {
+		return nil
+	}
+	switch r.recursion {
+	case recursionRejected:
+		return fmt.Errorf("--recursive is not supported for %s rule: %s", r.name, r.note)
+	case recursionIgnored:
+		fmt.Printf("Warning: --recursive has no effect for %s rule, %s\n", r.name, r.note)
+	}
+	return nil
+}
+
+// strictFlags makes flags the chosen rule ignores an error instead of a warning
+var strictFlags bool
+
+// patternFlags are the flags of renames by --pattern and --replacement, without --rule
+var patternFlags = []string{"pattern", "replacement", "ignore-case", "stem-only"}
+
+// checkRuleFlags warns about the rule-specific flags given on the command line that the named
+// rule, or pattern renames without one, does not read, such as --pattern with --rule lowercase
+// or --pre-name without wx-exporter. With --strict it returns an error instead. The flags of
+// each rule are declared in the registry; all other flags apply to every rule.
+func checkRuleFlags(flags *pflag.FlagSet, name string) error {
+	used := patternFlags
+	usedBy := "renames by --pattern"
+	if name != "" {
+		r := lookupRule(name)
+		if r == nil {
+			return nil
+		}
+		used, usedBy = r.flags, r.name+" rule"
+	}
+
+	specific := map[string]bool{}
+	for _, f := range patternFlags {
+		specific[f] = true
+	}
+	for _, r := range renameRules {
+		for _, f := range r.flags {
+			specific[f] = true
+		}
+	}
+	for _, f := range used {
+		delete(specific, f)
+	}
+
+	var ignored []string
+	flags.Visit(func(f *pflag.Flag) {
+		if specific[f.Name] {
+			ignored = append(ignored, "--"+f.Name)
+		}
+	})
+	if len(ignored) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%s not used by %s", strings.Join(ignored, ", "), usedBy)
+	if strictFlags {
+		return err
+	}
+	fmt.Printf("Warning: %v, ignoring (use --strict to make this an error)\n", err)
+	return nil
+}
+
+// explainRule writes the description of the named rule and how it treats --recursive
+func explainRule(w io.Writer, name string) error {
+	r := lookupRule(name)
+	if r == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownRule, name)
+	}
+	fmt.Fprintf(w, "%s: %s\n", r.name, r.description)
+	if r.note != "" {
+		fmt.Fprintf(w, "  --recursive: %s, %s\n", r.recursion, r.note)
+	} else {
+		fmt.Fprintf(w, "  --recursive: %s\n", r.recursion)
+	}
+	if len(r.flags) > 0 {
+		fmt.Fprintf(w, "  Options: --%s\n", strings.Join(r.flags, ", --"))
+	}
+	if r.applied != nil {
+		fmt.Fprintln(w, "  Files that already carry the result of the rule are skipped")
+	}
+	return nil
+}
+
+// alreadyApplied reports whether the named rule has already been applied to filename
+func alreadyApplied(rule string, filename string) bool {
+	r := lookupRule(rule)
+	if r == nil || r.applied == nil {
+		return false
+	}
+	return r.applied(filename)
+}
+
+// unstableNumbering is the unstable check of the rules numbering files in --sort-by order
+func unstableNumbering(flags *pflag.FlagSet) string {
+	if !flags.Changed("sort-by") {
+		return "it numbers files in --sort-by order, give --sort-by name or size"
+	}
+	return ""
+}
+
+// isSequenceName reports whether filename looks like <sequenceName>_NNN.ext
+func isSequenceName(filename string) bool {
+	namePrefix := "file"
+	if sequenceName != "" {
+		namePrefix = sequenceName
+	}
+	return pyrgear.IsSequenceName(namePrefix, filename)
+}
@@ -0,0 +1,192 @@
+package comands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runLang        string
+	runScript      string
+	runInterpreter string
+	runRecursive   bool
+	runDataFormat  string
+	runDataVia     string
+)
+
+// runInterpreters are the interpreters tried for each --lang, in order
+var runInterpreters = map[string][]string{
+	"python": {"python3", "python"},
+	"r":      {"Rscript"},
+}
+
+// RunCmd represents the run command
+var RunCmd = &cobra.Command{
+	Use:   "run [flags] [-- script arguments]",
+	Short: "Run a Python or R script on the EXIF data of a directory",
+	Long: `Run a Python or R script and hand it a dataset produced by pyrgear: the EXIF records of
+the images in --dir, as a CSV table or a JSON array. The data is piped to the script's stdin,
+or with --data-via file written to a temporary file whose path is the first argument of the
+script. The script's output is streamed as it is written, and pyrgear exits non-zero if it fails.
+The language is taken from the script's extension (.py, .R) unless --lang is given; python
+runs python3 or python, r runs Rscript, whichever is found first on PATH.
+
+Examples:
+  # Summarize the cameras of a folder with pandas, reading the CSV from stdin
+  pyrgear run --script cameras.py --dir ./photos --recursive
+
+  # Hand the records to R as a JSON file, e.g. for jsonlite::fromJSON(commandArgs(TRUE)[1])
+  pyrgear run --lang r --script plot.R --dir ./photos --data-format json --data-via file
+
+  # Pass arguments on to the script
+  pyrgear run --script report.py --dir ./photos -- --out report.html`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if runScript == "" {
+			fmt.Println("Error: --script is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		if err := runScriptWithData(runScript, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			activeIssues.addError("run", runScript, err)
+			runFailed = true
+		}
+	},
+}
+
+func init() {
+	RunCmd.Flags().StringVar(&runScript, "script", "", "Python or R script to run")
+	RunCmd.Flags().StringVar(
+		&runLang, "lang", "", "Language of the script: python or r (optional, defaults to the script's extension)",
+	)
+	RunCmd.Flags().StringVar(
+		&runInterpreter, "interpreter", "", "Interpreter to run the script with (optional, defaults to the one found on PATH)",
+	)
+	RunCmd.Flags().StringVar(&directory, "dir", "", "Directory whose EXIF data is handed to the script (optional)")
+	RunCmd.Flags().BoolVar(&runRecursive, "recursive", false, "Include subdirectories recursively")
+	RunCmd.Flags().StringVar(&runDataFormat, "data-format", "csv", "Format of the data handed to the script: csv or json")
+	RunCmd.Flags().StringVar(
+		&runDataVia, "data-via", "stdin", "How the data is handed to the script: stdin or file (its path is the first argument)",
+	)
+	addFilterFlags(RunCmd)
+}
+
+// runScriptWithData runs script with args, handing it the EXIF data of --dir as configured.
+// Without --dir the script gets pyrgear's own stdin.
+func runScriptWithData(script string, args []string) error {
+	if runDataFormat != "csv" && runDataFormat != "json" {
+		return fmt.Errorf("unknown --data-format: %s (supported: csv, json)", runDataFormat)
+	}
+	if runDataVia != "stdin" && runDataVia != "file" {
+		return fmt.Errorf("unknown --data-via: %s (supported: stdin, file)", runDataVia)
+	}
+	interpreter, err := scriptInterpreter(script)
+	if err != nil {
+		return err
+	}
+
+	c := exec.Command(interpreter, append([]string{script}, args...)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if directory != "" {
+		data, err := runDataset(directory, runRecursive, runDataFormat)
+		if err != nil {
+			return err
+		}
+		c.Env = append(os.Environ(), "PYRGEAR_DATA_FORMAT="+runDataFormat)
+		if runDataVia == "stdin" {
+			c.Stdin = bytes.NewReader(data)
+		} else {
+			path, err := writeRunData(data, runDataFormat)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(path)
+			c.Args = append([]string{interpreter, script, path}, args...)
+			c.Env = append(c.Env, "PYRGEAR_DATA="+path)
+		}
+	}
+
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s exited with status %d", script, exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run %s: %v", script, err)
+	}
+	return nil
+}
+
+// scriptInterpreter returns the interpreter to run script with: --interpreter, or the first
+// interpreter of its language found on PATH
+func scriptInterpreter(script string) (string, error) {
+	if runInterpreter != "" {
+		return runInterpreter, nil
+	}
+	lang := strings.ToLower(runLang)
+	if lang == "" {
+		switch strings.ToLower(filepath.Ext(script)) {
+		case ".py":
+			lang = "python"
+		case ".r":
+			lang = "r"
+		default:
+			return "", fmt.Errorf("cannot tell the language of %s from its extension, use --lang python or r", script)
+		}
+	}
+	names, ok := runInterpreters[lang]
+	if !ok {
+		return "", fmt.Errorf("unknown --lang: %s (supported: python, r)", runLang)
+	}
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf(
+		"no %s interpreter found on PATH (looked for %s), see pyrgear doctor", lang, strings.Join(names, ", "),
+	)
+}
+
+// runDataset returns the EXIF records of the selected images of dir in format, written as the
+// exif command writes them. Images that cannot be read are reported on stderr and left out.
+// The records are streamed into the dataset, which is held whole to hand it to the script.
+func runDataset(dir string, recursive bool, format string) ([]byte, error) {
+	images, err := collectExifImages(os.Stderr, dir, recursive)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, _, err := writeExifScan(&buf, filterFiles(images), format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeRunData writes data to a temporary file for --data-via file and returns its path
+func writeRunData(data []byte, format string) (string, error) {
+	file, err := os.CreateTemp("", "pyrgear-data-*."+format)
+	if err != nil {
+		return "", fmt.Errorf("failed to create data file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write data file: %v", err)
+	}
+	return file.Name(), nil
+}
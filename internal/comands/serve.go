@@ -0,0 +1,340 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr string
+	serveRoot string
+)
+
+// serveMu serializes requests, the rename processors share package level options
+var serveMu sync.Mutex
+
+// serveMaxBody is the maximum size of a request body in bytes
+const serveMaxBody = 10 << 20
+
+// ServeCmd represents the serve command
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the exif and rename operations over HTTP",
+	Long: `Serve the exif and rename operations as a JSON API, so other programs
+(e.g. Python or R) can use pyrgear without calling the command line.
+
+Endpoints (all POST, JSON request and response bodies):
+  /exif            {"path": "...", "recursive": false}
+                   Read the EXIF data of an image, or of all images in a directory
+  /rename/preview  {"dir": "...", "rule": "sequence", "sequence_name": "photo", ...}
+                   Compute the renames a rule or pattern would perform, without renaming
+  /rename/apply    {"renames": [{"old": "...", "new": "..."}]}
+                   Perform the renames of a plan returned by /rename/preview
+
+Example:
+  pyrgear serve --addr 127.0.0.1:8765 --root /data/photos
+  curl -X POST localhost:8765/exif -d '{"path": "/data/photos/a.jpg"}'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Renames applied through /rename/apply are logged as rule serve
+		finishRenameLog, err := startRenameLog("serve")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer finishRenameLog()
+
+		fmt.Printf("Listening on http://%s\n", serveAddr)
+		if err := http.ListenAndServe(serveAddr, newServeMux()); err != nil {
+			fmt.Printf("Error serving: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	ServeCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8765", "Address to listen on")
+	ServeCmd.Flags().StringVar(
+		&serveRoot, "root", "", "Only allow paths inside this directory (optional, defaults to no restriction)",
+	)
+}
+
+// newServeMux returns the handler of the serve command
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /exif", handleExif)
+	mux.HandleFunc("POST /rename/preview", handleRenamePreview)
+	mux.HandleFunc("POST /rename/apply", handleRenameApply)
+	return mux
+}
+
+// exifRequest is the body of /exif
+type exifRequest struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+}
+
+// exifFileResult is the EXIF data, or the error, of a single image
+type exifFileResult struct {
+	Path  string          `json:"path"`
+	EXIF  *pyrgear.Record `json:"exif,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// exifResponse is the response of /exif
+type exifResponse struct {
+	Files []exifFileResult `json:"files"`
+}
+
+// renamePreviewRequest is the body of /rename/preview, the fields mirror the rename flags
+type renamePreviewRequest struct {
+	Dir          string `json:"dir"`
+	Rule         string `json:"rule"`
+	Pattern      string `json:"pattern"`
+	Replacement  string `json:"replacement"`
+	IgnoreCase   bool   `json:"ignore_case"`
+	StemOnly     bool   `json:"stem_only"`
+	Recursive    bool   `json:"recursive"`
+	Prefix       string `json:"prefix"`
+	SequenceName string `json:"sequence_name"`
+	Locale       string `json:"locale"`
+	Seed         int64  `json:"seed"`
+	RandomFormat string `json:"random_format"`
+}
+
+// failedRename is a rename of a plan that could not be performed
+type failedRename struct {
+	plannedRename
+	Error string `json:"error"`
+}
+
+// renameApplyResponse is the response of /rename/apply
+type renameApplyResponse struct {
+	Applied []plannedRename `json:"applied"`
+	Failed  []failedRename  `json:"failed"`
+}
+
+func handleExif(w http.ResponseWriter, r *http.Request) {
+	var req exifRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if err := checkServeRoot(req.Path); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	images := []string{req.Path}
+	if info.IsDir() {
+		if images, err = collectExifImages(io.Discard, req.Path, req.Recursive); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	resp := exifResponse{Files: []exifFileResult{}}
+	scanExif(
+		images, exifWorkers, true, func(result exifResult) {
+			file := exifFileResult{Path: result.Path, EXIF: result.Record}
+			if result.Err != nil {
+				file.Error = result.Err.Error()
+			}
+			resp.Files = append(resp.Files, file)
+		},
+	)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleRenamePreview(w http.ResponseWriter, r *http.Request) {
+	var req renamePreviewRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Dir == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("dir is required"))
+		return
+	}
+	if err := checkServeRoot(req.Dir); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	serveMu.Lock()
+	defer serveMu.Unlock()
+
+	plan, err := previewRename(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func handleRenameApply(w http.ResponseWriter, r *http.Request) {
+	var plan renamePlan
+	if !decodeRequest(w, r, &plan) {
+		return
+	}
+
+	serveMu.Lock()
+	defer serveMu.Unlock()
+
+	// Plans are previewed within a directory of --root, they may move files between its subdirectories
+	renameBase = serveRoot
+	defer func() {
+		renameBase = ""
+	}()
+
+	resp := renameApplyResponse{Applied: []plannedRename{}, Failed: []failedRename{}}
+	for _, rename := range plan.Renames {
+		err := checkServeRoot(rename.Old)
+		if err == nil {
+			err = checkServeTarget(rename.New)
+		}
+		if err == nil {
+			err = renameFile(rename.Old, rename.New, false)
+		}
+
+		if err != nil {
+			resp.Failed = append(resp.Failed, failedRename{plannedRename: rename, Error: err.Error()})
+		} else {
+			resp.Applied = append(resp.Applied, rename)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// previewRename computes the renames of a preview request with the same processors as the rename command
+func previewRename(req renamePreviewRequest) (*renamePlan, error) {
+	// The processors read their options from the rename flags
+	savedPrefix, savedSequence, savedSeed, savedFormat := prefixName, sequenceName, randomSeed, randomFormat
+	savedStemOnly, savedLocale := stemOnly, caseLocale
+	prefixName, sequenceName, randomSeed, randomFormat = req.Prefix, req.SequenceName, req.Seed, req.RandomFormat
+	stemOnly, caseLocale = req.StemOnly, req.Locale
+	renameBase = req.Dir
+	if randomFormat == "" {
+		randomFormat = "hex"
+	}
+	randomTokens = nil
+	defer func() {
+		prefixName, sequenceName, randomSeed, randomFormat = savedPrefix, savedSequence, savedSeed, savedFormat
+		stemOnly, caseLocale = savedStemOnly, savedLocale
+		renameBase = ""
+		randomTokens = nil
+	}()
+
+	return collectRenamePlan(
+		func() error {
+			switch {
+			case strings.ToLower(req.Rule) == "wx-exporter":
+				return fmt.Errorf("wx-exporter copies files and cannot be previewed as a rename plan")
+			case strings.ToLower(req.Rule) == "foldername-rename":
+				return processFoldernameRename(req.Dir, true)
+			case req.Rule != "":
+				return processDirectoryWithRule(req.Dir, req.Rule, req.Recursive, true)
+			case req.Pattern != "":
+				re, err := compilePattern(req.Pattern, req.IgnoreCase)
+				if err != nil {
+					return fmt.Errorf("failed to compile pattern: %v", err)
+				}
+				return processDirectory(req.Dir, re, req.Replacement, req.Recursive, true)
+			default:
+				return fmt.Errorf("either pattern or rule is required")
+			}
+		},
+	)
+}
+
+// checkServeRoot returns an error if path is outside of --root. Symbolic links are resolved
+// first, so a link within the root to a file or directory outside of it is outside too.
+func checkServeRoot(path string) error {
+	return checkServeRootResolved(path, path)
+}
+
+// checkServeTarget is checkServeRoot for the new name of a rename: only its directory is
+// resolved, as the rename replaces the name itself rather than following it
+func checkServeTarget(path string) error {
+	return checkServeRootResolved(path, filepath.Dir(path))
+}
+
+// checkServeRootResolved checks path, resolved up to the existing part of dir, against --root
+func checkServeRootResolved(path, dir string) error {
+	if serveRoot == "" {
+		return nil
+	}
+	root, err := resolveExisting(serveRoot)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveExisting(dir)
+	if err != nil {
+		return err
+	}
+	if dir != path {
+		resolved = filepath.Join(resolved, filepath.Base(path))
+	}
+	if resolved != root && !pyrgear.IsWithinBase(root, resolved) {
+		return fmt.Errorf("%s is outside of %s", path, serveRoot)
+	}
+	return nil
+}
+
+// resolveExisting returns the absolute path of path with the symbolic links of its longest
+// existing prefix resolved, the part that does not exist yet is appended as is
+func resolveExisting(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for dir := abs; ; {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// decodeRequest decodes the JSON body of r into v, writing an error response if it is invalid
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, serveMaxBody)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,178 @@
+package comands
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postJSON posts body to path on the serve handler and decodes the JSON response into out
+func postJSON(t *testing.T, handler http.Handler, path string, body interface{}, out interface{}) int {
+	data, err := json.Marshal(body)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	return rec.Code
+}
+
+func TestServePreviewAndApply(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "serve_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	for _, name := range []string{"B.txt", "a.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644))
+	}
+	handler := newServeMux()
+
+	// Preview does not touch the files
+	var plan renamePlan
+	code := postJSON(
+		t, handler, "/rename/preview", renamePreviewRequest{Dir: tempDir, Rule: "sequence", SequenceName: "doc"}, &plan,
+	)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(
+		t, []plannedRename{
+			{Old: filepath.Join(tempDir, "B.txt"), New: filepath.Join(tempDir, "doc_001.txt")},
+			{Old: filepath.Join(tempDir, "a.txt"), New: filepath.Join(tempDir, "doc_002.txt")},
+		}, plan.Renames,
+	)
+	assert.Equal(t, []string{"B.txt", "a.txt"}, listNames(t, tempDir))
+	assert.Empty(t, sequenceName)
+
+	// Applying the plan performs exactly those renames
+	var applied renameApplyResponse
+	code = postJSON(t, handler, "/rename/apply", plan, &applied)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, plan.Renames, applied.Applied)
+	assert.Empty(t, applied.Failed)
+	assert.Equal(t, []string{"doc_001.txt", "doc_002.txt"}, listNames(t, tempDir))
+
+	// Applying it again fails for every entry
+	code = postJSON(t, handler, "/rename/apply", plan, &applied)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, applied.Applied)
+	assert.Len(t, applied.Failed, 2)
+
+	// An unknown rule is a bad request
+	var errResp map[string]string
+	code = postJSON(t, handler, "/rename/preview", renamePreviewRequest{Dir: tempDir, Rule: "nope"}, &errResp)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, errResp["error"], "unknown rule type")
+}
+
+func TestServeExifAndRoot(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "serve_exif_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	image := filepath.Join(tempDir, "photo.jpg")
+	writeTestJPEG(t, image, map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "broken.jpg"), []byte("fake image data"), 0644))
+	handler := newServeMux()
+
+	var resp exifResponse
+	code := postJSON(t, handler, "/exif", exifRequest{Path: tempDir}, &resp)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Files, 2) {
+		assert.Equal(t, filepath.Join(tempDir, "broken.jpg"), resp.Files[0].Path)
+		assert.Contains(t, resp.Files[0].Error, "failed to decode EXIF data")
+		value, ok := resp.Files[1].EXIF.Get("Make")
+		assert.True(t, ok)
+		assert.Equal(t, "Canon", value)
+	}
+
+	// Paths outside of --root are refused
+	serveRoot = filepath.Join(tempDir, "sub")
+	defer func() {
+		serveRoot = ""
+	}()
+	var errResp map[string]string
+	code = postJSON(t, handler, "/exif", exifRequest{Path: image}, &errResp)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, errResp["error"], "outside of")
+}
+
+func TestServeRootSymlinks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "serve_symlink_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	root := filepath.Join(tempDir, "root")
+	outside := filepath.Join(tempDir, "outside")
+	assert.NoError(t, os.Mkdir(root, 0755))
+	assert.NoError(t, os.Mkdir(outside, 0755))
+	image := filepath.Join(outside, "photo.jpg")
+	writeTestJPEG(t, image, map[uint16]string{0x010f: "Canon"})
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Skipf("symbolic links are not supported: %v", err)
+	}
+	assert.NoError(t, os.Symlink(image, filepath.Join(root, "photo.jpg")))
+	serveRoot = root
+	defer func() {
+		serveRoot = ""
+	}()
+	handler := newServeMux()
+
+	// Links within the root to a directory or a file outside of it are refused
+	links := []string{filepath.Join(root, "out"), filepath.Join(root, "out", "photo.jpg"), filepath.Join(root, "photo.jpg")}
+	for _, path := range links {
+		var errResp map[string]string
+		code := postJSON(t, handler, "/exif", exifRequest{Path: path}, &errResp)
+		assert.Equal(t, http.StatusForbidden, code, path)
+		assert.Contains(t, errResp["error"], "outside of")
+	}
+	var errResp map[string]string
+	code := postJSON(
+		t, handler, "/rename/preview", renamePreviewRequest{Dir: filepath.Join(root, "out"), Rule: "lowercase"}, &errResp,
+	)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// A rename into a linked directory is refused, nothing is moved
+	var applied renameApplyResponse
+	plan := renamePlan{
+		Renames: []plannedRename{
+			{Old: filepath.Join(root, "a.txt"), New: filepath.Join(root, "out", "a.txt")},
+			{Old: filepath.Join(root, "photo.jpg"), New: filepath.Join(root, "renamed.jpg")},
+		},
+	}
+	code = postJSON(t, handler, "/rename/apply", plan, &applied)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, applied.Applied)
+	if assert.Len(t, applied.Failed, 2) {
+		assert.Contains(t, applied.Failed[0].Error, "outside of")
+		assert.Contains(t, applied.Failed[1].Error, "outside of")
+	}
+	assert.Equal(t, []string{"photo.jpg"}, listNames(t, outside))
+	assert.Equal(t, []string{"a.txt", "out", "photo.jpg"}, listNames(t, root))
+
+	// A new name within the root is accepted, also in a directory that does not exist yet
+	assert.NoError(t, checkServeTarget(filepath.Join(root, "new", "b.txt")))
+	assert.NoError(t, checkServeTarget(filepath.Join(root, "b.txt")))
+	assert.Error(t, checkServeTarget(filepath.Join(root, "out", "new", "b.txt")))
+}
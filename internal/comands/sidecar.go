@@ -0,0 +1,120 @@
+package comands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// exifSidecar writes the record of each image to a file next to it instead of to stdout
+	exifSidecar bool
+	// exifSidecarExists is the policy for sidecars that already exist: error, skip or overwrite
+	exifSidecarExists string
+)
+
+// sidecarExtensions are the extensions of the sidecars of each --format, appended to the
+// image name: photo.jpg gets photo.jpg.json
+var sidecarExtensions = map[string]string{
+	"text":    ".txt",
+	"json":    ".json",
+	"ndjson":  ".json",
+	"yaml":    ".yaml",
+	"csv":     ".csv",
+	"parquet": ".parquet",
+}
+
+// checkSidecarPolicy returns an error for unknown --sidecar-exists policies
+func checkSidecarPolicy(policy string) error {
+	switch policy {
+	case duplicateError, duplicateSkip, duplicateOverwrite:
+		return nil
+	default:
+		return fmt.Errorf("unknown --sidecar-exists policy: %s (supported: error, skip, overwrite)", policy)
+	}
+}
+
+// sidecarPath returns the path of the sidecar of imagePath in format
+func sidecarPath(imagePath string, format string) (string, error) {
+	ext, ok := sidecarExtensions[format]
+	if !ok {
+		return "", fmt.Errorf("unknown output format: %s (supported: text, json, ndjson, yaml, csv, parquet)", format)
+	}
+	return imagePath + ext, nil
+}
+
+// writeExifSidecars writes the record of each of images to its own sidecar in format, next to
+// the image, reporting what was written to w. Existing sidecars are handled by policy, so a
+// library can be regenerated with overwrite or completed with skip. It returns false if any
+// image failed.
+func writeExifSidecars(w io.Writer, images []string, format string, policy string) bool {
+	if err := checkSidecarPolicy(policy); err != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return false
+	}
+	if _, err := sidecarPath("", format); err != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return false
+	}
+
+	startProgress(len(images), "Writing sidecars")
+	defer finishProgress()
+
+	written, skipped, failed := 0, 0, 0
+	scanExif(
+		images, exifWorkers, true, func(result exifResult) {
+			path, _ := sidecarPath(result.Path, format)
+			wrote, err := writeExifSidecar(path, result, format, policy)
+			switch {
+			case err != nil:
+				fmt.Fprintf(w, "Error writing sidecar of %s: %v\n", result.Path, err)
+				activeIssues.addError("exif", result.Path, err)
+				failed++
+			case !wrote:
+				fmt.Fprintf(w, "Skipped: %s (sidecar exists)\n", path)
+				activeReport.countSkipped()
+				skipped++
+			default:
+				fmt.Fprintf(w, "Wrote: %s\n", path)
+				written++
+			}
+		},
+	)
+
+	if !quiet {
+		fmt.Fprintf(w, "Sidecars: %d written, %d skipped, %d failed\n", written, skipped, failed)
+	}
+	return failed == 0
+}
+
+// writeExifSidecar writes the record of result to the sidecar at path. It returns false if
+// the sidecar exists and policy skips it.
+func writeExifSidecar(path string, result exifResult, format string, policy string) (bool, error) {
+	if result.Err != nil {
+		return false, result.Err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		switch {
+		case info.IsDir():
+			return false, fmt.Errorf("%w: %s is a directory", ErrCollision, path)
+		case policy == duplicateSkip:
+			return false, nil
+		case policy != duplicateOverwrite:
+			return false, fmt.Errorf("%w: %s (use --sidecar-exists skip or overwrite)", ErrCollision, path)
+		}
+	}
+
+	formatter, err := newExifFormatter(format, false)
+	if err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	formatter.begin(&buf)
+	formatter.write(&buf, result.Path, result.Record)
+	formatter.end(&buf)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// sortBy is the order in which the sequence and foldername-rename rules number files
+var sortBy string
+
+// sortEntries orders the entries of dir by key: name, mtime (oldest first) or size
+// (smallest first), equal keys in name order. Sorting by name only compares the names, so
+// directories of many files are not stat'ed; the other keys read the info of each entry once.
+// Entries whose info cannot be read are reported and sort first.
+func sortEntries(dir string, entries []os.DirEntry, key string) ([]os.DirEntry, error) {
+	byName := func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	}
+
+	switch key {
+	case "", "name":
+		// os.ReadDir already sorts by name, which keeps this a single pass
+		if !sort.SliceIsSorted(entries, byName) {
+			sort.SliceStable(entries, byName)
+		}
+		return entries, nil
+	case "mtime", "size":
+	default:
+		return nil, fmt.Errorf("unknown sort key: %s (supported: name, mtime, size)", key)
+	}
+
+	type sortedEntry struct {
+		entry   os.DirEntry
+		modTime time.Time
+		size    int64
+	}
+	sorted := make([]sortedEntry, len(entries))
+	for i, entry := range entries {
+		sorted[i].entry = entry
+		info, err := entry.Info()
+		if err != nil {
+			fmt.Printf("Warning: failed to read file info of %s: %v\n", entry.Name(), err)
+			activeIssues.addWarning("sort", filepath.Join(dir, entry.Name()), err)
+			continue
+		}
+		sorted[i].modTime, sorted[i].size = info.ModTime(), info.Size()
+	}
+
+	sort.SliceStable(
+		sorted, func(i, j int) bool {
+			a, b := sorted[i], sorted[j]
+			if key == "mtime" && !a.modTime.Equal(b.modTime) {
+				return a.modTime.Before(b.modTime)
+			}
+			if key == "size" && a.size != b.size {
+				return a.size < b.size
+			}
+			return a.entry.Name() < b.entry.Name()
+		},
+	)
+	for i := range sorted {
+		entries[i] = sorted[i].entry
+	}
+	return entries, nil
+}
@@ -0,0 +1,136 @@
+package comands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	stateFile  string
+	resetState bool
+)
+
+// activeState is the sequence state of the current run when --state-file is set
+var activeState *sequenceState
+
+// sequenceState remembers which files the sequence and foldername-rename rules have
+// already numbered, so that re-runs only number new arrivals and continue the sequence
+type sequenceState struct {
+	path string
+	Dirs map[string]*dirState `json:"dirs"`
+}
+
+// dirState is the numbering state of a single directory
+type dirState struct {
+	Rule string `json:"rule"`
+	// Last is the highest number assigned so far
+	Last int `json:"last"`
+	// Numbered maps the name given to each numbered file to its content hash. Keying on the
+	// name keeps files with identical content apart: each is numbered once.
+	Numbered map[string]string `json:"numbered"`
+	// Files is the content hash to name map of state files written before Numbered, it is
+	// moved into Numbered when the state is loaded
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// loadSequenceState reads the state file at path, a missing file yields an empty state
+func loadSequenceState(path string) (*sequenceState, error) {
+	st := &sequenceState{path: path, Dirs: map[string]*dirState{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	if st.Dirs == nil {
+		st.Dirs = map[string]*dirState{}
+	}
+	for _, ds := range st.Dirs {
+		if ds.Numbered == nil {
+			ds.Numbered = map[string]string{}
+		}
+		for hash, name := range ds.Files {
+			ds.Numbered[name] = hash
+		}
+		ds.Files = nil
+	}
+	return st, nil
+}
+
+// save writes the state back to the file it was loaded from, it is a no-op on a nil state
+func (s *sequenceState) save() error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// forDir returns the state of dir for rule, or nil when no state is tracked.
+// Switching to another rule starts the directory's numbering over.
+func (s *sequenceState) forDir(dir string, rule string) *dirState {
+	if s == nil {
+		return nil
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	ds := s.Dirs[dir]
+	if ds == nil || ds.Rule != rule {
+		ds = &dirState{Rule: rule, Numbered: map[string]string{}}
+		s.Dirs[dir] = ds
+	}
+	return ds
+}
+
+// isStateFile reports whether path is the state file itself, which must never be renamed
+func (s *sequenceState) isStateFile(path string) bool {
+	if s == nil {
+		return false
+	}
+	a, errA := filepath.Abs(path)
+	b, errB := filepath.Abs(s.path)
+	return errA == nil && errB == nil && a == b
+}
+
+// check returns the content hash of path and whether the file was already numbered: it still
+// has the name it was given and the content it had then. Another file with the same content
+// is new. It is a no-op on a nil state. Unreadable files are treated as new.
+func (d *dirState) check(path string) (hash string, seen bool) {
+	if d == nil {
+		return "", false
+	}
+	hash, err := pyrgear.HashFile(path)
+	if err != nil {
+		fmt.Printf("Warning: failed to hash %s: %v\n", path, err)
+		activeIssues.addWarning("hash", path, err)
+		return "", false
+	}
+	numbered, ok := d.Numbered[filepath.Base(path)]
+	return hash, ok && numbered == hash
+}
+
+// record remembers that the file with the given content hash was numbered seq and named name.
+// It is a no-op on a nil state.
+func (d *dirState) record(hash string, name string, seq int) {
+	if d == nil || hash == "" {
+		return
+	}
+	d.Numbered[name] = hash
+	if seq > d.Last {
+		d.Last = seq
+	}
+}
@@ -0,0 +1,206 @@
+package comands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsRecursive bool
+	statsFormat    string
+)
+
+// StatsCmd represents the stats command
+var StatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarize the files of a directory by extension and by content type",
+	Long: `Count the files of a directory by extension and by MIME type sniffed from their content,
+side by side, and list the files whose content contradicts their extension (e.g. a .jpg
+that is really a PNG). The selection filters of the rename and exif commands apply.
+
+Examples:
+  pyrgear stats --dir ./archive --recursive
+  pyrgear stats --dir ./archive --recursive --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if directory == "" {
+			fmt.Println("Error: --dir is required")
+			cmd.Help()
+			return
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		finishLastRun, err := startSinceLastRun("stats", directory, "")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		stats, err := collectFileStats(directory, statsRecursive)
+		finishLastRun(err == nil)
+		if err != nil {
+			fmt.Printf("Error processing directory: %v\n", err)
+			activeIssues.addError("stats", directory, err)
+			return
+		}
+
+		out, finishOutput := startOutput(statsFormat)
+		defer finishOutput()
+		if err := stats.write(out, statsFormat); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	StatsCmd.Flags().StringVar(&directory, "dir", "", "Directory to summarize")
+	StatsCmd.Flags().BoolVar(&statsRecursive, "recursive", false, "Include subdirectories recursively")
+	StatsCmd.Flags().StringVar(&statsFormat, "format", "text", "Output format: text or json")
+	addFilterFlags(StatsCmd)
+}
+
+// extensionStats are the files of one extension and the content types found among them
+type extensionStats struct {
+	Count   int            `json:"count"`
+	Bytes   int64          `json:"bytes"`
+	Content map[string]int `json:"content"`
+}
+
+// mislabeledFile is a file whose content contradicts its extension
+type mislabeledFile struct {
+	Path      string `json:"path"`
+	Extension string `json:"extension"`
+	Expected  string `json:"expected"`
+	Content   string `json:"content"`
+}
+
+// fileStats summarizes the files of a directory
+type fileStats struct {
+	Files       int                        `json:"files"`
+	Bytes       int64                      `json:"bytes"`
+	ByExtension map[string]*extensionStats `json:"by_extension"`
+	ByContent   map[string]int             `json:"by_content"`
+	Mislabeled  []mislabeledFile           `json:"mislabeled"`
+}
+
+// collectFileStats sniffs the content of every selected file of dir
+func collectFileStats(dir string, recursive bool) (*fileStats, error) {
+	stats := &fileStats{
+		ByExtension: make(map[string]*extensionStats),
+		ByContent:   make(map[string]int),
+		Mislabeled:  []mislabeledFile{},
+	}
+	err := walkSelectedFiles(
+		dir, recursive, func(path string, entry os.DirEntry) {
+			info, err := entry.Info()
+			if err != nil {
+				fmt.Printf("Warning: Failed to stat %s: %v\n", path, err)
+				activeIssues.addWarning("stats", path, err)
+				return
+			}
+			contentType, err := pyrgear.DetectContentType(path)
+			if err != nil {
+				fmt.Printf("Warning: Failed to read %s: %v\n", path, err)
+				activeIssues.addWarning("stats", path, err)
+				return
+			}
+			stats.add(path, info.Size(), contentType)
+		},
+	)
+	return stats, err
+}
+
+// add counts a file of the given size and sniffed content type
+func (s *fileStats) add(path string, size int64, contentType string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	es := s.ByExtension[ext]
+	if es == nil {
+		es = &extensionStats{Content: make(map[string]int)}
+		s.ByExtension[ext] = es
+	}
+	es.Count++
+	es.Bytes += size
+	es.Content[contentType]++
+
+	s.Files++
+	s.Bytes += size
+	s.ByContent[contentType]++
+
+	if pyrgear.IsMislabeled(path, contentType) {
+		s.Mislabeled = append(
+			s.Mislabeled, mislabeledFile{
+				Path: path, Extension: ext, Expected: pyrgear.ExtensionType(path), Content: contentType,
+			},
+		)
+	}
+}
+
+// write writes the statistics to w in the given format
+func (s *fileStats) write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		s.writeText(w)
+		return nil
+	case "json":
+		data, err := marshalJSON(s)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+}
+
+// writeText writes the extension and content tallies side by side, then the mislabeled files
+func (s *fileStats) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Files: %d (%s)\n\n", s.Files, formatSize(s.Bytes))
+
+	fmt.Fprintf(w, "%-12s %7s %10s  %s\n", "Extension", "Files", "Size", "Content")
+	for _, ext := range sortedKeys(s.ByExtension) {
+		es := s.ByExtension[ext]
+		var content []string
+		for _, ct := range sortedKeys(es.Content) {
+			content = append(content, fmt.Sprintf("%s %d", ct, es.Content[ct]))
+		}
+		name := ext
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Fprintf(w, "%-12s %7d %10s  %s\n", name, es.Count, formatSize(es.Bytes), strings.Join(content, ", "))
+	}
+
+	fmt.Fprintf(w, "\n%-30s %7s\n", "Content", "Files")
+	for _, ct := range sortedKeys(s.ByContent) {
+		fmt.Fprintf(w, "%-30s %7d\n", ct, s.ByContent[ct])
+	}
+
+	if len(s.Mislabeled) > 0 {
+		fmt.Fprintf(w, "\nMislabeled files (%d):\n", len(s.Mislabeled))
+		for _, f := range s.Mislabeled {
+			fmt.Fprintf(w, "  %s: extension %s (%s), content %s\n", f.Path, f.Extension, f.Expected, f.Content)
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
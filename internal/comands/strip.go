@@ -0,0 +1,153 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stripImagePath string
+	stripRecursive bool
+	stripKeep      []string
+	stripRemove    []string
+)
+
+// StripCmd represents the strip command
+var StripCmd = &cobra.Command{
+	Use:   "strip",
+	Short: "Remove EXIF information from JPEG images",
+	Long: `Remove EXIF information from JPEG images, in place. The image data is not re-encoded.
+
+Without --keep or --remove all EXIF data is removed. Tag names are those shown by the
+exif command and may use glob patterns such as 'GPS*'.
+
+Examples:
+  # Remove all EXIF data
+  pyrgear strip --dir ./photos --recursive
+
+  # Keep only the camera information
+  pyrgear strip --image photo.jpg --keep Make,Model,Orientation
+
+  # Drop the location but keep everything else
+  pyrgear strip --dir ./photos --remove 'GPS*'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if stripImagePath == "" && directory == "" {
+			fmt.Println("Error: either --image or --dir is required")
+			cmd.Help()
+			return
+		}
+		if len(stripKeep) > 0 && len(stripRemove) > 0 {
+			fmt.Println("Error: --keep and --remove cannot be used together")
+			return
+		}
+		for _, name := range append(append([]string{}, stripKeep...), stripRemove...) {
+			if _, err := path.Match(name, ""); err != nil {
+				fmt.Printf("Error: invalid tag pattern %q: %v\n", name, err)
+				return
+			}
+		}
+
+		finishIssues := startIssues()
+		defer func() {
+			if err := finishIssues(); err != nil {
+				fmt.Printf("Error writing errors file: %v\n", err)
+			}
+		}()
+
+		// Stripping other tags is another rule for --since-last-run
+		rule := "all"
+		if len(stripKeep) > 0 {
+			rule = "keep " + strings.Join(stripKeep, ",")
+		} else if len(stripRemove) > 0 {
+			rule = "remove " + strings.Join(stripRemove, ",")
+		}
+		finishLastRun, err := startSinceLastRun("strip", directory, rule)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		// Images that failed are retried by the next --since-last-run
+		failed := false
+		defer func() {
+			finishLastRun(!failed)
+		}()
+
+		images := []string{stripImagePath}
+		if stripImagePath == "" {
+			images, err = collectExifImages(os.Stdout, directory, stripRecursive)
+			if err != nil {
+				fmt.Printf("Error processing directory: %v\n", err)
+				activeIssues.addError("strip", directory, err)
+				failed = true
+				return
+			}
+		}
+
+		for _, image := range filterFiles(images) {
+			if activeErrorBudget.exceeded() {
+				failed = true
+				break
+			}
+			stepProgress()
+			if err := stripImage(image, stripFilter(stripKeep, stripRemove), dryRun); err != nil {
+				fmt.Printf("Error stripping %s: %v\n", image, err)
+				activeIssues.addError("strip", image, err)
+				failed = true
+			}
+		}
+	},
+}
+
+func init() {
+	StripCmd.Flags().StringVar(&stripImagePath, "image", "", "Path to a single JPEG image")
+	StripCmd.Flags().StringVar(&directory, "dir", "", "Directory containing images")
+	StripCmd.Flags().BoolVar(&stripRecursive, "recursive", false, "Process subdirectories recursively")
+	StripCmd.Flags().StringSliceVar(
+		&stripKeep, "keep", nil, "Only keep these EXIF tags, e.g. Make,Model,Orientation (glob patterns allowed)",
+	)
+	StripCmd.Flags().StringSliceVar(
+		&stripRemove, "remove", nil, "Only remove these EXIF tags, e.g. GPSLatitude,GPSLongitude (glob patterns allowed)",
+	)
+	StripCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be stripped without changing any file")
+	addFilterFlags(StripCmd)
+}
+
+// stripFilter returns the tag filter for --keep or --remove, or nil to remove all EXIF data
+func stripFilter(keep, remove []string) pyrgear.TagFilter {
+	matches := func(patterns []string, name string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case len(keep) > 0:
+		return func(name string) bool { return matches(keep, name) }
+	case len(remove) > 0:
+		return func(name string) bool { return !matches(remove, name) }
+	default:
+		return nil
+	}
+}
+
+// stripImage removes the EXIF tags rejected by keep from a JPEG, or only reports it in dry-run mode
+func stripImage(imagePath string, keep pyrgear.TagFilter, dryRun bool) error {
+	if ext := strings.ToLower(filepath.Ext(imagePath)); ext != ".jpg" && ext != ".jpeg" {
+		return fmt.Errorf("%w: %s (supported: jpg, jpeg)", ErrUnsupportedFormat, imagePath)
+	}
+	if dryRun {
+		fmt.Printf("Would strip: %s\n", imagePath)
+		return nil
+	}
+	fmt.Printf("Stripping: %s\n", imagePath)
+	return pyrgear.StripEXIFFile(imagePath, keep)
+}
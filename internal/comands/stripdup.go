@@ -0,0 +1,180 @@
+package comands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// onConflict decides which file strip-dup-suffix keeps when the stripped name is taken
+var onConflict string
+
+// Policies of --on-conflict
+const (
+	// conflictNewer keeps the file modified last
+	conflictNewer = "newer"
+	// conflictLarger keeps the larger file
+	conflictLarger = "larger"
+	// conflictSkip leaves both files alone
+	conflictSkip = "skip"
+)
+
+// checkConflictPolicy returns an error for unknown --on-conflict policies
+func checkConflictPolicy(policy string) error {
+	switch policy {
+	case conflictNewer, conflictLarger, conflictSkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown --on-conflict policy: %s (supported: newer, larger, skip)", policy)
+	}
+}
+
+// renameStripDupSuffix removes the duplicate markers of the files of dir, photo (1).jpg becomes
+// photo.jpg, see pyrgear.StripDupSuffix. When the stripped name is taken, by the original or by
+// another duplicate of this run, a copy with the same content is removed; otherwise --on-conflict
+// decides which file is kept and the other one is removed.
+func renameStripDupSuffix(dir string, entries []os.DirEntry, dryRun bool) error {
+	if err := checkConflictPolicy(onConflict); err != nil {
+		return err
+	}
+	// Removed files cannot be staged, they would be gone while the plan is collected
+	if activePlan != nil {
+		return fmt.Errorf("--atomic is not supported for strip-dup-suffix rule")
+	}
+
+	// occupants are the files that hold, or in dry-run would hold, each stripped name
+	occupants := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || alreadyApplied("strip-dup-suffix", entry.Name()) {
+			continue
+		}
+		if activeErrorBudget.exceeded() {
+			break
+		}
+		oldPath := filepath.Join(dir, entry.Name())
+		newPath := filepath.Join(dir, pyrgear.StripDupSuffix(entry.Name()))
+
+		occupant, ok := occupants[newPath]
+		if !ok {
+			if _, err := os.Lstat(newPath); err == nil {
+				occupant = newPath
+			}
+		}
+		if occupant == "" {
+			if renameFile(oldPath, newPath, dryRun) == nil {
+				occupants[newPath] = oldPath
+				if !dryRun {
+					occupants[newPath] = newPath
+				}
+			}
+			continue
+		}
+
+		keepDuplicate, reason, err := resolveDupConflict(oldPath, occupant)
+		if err != nil {
+			fmt.Printf("Error comparing %s with %s: %v\n", oldPath, occupant, err)
+			activeIssues.addError("rename", oldPath, err)
+			stepProgress()
+			continue
+		}
+		switch {
+		case reason == "":
+			fmt.Printf("Skipping %s: %s exists with different content\n", oldPath, newPath)
+			activeReport.countSkipped()
+			stepProgress()
+		case !keepDuplicate:
+			removeDupLoser(oldPath, occupant, reason, dryRun)
+			stepProgress()
+		default:
+			if removeDupLoser(occupant, oldPath, reason, dryRun) != nil {
+				stepProgress()
+				continue
+			}
+			// In dry-run the stripped name is still taken, the removal only would have freed it
+			if dryRun {
+				vacate(newPath, "")
+			}
+			if renameFile(oldPath, newPath, dryRun) == nil {
+				occupants[newPath] = oldPath
+				if !dryRun {
+					occupants[newPath] = newPath
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDupConflict decides whether the duplicate at path replaces occupant, the file holding
+// its stripped name. reason relates the file that goes to the one that stays, e.g. "older than";
+// it is empty when both are kept (--on-conflict skip).
+func resolveDupConflict(path string, occupant string) (keepDuplicate bool, reason string, err error) {
+	dupInfo, err := os.Stat(path)
+	if err != nil {
+		return false, "", err
+	}
+	occInfo, err := os.Stat(occupant)
+	if err != nil {
+		return false, "", err
+	}
+	if occInfo.IsDir() {
+		return false, "", fmt.Errorf("%w: %s is a directory", ErrCollision, occupant)
+	}
+
+	// Hard links of one file, and copies, lose nothing when removed
+	if os.SameFile(dupInfo, occInfo) {
+		return false, "the same file as", nil
+	}
+	if dupInfo.Size() == occInfo.Size() {
+		dupHash, err := pyrgear.HashFileAlgo(path, hashAlgo)
+		if err != nil {
+			return false, "", err
+		}
+		occHash, err := pyrgear.HashFileAlgo(occupant, hashAlgo)
+		if err != nil {
+			return false, "", err
+		}
+		if dupHash == occHash {
+			return false, "same content as", nil
+		}
+	}
+
+	// Ties keep the file that already holds the name
+	switch onConflict {
+	case conflictNewer:
+		switch dupInfo.ModTime().Compare(occInfo.ModTime()) {
+		case 1:
+			return true, "older than", nil
+		case -1:
+			return false, "older than", nil
+		}
+		return false, "not newer than", nil
+	case conflictLarger:
+		switch {
+		case dupInfo.Size() > occInfo.Size():
+			return true, "smaller than", nil
+		case dupInfo.Size() < occInfo.Size():
+			return false, "smaller than", nil
+		}
+		return false, "not larger than", nil
+	}
+	return false, "", nil
+}
+
+// removeDupLoser removes loser, the file strip-dup-suffix does not keep of it and winner.
+// reason relates it to winner, e.g. "older than".
+func removeDupLoser(loser string, winner string, reason string, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("Would remove: %s (%s %s)\n", loser, reason, winner)
+		return nil
+	}
+	if err := os.Remove(loser); err != nil {
+		fmt.Printf("Error removing %s: %v\n", loser, err)
+		activeIssues.addError("remove", loser, err)
+		return err
+	}
+	fmt.Printf("Removing: %s (%s %s)\n", loser, reason, winner)
+	return nil
+}
@@ -0,0 +1,572 @@
+package comands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+var (
+	// wxWorkers is the number of concurrent copies of the wx-exporter rule
+	wxWorkers int
+	// wxShowSummary prints the totals of a wx-exporter run
+	wxShowSummary bool
+	// verifyCopy compares each copy with its source after copying
+	verifyCopy bool
+	// wxSeparator joins the source name, path2 name and sequence number of exported files
+	wxSeparator string
+	// wxEscapeSeparator replaces the separator inside source and path2 names
+	wxEscapeSeparator bool
+	// wxSlugOutput turns exported names into web-safe slugs, see pyrgear.SlugName
+	wxSlugOutput bool
+	// wxAlsoCopy are globs of files next to assets/ that are copied as well
+	wxAlsoCopy []string
+	// copyBufferSizeFlag is the human-readable --copy-buffer-size, copyBufferSize the parsed
+	// value used by copyFile; 0 keeps the default copy
+	copyBufferSizeFlag string
+	copyBufferSize     int
+	// wxOutputManifest is the JSON file mapping each exported asset to its output name
+	wxOutputManifest string
+)
+
+// maxCopyBufferSize bounds --copy-buffer-size, as every concurrent copy allocates its own buffer
+const maxCopyBufferSize = 256 << 20
+
+// wxCopyJob is a single asset copy of the wx-exporter rule
+type wxCopyJob struct {
+	src  string
+	dst  string
+	size int64
+	// path2 is the path2 name used in the output name
+	path2 string
+	// redo replaces dst, the unfinished or changed copy of a resumed run
+	redo bool
+}
+
+// wxManifestEntry is an exported asset in the --output-manifest file
+type wxManifestEntry struct {
+	Source string `json:"source"`
+	Path2  string `json:"path2"`
+	Output string `json:"output"`
+	SHA256 string `json:"sha256"`
+}
+
+// wxSummary holds the totals of a wx-exporter run
+type wxSummary struct {
+	// dirs is the number of path2 directories with an assets directory
+	dirs int
+	// copied and bytes count the copied assets, or the assets that would be copied in dry-run
+	copied int
+	bytes  int64
+	// skipped counts the files in assets directories that are not images, and the
+	// copies left out by --on-duplicate skip
+	skipped int
+	failed  int
+	// resumed counts the copies an earlier run already made, see --resume
+	resumed int
+	output  string
+}
+
+// print writes the summary to stdout
+func (s *wxSummary) print(dryRun bool) {
+	title, copied := "Summary:", "Copied:"
+	if dryRun {
+		title, copied = "Summary (dry run):", "Would copy:"
+	}
+	fmt.Println(title)
+	fmt.Printf("  %-14s%d\n", "Directories:", s.dirs)
+	fmt.Printf("  %-14s%d\n", copied, s.copied)
+	fmt.Printf("  %-14s%d\n", "Skipped:", s.skipped)
+	if s.resumed > 0 {
+		fmt.Printf("  %-14s%d\n", "Resumed:", s.resumed)
+	}
+	fmt.Printf("  %-14s%d\n", "Failed:", s.failed)
+	fmt.Printf("  %-14s%s\n", "Total size:", formatSize(s.bytes))
+	fmt.Printf("  %-14s%s\n", "Output:", s.output)
+}
+
+// formatSize returns n bytes in human-readable binary units
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// processWxExporter processes the wx-exporter rule
+func processWxExporter(sourcePath string, outputDir string, dryRun bool) error {
+	copyBufferSize = resolveCopyBufferSize(copyBufferSizeFlag)
+
+	// If sourcePath is not specified, use current directory
+	if sourcePath == "" {
+		var err error
+		sourcePath, err = os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %v", err)
+		}
+	}
+
+	jobs, summary, err := planWxExport(sourcePath, outputDir)
+	if err != nil {
+		return err
+	}
+	if err := checkMaxFiles(len(jobs)); err != nil {
+		return err
+	}
+
+	// Create output directory if it doesn't exist, in dry-run only report it
+	if dryRun {
+		if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+			fmt.Printf("Would create output directory: %s\n", outputDir)
+		}
+	} else {
+		err := os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create output directory %s: %v", outputDir, err)
+		}
+	}
+
+	journal, err := openWxJournal(outputDir, wxResume, dryRun)
+	if err != nil {
+		return err
+	}
+	activeWxJournal = journal
+	defer func() {
+		activeWxJournal = nil
+	}()
+	jobs = journal.resume(jobs, summary)
+	jobs = resolveWxDestinations(jobs, summary)
+
+	exported := jobs
+	if dryRun {
+		for _, job := range jobs {
+			fmt.Printf("Would copy: %s -> %s\n", job.src, job.dst)
+			summary.copied++
+			summary.bytes += job.size
+			stepProgress()
+		}
+	} else {
+		// Only failed copies are worth resuming, refused destinations fail again
+		failed := summary.failed
+		exported = runWxCopies(jobs, wxWorkers, summary)
+		if err := journal.finish(summary.failed > failed); err != nil {
+			fmt.Printf("Warning: Failed to finish journal: %v\n", err)
+		}
+	}
+
+	if wxOutputManifest != "" {
+		if err := writeWxManifest(wxOutputManifest, exported, dryRun); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case quiet:
+	case wxShowSummary:
+		summary.print(dryRun)
+	case !dryRun:
+		fmt.Printf("Copied %d of %d files (%d failed)\n", summary.copied, len(jobs), summary.failed)
+	}
+	return nil
+}
+
+// planWxExport returns the copies of the assets of sourcePath into outputDir. Sequence
+// numbers are assigned in directory order before any copy starts, so they do not depend
+// on --workers. The returned summary counts the directories and skipped files.
+func planWxExport(sourcePath string, outputDir string) ([]wxCopyJob, *wxSummary, error) {
+	// Map to track sequence numbers for each path2
+	sequenceMap := make(map[string]int)
+	var jobs []wxCopyJob
+	summary := &wxSummary{output: outputDir}
+
+	for _, pattern := range wxAlsoCopy {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid --also-copy pattern %s: %v", pattern, err)
+		}
+	}
+
+	// First, find all subdirectories (path2) in the source directory (path1)
+	path2Dirs, err := findPath2Directories(sourcePath, recursive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find subdirectories in %s: %v", sourcePath, err)
+	}
+
+	if len(path2Dirs) == 0 {
+		fmt.Printf("Warning: No subdirectories found in %s\n", sourcePath)
+	}
+
+	sourceName := filepath.Base(sourcePath)
+	// If preName is specified, use it as the prefix
+	if preName != "" {
+		sourceName = preName
+	}
+	sourceName = wxNamePart(sourceName)
+
+	// Process each path2 directory
+	for _, path2Dir := range path2Dirs {
+		// Get the path2 name (just the directory name, not the full path)
+		path2Name := wxNamePart(wxPath2Name(sourcePath, path2Dir))
+
+		// Companion files matching --also-copy keep their name behind the path2 prefix
+		prefix := sourceName + wxSeparator + path2Name + wxSeparator
+		jobs = append(jobs, planWxCompanions(path2Dir, path2Name, prefix, outputDir)...)
+
+		// Check if assets directory exists
+		assetsDir := filepath.Join(path2Dir, "assets")
+		assetsInfo, err := os.Stat(assetsDir)
+		if err != nil || !assetsInfo.IsDir() {
+			// Skip if assets directory doesn't exist
+			continue
+		}
+
+		// Process all files in the assets directory
+		assetFiles, err := os.ReadDir(assetsDir)
+		if err != nil {
+			fmt.Printf("Warning: Failed to read assets directory %s: %v\n", assetsDir, err)
+			activeIssues.addWarning("copy", assetsDir, err)
+			continue
+		}
+		summary.dirs++
+
+		// Process each file in the assets directory
+		for _, file := range assetFiles {
+			if file.IsDir() {
+				// Skip subdirectories in assets
+				continue
+			}
+
+			filePath := filepath.Join(assetsDir, file.Name())
+
+			// Check if the file is an image (simple check by extension)
+			ext := strings.ToLower(filepath.Ext(file.Name()))
+			if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" {
+				summary.skipped++
+				continue
+			}
+
+			// Increment sequence number for this path2
+			sequenceMap[path2Name]++
+			sequence := sequenceMap[path2Name]
+
+			// Create new filename: path2_sequence with original extension
+			newName := fmt.Sprintf("%s%03d%s", prefix, sequence, ext)
+			job := wxCopyJob{src: filePath, dst: filepath.Join(outputDir, wxOutputName(newName)), path2: path2Name}
+			if info, err := file.Info(); err == nil {
+				// Unchanged files keep their number but are not copied again with --since-last-run
+				if !modifiedSinceLastRun(info) {
+					continue
+				}
+				job.size = info.Size()
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, summary, nil
+}
+
+// wxNamePart returns a source or path2 name for use in exported names. With
+// --escape-separator occurrences of the separator are replaced by wxEscape, so the
+// parts of exported names can be told apart.
+func wxNamePart(name string) string {
+	if !wxEscapeSeparator || wxSeparator == "" {
+		return name
+	}
+	return strings.ReplaceAll(name, wxSeparator, wxEscape())
+}
+
+// wxEscape returns the replacement of separators inside names: "-", or "_" if the
+// separator is "-"
+func wxEscape() string {
+	if wxSeparator == "-" {
+		return "_"
+	}
+	return "-"
+}
+
+// wxOutputName returns the name an exported file is written as: name itself, or with
+// --slug-output its slug. Slugs of different names may collide, which --on-duplicate handles.
+func wxOutputName(name string) string {
+	if !wxSlugOutput {
+		return name
+	}
+	return pyrgear.SlugName(name)
+}
+
+// planWxCompanions returns the copies of the files of path2Dir that match --also-copy,
+// named prefix + their original name
+func planWxCompanions(path2Dir string, path2Name string, prefix string, outputDir string) []wxCopyJob {
+	if len(wxAlsoCopy) == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(path2Dir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to read directory %s: %v\n", path2Dir, err)
+		activeIssues.addWarning("copy", path2Dir, err)
+		return nil
+	}
+
+	var jobs []wxCopyJob
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, pattern := range wxAlsoCopy {
+			if matched, _ := filepath.Match(pattern, entry.Name()); !matched {
+				continue
+			}
+			job := wxCopyJob{
+				src:   filepath.Join(path2Dir, entry.Name()),
+				dst:   filepath.Join(outputDir, wxOutputName(prefix+entry.Name())),
+				path2: path2Name,
+			}
+			if info, err := entry.Info(); err == nil {
+				job.size = info.Size()
+			}
+			jobs = append(jobs, job)
+			break
+		}
+	}
+	return jobs
+}
+
+// resolveWxDestinations applies --on-duplicate to the destinations of jobs before anything is
+// copied, so concurrent copies never race for a name. Skipped jobs are dropped and counted as
+// skipped, refused ones as failed.
+func resolveWxDestinations(jobs []wxCopyJob, summary *wxSummary) []wxCopyJob {
+	taken := make(map[string]bool)
+	var resolved []wxCopyJob
+	for _, job := range jobs {
+		if job.redo {
+			taken[job.dst] = true
+			resolved = append(resolved, job)
+			continue
+		}
+		dst, err := resolveDestination("", job.dst, onDuplicate, taken)
+		if err != nil {
+			fmt.Printf("Error copying %s: %v\n", job.src, err)
+			activeIssues.addError("copy", job.src, err)
+			summary.failed++
+			stepProgress()
+			continue
+		}
+		if dst == "" {
+			fmt.Printf("Skipping %s: target already exists: %s\n", job.src, job.dst)
+			summary.skipped++
+			activeReport.countSkipped()
+			stepProgress()
+			continue
+		}
+		taken[dst] = true
+		job.dst = dst
+		resolved = append(resolved, job)
+	}
+	return resolved
+}
+
+// resolveCopyBufferSize parses a --copy-buffer-size value. Empty, invalid and out of range
+// values fall back to the default copy (0) with a warning.
+func resolveCopyBufferSize(value string) int {
+	if value == "" {
+		return 0
+	}
+	size, err := parseSize(value)
+	if err == nil && (size <= 0 || size > maxCopyBufferSize) {
+		err = fmt.Errorf("size %q must be between 1B and %s", value, formatSize(maxCopyBufferSize))
+	}
+	if err != nil {
+		fmt.Printf("Warning: Invalid --copy-buffer-size, using the default: %v\n", err)
+		return 0
+	}
+	return int(size)
+}
+
+// copyFile copies src to dst. With --verify-copy the copy is compared with its source
+// afterwards, by their --hash-algo hashes, and copied once more if it differs.
+func copyFile(src, dst string) error {
+	err := pyrgear.CopyFileBuffer(src, dst, copyBufferSize)
+	if err != nil || !verifyCopy {
+		return err
+	}
+
+	err = pyrgear.VerifyCopyAlgo(src, dst, hashAlgo)
+	if !errors.Is(err, ErrCopyMismatch) {
+		return err
+	}
+
+	// The first copy may have been incomplete, try once more
+	if err := pyrgear.CopyFileBuffer(src, dst, copyBufferSize); err != nil {
+		return err
+	}
+	return pyrgear.VerifyCopyAlgo(src, dst, hashAlgo)
+}
+
+// runWxCopies performs the copies with up to workers concurrent copies,
+// counting successful and failed copies in summary. It returns the jobs that
+// were copied, in their original order.
+func runWxCopies(jobs []wxCopyJob, workers int, summary *wxSummary) []wxCopyJob {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// mu guards the counters, the progress bar and the output lines
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	copied := make([]bool, len(jobs))
+	queue := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				job := jobs[i]
+				journalErr := activeWxJournal.start(job)
+				err := copyFile(job.src, job.dst)
+				if err == nil && journalErr == nil {
+					journalErr = activeWxJournal.done(job)
+				}
+
+				mu.Lock()
+				if journalErr != nil {
+					fmt.Printf("Warning: Failed to record %s in the journal: %v\n", job.dst, journalErr)
+					activeIssues.addWarning("copy", job.dst, journalErr)
+				}
+				if err != nil {
+					fmt.Printf("Error copying %s: %v\n", job.src, err)
+					activeIssues.addError("copy", job.src, err)
+					summary.failed++
+				} else {
+					fmt.Printf("Copying: %s -> %s\n", job.src, job.dst)
+					summary.copied++
+					summary.bytes += job.size
+					copied[i] = true
+				}
+				stepProgress()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range jobs {
+		if activeErrorBudget.exceeded() {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	var done []wxCopyJob
+	for i, job := range jobs {
+		if copied[i] {
+			done = append(done, job)
+		}
+	}
+	return done
+}
+
+// writeWxManifest writes the --output-manifest file of jobs to path: the source, path2 name,
+// output name and SHA-256 of each exported asset. In dry-run the planned copies are listed
+// with the hash of their source; otherwise the copies themselves are hashed.
+func writeWxManifest(path string, jobs []wxCopyJob, dryRun bool) error {
+	entries := make([]wxManifestEntry, 0, len(jobs))
+	for _, job := range jobs {
+		source, err := filepath.Abs(job.src)
+		if err != nil {
+			source = job.src
+		}
+		hashed := job.dst
+		if dryRun {
+			hashed = job.src
+		}
+		sum, err := pyrgear.HashFile(hashed)
+		if err != nil {
+			fmt.Printf("Warning: Failed to hash %s for the output manifest: %v\n", hashed, err)
+			activeIssues.addWarning("copy", hashed, err)
+		}
+		entries = append(
+			entries, wxManifestEntry{Source: source, Path2: job.path2, Output: filepath.Base(job.dst), SHA256: sum},
+		)
+	}
+
+	data, err := marshalJSON(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write output manifest %s: %v", path, err)
+	}
+	return nil
+}
+
+// findPath2Directories finds all immediate subdirectories in the given path1 directory, or
+// with recursive all directories below it that contain an assets directory, in walk order
+func findPath2Directories(path1 string, recursive bool) ([]string, error) {
+	if !recursive {
+		entries, err := os.ReadDir(path1)
+		if err != nil {
+			return nil, err
+		}
+
+		var dirs []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(path1, entry.Name()))
+			}
+		}
+		return dirs, nil
+	}
+
+	// Any directory below path1 with an assets directory is a path2, assets directories
+	// themselves are not searched
+	var dirs []string
+	err := filepath.WalkDir(
+		path1, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				if path == path1 {
+					return err
+				}
+				fmt.Printf("Warning: Failed to read directory %s: %v\n", path, err)
+				activeIssues.addWarning("copy", path, err)
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if path != path1 && d.Name() == "assets" {
+				return filepath.SkipDir
+			}
+			if path == path1 {
+				return nil
+			}
+			if info, err := os.Stat(filepath.Join(path, "assets")); err == nil && info.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		},
+	)
+	return dirs, err
+}
+
+// wxPath2Name returns the name of path2Dir in exported names: its path relative to path1,
+// with the path separators replaced like separators inside names (see wxEscape), so
+// blog/2023/post becomes blog-2023-post
+func wxPath2Name(path1 string, path2Dir string) string {
+	rel, err := filepath.Rel(path1, path2Dir)
+	if err != nil {
+		return filepath.Base(path2Dir)
+	}
+	return strings.Join(strings.Split(filepath.ToSlash(rel), "/"), wxEscape())
+}
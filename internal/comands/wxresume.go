@@ -0,0 +1,176 @@
+package comands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pyronn/pyrgear/pkg/pyrgear"
+)
+
+// wxResume continues an interrupted wx-exporter run from its journal
+var wxResume bool
+
+// activeWxJournal records the copies of the current wx-exporter run
+var activeWxJournal *wxJournal
+
+// wxJournalName is the journal of a wx-exporter run in the output directory. It only
+// remains after runs that were interrupted or had failed copies.
+const wxJournalName = ".pyrgear-wx-journal.ndjson"
+
+// wxJournalLine is a line of the journal: a copy that started, or one that completed
+// with the SHA-256 of the copy
+type wxJournalLine struct {
+	Op     string `json:"op"`
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// wxJournal records the copies of a wx-exporter run as they happen, so an interrupted run
+// can be resumed. Its methods are no-ops on a nil journal.
+type wxJournal struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	// previous are the lines of the journal being resumed, by destination
+	previous map[string]wxJournalLine
+}
+
+// openWxJournal opens the journal in outputDir. With resume the lines of an existing journal
+// are loaded and new lines appended; otherwise an existing journal is replaced. In dry-run the
+// journal is only read, and nil is returned without resume.
+func openWxJournal(outputDir string, resume bool, dryRun bool) (*wxJournal, error) {
+	j := &wxJournal{path: filepath.Join(outputDir, wxJournalName), previous: map[string]wxJournalLine{}}
+
+	if resume {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		if !resume {
+			return nil, nil
+		}
+		return j, nil
+	}
+	if _, err := os.Stat(j.path); err == nil && !resume {
+		fmt.Printf("Warning: Starting over an interrupted export in %s, use --resume to continue it\n", outputDir)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(j.path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %v", j.path, err)
+	}
+	j.file = file
+	return j, nil
+}
+
+// load reads the lines of an existing journal. A missing journal is empty, and the
+// unfinished last line of an interrupted run is ignored.
+func (j *wxJournal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal %s: %v", j.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line wxJournalLine
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		j.previous[line.Dst] = line
+	}
+	return scanner.Err()
+}
+
+// resume drops the jobs the resumed run already copied, counting them in summary. A copy
+// counts as done if the journal completed it from the same source and it still has the
+// recorded content; copies that were cut off or no longer match are made again, replacing
+// the destination.
+func (j *wxJournal) resume(jobs []wxCopyJob, summary *wxSummary) []wxCopyJob {
+	if j == nil || len(j.previous) == 0 {
+		return jobs
+	}
+
+	var remaining []wxCopyJob
+	for _, job := range jobs {
+		line, ok := j.previous[job.dst]
+		if !ok || line.Src != job.src {
+			remaining = append(remaining, job)
+			continue
+		}
+		if line.Op == "done" {
+			if sum, err := pyrgear.HashFile(job.dst); err == nil && sum == line.SHA256 {
+				fmt.Printf("Already copied: %s -> %s\n", job.src, job.dst)
+				summary.resumed++
+				stepProgress()
+				continue
+			}
+			fmt.Printf("Copying again, the copy has changed: %s\n", job.dst)
+		}
+		job.redo = true
+		remaining = append(remaining, job)
+	}
+	return remaining
+}
+
+// start records that the copy of job begins
+func (j *wxJournal) start(job wxCopyJob) error {
+	return j.write(wxJournalLine{Op: "start", Src: job.src, Dst: job.dst})
+}
+
+// done records that the copy of job completed, with the hash of the copy
+func (j *wxJournal) done(job wxCopyJob) error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	sum, err := pyrgear.HashFile(job.dst)
+	if err != nil {
+		return err
+	}
+	return j.write(wxJournalLine{Op: "done", Src: job.src, Dst: job.dst, SHA256: sum})
+}
+
+// write appends a line to the journal
+func (j *wxJournal) write(line wxJournalLine) error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+// finish closes the journal, and removes it unless copies failed
+func (j *wxJournal) finish(copyFailed bool) error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	if copyFailed {
+		fmt.Printf("Run again with --resume to retry the failed copies, see %s\n", j.path)
+		return nil
+	}
+	return os.Remove(j.path)
+}
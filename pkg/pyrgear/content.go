@@ -0,0 +1,60 @@
+package pyrgear
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is the number of bytes content detection looks at
+const sniffLen = 512
+
+// DetectContentType returns the MIME type of the file at path sniffed from its first bytes,
+// without parameters such as the charset. Unknown content is "application/octet-stream".
+func DetectContentType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return mediaType(http.DetectContentType(buf[:n])), nil
+}
+
+// ExtensionType returns the MIME type registered for the extension of name, or "" if there is none
+func ExtensionType(name string) string {
+	return mediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))))
+}
+
+// IsMislabeled reports whether the content of a file, of the given sniffed MIME type, contradicts
+// the extension of name, e.g. a ".jpg" holding a PNG. Only image, audio, video and PDF signatures
+// are trusted: text and container formats (e.g. ".docx" sniffed as a ZIP) are never reported.
+func IsMislabeled(name string, contentType string) bool {
+	expected := ExtensionType(name)
+	if expected == "" || expected == contentType {
+		return false
+	}
+	return isSignatureType(contentType)
+}
+
+// isSignatureType reports whether contentType is recognized by a reliable binary signature
+func isSignatureType(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "audio/") ||
+		strings.HasPrefix(contentType, "video/") || contentType == "application/pdf"
+}
+
+// mediaType strips the parameters from a MIME type
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return contentType
+}
@@ -0,0 +1,57 @@
+package pyrgear
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_content_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// A PNG saved with a .jpg extension
+	fake := filepath.Join(tempDir, "photo.jpg")
+	file, err := os.Create(fake)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, image.NewGray(image.Rect(0, 0, 1, 1))))
+	assert.NoError(t, file.Close())
+
+	notes := filepath.Join(tempDir, "notes.txt")
+	assert.NoError(t, os.WriteFile(notes, []byte("plain text"), 0644))
+	empty := filepath.Join(tempDir, "empty.bin")
+	assert.NoError(t, os.WriteFile(empty, nil, 0644))
+
+	contentType, err := DetectContentType(fake)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.True(t, IsMislabeled(fake, contentType))
+	assert.False(t, IsMislabeled(filepath.Join(tempDir, "photo.png"), contentType))
+
+	// Parameters such as the charset are dropped
+	contentType, err = DetectContentType(notes)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+	assert.False(t, IsMislabeled("data.csv", contentType))
+
+	contentType, err = DetectContentType(empty)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+
+	// Container formats are not reported
+	assert.False(t, IsMislabeled("report.docx", "application/zip"))
+	assert.False(t, IsMislabeled("noext", "image/png"))
+
+	_, err = DetectContentType(filepath.Join(tempDir, "missing"))
+	assert.Error(t, err)
+}
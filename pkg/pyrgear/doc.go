@@ -0,0 +1,7 @@
+// Package pyrgear exposes the file renaming and EXIF reading logic of the
+// pyrgear command line tool as a library.
+//
+// The functions in this package do not print anything; errors are returned
+// wrapped around the sentinel errors below so callers can check them with
+// errors.Is.
+package pyrgear
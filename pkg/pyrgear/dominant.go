@@ -0,0 +1,144 @@
+package pyrgear
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// dominantSamples is the number of pixels per axis DominantColor samples at most
+const dominantSamples = 200
+
+// ColorNames are the names ColorName gives colors
+var ColorNames = []string{
+	"black", "white", "gray", "brown", "red", "orange", "yellow", "green", "cyan", "blue", "purple", "pink",
+}
+
+// DominantColor returns the most common color of img: the pixels are quantized to 16 levels
+// per channel and the average of the most populated bucket is returned, so a photo of a red
+// car on grey asphalt is grey rather than the muddy average of both. Large images are sampled
+// on a grid. Transparent pixels are left out; ok is false if all of them are.
+func DominantColor(img image.Image) (c color.RGBA, ok bool) {
+	bounds := img.Bounds()
+	stepX := max(1, bounds.Dx()/dominantSamples)
+	stepY := max(1, bounds.Dy()/dominantSamples)
+
+	type bucket struct {
+		n       int
+		r, g, b int
+	}
+	var buckets [4096]bucket
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			nrgba := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if nrgba.A < 0x80 {
+				continue
+			}
+			key := int(nrgba.R>>4)<<8 | int(nrgba.G>>4)<<4 | int(nrgba.B>>4)
+			b := &buckets[key]
+			b.n++
+			b.r += int(nrgba.R)
+			b.g += int(nrgba.G)
+			b.b += int(nrgba.B)
+		}
+	}
+
+	best := -1
+	for key := range buckets {
+		if buckets[key].n > 0 && (best < 0 || buckets[key].n > buckets[best].n) {
+			best = key
+		}
+	}
+	if best < 0 {
+		return color.RGBA{}, false
+	}
+	b := buckets[best]
+	return color.RGBA{R: uint8(b.r / b.n), G: uint8(b.g / b.n), B: uint8(b.b / b.n), A: 0xff}, true
+}
+
+// ColorName returns the name of the nearest of ColorNames to c, by its hue, saturation and
+// brightness: dark colors are black, unsaturated ones white or gray, dark reds and oranges
+// brown, and the others named after their hue.
+func ColorName(c color.RGBA) string {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	v := math.Max(r, math.Max(g, b))
+	delta := v - math.Min(r, math.Min(g, b))
+	s := 0.0
+	if v > 0 {
+		s = delta / v
+	}
+
+	switch {
+	case v < 0.2:
+		return "black"
+	case s < 0.15 && v > 0.85:
+		return "white"
+	case s < 0.15:
+		return "gray"
+	}
+
+	var h float64
+	switch v {
+	case r:
+		h = math.Mod((g-b)/delta, 6)
+	case g:
+		h = (b-r)/delta + 2
+	default:
+		h = (r-g)/delta + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+
+	switch {
+	case (h < 45 || h >= 345) && v < 0.6:
+		return "brown"
+	case h < 15 || h >= 345:
+		return "red"
+	case h < 45:
+		return "orange"
+	case h < 70:
+		return "yellow"
+	case h < 165:
+		return "green"
+	case h < 195:
+		return "cyan"
+	case h < 255:
+		return "blue"
+	case h < 290:
+		return "purple"
+	default:
+		return "pink"
+	}
+}
+
+// ColorHex returns c as six lowercase hex digits, e.g. ff8800
+func ColorHex(c color.RGBA) string {
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// HasColorTag reports whether name starts with a color tag followed by an underscore: one
+// of ColorNames or six lowercase hex digits, as given by ColorName and ColorHex
+func HasColorTag(name string) bool {
+	tag, _, found := strings.Cut(name, "_")
+	if !found {
+		return false
+	}
+	for _, colorName := range ColorNames {
+		if tag == colorName {
+			return true
+		}
+	}
+	if len(tag) != 6 {
+		return false
+	}
+	for _, r := range tag {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
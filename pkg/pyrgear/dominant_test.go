@@ -0,0 +1,63 @@
+package pyrgear
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDominantColor(t *testing.T) {
+	// Two thirds red, a third blue: red wins, not the purple average of both
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 30; x++ {
+			c := color.NRGBA{R: 220, G: 20, B: 30, A: 255}
+			if x >= 20 {
+				c = color.NRGBA{R: 10, G: 40, B: 200, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	c, ok := DominantColor(img)
+	assert.True(t, ok)
+	assert.Equal(t, color.RGBA{R: 220, G: 20, B: 30, A: 255}, c)
+	assert.Equal(t, "red", ColorName(c))
+	assert.Equal(t, "dc141e", ColorHex(c))
+
+	// Transparent pixels do not count
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 20; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 220, G: 20, B: 30})
+		}
+	}
+	c, _ = DominantColor(img)
+	assert.Equal(t, "blue", ColorName(c))
+	_, ok = DominantColor(image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	assert.False(t, ok)
+}
+
+func TestColorName(t *testing.T) {
+	for name, c := range map[string]color.RGBA{
+		"black":  {R: 20, G: 25, B: 30},
+		"white":  {R: 245, G: 245, B: 240},
+		"gray":   {R: 128, G: 128, B: 130},
+		"brown":  {R: 120, G: 70, B: 30},
+		"orange": {R: 255, G: 140, B: 0},
+		"yellow": {R: 240, G: 220, B: 40},
+		"green":  {R: 40, G: 160, B: 60},
+		"cyan":   {R: 0, G: 200, B: 210},
+		"blue":   {R: 30, G: 80, B: 220},
+		"purple": {R: 130, G: 40, B: 200},
+		"pink":   {R: 250, G: 100, B: 180},
+	} {
+		assert.Equal(t, name, ColorName(c))
+	}
+
+	assert.True(t, HasColorTag("red_swatch.png"))
+	assert.True(t, HasColorTag("ff8800_swatch.png"))
+	assert.False(t, HasColorTag("FF8800_swatch.png"))
+	assert.False(t, HasColorTag("reddish_swatch.png"))
+	assert.False(t, HasColorTag("red.png"))
+}
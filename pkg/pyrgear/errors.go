@@ -0,0 +1,18 @@
+package pyrgear
+
+import "errors"
+
+var (
+	// ErrUnsupportedFormat is returned for files whose format cannot be read for EXIF data
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrNoEXIF is returned for images without readable EXIF data
+	ErrNoEXIF = errors.New("failed to decode EXIF data")
+	// ErrCollision is returned when a rename or copy target already exists
+	ErrCollision = errors.New("target already exists")
+	// ErrCopyMismatch is returned when a copy does not have the content of its source
+	ErrCopyMismatch = errors.New("copy does not match source")
+	// ErrNameTooLong is returned for names longer than MaxNameBytes
+	ErrNameTooLong = errors.New("name too long")
+	// ErrUnknownHashAlgo is returned for hash algorithms NewHasher does not support
+	ErrUnknownHashAlgo = errors.New("unknown hash algorithm")
+)
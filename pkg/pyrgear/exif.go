@@ -0,0 +1,345 @@
+package pyrgear
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Tag is a single decoded EXIF field
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Decimal is the value of a rational tag written as decimals, e.g. 0.004 for 1/250
+	Decimal string `json:"decimal,omitempty"`
+}
+
+// Record holds the decoded EXIF data of an image
+type Record struct {
+	// Tags are sorted by name
+	Tags   []Tag   `json:"tags"`
+	HasGPS bool    `json:"has_gps,omitempty"`
+	Lat    float64 `json:"lat,omitempty"`
+	Lon    float64 `json:"lon,omitempty"`
+}
+
+// Map returns the tags of the record keyed by name
+func (r *Record) Map() map[string]string {
+	m := make(map[string]string, len(r.Tags))
+	for _, tag := range r.Tags {
+		m[tag.Name] = tag.Value
+	}
+	return m
+}
+
+// Get returns the value of the named tag
+func (r *Record) Get(name string) (string, bool) {
+	for _, tag := range r.Tags {
+		if tag.Name == name {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// exifTimeLayout is the layout of EXIF date and time values
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// DateTime returns the time the image was taken, from DateTimeOriginal or, failing that,
+// DateTime. Times without a recorded offset are taken to be in the local time zone.
+func (r *Record) DateTime() (time.Time, bool) {
+	return r.DateTimeIn(time.Local)
+}
+
+// dateTimeTags are the date tags DateTimeIn reads, in order, with the tag of their UTC offset
+var dateTimeTags = []struct{ date, offset string }{
+	{"DateTimeOriginal", "OffsetTimeOriginal"},
+	{"DateTime", "OffsetTime"},
+}
+
+// DateTimeIn returns the time the image was taken like DateTime. EXIF times carry no time
+// zone: the UTC offset of OffsetTimeOriginal or OffsetTime (EXIF 2.31) is used when recorded,
+// otherwise the time is taken to be in loc. The returned time keeps the wall clock of the camera.
+func (r *Record) DateTimeIn(loc *time.Location) (time.Time, bool) {
+	for _, tags := range dateTimeTags {
+		val, ok := r.Get(tags.date)
+		if !ok {
+			continue
+		}
+		zone := loc
+		if offset, ok := r.Get(tags.offset); ok {
+			if fixed, err := ParseUTCOffset(strings.Trim(offset, " \x00")); err == nil {
+				zone = fixed
+			}
+		}
+		if t, err := time.ParseInLocation(exifTimeLayout, strings.Trim(val, " \x00"), zone); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseUTCOffset parses an offset such as "+09:00", "-0530" or "Z" into a fixed time zone
+func ParseUTCOffset(offset string) (*time.Location, error) {
+	if offset == "Z" {
+		return time.UTC, nil
+	}
+	for _, layout := range []string{"-07:00", "-0700", "-07"} {
+		if t, err := time.Parse(layout, offset); err == nil {
+			_, seconds := t.Zone()
+			return time.FixedZone(offset, seconds), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid UTC offset %q", offset)
+}
+
+// ParseTimeZone parses a time zone given as an IANA name ("Asia/Tokyo"), "UTC", "Local"
+// or a UTC offset ("+09:00")
+func ParseTimeZone(name string) (*time.Location, error) {
+	if loc, err := ParseUTCOffset(name); err == nil {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", name, err)
+	}
+	return loc, nil
+}
+
+// SubSecond returns the fraction of a second the image was taken at, from SubSecTimeOriginal
+// or, failing that, SubSecTime. The tags hold the leading digits of the fraction, "5" is 0.5s.
+func (r *Record) SubSecond() (time.Duration, bool) {
+	for _, name := range []string{"SubSecTimeOriginal", "SubSecTime"} {
+		val, ok := r.Get(name)
+		if !ok {
+			continue
+		}
+		digits := strings.Trim(val, " \x00")
+		if digits == "" || strings.Trim(digits, "0123456789") != "" {
+			continue
+		}
+		// Pad or cut to nanoseconds
+		digits = (digits + "000000000")[:9]
+		if ns, err := strconv.Atoi(digits); err == nil {
+			return time.Duration(ns), true
+		}
+	}
+	return 0, false
+}
+
+// IsEXIFImage reports whether path has the extension of a format EXIF data can be read from
+func IsEXIFImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".tiff" || ext == ".tif"
+}
+
+// IsEXIFContent reports whether the file at path starts with the signature of a JPEG or TIFF
+// image, whatever its extension. Camera dumps may hold such images without an extension.
+func IsEXIFContent(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}) ||
+		bytes.Equal(header, []byte("II*\x00")) || bytes.Equal(header, []byte("MM\x00*"))
+}
+
+// DecodeOptions select the optional parts of EXIF decoding, the zero value decodes the
+// standard tags only
+type DecodeOptions struct {
+	// MakerNotes adds the fields of Canon and Nikon maker notes, see decodeMakerNote
+	MakerNotes bool
+}
+
+// DecodeEXIFFile decodes the EXIF data of the image at path
+func DecodeEXIFFile(path string) (*Record, error) {
+	return DecodeOptions{}.DecodeFile(path)
+}
+
+// DecodeEXIFFileContent is DecodeEXIFFile for files whose extension is not that of a JPEG
+// or TIFF image but whose content is, see IsEXIFContent
+func DecodeEXIFFileContent(path string) (*Record, error) {
+	return DecodeOptions{}.DecodeFileContent(path)
+}
+
+// DecodeEXIF decodes the EXIF data of a JPEG or TIFF image read from r
+func DecodeEXIF(r io.Reader) (*Record, error) {
+	return DecodeOptions{}.Decode(r)
+}
+
+// DecodeFile is DecodeEXIFFile with the options of o
+func (o DecodeOptions) DecodeFile(path string) (*Record, error) {
+	if !IsEXIFImage(path) {
+		ext := strings.ToLower(filepath.Ext(path))
+		return nil, fmt.Errorf("%w: %s (supported: jpg, jpeg, tiff, tif)", ErrUnsupportedFormat, ext)
+	}
+	return o.decodePath(path)
+}
+
+// DecodeFileContent is DecodeEXIFFileContent with the options of o
+func (o DecodeOptions) DecodeFileContent(path string) (*Record, error) {
+	if !IsEXIFImage(path) && !IsEXIFContent(path) {
+		return nil, fmt.Errorf("%w: %s is not a JPEG or TIFF image", ErrUnsupportedFormat, path)
+	}
+	return o.decodePath(path)
+}
+
+// decodePath opens the image at path and decodes its EXIF data
+func (o DecodeOptions) decodePath(path string) (*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file: %v", err)
+	}
+	defer file.Close()
+
+	return o.Decode(file)
+}
+
+// Decode is DecodeEXIF with the options of o
+func (o DecodeOptions) Decode(r io.Reader) (*Record, error) {
+	exifData, err := exif.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoEXIF, err)
+	}
+
+	record := &Record{}
+	if err := exifData.Walk(tagWalker{record: record}); err != nil {
+		return nil, err
+	}
+	// Maker notes are brand specific and often malformed, failing to decode them leaves
+	// the standard tags as they are
+	if o.MakerNotes {
+		if tags, err := decodeMakerNote(exifData); err == nil {
+			record.Tags = append(record.Tags, tags...)
+		}
+	}
+	sort.Slice(
+		record.Tags, func(i, j int) bool {
+			return record.Tags[i].Name < record.Tags[j].Name
+		},
+	)
+
+	// GPS coordinates are optional
+	if lat, lon, err := exifData.LatLong(); err == nil {
+		record.HasGPS, record.Lat, record.Lon = true, lat, lon
+	}
+
+	return record, nil
+}
+
+// newerTagParser loads the newerTagNames of the Exif sub-IFD, which goexif leaves out
+type newerTagParser struct{}
+
+func (newerTagParser) Parse(x *exif.Exif) error {
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil || offset < 0 || offset >= int64(len(x.Raw)) {
+		return nil
+	}
+	// Value offsets are relative to the start of the TIFF data, so seek rather than slice
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	fields := make(map[uint16]exif.FieldName, len(newerTagNames))
+	for id, name := range newerTagNames {
+		fields[id] = exif.FieldName(name)
+	}
+	x.LoadTags(dir, fields, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(newerTagParser{})
+}
+
+// tagWalker implements the exif.Walker interface, collecting all fields into a record
+type tagWalker struct {
+	record *Record
+}
+
+func (w tagWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	val, err := tagValue(tag)
+	if err != nil {
+		val = fmt.Sprintf("(error: %v)", err)
+	}
+
+	w.record.Tags = append(w.record.Tags, Tag{Name: string(name), Value: val, Decimal: tagDecimal(tag)})
+	return nil
+}
+
+// tagValue returns the value of tag as a string. Numbers are written as they are stored:
+// integers as 800, rationals as 1/250 and floats in their shortest form. Tags with several
+// values list them separated by ", ", e.g. the 8, 8, 8 of BitsPerSample.
+func tagValue(tag *tiff.Tag) (string, error) {
+	var format func(i int) (string, error)
+	switch tag.Format() {
+	case tiff.IntVal:
+		format = func(i int) (string, error) {
+			n, err := tag.Int64(i)
+			return strconv.FormatInt(n, 10), err
+		}
+	case tiff.RatVal:
+		format = func(i int) (string, error) {
+			num, den, err := tag.Rat2(i)
+			return fmt.Sprintf("%d/%d", num, den), err
+		}
+	case tiff.FloatVal:
+		format = func(i int) (string, error) {
+			f, err := tag.Float(i)
+			return strconv.FormatFloat(f, 'g', -1, 64), err
+		}
+	default:
+		return tag.StringVal()
+	}
+
+	values := make([]string, tag.Count)
+	for i := range values {
+		value, err := format(i)
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// tagDecimal returns the value of a rational tag with each rational written as a decimal in
+// its shortest form, 1/250 as 0.004 and 28/10 as 2.8. It returns "" for tags of other
+// formats and for rationals with a zero denominator, which have no decimal value.
+func tagDecimal(tag *tiff.Tag) string {
+	if tag.Format() != tiff.RatVal {
+		return ""
+	}
+	values := make([]string, tag.Count)
+	for i := range values {
+		num, den, err := tag.Rat2(i)
+		if err != nil || den == 0 {
+			return ""
+		}
+		values[i] = strconv.FormatFloat(float64(num)/float64(den), 'g', -1, 64)
+	}
+	return strings.Join(values, ", ")
+}
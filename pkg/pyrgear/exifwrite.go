@@ -0,0 +1,196 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Tags set by UpdateEXIF
+const (
+	makeTag             = 0x010F
+	modelTag            = 0x0110
+	exifVersionTag      = 0x9000
+	dateTimeOriginalTag = 0x9003
+
+	gpsVersionIDTag    = 0x0000
+	gpsLatitudeRefTag  = 0x0001
+	gpsLatitudeTag     = 0x0002
+	gpsLongitudeRefTag = 0x0003
+	gpsLongitudeTag    = 0x0004
+)
+
+// exifDateLayout is the layout of EXIF date and time values
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// EXIFUpdate lists the tags UpdateEXIF sets, zero values leave a tag unchanged
+type EXIFUpdate struct {
+	// DateTimeOriginal is written with its wall clock time, without a UTC offset
+	DateTimeOriginal time.Time
+	Make             string
+	Model            string
+	// With GPS set, Lat and Lon are written as GPSLatitude and GPSLongitude with their refs
+	GPS      bool
+	Lat, Lon float64
+}
+
+// UpdateEXIF copies the JPEG read from r to w with the tags of update set, keeping all other
+// tags. Images without EXIF data get a new EXIF segment. The image data is copied unchanged.
+func UpdateEXIF(r io.Reader, w io.Writer, update EXIFUpdate) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	segments, image, err := splitJPEG(data)
+	if err != nil {
+		return err
+	}
+
+	// A new EXIF segment goes after the JFIF (APP0) segments, as the first segment otherwise
+	at, found := 0, false
+	for i, segment := range segments {
+		if _, ok := exifPayload(segment); ok {
+			at, found = i, true
+			break
+		}
+		if at == i && len(segment) > 1 && segment[1] == 0xE0 {
+			at = i + 1
+		}
+	}
+
+	var t *tiffData
+	if found {
+		tiff, _ := exifPayload(segments[at])
+		if t, err = parseTIFF(tiff); err != nil {
+			return err
+		}
+	} else {
+		t = &tiffData{order: binary.BigEndian}
+	}
+	update.apply(t)
+	t.linkIFDs()
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+1024))
+	out.Write(data[:2])
+	for i, segment := range segments {
+		if i == at {
+			if err := writeEXIFSegment(out, t.bytes()); err != nil {
+				return err
+			}
+			if found {
+				continue
+			}
+		}
+		out.Write(segment)
+	}
+	if at == len(segments) {
+		if err := writeEXIFSegment(out, t.bytes()); err != nil {
+			return err
+		}
+	}
+	out.Write(image)
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// UpdateEXIFFile updates the EXIF data of the JPEG at path in place, see UpdateEXIF. The
+// rewritten image is decoded again and only replaces path if it holds the values of update.
+func UpdateEXIFFile(path string, update EXIFUpdate) error {
+	return rewriteFile(
+		path, func(r io.Reader, w io.Writer) error {
+			return UpdateEXIF(r, w, update)
+		}, func(tmpPath string) error {
+			file, err := os.Open(tmpPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			record, err := DecodeEXIF(file)
+			if err != nil {
+				return fmt.Errorf("written EXIF data cannot be read back: %v", err)
+			}
+			return update.Check(record)
+		},
+	)
+}
+
+// Check returns an error if record does not hold the values of the update
+func (u EXIFUpdate) Check(record *Record) error {
+	date := ""
+	if !u.DateTimeOriginal.IsZero() {
+		date = u.DateTimeOriginal.Format(exifDateLayout)
+	}
+	for _, c := range []struct{ name, want string }{{"DateTimeOriginal", date}, {"Make", u.Make}, {"Model", u.Model}} {
+		if c.want == "" {
+			continue
+		}
+		if got, _ := record.Get(c.name); got != c.want {
+			return fmt.Errorf("%s reads back as %q instead of %q", c.name, got, c.want)
+		}
+	}
+
+	// Seconds are stored in 1/10000, far finer than this
+	const precision = 1e-6
+	if u.GPS &&
+		(!record.HasGPS || math.Abs(record.Lat-u.Lat) > precision || math.Abs(record.Lon-u.Lon) > precision) {
+		return fmt.Errorf("GPS reads back as %f, %f instead of %f, %f", record.Lat, record.Lon, u.Lat, u.Lon)
+	}
+	return nil
+}
+
+// apply sets the tags of the update in t
+func (u EXIFUpdate) apply(t *tiffData) {
+	ascii := func(id uint16, s string) tiffEntry {
+		value := append([]byte(s), 0)
+		return tiffEntry{id: id, typ: 2, count: uint32(len(value)), value: value}
+	}
+
+	if u.Make != "" {
+		t.ifd0 = t.ifd0.set(ascii(makeTag, u.Make))
+	}
+	if u.Model != "" {
+		t.ifd0 = t.ifd0.set(ascii(modelTag, u.Model))
+	}
+	if !u.DateTimeOriginal.IsZero() {
+		if len(t.exif) == 0 {
+			t.exif = t.exif.set(tiffEntry{id: exifVersionTag, typ: 7, count: 4, value: []byte("0232")})
+		}
+		t.exif = t.exif.set(ascii(dateTimeOriginalTag, u.DateTimeOriginal.Format(exifDateLayout)))
+	}
+	if u.GPS {
+		if len(t.gps) == 0 {
+			t.gps = t.gps.set(tiffEntry{id: gpsVersionIDTag, typ: 1, count: 4, value: []byte{2, 3, 0, 0}})
+		}
+		latRef, lonRef := "N", "E"
+		if u.Lat < 0 {
+			latRef = "S"
+		}
+		if u.Lon < 0 {
+			lonRef = "W"
+		}
+		t.gps = t.gps.set(ascii(gpsLatitudeRefTag, latRef))
+		t.gps = t.gps.set(tiffEntry{id: gpsLatitudeTag, typ: 5, count: 3, value: degreesRationals(u.Lat, t.order)})
+		t.gps = t.gps.set(ascii(gpsLongitudeRefTag, lonRef))
+		t.gps = t.gps.set(tiffEntry{id: gpsLongitudeTag, typ: 5, count: 3, value: degreesRationals(u.Lon, t.order)})
+	}
+}
+
+// degreesRationals encodes the absolute value of a coordinate as the three RATIONALs
+// degrees, minutes and seconds, the seconds in 1/10000
+func degreesRationals(coord float64, order binary.ByteOrder) []byte {
+	// Round once, so 59.99999 seconds carry over into the minutes
+	total := int64(math.Round(math.Abs(coord) * 3600 * 10000))
+	degrees, rest := total/(3600*10000), total%(3600*10000)
+	minutes, seconds := rest/(60*10000), rest%(60*10000)
+
+	value := make([]byte, 24)
+	for i, r := range [][2]uint32{{uint32(degrees), 1}, {uint32(minutes), 1}, {uint32(seconds), 10000}} {
+		order.PutUint32(value[8*i:], r[0])
+		order.PutUint32(value[8*i+4:], r[1])
+	}
+	return value
+}
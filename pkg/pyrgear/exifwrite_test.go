@@ -0,0 +1,124 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// updateToRecord updates src and decodes the EXIF data of the result
+func updateToRecord(t *testing.T, src []byte, update EXIFUpdate) ([]byte, *Record) {
+	t.Helper()
+
+	var out bytes.Buffer
+	assert.NoError(t, UpdateEXIF(bytes.NewReader(src), &out, update))
+
+	// The image itself is unchanged and still decodes
+	_, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+
+	record, err := DecodeEXIF(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	return out.Bytes(), record
+}
+
+func TestUpdateEXIFWithoutEXIF(t *testing.T) {
+	var plain bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 1, 1)), nil))
+
+	// A scan with a JFIF segment, the EXIF segment goes right after it
+	jfif := []byte{0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}
+	withJFIF := append(append([]byte{0xFF, 0xD8}, jfif...), plain.Bytes()[2:]...)
+
+	update := EXIFUpdate{
+		DateTimeOriginal: time.Date(1987, 7, 14, 16, 30, 0, 0, time.UTC),
+		Make:             "Kodak",
+		Model:            "Instamatic",
+		GPS:              true, Lat: -33.856784, Lon: 151.215297,
+	}
+	for _, src := range [][]byte{plain.Bytes(), withJFIF} {
+		out, record := updateToRecord(t, src, update)
+		assert.NoError(t, update.Check(record))
+		taken, ok := record.DateTime()
+		assert.True(t, ok)
+		assert.Equal(t, 1987, taken.Year())
+		assert.InDelta(t, -33.856784, record.Lat, 1e-7)
+		assert.InDelta(t, 151.215297, record.Lon, 1e-7)
+		_, ok = record.Get("ExifVersion")
+		assert.True(t, ok)
+
+		if len(src) == len(withJFIF) {
+			assert.Equal(t, jfif, out[2:2+len(jfif)])
+			assert.Equal(t, []byte{0xFF, 0xE1}, out[2+len(jfif):4+len(jfif)])
+		}
+	}
+}
+
+func TestUpdateEXIFKeepsOtherTags(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		update := EXIFUpdate{DateTimeOriginal: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), Make: "Nikon"}
+		_, record := updateToRecord(t, buildTestJPEG(t, order), update)
+		assert.NoError(t, update.Check(record))
+
+		for name, want := range map[string]string{
+			"Model": "EOS R5", "InteroperabilityIndex": "R98", "GPSLatitudeRef": "N",
+		} {
+			got, _ := record.Get(name)
+			assert.Equal(t, want, got, name)
+		}
+		assert.True(t, record.HasGPS)
+		for _, name := range []string{"Orientation", "ThumbJPEGInterchangeFormat"} {
+			_, ok := record.Get(name)
+			assert.True(t, ok, name)
+		}
+
+		// Check notices values that differ
+		update.Model = "D850"
+		assert.ErrorContains(t, update.Check(record), "Model")
+	}
+}
+
+func TestUpdateEXIFFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_update_exif_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	path := filepath.Join(tempDir, "scan.jpg")
+	var plain bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 1, 1)), nil))
+	assert.NoError(t, os.WriteFile(path, plain.Bytes(), 0600))
+
+	update := EXIFUpdate{DateTimeOriginal: time.Date(1975, 12, 24, 18, 0, 0, 0, time.UTC)}
+	assert.NoError(t, UpdateEXIFFile(path, update))
+	record, err := DecodeEXIFFile(path)
+	assert.NoError(t, err)
+	value, _ := record.Get("DateTimeOriginal")
+	assert.Equal(t, "1975:12:24 18:00:00", value)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Files that are not JPEGs are left alone
+	other := filepath.Join(tempDir, "scan.png")
+	assert.NoError(t, os.WriteFile(other, []byte("png"), 0644))
+	assert.ErrorIs(t, UpdateEXIFFile(other, update), ErrUnsupportedFormat)
+	data, err := os.ReadFile(other)
+	assert.NoError(t, err)
+	assert.Equal(t, "png", string(data))
+}
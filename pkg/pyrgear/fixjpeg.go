@@ -0,0 +1,242 @@
+package pyrgear
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"strings"
+)
+
+// orientationTag is the EXIF tag holding how the image must be turned to be displayed upright
+const orientationTag = 0x0112
+
+// FixQuality is the JPEG quality FixJPEG re-encodes rotated images with
+const FixQuality = 95
+
+// FixResult reports what FixJPEG did to an image
+type FixResult struct {
+	// Orientation is the EXIF orientation the image had, 0 without one
+	Orientation int
+	// Rotated is set when the pixels were turned upright, which re-encodes the image
+	Rotated bool
+	// StrippedGPS is set when GPS tags were removed
+	StrippedGPS bool
+}
+
+// Changed reports whether the image was rewritten
+func (r FixResult) Changed() bool {
+	return r.Rotated || r.StrippedGPS
+}
+
+// String describes what was done, e.g. "rotated 90° clockwise, stripped GPS"
+func (r FixResult) String() string {
+	var done []string
+	if r.Rotated {
+		done = append(done, orientationNames[r.Orientation])
+	}
+	if r.StrippedGPS {
+		done = append(done, "stripped GPS")
+	}
+	if len(done) == 0 {
+		return "nothing to fix"
+	}
+	return strings.Join(done, ", ")
+}
+
+// orientationNames describe the transform that turns an image of each EXIF orientation upright
+var orientationNames = map[int]string{
+	2: "flipped horizontally",
+	3: "rotated 180°",
+	4: "flipped vertically",
+	5: "transposed",
+	6: "rotated 90° clockwise",
+	7: "transversed",
+	8: "rotated 90° counterclockwise",
+}
+
+// FixJPEG copies the JPEG read from r to w turned upright as its EXIF orientation says and
+// without GPS tags, in a single pass. The orientation is then reset to 1. Go has no lossless
+// transform of the compressed data, so rotated images are decoded and re-encoded with
+// FixQuality; images that are already upright keep their image data unchanged. Metadata
+// segments such as ICC profiles are kept, the EXIF thumbnail of a rotated image is dropped as
+// it would no longer match.
+func FixJPEG(r io.Reader, w io.Writer) (FixResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return FixResult{}, err
+	}
+	out, result, err := fixJPEG(data)
+	if err != nil {
+		return result, err
+	}
+	_, err = w.Write(out)
+	return result, err
+}
+
+// FixJPEGFile fixes the JPEG at path in place, see FixJPEG. Images with nothing to fix are
+// not rewritten.
+func FixJPEGFile(path string) (FixResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FixResult{}, err
+	}
+	out, result, err := fixJPEG(data)
+	if err != nil || !result.Changed() {
+		return result, err
+	}
+	return result, rewriteFile(
+		path, func(_ io.Reader, w io.Writer) error {
+			_, err := w.Write(out)
+			return err
+		}, nil,
+	)
+}
+
+// fixJPEG returns data turned upright and without GPS tags, see FixJPEG
+func fixJPEG(data []byte) ([]byte, FixResult, error) {
+	var result FixResult
+	segments, scan, err := splitJPEG(data)
+	if err != nil {
+		return nil, result, err
+	}
+
+	// Find the orientation and GPS tags of the EXIF segment
+	exifIndex := -1
+	var t *tiffData
+	for i, segment := range segments {
+		if tiff, ok := exifPayload(segment); ok {
+			if t, err = parseTIFF(tiff); err != nil {
+				return nil, result, err
+			}
+			exifIndex = i
+			break
+		}
+	}
+	if t == nil {
+		return data, result, nil
+	}
+	for _, entry := range t.ifd0 {
+		if entry.id == orientationTag && entry.typ == 3 && entry.count == 1 {
+			result.Orientation = int(t.order.Uint16(entry.value))
+		}
+	}
+	result.Rotated = orientationNames[result.Orientation] != ""
+	result.StrippedGPS = len(t.gps) > 0
+	if !result.Changed() {
+		return data, result, nil
+	}
+
+	// Rewrite the EXIF data
+	t.gps = nil
+	if result.Rotated {
+		value := make([]byte, 2)
+		t.order.PutUint16(value, 1)
+		t.ifd0 = t.ifd0.set(tiffEntry{id: orientationTag, typ: 3, count: 1, value: value})
+		t.ifd1, t.thumbnail = nil, nil
+	}
+	t.linkIFDs()
+	var exifSegment bytes.Buffer
+	if err := writeEXIFSegment(&exifSegment, t.bytes()); err != nil {
+		return nil, result, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	if !result.Rotated {
+		for i, segment := range segments {
+			if i == exifIndex {
+				segment = exifSegment.Bytes()
+			}
+			out.Write(segment)
+		}
+		out.Write(scan)
+		return out.Bytes(), result, nil
+	}
+
+	// Re-encode the upright pixels, keeping the metadata segments of the original
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, result, err
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, orient(img, result.Orientation), &jpeg.Options{Quality: FixQuality}); err != nil {
+		return nil, result, err
+	}
+	encodedSegments, encodedScan, err := splitJPEG(encoded.Bytes())
+	if err != nil {
+		return nil, result, fmt.Errorf("failed to re-encode image: %v", err)
+	}
+	for i, segment := range segments {
+		switch {
+		case i == exifIndex:
+			out.Write(exifSegment.Bytes())
+		case isMetadataSegment(segment):
+			out.Write(segment)
+		}
+	}
+	for _, segment := range encodedSegments {
+		if !isMetadataSegment(segment) {
+			out.Write(segment)
+		}
+	}
+	out.Write(encodedScan)
+	return out.Bytes(), result, nil
+}
+
+// isMetadataSegment reports whether a JPEG segment is an application segment or a comment,
+// rather than a table or frame header of the image data
+func isMetadataSegment(segment []byte) bool {
+	return len(segment) >= 2 && (segment[1] >= 0xE0 && segment[1] <= 0xEF || segment[1] == 0xFE)
+}
+
+// orient returns img turned upright as the EXIF orientation says
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	width, height := w, h
+	if orientation >= 5 {
+		width, height = h, w
+	}
+
+	// source returns the point of img shown at x, y of the upright image
+	source := func(x, y int) (int, int) {
+		switch orientation {
+		case 2:
+			return w - 1 - x, y
+		case 3:
+			return w - 1 - x, h - 1 - y
+		case 4:
+			return x, h - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return y, h - 1 - x
+		case 7:
+			return w - 1 - y, h - 1 - x
+		case 8:
+			return w - 1 - y, x
+		}
+		return x, y
+	}
+
+	var set func(x, y int, c color.Color)
+	var upright image.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst := image.NewGray(image.Rect(0, 0, width, height))
+		set, upright = dst.Set, dst
+	} else {
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		set, upright = dst.Set, dst
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := source(x, y)
+			set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return upright
+}
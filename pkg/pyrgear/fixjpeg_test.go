@@ -0,0 +1,132 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrient(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(src.Pix, []uint8{1, 2, 3, 4, 5, 6})
+
+	for orientation, want := range map[int][]uint8{
+		1: {1, 2, 3, 4, 5, 6},
+		2: {3, 2, 1, 6, 5, 4},
+		3: {6, 5, 4, 3, 2, 1},
+		4: {4, 5, 6, 1, 2, 3},
+		5: {1, 4, 2, 5, 3, 6},
+		6: {4, 1, 5, 2, 6, 3},
+		7: {6, 3, 5, 2, 4, 1},
+		8: {3, 6, 2, 5, 1, 4},
+	} {
+		upright := orient(src, orientation).(*image.Gray)
+		assert.Equal(t, want, upright.Pix, "orientation %d", orientation)
+		if orientation >= 5 {
+			assert.Equal(t, image.Rect(0, 0, 2, 3), upright.Bounds())
+		}
+	}
+}
+
+func TestFixJPEG(t *testing.T) {
+	// The EXIF segment of buildTestJPEG (orientation 6, with GPS) on a 16x8 image whose
+	// left half is black
+	segments, _, err := splitJPEG(buildTestJPEG(t, binary.BigEndian))
+	assert.NoError(t, err)
+	stored := image.NewGray(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 8; x < 16; x++ {
+			stored.Pix[stored.PixOffset(x, y)] = 255
+		}
+	}
+	var img bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&img, stored, &jpeg.Options{Quality: 100}))
+	src := append(append([]byte{0xFF, 0xD8}, segments[0]...), img.Bytes()[2:]...)
+
+	var out bytes.Buffer
+	result, err := FixJPEG(bytes.NewReader(src), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, FixResult{Orientation: 6, Rotated: true, StrippedGPS: true}, result)
+	assert.Equal(t, "rotated 90° clockwise, stripped GPS", result.String())
+
+	// Turned clockwise, the black half is on top
+	upright, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 16), upright.Bounds())
+	top, _, _, _ := upright.At(4, 2).RGBA()
+	bottom, _, _, _ := upright.At(4, 13).RGBA()
+	assert.Less(t, top>>8, uint32(32))
+	assert.Greater(t, bottom>>8, uint32(224))
+
+	record, err := DecodeEXIF(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.False(t, record.HasGPS)
+	fixed, _, err := splitJPEG(out.Bytes())
+	assert.NoError(t, err)
+	tiff, ok := exifPayload(fixed[0])
+	assert.True(t, ok)
+	parsed, err := parseTIFF(tiff)
+	assert.NoError(t, err)
+	for _, entry := range parsed.ifd0 {
+		if entry.id == orientationTag {
+			assert.Equal(t, uint16(1), parsed.order.Uint16(entry.value))
+		}
+	}
+	assert.Empty(t, parsed.ifd1)
+	camera, _ := record.Get("Make")
+	assert.Equal(t, "Canon", camera)
+
+	// Fixing again changes nothing
+	again, err := FixJPEG(bytes.NewReader(out.Bytes()), &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.False(t, again.Changed())
+	assert.Equal(t, "nothing to fix", again.String())
+}
+
+func TestFixJPEGFileKeepsUprightImageData(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_fix_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	// Upright, so only the GPS tags go and the image data is copied as is
+	update := EXIFUpdate{Make: "Kodak", GPS: true, Lat: 52.5, Lon: 13.4}
+	var plain, src bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 4, 4)), nil))
+	assert.NoError(t, UpdateEXIF(bytes.NewReader(plain.Bytes()), &src, update))
+	path := filepath.Join(tempDir, "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, src.Bytes(), 0644))
+
+	result, err := FixJPEGFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FixResult{StrippedGPS: true}, result)
+
+	fixed, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	_, scan, err := splitJPEG(plain.Bytes())
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(fixed, scan))
+	record, err := DecodeEXIF(bytes.NewReader(fixed))
+	assert.NoError(t, err)
+	assert.False(t, record.HasGPS)
+
+	// Images with nothing to fix are left alone
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	result, err = FixJPEGFile(path)
+	assert.NoError(t, err)
+	assert.False(t, result.Changed())
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+}
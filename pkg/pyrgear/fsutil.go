@@ -0,0 +1,144 @@
+package pyrgear
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyFile copies the content of src to dst, replacing dst if it exists
+func CopyFile(src, dst string) error {
+	return CopyFileBuffer(src, dst, 0)
+}
+
+// CopyFileBuffer copies src to dst like CopyFile, through a buffer of bufSize bytes.
+// A bufSize of 0 or less lets io.Copy choose, which may use an in-kernel copy instead of a buffer.
+func CopyFileBuffer(src, dst string, bufSize int) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	if bufSize > 0 {
+		// Hide ReadFrom and WriteTo of the files so the buffer is actually used
+		_, err = io.CopyBuffer(struct{ io.Writer }{destFile}, struct{ io.Reader }{sourceFile}, make([]byte, bufSize))
+	} else {
+		_, err = io.Copy(destFile, sourceFile)
+	}
+	if err != nil {
+		return err
+	}
+	return destFile.Close()
+}
+
+// HashFile returns the hex encoded SHA-256 of the file's content
+func HashFile(path string) (string, error) {
+	return HashFileAlgo(path, HashSHA256)
+}
+
+// VerifyCopy returns ErrCopyMismatch if dst does not have the same content as src
+func VerifyCopy(src, dst string) error {
+	return VerifyCopyAlgo(src, dst, HashSHA256)
+}
+
+// CheckCollision returns ErrCollision if newPath exists and is not oldPath itself
+func CheckCollision(oldPath, newPath string) error {
+	targetInfo, err := os.Lstat(newPath)
+	if err != nil {
+		return nil
+	}
+	// Renaming onto the same file (e.g. a case-only rename on a case-insensitive filesystem) is fine
+	if sourceInfo, err := os.Lstat(oldPath); err == nil && os.SameFile(sourceInfo, targetInfo) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCollision, newPath)
+}
+
+// IsWithinBase reports whether target is located inside the base directory
+func IsWithinBase(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Rename renames oldPath to newPath, refusing to overwrite another file. A case-only
+// rename on a case-insensitive filesystem (File.JPG to file.jpg on macOS or Windows),
+// where a direct rename may be a no-op, goes through a temporary name.
+func Rename(oldPath, newPath string) error {
+	if err := CheckCollision(oldPath, newPath); err != nil {
+		return err
+	}
+	if IsCaseOnlyRename(oldPath, newPath) && IsSameFile(oldPath, newPath) {
+		return renameViaTemp(oldPath, newPath)
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// ReplaceFile renames oldPath to newPath, replacing newPath if it is an existing file.
+// Directories are never replaced.
+func ReplaceFile(oldPath, newPath string) error {
+	if info, err := os.Lstat(newPath); err == nil && info.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrCollision, newPath)
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// LinkFile replaces the file at path with a hard link to target. The link is made under a
+// temporary name next to path first, so path is never missing if linking fails, e.g. because
+// target is on another filesystem.
+func LinkFile(target, path string) error {
+	if IsSameFile(target, path) {
+		return nil
+	}
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".pyrgear-link-%d-%s", os.Getpid(), filepath.Base(path)))
+	if err := os.Link(target, tmpPath); err != nil {
+		return err
+	}
+	if err := ReplaceFile(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// IsCaseOnlyRename reports whether oldPath and newPath are in the same directory
+// and their names differ only in case
+func IsCaseOnlyRename(oldPath, newPath string) bool {
+	oldName, newName := filepath.Base(oldPath), filepath.Base(newPath)
+	return filepath.Dir(oldPath) == filepath.Dir(newPath) && oldName != newName && strings.EqualFold(oldName, newName)
+}
+
+// IsSameFile reports whether both paths exist and refer to the same file, e.g. hard links
+func IsSameFile(a, b string) bool {
+	aInfo, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Lstat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// renameViaTemp renames oldPath to a temporary name next to it and then to newPath
+func renameViaTemp(oldPath, newPath string) error {
+	tmpPath := filepath.Join(filepath.Dir(oldPath), fmt.Sprintf(".pyrgear-case-%d-%s", os.Getpid(), filepath.Base(newPath)))
+	if _, err := os.Lstat(tmpPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrCollision, tmpPath)
+	}
+	if err := os.Rename(oldPath, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		// Put the file back under its original name
+		os.Rename(tmpPath, oldPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package pyrgear
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyAndRename(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_fsutil_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	src := filepath.Join(tempDir, "a.txt")
+	dst := filepath.Join(tempDir, "b.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("content"), 0644))
+
+	assert.NoError(t, CopyFile(src, dst))
+	data, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	assert.NoError(t, VerifyCopy(src, dst))
+	assert.NoError(t, os.WriteFile(dst, []byte("contenT"), 0644))
+	assert.ErrorIs(t, VerifyCopy(src, dst), ErrCopyMismatch)
+
+	// Renaming onto an existing file is refused
+	assert.ErrorIs(t, Rename(src, dst), ErrCollision)
+	assert.FileExists(t, src)
+
+	moved := filepath.Join(tempDir, "c.txt")
+	assert.NoError(t, Rename(src, moved))
+	assert.NoFileExists(t, src)
+	assert.FileExists(t, moved)
+
+	assert.True(t, IsWithinBase(tempDir, filepath.Join(tempDir, "sub", "a.txt")))
+	assert.False(t, IsWithinBase(tempDir, filepath.Join(tempDir, "..", "a.txt")))
+	assert.False(t, IsWithinBase(tempDir, tempDir))
+}
+
+func TestDecodeEXIFFileErrors(t *testing.T) {
+	tempDir := t.TempDir()
+
+	txt := filepath.Join(tempDir, "notes.txt")
+	assert.NoError(t, os.WriteFile(txt, []byte("text"), 0644))
+	_, err := DecodeEXIFFile(txt)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	jpg := filepath.Join(tempDir, "broken.jpg")
+	assert.NoError(t, os.WriteFile(jpg, []byte("fake image data"), 0644))
+	_, err = DecodeEXIFFile(jpg)
+	assert.ErrorIs(t, err, ErrNoEXIF)
+}
+
+func TestCaseOnlyRename(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_case_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	upper := filepath.Join(tempDir, "File.JPG")
+	lower := filepath.Join(tempDir, "file.jpg")
+	assert.True(t, IsCaseOnlyRename(upper, lower))
+	assert.False(t, IsCaseOnlyRename(upper, upper))
+	assert.False(t, IsCaseOnlyRename(upper, filepath.Join(tempDir, "sub", "file.jpg")))
+	assert.False(t, IsCaseOnlyRename(upper, filepath.Join(tempDir, "other.jpg")))
+
+	// The intermediate step used on case-insensitive filesystems
+	assert.NoError(t, os.WriteFile(upper, []byte("photo"), 0644))
+	assert.NoError(t, renameViaTemp(upper, lower))
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "file.jpg", entries[0].Name())
+
+	assert.NoError(t, Rename(lower, upper))
+	data, err := os.ReadFile(upper)
+	assert.NoError(t, err)
+	assert.Equal(t, "photo", string(data))
+}
+
+func TestCopyFileBuffer(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_copy_buffer_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	src := filepath.Join(tempDir, "src.bin")
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	assert.NoError(t, os.WriteFile(src, content, 0644))
+
+	// Buffers smaller and larger than the file, and the default copy
+	for _, size := range []int{7, 4096, 1 << 20, 0} {
+		dst := filepath.Join(tempDir, fmt.Sprintf("dst-%d.bin", size))
+		assert.NoError(t, CopyFileBuffer(src, dst, size))
+		assert.NoError(t, VerifyCopy(src, dst))
+	}
+}
+
+func TestLinkFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_link_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	target := filepath.Join(tempDir, "a.jpg")
+	path := filepath.Join(tempDir, "b.jpg")
+	assert.NoError(t, os.WriteFile(target, []byte("same"), 0644))
+	assert.NoError(t, os.WriteFile(path, []byte("same"), 0644))
+	assert.False(t, IsSameFile(target, path))
+
+	assert.NoError(t, LinkFile(target, path))
+	assert.True(t, IsSameFile(target, path))
+	// Linking again is a no-op, no temporary file is left
+	assert.NoError(t, LinkFile(target, path))
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Error(t, LinkFile(filepath.Join(tempDir, "missing.jpg"), path))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "same", string(content))
+}
+
+// BenchmarkCopyFileBuffer compares buffer sizes for --copy-buffer-size on a 64MB file.
+// Point TMPDIR at a network share and run it with -benchtime 5x to pick a value for that share.
+func BenchmarkCopyFileBuffer(b *testing.B) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_copy_buffer_bench")
+	if err != nil {
+		b.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src.bin")
+	const fileSize = 64 << 20
+	if err := os.WriteFile(src, make([]byte, fileSize), 0644); err != nil {
+		b.Fatalf("Failed to create source file: %v", err)
+	}
+	dst := filepath.Join(tempDir, "dst.bin")
+
+	for _, size := range []int{0, 32 << 10, 256 << 10, 1 << 20, 4 << 20} {
+		name := "default"
+		if size > 0 {
+			name = fmt.Sprintf("%dKB", size>>10)
+		}
+		b.Run(
+			name, func(b *testing.B) {
+				b.SetBytes(fileSize)
+				for i := 0; i < b.N; i++ {
+					if err := CopyFileBuffer(src, dst, size); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestHashFileAlgo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_hash_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	path := filepath.Join(tempDir, "abc.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("abc"), 0644))
+	for algo, want := range map[string]string{
+		HashMD5:    "900150983cd24fb0d6963f7d28e17f72",
+		HashSHA1:   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		HashSHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		HashBLAKE3: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+		"SHA256":   "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	} {
+		sum, err := HashFileAlgo(path, algo)
+		assert.NoError(t, err, algo)
+		assert.Equal(t, want, sum, algo)
+	}
+
+	sum, err := HashFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", sum)
+
+	_, err = HashFileAlgo(path, "crc32")
+	assert.ErrorIs(t, err, ErrUnknownHashAlgo)
+
+	copied := filepath.Join(tempDir, "copy.txt")
+	assert.NoError(t, os.WriteFile(copied, []byte("abd"), 0644))
+	assert.ErrorIs(t, VerifyCopyAlgo(path, copied, HashBLAKE3), ErrCopyMismatch)
+}
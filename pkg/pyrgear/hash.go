@@ -0,0 +1,81 @@
+package pyrgear
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Hash algorithms for HashFileAlgo and VerifyCopyAlgo
+const (
+	// HashMD5 is fast but has known collisions, fine to find duplicates
+	HashMD5 = "md5"
+	// HashSHA1 is fast but has known collisions, fine to find duplicates
+	HashSHA1 = "sha1"
+	// HashSHA256 is the default, collision resistant
+	HashSHA256 = "sha256"
+	// HashBLAKE3 is collision resistant and the fastest on large files
+	HashBLAKE3 = "blake3"
+)
+
+// HashAlgos are the supported hash algorithms
+var HashAlgos = []string{HashMD5, HashSHA1, HashSHA256, HashBLAKE3}
+
+// NewHasher returns a new hash of the algorithm named algo, case-insensitively
+func NewHasher(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashBLAKE3:
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("%w %q (use %s)", ErrUnknownHashAlgo, algo, strings.Join(HashAlgos, ", "))
+}
+
+// HashFileAlgo returns the hex encoded hash of the file's content with the algorithm algo
+func HashFileAlgo(path string, algo string) (string, error) {
+	h, err := NewHasher(algo)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyCopyAlgo returns ErrCopyMismatch if dst does not have the same content as src,
+// comparing hashes of the algorithm algo
+func VerifyCopyAlgo(src, dst string, algo string) error {
+	srcHash, err := HashFileAlgo(src, algo)
+	if err != nil {
+		return err
+	}
+	dstHash, err := HashFileAlgo(dst, algo)
+	if err != nil {
+		return err
+	}
+	if srcHash != dstHash {
+		return fmt.Errorf("%w: %s differs from %s", ErrCopyMismatch, dst, src)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package pyrgear
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// canonMakerNoteFields are the names of the Canon maker note tags decoded, after ExifTool
+var canonMakerNoteFields = map[uint16]string{
+	0x0006: "ImageType",
+	0x0007: "FirmwareVersion",
+	0x0008: "FileNumber",
+	0x0009: "OwnerName",
+	0x000c: "SerialNumber",
+	0x0010: "ModelID",
+	0x0095: "LensModel",
+	0x0096: "InternalSerialNumber",
+}
+
+// Canon keeps the lens ID in its CameraSettings array rather than in a tag of its own
+const (
+	canonCameraSettingsTag = 0x0001
+	canonLensTypeIndex     = 22
+)
+
+// nikonMakerNoteFields are the names of the Nikon maker note tags decoded, after ExifTool
+var nikonMakerNoteFields = map[uint16]string{
+	0x0004: "Quality",
+	0x0005: "WhiteBalance",
+	0x0007: "FocusMode",
+	0x001d: "SerialNumber",
+	0x0083: "LensType",
+	0x0084: "Lens",
+	0x00a5: "ImageCount",
+	0x00a6: "DeletedImageCount",
+	0x00a7: "ShutterCount",
+}
+
+// nikonMakerNoteHeader starts the type 3 maker notes of Nikon cameras, followed by a version
+// and a TIFF header 10 bytes in. Offsets of the note are relative to that header.
+var nikonMakerNoteHeader = []byte("Nikon\x00")
+
+// decodeMakerNote returns the fields of the Canon or Nikon maker note of x, named after their
+// brand: Canon.LensModel, Nikon.ShutterCount, ... Canon notes are a bare IFD whose offsets are
+// relative to the TIFF data of the image, Nikon type 3 notes hold a TIFF structure of their own.
+// Notes of other brands and older Nikon formats have no fields. Maker notes are undocumented
+// by the vendors, so a malformed note returns an error instead of the fields.
+func decodeMakerNote(x *exif.Exif) (tags []Tag, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			tags, err = nil, fmt.Errorf("malformed maker note: %v", r)
+		}
+	}()
+
+	note, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return nil, nil
+	}
+
+	if bytes.HasPrefix(note.Val, nikonMakerNoteHeader) {
+		if len(note.Val) < 10 {
+			return nil, fmt.Errorf("malformed maker note: Nikon header without data")
+		}
+		t, err := tiff.Decode(bytes.NewReader(note.Val[10:]))
+		if err != nil {
+			return nil, fmt.Errorf("malformed maker note: %v", err)
+		}
+		if len(t.Dirs) == 0 {
+			return nil, nil
+		}
+		return makerNoteTags("Nikon", t.Dirs[0], nikonMakerNoteFields), nil
+	}
+
+	if !isCanon(x) {
+		return nil, nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(int64(note.ValOffset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("malformed maker note: %v", err)
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil, fmt.Errorf("malformed maker note: %v", err)
+	}
+	tags = makerNoteTags("Canon", dir, canonMakerNoteFields)
+	for _, tag := range dir.Tags {
+		if tag.Id != canonCameraSettingsTag || tag.Format() != tiff.IntVal || int(tag.Count) <= canonLensTypeIndex {
+			continue
+		}
+		if lens, err := tag.Int64(canonLensTypeIndex); err == nil {
+			tags = append(tags, Tag{Name: "Canon.LensType", Value: strconv.FormatInt(lens, 10)})
+		}
+	}
+	return tags, nil
+}
+
+// makerNoteTags returns the tags of dir listed in fields, named <brand>.<field>
+func makerNoteTags(brand string, dir *tiff.Dir, fields map[uint16]string) []Tag {
+	var tags []Tag
+	for _, tag := range dir.Tags {
+		name, ok := fields[tag.Id]
+		if !ok {
+			continue
+		}
+		val, err := tagValue(tag)
+		if err != nil {
+			continue
+		}
+		tags = append(tags, Tag{Name: brand + "." + name, Value: val, Decimal: tagDecimal(tag)})
+	}
+	return tags
+}
+
+// isCanon reports whether x was written by a Canon camera
+func isCanon(x *exif.Exif) bool {
+	tag, err := x.Get(exif.Make)
+	if err != nil {
+		return false
+	}
+	val, err := tag.StringVal()
+	return err == nil && strings.Trim(val, " \x00") == "Canon"
+}
@@ -0,0 +1,143 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ifdEntry is a tag written by encodeIFD, data holds its values in little-endian order
+type ifdEntry struct {
+	id, typ uint16
+	count   uint32
+	data    []byte
+}
+
+// encodeIFD encodes entries as a little-endian IFD at offset base, followed by the values that
+// do not fit into an entry. Offsets are relative to where base is measured from.
+func encodeIFD(base uint32, entries []ifdEntry) []byte {
+	order := binary.LittleEndian
+	var ifd, data bytes.Buffer
+	dataOffset := base + uint32(2+12*len(entries)+4)
+	binary.Write(&ifd, order, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&ifd, order, entry.id)
+		binary.Write(&ifd, order, entry.typ)
+		binary.Write(&ifd, order, entry.count)
+		if len(entry.data) <= 4 {
+			ifd.Write(append(entry.data, make([]byte, 4-len(entry.data))...))
+			continue
+		}
+		binary.Write(&ifd, order, dataOffset+uint32(data.Len()))
+		data.Write(entry.data)
+	}
+	binary.Write(&ifd, order, uint32(0))
+	return append(ifd.Bytes(), data.Bytes()...)
+}
+
+// le returns values encoded in little-endian order
+func le(values ...any) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// makerNoteTIFF returns a TIFF image with the Make camera and the maker note returned by note,
+// which is given the offset the note is stored at
+func makerNoteTIFF(camera string, note func(offset uint32) []byte) []byte {
+	makeData := append([]byte(camera), 0)
+	// IFD0 holds Make and the Exif pointer, the Exif IFD only the maker note
+	exifOffset := uint32(8 + 2 + 2*12 + 4 + len(makeData))
+	noteOffset := exifOffset + 2 + 12 + 4
+	mn := note(noteOffset)
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(
+		tiff, encodeIFD(
+			8, []ifdEntry{
+				{id: 0x010f, typ: 2, count: uint32(len(makeData)), data: makeData},
+				{id: 0x8769, typ: 4, count: 1, data: le(exifOffset)},
+			},
+		)...,
+	)
+	return append(tiff, encodeIFD(exifOffset, []ifdEntry{{id: 0x927c, typ: 7, count: uint32(len(mn)), data: mn}})...)
+}
+
+func TestDecodeMakerNoteCanon(t *testing.T) {
+	lens := append([]byte("EF24-105mm f/4L IS USM"), 0)
+	settings := make([]uint16, 23)
+	settings[22] = 237
+	image := makerNoteTIFF(
+		"Canon", func(offset uint32) []byte {
+			// Canon notes are a bare IFD with offsets relative to the TIFF header
+			return encodeIFD(
+				offset, []ifdEntry{
+					{id: 0x0001, typ: 3, count: 23, data: le(settings)},
+					{id: 0x000c, typ: 4, count: 1, data: le(uint32(123456789))},
+					{id: 0x0095, typ: 2, count: uint32(len(lens)), data: lens},
+				},
+			)
+		},
+	)
+
+	// Maker notes are only decoded on request
+	record, err := DecodeEXIF(bytes.NewReader(image))
+	assert.NoError(t, err)
+	_, ok := record.Get("Canon.LensModel")
+	assert.False(t, ok)
+
+	record, err = DecodeOptions{MakerNotes: true}.Decode(bytes.NewReader(image))
+	assert.NoError(t, err)
+	tags := record.Map()
+	assert.Equal(t, "EF24-105mm f/4L IS USM", tags["Canon.LensModel"])
+	assert.Equal(t, "123456789", tags["Canon.SerialNumber"])
+	assert.Equal(t, "237", tags["Canon.LensType"])
+	assert.Equal(t, "Canon", tags["Make"])
+}
+
+func TestDecodeMakerNoteNikon(t *testing.T) {
+	image := makerNoteTIFF(
+		"NIKON CORPORATION", func(offset uint32) []byte {
+			// Nikon notes hold a TIFF structure of their own after a 10 byte header
+			note := []byte("Nikon\x00\x02\x10\x00\x00II*\x00\x08\x00\x00\x00")
+			return append(
+				note, encodeIFD(
+					8, []ifdEntry{
+						{id: 0x0084, typ: 5, count: 4, data: le(uint32(180), uint32(10), uint32(550), uint32(10),
+							uint32(35), uint32(10), uint32(56), uint32(10))},
+						{id: 0x00a7, typ: 4, count: 1, data: le(uint32(48213))},
+					},
+				)...,
+			)
+		},
+	)
+
+	record, err := DecodeOptions{MakerNotes: true}.Decode(bytes.NewReader(image))
+	assert.NoError(t, err)
+	tags := record.Map()
+	assert.Equal(t, "48213", tags["Nikon.ShutterCount"])
+	assert.Equal(t, "180/10, 550/10, 35/10, 56/10", tags["Nikon.Lens"])
+	for _, tag := range record.Tags {
+		if tag.Name == "Nikon.Lens" {
+			assert.Equal(t, "18, 55, 3.5, 5.6", tag.Decimal)
+		}
+	}
+}
+
+func TestDecodeMakerNoteMalformed(t *testing.T) {
+	// A truncated note leaves the standard tags as they are
+	image := makerNoteTIFF(
+		"NIKON CORPORATION", func(offset uint32) []byte {
+			return []byte("Nikon\x00\x02\x10\x00\x00II*\x00\xff\xff\x00\x00")
+		},
+	)
+	record, err := DecodeOptions{MakerNotes: true}.Decode(bytes.NewReader(image))
+	assert.NoError(t, err)
+	assert.Equal(t, "NIKON CORPORATION", record.Map()["Make"])
+	_, ok := record.Get("Nikon.ShutterCount")
+	assert.False(t, ok)
+}
@@ -0,0 +1,442 @@
+package pyrgear
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TimestampLayout is the layout of the prefix added by TimestampName
+const TimestampLayout = "20060102_150405"
+
+// timestampPrefix matches the prefix added by TimestampName
+var timestampPrefix = regexp.MustCompile(`^\d{8}_\d{6}_`)
+
+// TimestampName prefixes name with t formatted as YYYYMMDD_HHMMSS_
+func TimestampName(name string, t time.Time) string {
+	return fmt.Sprintf("%s_%s", t.Format(TimestampLayout), name)
+}
+
+// HasTimestamp reports whether name already carries the prefix added by TimestampName
+func HasTimestamp(name string) bool {
+	return timestampPrefix.MatchString(name)
+}
+
+// dateName matches the names returned by DateName
+var dateName = regexp.MustCompile(`^\d{8}_\d{6}(_\d{3})?(-\d+)?(\.[^.]*)?$`)
+
+// DateName returns t formatted as YYYYMMDD_HHMMSS<ext>, or YYYYMMDD_HHMMSS_mmm<ext> with the
+// milliseconds when millis is set. A positive n appends -n, to tell apart images taken at the same time.
+func DateName(t time.Time, millis bool, n int, ext string) string {
+	name := t.Format(TimestampLayout)
+	if millis {
+		name += fmt.Sprintf("_%03d", t.Nanosecond()/int(time.Millisecond))
+	}
+	if n > 0 {
+		name += fmt.Sprintf("-%d", n)
+	}
+	return name + ext
+}
+
+// IsDateName reports whether name looks like a name returned by DateName
+func IsDateName(name string) bool {
+	return dateName.MatchString(name)
+}
+
+// MaxNameBytes is the longest file name, in bytes, accepted by common filesystems
+const MaxNameBytes = 255
+
+// datePrefix matches a DateName followed by an original stem, see AppendStem
+var datePrefix = regexp.MustCompile(`^\d{8}_\d{6}(_\d{3})?(-\d+)?_.`)
+
+// HasDatePrefix reports whether name is a DateName followed by _ and an original stem
+func HasDatePrefix(name string) bool {
+	return datePrefix.MatchString(name)
+}
+
+// AppendStem inserts _stem before the extension of name. The stem is cut at a character
+// boundary so the result is at most maxBytes long, which is then reported as truncated.
+// If not even one character of the stem fits, name is returned unchanged.
+func AppendStem(name string, stem string, maxBytes int) (string, bool) {
+	base, ext := SplitExt(name)
+	room := maxBytes - len(base) - len("_") - len(ext)
+	if len(stem) <= room {
+		return base + "_" + stem + ext, false
+	}
+	if cut := cutBytes(stem, room); cut != "" {
+		return base + "_" + cut + ext, true
+	}
+	return name, true
+}
+
+// CheckNameLength returns ErrNameTooLong if name is longer than MaxNameBytes
+func CheckNameLength(name string) error {
+	if len(name) > MaxNameBytes {
+		return fmt.Errorf("%w: %d bytes, the limit is %d: %s", ErrNameTooLong, len(name), MaxNameBytes, name)
+	}
+	return nil
+}
+
+// TruncateName shortens the stem of name at a character boundary so the name is at most
+// maxBytes long, keeping the extension. With hash the stem ends with ~ and 8 hex digits of
+// the SHA-256 of the full name, so names that only differ after the cut stay distinct.
+// Names within the limit are returned unchanged.
+func TruncateName(name string, maxBytes int, hash bool) string {
+	if len(name) <= maxBytes {
+		return name
+	}
+	stem, ext := SplitExt(name)
+	suffix := ""
+	if hash {
+		sum := sha256.Sum256([]byte(name))
+		suffix = "~" + hex.EncodeToString(sum[:4])
+	}
+	return cutBytes(stem, maxBytes-len(suffix)-len(ext)) + suffix + ext
+}
+
+// cutBytes returns the longest prefix of s of at most n bytes that ends at a character boundary
+func cutBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := 0
+	for i := range s {
+		if i > n {
+			break
+		}
+		cut = i
+	}
+	return s[:cut]
+}
+
+// SequenceName returns <prefix>_NNN<ext>, numbered with at least three digits
+func SequenceName(prefix string, seq int, ext string) string {
+	return fmt.Sprintf("%s_%03d%s", prefix, seq, ext)
+}
+
+// IsSequenceName reports whether name looks like a name returned by SequenceName for prefix
+func IsSequenceName(prefix string, name string) bool {
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_\d{3,}(\.[^.]*)?$`)
+	return re.MatchString(name)
+}
+
+// NumberedName returns <prefix>_<n><ext>, or <n><ext> without a prefix,
+// with n zero-padded to width digits
+func NumberedName(prefix string, n int, width int, ext string) string {
+	if prefix == "" {
+		return fmt.Sprintf("%0*d%s", width, n, ext)
+	}
+	return fmt.Sprintf("%s_%0*d%s", prefix, width, n, ext)
+}
+
+// NumberedIndex returns the number of a name returned by NumberedName for prefix
+func NumberedIndex(prefix string, name string) (int, bool) {
+	stem, _ := SplitExt(name)
+	if prefix != "" {
+		if !strings.HasPrefix(stem, prefix+"_") {
+			return 0, false
+		}
+		stem = strings.TrimPrefix(stem, prefix+"_")
+	}
+	if stem == "" || strings.Trim(stem, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(stem)
+	return n, err == nil
+}
+
+// EventName returns <folder>_YYYYMMDD_NNN<ext>, the name of the seq-th image of an event
+// folder taken on the day of t, numbered with at least three digits
+func EventName(folder string, t time.Time, seq int, ext string) string {
+	return fmt.Sprintf("%s_%s_%03d%s", folder, t.Format("20060102"), seq, ext)
+}
+
+// EventIndex returns the number of a name returned by EventName for folder
+func EventIndex(folder string, name string) (int, bool) {
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(folder) + `_\d{8}_(\d{3,})(\.[^.]*)?$`)
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// trailingNumber matches a stem ending in a number, split into the part before and the number
+var trailingNumber = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// TrailingNumber returns the number at the end of the stem of name, shot_9.jpg has 9
+func TrailingNumber(name string) (int, bool) {
+	stem, _ := SplitExt(name)
+	m := trailingNumber.FindStringSubmatch(stem)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[2])
+	return n, err == nil
+}
+
+// IncrementName returns name with by added to the number at the end of its stem, keeping
+// its zero padding: shot_09.jpg becomes shot_10.jpg with 1. It reports false for names
+// without a trailing number and for numbers that would drop below zero.
+func IncrementName(name string, by int) (string, bool) {
+	stem, ext := SplitExt(name)
+	m := trailingNumber.FindStringSubmatch(stem)
+	if m == nil {
+		return name, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil || n+by < 0 {
+		return name, false
+	}
+	return fmt.Sprintf("%s%0*d%s", m[1], len(m[2]), n+by, ext), true
+}
+
+// BurstName returns burstGG_NNN<ext> for image seq of burst group
+func BurstName(group int, seq int, ext string) string {
+	return fmt.Sprintf("burst%02d_%03d%s", group, seq, ext)
+}
+
+// burstName matches the names returned by BurstName
+var burstName = regexp.MustCompile(`^burst(\d{2,})_\d{3,}(\.[^.]*)?$`)
+
+// BurstGroup returns the group number of a name returned by BurstName
+func BurstGroup(name string) (int, bool) {
+	m := burstName.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	group, err := strconv.Atoi(m[1])
+	return group, err == nil
+}
+
+// LowercaseName returns name converted to lowercase with the case rules of lang.
+// language.Und applies locale-independent rules.
+func LowercaseName(name string, lang language.Tag) string {
+	return cases.Lower(lang).String(name)
+}
+
+// UppercaseName returns name converted to uppercase with the case rules of lang.
+// language.Und applies locale-independent rules.
+func UppercaseName(name string, lang language.Tag) string {
+	return cases.Upper(lang).String(name)
+}
+
+// TitleCaseName returns name with the first letter of every word of its stem capitalized, with
+// the case rules of lang, keeping the extension. Words are separated by spaces, "-" and "_", so
+// hyphenated and underscore-separated words are capitalized alike. Words listed in small, such
+// as "of" or "the", are lowercased unless they are the first or last word of the stem: "the
+// lord of the rings.jpg" becomes "The Lord of the Rings.jpg". Only the first letter of the
+// other words is changed, so acronyms such as "NASA" are kept.
+func TitleCaseName(name string, small []string, lang language.Tag) string {
+	stem, ext := SplitExt(name)
+	isSmall := make(map[string]bool, len(small))
+	for _, word := range small {
+		isSmall[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+	isSeparator := func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}
+
+	// The byte ranges of the words of stem
+	var words [][2]int
+	start := -1
+	for i, r := range stem {
+		switch {
+		case isSeparator(r) && start >= 0:
+			words = append(words, [2]int{start, i})
+			start = -1
+		case !isSeparator(r) && start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, [2]int{start, len(stem)})
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, span := range words {
+		word := stem[span[0]:span[1]]
+		b.WriteString(stem[last:span[0]])
+		last = span[1]
+		if i > 0 && i < len(words)-1 && isSmall[strings.ToLower(word)] {
+			b.WriteString(cases.Lower(lang).String(word))
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(word)
+		if !unicode.IsLetter(r) {
+			b.WriteString(word)
+			continue
+		}
+		b.WriteString(cases.Upper(lang).String(word[:size]) + word[size:])
+	}
+	b.WriteString(stem[last:])
+	return b.String() + ext
+}
+
+// ReplaceInName replaces every occurrence of from with to in the stem of name, keeping the
+// extension, so "a.b.c.jpg" with "." and "-" becomes "a-b-c.jpg". With wholeName the
+// extension is included. The strings are literal, not patterns. The leading dot of a
+// hidden file is kept.
+func ReplaceInName(name, from, to string, wholeName bool) string {
+	if from == "" {
+		return name
+	}
+	dot := ""
+	if strings.HasPrefix(name, ".") {
+		dot, name = ".", name[1:]
+	}
+	if wholeName {
+		return dot + strings.ReplaceAll(name, from, to)
+	}
+	stem, ext := SplitExt(name)
+	return dot + strings.ReplaceAll(stem, from, to) + ext
+}
+
+// PrefixName adds prefix to name unless it is already present
+func PrefixName(prefix string, name string) string {
+	if strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return prefix + name
+}
+
+// ReverseName reverses the characters of the stem of name, keeping its extension. Runes are
+// reversed, not bytes, so multi-byte UTF-8 names stay valid. Leading and trailing dots of the
+// stem stay in place, so hidden files stay hidden and reversing twice restores the name.
+func ReverseName(name string) string {
+	stem, ext := SplitExt(name)
+	core := strings.Trim(stem, ".")
+	if core == "" {
+		return name
+	}
+	lead := stem[:strings.Index(stem, core)]
+	trail := stem[len(lead)+len(core):]
+
+	runes := []rune(core)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return lead + string(runes) + trail + ext
+}
+
+// SlugName returns the web-safe form of name: accents are removed, letters are lowercased and
+// every run of characters other than a-z, 0-9 and "_" becomes a single "-", dropped next to
+// a "_". Leading and trailing "-" and "_" are trimmed, so "Café Menu (2).PNG" becomes
+// "cafe-menu-2.png". Stems with nothing left become "file"; extensions are slugged alike.
+func SlugName(name string) string {
+	slug := func(s string) string {
+		var b strings.Builder
+		dash := false
+		for _, r := range norm.NFKD.String(s) {
+			switch {
+			case unicode.Is(unicode.Mn, r):
+				continue
+			case r >= 'A' && r <= 'Z':
+				r += 'a' - 'A'
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			case r == '_':
+				// "_" absorbs the separators around it
+				dash = false
+			default:
+				dash = b.Len() > 0 && !strings.HasSuffix(b.String(), "_")
+				continue
+			}
+			if dash {
+				b.WriteByte('-')
+				dash = false
+			}
+			b.WriteRune(r)
+		}
+		return strings.Trim(b.String(), "-_")
+	}
+
+	stem, ext := SplitExt(name)
+	stem = slug(stem)
+	if stem == "" {
+		stem = "file"
+	}
+	if ext = slug(ext); ext != "" {
+		ext = "." + ext
+	}
+	return stem + ext
+}
+
+// CollisionKey returns the form of name that filesystems which ignore case or Unicode
+// normalization compare: the NFC normalized, case folded name. Names with the same key,
+// such as "Photo.jpg" and "photo.JPG" or "café" in NFC and NFD, collide on such filesystems.
+func CollisionKey(name string) string {
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(name)))
+}
+
+// SplitExt splits name into its stem and extension. Names such as ".gitignore"
+// that only consist of a leading dot and a suffix have no extension.
+func SplitExt(name string) (stem string, ext string) {
+	ext = filepath.Ext(name)
+	if ext == name {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// IsEmptyStem reports whether name, the new name of a file named oldName, has lost its whole
+// stem: it is empty, only dots, or a bare extension such as ".jpg" that would hide the file.
+// Dot files such as ".gitignore" that were already named that way are not empty.
+func IsEmptyStem(oldName string, name string) bool {
+	if strings.Trim(name, ". ") == "" {
+		return true
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	oldStem := strings.TrimSuffix(oldName, filepath.Ext(oldName))
+	return strings.TrimSpace(stem) == "" && strings.TrimSpace(oldStem) != ""
+}
+
+// FallbackName returns <fallback>_<n><ext>, the name given to files whose new name is empty
+func FallbackName(fallback string, n int, ext string) string {
+	return fmt.Sprintf("%s_%d%s", fallback, n, ext)
+}
+
+// SuffixedName returns name with -n appended to its stem, photo.jpg becomes photo-2.jpg
+func SuffixedName(name string, n int) string {
+	stem, ext := SplitExt(name)
+	return fmt.Sprintf("%s-%d%s", stem, n, ext)
+}
+
+// dupSuffix is a marker browsers and file managers append to the stem of a duplicate:
+// " (1)", "-copy" or " - Copy"
+var dupSuffix = regexp.MustCompile(`(?i)( \(\d+\)|-copy| - copy)$`)
+
+// StripDupSuffix removes the duplicate markers ending the stem of name, so "photo (1).jpg",
+// "photo-copy.jpg" and "photo - Copy (2).jpg" all become "photo.jpg". Markers are only
+// removed while a stem remains, "(1).jpg" is left alone.
+func StripDupSuffix(name string) string {
+	stem, ext := SplitExt(name)
+	for {
+		loc := dupSuffix.FindStringIndex(stem)
+		if loc == nil || strings.TrimSpace(stem[:loc[0]]) == "" {
+			return stem + ext
+		}
+		stem = stem[:loc[0]]
+	}
+}
+
+// PatternName applies the replacement repl to name if it matches re.
+// It reports false if the name does not match.
+func PatternName(re *regexp.Regexp, repl string, name string) (string, bool) {
+	if !re.MatchString(name) {
+		return name, false
+	}
+	return re.ReplaceAllString(name, repl), true
+}
@@ -0,0 +1,319 @@
+package pyrgear
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestNaming(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	name := TimestampName("a.jpg", ts)
+	assert.Equal(t, "20240305_140709_a.jpg", name)
+	assert.True(t, HasTimestamp(name))
+	assert.False(t, HasTimestamp("a.jpg"))
+
+	assert.Equal(t, "photo_007.jpg", SequenceName("photo", 7, ".jpg"))
+	assert.Equal(t, "photo_1234", SequenceName("photo", 1234, ""))
+	assert.True(t, IsSequenceName("photo", "photo_007.jpg"))
+	assert.True(t, IsSequenceName("a.b", "a.b_001"))
+	assert.False(t, IsSequenceName("a.b", "axb_001"))
+	assert.False(t, IsSequenceName("photo", "photo_07.jpg"))
+
+	assert.Equal(t, "img.jpg", LowercaseName("IMG.JPG", language.Und))
+	assert.Equal(t, "x_a.jpg", PrefixName("x_", "a.jpg"))
+	assert.Equal(t, "x_a.jpg", PrefixName("x_", "x_a.jpg"))
+
+	re := regexp.MustCompile(`file_(\d+)`)
+	newName, ok := PatternName(re, "doc_$1", "file_12.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "doc_12.txt", newName)
+	_, ok = PatternName(re, "doc_$1", "other.txt")
+	assert.False(t, ok)
+}
+
+func TestSplitExt(t *testing.T) {
+	for name, want := range map[string][2]string{
+		"photo.jpg":      {"photo", ".jpg"},
+		"archive.tar.gz": {"archive.tar", ".gz"},
+		".gitignore":     {".gitignore", ""},
+		"README":         {"README", ""},
+	} {
+		stem, ext := SplitExt(name)
+		assert.Equal(t, want, [2]string{stem, ext}, name)
+	}
+}
+
+func TestIsEmptyStem(t *testing.T) {
+	assert.True(t, IsEmptyStem("IMG_001.jpg", ".jpg"))
+	assert.True(t, IsEmptyStem("IMG_001.jpg", ""))
+	assert.True(t, IsEmptyStem("IMG_001.jpg", ".."))
+	assert.True(t, IsEmptyStem("IMG_001.jpg", "  .jpg"))
+	assert.False(t, IsEmptyStem("IMG_001.jpg", "img_001.jpg"))
+	assert.False(t, IsEmptyStem("IMG_001", "x"))
+	// Dot files keep their name
+	assert.False(t, IsEmptyStem(".Gitignore", ".gitignore"))
+	assert.Equal(t, "unnamed_2.jpg", FallbackName("unnamed", 2, ".jpg"))
+}
+
+func TestEventName(t *testing.T) {
+	taken := time.Date(2024, 6, 1, 15, 4, 5, 0, time.UTC)
+	assert.Equal(t, "Smith Wedding_20240601_007.jpg", EventName("Smith Wedding", taken, 7, ".jpg"))
+	assert.Equal(t, "party_20240601_1234.CR2", EventName("party", taken, 1234, ".CR2"))
+
+	n, ok := EventIndex("Smith Wedding", "Smith Wedding_20240601_007.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, 7, n)
+	_, ok = EventIndex("Smith Wedding", "Other_20240601_007.jpg")
+	assert.False(t, ok)
+	_, ok = EventIndex("party", "party_2024_007.jpg")
+	assert.False(t, ok)
+}
+
+func TestIncrementName(t *testing.T) {
+	for name, want := range map[string]string{
+		"shot_9.jpg":  "shot_10.jpg",
+		"shot_09.jpg": "shot_10.jpg",
+		"IMG0042.CR2": "IMG0043.CR2",
+		"v2_final3":   "v2_final4",
+		"99":          "100",
+	} {
+		got, ok := IncrementName(name, 1)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, got, name)
+	}
+
+	got, ok := IncrementName("shot_010.jpg", -3)
+	assert.True(t, ok)
+	assert.Equal(t, "shot_007.jpg", got)
+	_, ok = IncrementName("shot_1.jpg", -2)
+	assert.False(t, ok)
+	_, ok = IncrementName("cover.jpg", 1)
+	assert.False(t, ok)
+	// The number must end the stem
+	_, ok = IncrementName("2024_party.jpg", 1)
+	assert.False(t, ok)
+
+	n, ok := TrailingNumber("shot_12.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, 12, n)
+}
+
+func TestReplaceInName(t *testing.T) {
+	assert.Equal(t, "a-b-c.jpg", ReplaceInName("a.b.c.jpg", ".", "-", false))
+	assert.Equal(t, "a-b-c-jpg", ReplaceInName("a.b.c.jpg", ".", "-", true))
+	assert.Equal(t, "photo_1_.png", ReplaceInName("photo#1#.png", "#", "_", false))
+	// Regex characters are literal
+	assert.Equal(t, "a+b.txt", ReplaceInName("a(*)b.txt", "(*)", "+", false))
+	assert.Equal(t, "ab.txt", ReplaceInName("a b.txt", " ", "", false))
+	// Hidden files stay hidden
+	assert.Equal(t, ".my_old.notes", ReplaceInName(".my.old.notes", ".", "_", false))
+	assert.Equal(t, ".gitignore", ReplaceInName(".gitignore", ".", "_", false))
+	assert.Equal(t, "same.txt", ReplaceInName("same.txt", "", "x", true))
+}
+
+func TestSlugName(t *testing.T) {
+	assert.Equal(t, "cafe-menu-2.png", SlugName("Café Menu (2).PNG"))
+	assert.Equal(t, "home_banner_001.jpg", SlugName("home_banner_001.jpg"))
+	assert.Equal(t, "my-shop_home_001.webp", SlugName("My  Shop!_home_001.webp"))
+	assert.Equal(t, "a_b-c.gif", SlugName("a_ b--c.gif"))
+	// Characters without a Latin form are dropped
+	assert.Equal(t, "home_001.png", SlugName("小程序_home_001.png"))
+	assert.Equal(t, "file.png", SlugName("首页.png"))
+	assert.Equal(t, "gitignore", SlugName(".gitignore"))
+	assert.Equal(t, "fi-a.jpg", SlugName("ﬁ á.JPG"))
+}
+
+func TestCaseNames(t *testing.T) {
+	// Turkish has a dotted and a dotless i
+	assert.Equal(t, "ıstanbul.jpg", LowercaseName("ISTANBUL.JPG", language.Turkish))
+	assert.Equal(t, "istanbul.jpg", LowercaseName("İSTANBUL.JPG", language.Turkish))
+	assert.Equal(t, "İZMİR.JPG", UppercaseName("izmir.jpg", language.Turkish))
+	assert.Equal(t, "istanbul.jpg", LowercaseName("ISTANBUL.JPG", language.Und))
+	assert.Equal(t, "IZMIR.JPG", UppercaseName("izmir.jpg", language.Und))
+
+	// German sharp s has no single uppercase letter
+	assert.Equal(t, "STRASSE.JPG", UppercaseName("straße.jpg", language.German))
+	assert.Equal(t, "straße.jpg", LowercaseName("Straße.JPG", language.German))
+}
+
+func TestTitleCaseName(t *testing.T) {
+	small := []string{"a", "an", "the", "of", "and", "in"}
+	assert.Equal(t, "The Lord of the Rings.jpg", TitleCaseName("the lord of the rings.jpg", small, language.Und))
+	assert.Equal(t, "State-of-the-Art.PNG", TitleCaseName("state-of-the-art.PNG", small, language.Und))
+	assert.Equal(t, "Walk_in_the_Park.jpg", TitleCaseName("walk_In_THE_park.jpg", small, language.Und))
+
+	// Small words are capitalized first and last, acronyms and numbers are kept
+	assert.Equal(t, "A Day In.jpg", TitleCaseName("a day in.jpg", small, language.Und))
+	assert.Equal(t, "NASA Launch 2024_01.jpg", TitleCaseName("NASA launch 2024_01.jpg", small, language.Und))
+	assert.Equal(t, "Of Mice And Men", TitleCaseName("of mice and men", nil, language.Und))
+
+	// Separators are kept as they are, also in runs
+	assert.Equal(t, " Trip  --  Rome_.jpg", TitleCaseName(" trip  --  rome_.jpg", small, language.Und))
+	assert.Equal(t, "İstanbul Trip.jpg", TitleCaseName("istanbul trip.jpg", small, language.Turkish))
+}
+
+func TestNumberedName(t *testing.T) {
+	assert.Equal(t, "0007.jpg", NumberedName("", 7, 4, ".jpg"))
+	assert.Equal(t, "shoot_12", NumberedName("shoot", 12, 1, ""))
+
+	n, ok := NumberedIndex("", "0007.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, 7, n)
+	n, ok = NumberedIndex("shoot", "shoot_12.png")
+	assert.True(t, ok)
+	assert.Equal(t, 12, n)
+	_, ok = NumberedIndex("shoot", "12.png")
+	assert.False(t, ok)
+	_, ok = NumberedIndex("", "a12.png")
+	assert.False(t, ok)
+	_, ok = NumberedIndex("", ".jpg")
+	assert.False(t, ok)
+}
+
+func TestDateName(t *testing.T) {
+	taken := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.Local)
+	assert.Equal(t, "20240506_070809.jpg", DateName(taken, false, 0, ".jpg"))
+	assert.Equal(t, "20240506_070809_123.jpg", DateName(taken, true, 0, ".jpg"))
+	assert.Equal(t, "20240506_070809_123-2.jpg", DateName(taken, true, 2, ".jpg"))
+
+	assert.True(t, IsDateName("20240506_070809.jpg"))
+	assert.True(t, IsDateName("20240506_070809_123-2.jpg"))
+	assert.False(t, IsDateName("20240506_070809_photo.jpg"))
+	assert.False(t, IsDateName("IMG_0001.jpg"))
+
+	name, truncated := AppendStem("20240506_070809.jpg", "IMG_0042", MaxNameBytes)
+	assert.Equal(t, "20240506_070809_IMG_0042.jpg", name)
+	assert.False(t, truncated)
+	assert.True(t, HasDatePrefix(name))
+	assert.True(t, HasDatePrefix("20240506_070809_123-2_IMG_0042.jpg"))
+	assert.False(t, HasDatePrefix("20240506_070809.jpg"))
+	assert.False(t, HasDatePrefix("IMG_0042.jpg"))
+
+	// Stems are cut to the limit at a character boundary
+	name, truncated = AppendStem("20240506_070809.jpg", "été", 22)
+	assert.Equal(t, "20240506_070809_é.jpg", name)
+	assert.True(t, truncated)
+	name, truncated = AppendStem("20240506_070809.jpg", "été", 20)
+	assert.Equal(t, "20240506_070809.jpg", name)
+	assert.True(t, truncated)
+}
+
+func TestTruncateName(t *testing.T) {
+	assert.NoError(t, CheckNameLength(strings.Repeat("a", MaxNameBytes)))
+	long := strings.Repeat("a", 300) + ".jpeg"
+	assert.ErrorIs(t, CheckNameLength(long), ErrNameTooLong)
+
+	assert.Equal(t, "short.jpg", TruncateName("short.jpg", MaxNameBytes, true))
+	assert.Equal(t, strings.Repeat("a", 250)+".jpeg", TruncateName(long, MaxNameBytes, false))
+
+	// The hash keeps names distinct that only differ after the cut
+	hashed := TruncateName(long, MaxNameBytes, true)
+	assert.Len(t, hashed, MaxNameBytes)
+	assert.Regexp(t, `^a{241}~[0-9a-f]{8}\.jpeg$`, hashed)
+	assert.NotEqual(t, hashed, TruncateName(strings.Repeat("a", 301)+".jpeg", MaxNameBytes, true))
+
+	// Multi-byte characters are never split
+	assert.Equal(t, "éé.txt", TruncateName("ééé.txt", 9, false))
+
+	for val, want := range map[string]time.Duration{
+		"5": 500 * time.Millisecond, "123": 123 * time.Millisecond, "1234": 123400 * time.Microsecond,
+	} {
+		subsec, ok := (&Record{Tags: []Tag{{Name: "SubSecTimeOriginal", Value: val}}}).SubSecond()
+		assert.True(t, ok)
+		assert.Equal(t, want, subsec)
+	}
+	_, ok := (&Record{Tags: []Tag{{Name: "SubSecTimeOriginal", Value: "n/a"}}}).SubSecond()
+	assert.False(t, ok)
+}
+
+func TestDateTimeOffsets(t *testing.T) {
+	tokyo, err := ParseTimeZone("+09:00")
+	assert.NoError(t, err)
+
+	// Without a recorded offset the time is in the given zone
+	record := &Record{Tags: []Tag{{Name: "DateTimeOriginal", Value: "2024:05:06 07:08:09"}}}
+	taken, ok := record.DateTimeIn(tokyo)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 5, 22, 8, 9, 0, time.UTC), taken.UTC())
+	taken, ok = record.DateTime()
+	assert.True(t, ok)
+	assert.Equal(t, time.Local, taken.Location())
+
+	// A recorded offset wins, and the wall clock of the camera is kept
+	record.Tags = append(record.Tags, Tag{Name: "OffsetTimeOriginal", Value: "-05:00"})
+	taken, ok = record.DateTimeIn(tokyo)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 6, 12, 8, 9, 0, time.UTC), taken.UTC())
+	assert.Equal(t, "20240506_070809.jpg", DateName(taken, false, 0, ".jpg"))
+
+	// The offset of DateTime goes with DateTime, unusable offsets are ignored
+	record = &Record{
+		Tags: []Tag{
+			{Name: "DateTime", Value: "2024:05:06 07:08:09"}, {Name: "OffsetTime", Value: "+0530"},
+			{Name: "OffsetTimeOriginal", Value: "+09:00"},
+		},
+	}
+	taken, _ = record.DateTimeIn(time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 6, 1, 38, 9, 0, time.UTC), taken.UTC())
+	record.Tags[1].Value = "   :  "
+	taken, _ = record.DateTimeIn(time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), taken)
+
+	for _, name := range []string{"Z", "UTC", "+09", "Asia/Tokyo", "Local"} {
+		_, err := ParseTimeZone(name)
+		assert.NoError(t, err, name)
+	}
+	_, err = ParseTimeZone("Mars/Olympus")
+	assert.Error(t, err)
+}
+
+func TestReverseName(t *testing.T) {
+	for name, want := range map[string]string{
+		"photo_01.jpg":   "10_otohp.jpg",
+		"日本語の写真.png":     "真写の語本日.png",
+		"café.txt":       "éfac.txt",
+		"archive.tar.gz": "rat.evihcra.gz",
+		".bashrc":        ".crhsab",
+		"x..jpg":         "x..jpg",
+		"README":         "EMDAER",
+		"level.txt":      "level.txt",
+	} {
+		assert.Equal(t, want, ReverseName(name), name)
+		// Reversing twice restores the name
+		assert.Equal(t, name, ReverseName(ReverseName(name)), name)
+	}
+}
+
+func TestCollisionKey(t *testing.T) {
+	nfc, nfd := "café.txt", "café.txt"
+	assert.NotEqual(t, nfc, nfd)
+	assert.Equal(t, CollisionKey(nfc), CollisionKey(nfd))
+	assert.Equal(t, CollisionKey("Photo.JPG"), CollisionKey("photo.jpg"))
+	assert.Equal(t, CollisionKey("STRASSE"), CollisionKey("straße"))
+	assert.NotEqual(t, CollisionKey("photo1.jpg"), CollisionKey("photo2.jpg"))
+}
+
+func TestStripDupSuffix(t *testing.T) {
+	for name, want := range map[string]string{
+		"photo (1).jpg":        "photo.jpg",
+		"photo (12).JPG":       "photo.JPG",
+		"photo-copy.jpg":       "photo.jpg",
+		"photo - Copy.jpg":     "photo.jpg",
+		"photo - Copy (2).jpg": "photo.jpg",
+		"photo (1) (1).jpg":    "photo.jpg",
+		"report (1)":           "report",
+		"photo.jpg":            "photo.jpg",
+		"photo(1).jpg":         "photo(1).jpg",
+		"photo (a).jpg":        "photo (a).jpg",
+		"photocopy.jpg":        "photocopy.jpg",
+		"(1).jpg":              "(1).jpg",
+		"photo (1).tar.gz":     "photo (1).tar.gz",
+	} {
+		assert.Equal(t, want, StripDupSuffix(name), name)
+	}
+}
@@ -0,0 +1,403 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parquet physical types, repetitions, encodings and converted types, see parquet.thrift
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetUTF8 = 0
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetNumber matches the values written as numbers: no leading zeros, which would be lost,
+// no exponents and no signs other than a leading minus
+var parquetNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+
+// ParquetRowGroupRows is the number of rows WriteParquet puts in each row group
+const ParquetRowGroupRows = 10000
+
+// WriteParquet writes rows as a Parquet file with the given columns, in row groups of
+// ParquetRowGroupRows rows and without compression, readable by pandas.read_parquet and
+// arrow::read_parquet in R. Cells missing from a row are null. A column whose values are all
+// integers is written as INT64, one whose values are all decimal numbers as DOUBLE, any other
+// as a UTF-8 string, see ParquetSchema. To write more rows than fit in memory, use
+// ParquetWriter directly.
+func WriteParquet(w io.Writer, columns []string, rows []map[string]string) error {
+	schema := NewParquetSchema()
+	for _, row := range rows {
+		schema.Observe(row)
+	}
+	pw := NewParquetWriter(w, columns, schema)
+	for start := 0; start < len(rows); start += ParquetRowGroupRows {
+		if err := pw.WriteRowGroup(rows[start:min(start+ParquetRowGroupRows, len(rows))]); err != nil {
+			return err
+		}
+	}
+	return pw.Close()
+}
+
+// ParquetSchema holds the type of each column of a Parquet file, inferred from the values it
+// was shown: INT64 while all values are integers, DOUBLE while all are decimal numbers and
+// a UTF-8 string once any is not a number. Columns without values are strings.
+type ParquetSchema struct {
+	types map[string]int32
+}
+
+// NewParquetSchema returns a schema that was shown no values yet
+func NewParquetSchema() *ParquetSchema {
+	return &ParquetSchema{types: map[string]int32{}}
+}
+
+// Observe narrows the types of the columns of row to fit its values
+func (s *ParquetSchema) Observe(row map[string]string) {
+	for column, value := range row {
+		typ, seen := s.types[column]
+		switch {
+		case !seen:
+			typ = parquetInt64
+		case typ == parquetByteArray:
+			continue
+		}
+		if !parquetNumber.MatchString(value) {
+			typ = parquetByteArray
+		} else if strings.Contains(value, ".") {
+			typ = parquetDouble
+		} else if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			typ = parquetDouble
+		}
+		s.types[column] = typ
+	}
+}
+
+// columnType returns the physical type of column
+func (s *ParquetSchema) columnType(column string) int32 {
+	if typ, ok := s.types[column]; ok {
+		return typ
+	}
+	return parquetByteArray
+}
+
+// ParquetWriter writes a Parquet file to w one row group at a time, so only the rows of one
+// group are held in memory. The footer describing the row groups is written by Close.
+type ParquetWriter struct {
+	w       io.Writer
+	columns []string
+	types   []int32
+	// offset is the number of bytes written so far
+	offset    int64
+	rowGroups []parquetRowGroup
+	numRows   int64
+	err       error
+}
+
+// parquetRowGroup describes a row group written by ParquetWriter for the footer
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// parquetChunk describes the pages of one column in a row group
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// NewParquetWriter starts a Parquet file on w with the given columns, typed as schema says.
+// All rows written must have been observed by schema.
+func NewParquetWriter(w io.Writer, columns []string, schema *ParquetSchema) *ParquetWriter {
+	p := &ParquetWriter{w: w, columns: columns, types: make([]int32, len(columns))}
+	for i, column := range columns {
+		p.types[i] = schema.columnType(column)
+	}
+	p.write([]byte("PAR1"))
+	return p
+}
+
+// write writes data to the file, keeping the first error
+func (p *ParquetWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	p.err = err
+}
+
+// WriteRowGroup writes rows as one row group. Large columns are split into pages of at most
+// 2 GiB, the most a page header can describe.
+func (p *ParquetWriter) WriteRowGroup(rows []map[string]string) error {
+	group := parquetRowGroup{rows: int64(len(rows))}
+	for i, column := range p.columns {
+		pages, err := parquetPages(column, p.types[i], rows)
+		if err != nil {
+			return err
+		}
+		chunk := parquetChunk{offset: p.offset}
+		for _, page := range pages {
+			header := &thriftWriter{}
+			header.beginStruct()
+			header.i32Field(1, 0) // DATA_PAGE
+			header.i32Field(2, int32(len(page.data)))
+			header.i32Field(3, int32(len(page.data)))
+			header.structField(5)
+			header.i32Field(1, int32(page.rows))
+			header.i32Field(2, parquetPlain)
+			header.i32Field(3, parquetRLE)
+			header.i32Field(4, parquetRLE)
+			header.endStruct()
+			header.endStruct()
+			p.write(header.Bytes())
+			p.write(page.data)
+		}
+		chunk.size = p.offset - chunk.offset
+		group.chunks = append(group.chunks, chunk)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.rows
+	return nil
+}
+
+// Close writes the footer of the file: the schema and where the pages of each row group are
+func (p *ParquetWriter) Close() error {
+	// FileMetaData: version, schema, num_rows, row_groups, created_by
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32Field(1, 1)
+	meta.listField(2, thriftStruct, len(p.columns)+1)
+	meta.beginStruct()
+	meta.binaryField(4, "schema")
+	meta.i32Field(5, int32(len(p.columns)))
+	meta.endStruct()
+	for i, column := range p.columns {
+		meta.beginStruct()
+		meta.i32Field(1, p.types[i])
+		meta.i32Field(3, parquetOptional)
+		meta.binaryField(4, column)
+		if p.types[i] == parquetByteArray {
+			meta.i32Field(6, parquetUTF8)
+		}
+		meta.endStruct()
+	}
+	meta.i64Field(3, p.numRows)
+	meta.listField(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		// RowGroup: columns, total_byte_size, num_rows
+		meta.beginStruct()
+		meta.listField(1, thriftStruct, len(group.chunks))
+		var totalSize int64
+		for i, chunk := range group.chunks {
+			totalSize += chunk.size
+			// ColumnChunk: file_offset and ColumnMetaData
+			meta.beginStruct()
+			meta.i64Field(2, chunk.offset)
+			meta.structField(3)
+			meta.i32Field(1, p.types[i])
+			meta.listField(2, thriftI32, 2)
+			meta.varint(zigzag(parquetPlain))
+			meta.varint(zigzag(parquetRLE))
+			meta.listField(3, thriftBinary, 1)
+			meta.binary(p.columns[i])
+			meta.i32Field(4, 0) // UNCOMPRESSED
+			meta.i64Field(5, group.rows)
+			meta.i64Field(6, chunk.size)
+			meta.i64Field(7, chunk.size)
+			meta.i64Field(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64Field(2, totalSize)
+		meta.i64Field(3, group.rows)
+		meta.endStruct()
+	}
+	meta.binaryField(6, "pyrgear")
+	meta.endStruct()
+
+	p.write(meta.Bytes())
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(meta.Len())))
+	p.write([]byte("PAR1"))
+	return p.err
+}
+
+// parquetPageSize is the most bytes a data page holds: page sizes are 32-bit in the page header
+var parquetPageSize = math.MaxInt32
+
+// parquetPageData is one data page of a column: its data and the number of rows it holds
+type parquetPageData struct {
+	data []byte
+	rows int
+}
+
+// parquetPages returns the data pages holding all values of column, starting a new page before
+// one would grow past parquetPageSize
+func parquetPages(column string, typ int32, rows []map[string]string) ([]parquetPageData, error) {
+	var pages []parquetPageData
+	start, size := 0, 4
+	for i, row := range rows {
+		// A row takes at most 2 bytes of definition levels, one run of its own, and its value
+		rowSize := 2
+		if value, ok := row[column]; ok {
+			if typ == parquetByteArray {
+				rowSize += 4 + len(value)
+			} else {
+				rowSize += 8
+			}
+		}
+		if 4+rowSize > parquetPageSize {
+			return nil, fmt.Errorf("value of %s in row %d is too large for a Parquet page", column, i+1)
+		}
+		if size+rowSize > parquetPageSize {
+			pages = append(pages, parquetPageData{parquetPage(column, typ, rows[start:i]), i - start})
+			start, size = i, 4
+		}
+		size += rowSize
+	}
+	return append(pages, parquetPageData{parquetPage(column, typ, rows[start:]), len(rows) - start}), nil
+}
+
+// parquetPage returns the data of the page holding the values of column in rows: the definition
+// levels, 1 for present and 0 for null cells, followed by the present values in PLAIN encoding
+func parquetPage(column string, typ int32, rows []map[string]string) []byte {
+	var levels, values bytes.Buffer
+	for i := 0; i < len(rows); {
+		// A run of cells that are all present or all null
+		_, present := rows[i][column]
+		n := 1
+		for i+n < len(rows) {
+			if _, ok := rows[i+n][column]; ok != present {
+				break
+			}
+			n++
+		}
+		levels.Write(binary.AppendUvarint(nil, uint64(n)<<1))
+		if present {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		i += n
+	}
+
+	for _, row := range rows {
+		value, ok := row[column]
+		if !ok {
+			continue
+		}
+		switch typ {
+		case parquetInt64:
+			n, _ := strconv.ParseInt(value, 10, 64)
+			binary.Write(&values, binary.LittleEndian, n)
+		case parquetDouble:
+			f, _ := strconv.ParseFloat(value, 64)
+			binary.Write(&values, binary.LittleEndian, math.Float64bits(f))
+		default:
+			binary.Write(&values, binary.LittleEndian, uint32(len(value)))
+			values.WriteString(value)
+		}
+	}
+
+	page := binary.LittleEndian.AppendUint32(nil, uint32(levels.Len()))
+	page = append(page, levels.Bytes()...)
+	return append(page, values.Bytes()...)
+}
+
+// thriftWriter encodes structs in the Thrift compact protocol Parquet metadata is stored in
+type thriftWriter struct {
+	bytes.Buffer
+	// lastField holds the id of the last field written to each open struct
+	lastField []int16
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.lastField = append(t.lastField, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.WriteByte(0)
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.lastField[len(t.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binaryField(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary(s)
+}
+
+// structField starts a struct field, which is ended with endStruct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// listField starts a list field of size elements of typ, which are written next. Struct
+// elements are written with beginStruct and endStruct.
+func (t *thriftWriter) listField(id int16, typ byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | typ)
+	} else {
+		t.WriteByte(0xf0 | typ)
+		t.varint(uint64(size))
+	}
+}
+
+// zigzag maps signed integers to unsigned ones as the compact protocol encodes them
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,73 @@
+package pyrgear
+
+import (
+	"fmt"
+	"strings"
+)
+
+// illegalChars are the characters that may not appear in a filename on each target filesystem
+var illegalChars = map[string]string{
+	"windows": `<>:"/\|?*`,
+	"mac":     `/:`,
+	"linux":   `/`,
+}
+
+// windowsReserved are device names Windows does not allow as a filename, with or without extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName returns name with the characters that are illegal on the target filesystem
+// ("windows", "mac" or "linux") replaced by replacement. For Windows, control characters
+// and trailing dots and spaces are replaced too, and reserved device names such as CON or
+// NUL get replacement appended to their stem ("_" if replacement is empty).
+func SanitizeName(name string, targetFS string, replacement string) (string, error) {
+	illegal, ok := illegalChars[targetFS]
+	if !ok {
+		return "", fmt.Errorf("unknown target filesystem: %s (supported: windows, mac, linux)", targetFS)
+	}
+	windows := targetFS == "windows"
+	isIllegal := func(r rune) bool {
+		return r == 0 || strings.ContainsRune(illegal, r) || (windows && r < 32)
+	}
+	if strings.IndexFunc(replacement, isIllegal) >= 0 {
+		return "", fmt.Errorf("replacement %q is not allowed on %s", replacement, targetFS)
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		if isIllegal(r) {
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	sanitized := b.String()
+	if !windows {
+		return sanitized, nil
+	}
+
+	// Windows silently drops trailing dots and spaces
+	trimmed := strings.TrimRight(sanitized, ". ")
+	if trimmed != sanitized {
+		sanitized = trimmed + strings.Repeat(replacement, len(sanitized)-len(trimmed))
+	}
+
+	// Reserved device names are reserved with any extension
+	stem, ext := sanitized, ""
+	if i := strings.IndexByte(sanitized, '.'); i >= 0 {
+		stem, ext = sanitized[:i], sanitized[i:]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		suffix := replacement
+		if suffix == "" {
+			suffix = "_"
+		}
+		sanitized = stem + suffix + ext
+	}
+	return sanitized, nil
+}
@@ -0,0 +1,36 @@
+package pyrgear
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeName(t *testing.T) {
+	cases := []struct {
+		name, targetFS, replacement, want string
+	}{
+		{"a:b*c?.jpg", "windows", "_", "a_b_c_.jpg"},
+		{`say "hi" <now>|\.txt`, "windows", "-", "say -hi- -now---.txt"},
+		{"tab\there.txt", "windows", "_", "tab_here.txt"},
+		{"CON", "windows", "_", "CON_"},
+		{"nul.txt", "windows", "_", "nul_.txt"},
+		{"com1.tar.gz", "windows", "", "com1_.tar.gz"},
+		{"CONSOLE.txt", "windows", "_", "CONSOLE.txt"},
+		{"trailing. ", "windows", "_", "trailing__"},
+		{"a:b*c?.jpg", "mac", "_", "a_b*c?.jpg"},
+		{"a:b*c?.jpg", "linux", "_", "a:b*c?.jpg"},
+		{"a:b.jpg", "windows", "", "ab.jpg"},
+		{"CON", "linux", "_", "CON"},
+	}
+	for _, c := range cases {
+		got, err := SanitizeName(c.name, c.targetFS, c.replacement)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.want, got, c.name)
+	}
+
+	_, err := SanitizeName("a", "amiga", "_")
+	assert.Error(t, err)
+	_, err = SanitizeName("a", "windows", ":")
+	assert.Error(t, err)
+}
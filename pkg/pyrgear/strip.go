@@ -0,0 +1,469 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// TagFilter reports whether the EXIF tag with the given name (as reported by DecodeEXIF) is kept
+type TagFilter func(name string) bool
+
+// Pointer tags linking IFD0 to the Exif and GPS sub-IFDs and the Exif sub-IFD to the Interoperability sub-IFD
+const (
+	exifIFDPointer    = 0x8769
+	gpsIFDPointer     = 0x8825
+	interopIFDPointer = 0xA005
+
+	thumbnailOffsetTag = 0x0201
+	thumbnailLengthTag = 0x0202
+)
+
+// tiffTypeSizes are the sizes in bytes of the TIFF field types
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifHeader starts the payload of a JPEG APP1 segment holding EXIF data
+var exifHeader = []byte("Exif\x00\x00")
+
+// StripEXIF copies the JPEG read from r to w, rewriting its EXIF data so that only the tags
+// accepted by keep remain. The tags of IFD0 and of the Exif, GPS and Interoperability sub-IFDs
+// are filtered, the thumbnail IFD is kept as is. A nil keep, or a filter that keeps no tag,
+// removes the EXIF segment entirely. The image data is copied unchanged.
+//
+// Maker notes that store offsets into the EXIF data may no longer be readable after a rewrite.
+func StripEXIF(r io.Reader, w io.Writer, keep TagFilter) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	segments, image, err := splitJPEG(data)
+	if err != nil {
+		return err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	for _, segment := range segments {
+		tiff, ok := exifPayload(segment)
+		if !ok {
+			out.Write(segment)
+			continue
+		}
+		if keep == nil {
+			continue
+		}
+
+		tiff, err := filterTIFF(tiff, keep)
+		if err != nil {
+			return err
+		}
+		if tiff == nil {
+			continue
+		}
+		if err := writeEXIFSegment(out, tiff); err != nil {
+			return err
+		}
+	}
+	out.Write(image)
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// splitJPEG splits a JPEG into the segments (with their markers) before the image data,
+// and the image data itself starting at the start of scan marker
+func splitJPEG(data []byte) (segments [][]byte, image []byte, err error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, fmt.Errorf("%w: not a JPEG image", ErrUnsupportedFormat)
+	}
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF || pos+1 >= len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			segments = append(segments, data[pos:pos+1])
+			pos++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// The compressed image data follows
+			return segments, data[pos:], nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length
+			segments = append(segments, data[pos:pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		segments = append(segments, data[pos:end])
+		pos = end
+	}
+	return segments, nil, nil
+}
+
+// exifPayload returns the TIFF data of a JPEG segment holding EXIF data
+func exifPayload(segment []byte) ([]byte, bool) {
+	if len(segment) < 4 || segment[1] != 0xE1 || !bytes.HasPrefix(segment[4:], exifHeader) {
+		return nil, false
+	}
+	return segment[4+len(exifHeader):], true
+}
+
+// writeEXIFSegment writes an APP1 segment holding the EXIF data tiff
+func writeEXIFSegment(w *bytes.Buffer, tiff []byte) error {
+	length := 2 + len(exifHeader) + len(tiff)
+	if length > 0xFFFF {
+		return fmt.Errorf("rewritten EXIF data is too large (%d bytes)", length)
+	}
+	w.Write([]byte{0xFF, 0xE1})
+	binary.Write(w, binary.BigEndian, uint16(length))
+	w.Write(exifHeader)
+	w.Write(tiff)
+	return nil
+}
+
+// StripEXIFFile rewrites the EXIF data of the JPEG at path in place, see StripEXIF.
+// The file is replaced only once the rewritten image has been written completely.
+func StripEXIFFile(path string, keep TagFilter) error {
+	return rewriteFile(
+		path, func(r io.Reader, w io.Writer) error {
+			return StripEXIF(r, w, keep)
+		}, nil,
+	)
+}
+
+// rewriteFile replaces the file at path with what rewrite writes when reading it. The new
+// content goes to a temporary file next to path first, which check (if not nil) may reject
+// before it replaces path.
+func rewriteFile(path string, rewrite func(r io.Reader, w io.Writer) error, check func(tmpPath string) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pyrgear-rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := rewrite(src, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(tmp.Name()); err != nil {
+			return err
+		}
+	}
+	if info, err := src.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	src.Close()
+	return os.Rename(tmp.Name(), path)
+}
+
+// tiffEntry is an IFD entry with its raw value in the byte order of the source
+type tiffEntry struct {
+	id    uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffIFD is a parsed image file directory
+type tiffIFD []tiffEntry
+
+// tiffData is EXIF data parsed into its IFDs. The thumbnail IFD and its image are kept as they are.
+type tiffData struct {
+	order                    binary.ByteOrder
+	ifd0, exif, gps, interop tiffIFD
+	ifd1                     tiffIFD
+	thumbnail                []byte
+}
+
+// filterTIFF rebuilds the TIFF structure of EXIF data with only the tags accepted by keep.
+// It returns nil if no tag is kept.
+func filterTIFF(data []byte, keep TagFilter) ([]byte, error) {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	t.interop = t.interop.filter(interopTagNames, keep)
+	t.exif = t.exif.filter(mainTagNames, keep)
+	t.gps = t.gps.filter(gpsTagNames, keep)
+	t.ifd0 = t.ifd0.filter(mainTagNames, keep)
+	t.linkIFDs()
+	if len(t.ifd0) == 0 {
+		return nil, nil
+	}
+	return t.bytes(), nil
+}
+
+// parseTIFF parses the IFDs of EXIF data
+func parseTIFF(data []byte) (*tiffData, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%w: truncated TIFF header", ErrNoEXIF)
+	}
+	t := &tiffData{}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: invalid TIFF byte order", ErrNoEXIF)
+	}
+	order := t.order
+	if order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("%w: invalid TIFF header", ErrNoEXIF)
+	}
+
+	var next uint32
+	var err error
+	if t.ifd0, next, err = parseIFD(data, order, order.Uint32(data[4:])); err != nil {
+		return nil, err
+	}
+
+	// Sub-IFD pointers are structural and never matched by name
+	if off, ok := t.ifd0.pointer(exifIFDPointer, order); ok {
+		if t.exif, _, err = parseIFD(data, order, off); err != nil {
+			return nil, err
+		}
+		if off, ok := t.exif.pointer(interopIFDPointer, order); ok {
+			if t.interop, _, err = parseIFD(data, order, off); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if off, ok := t.ifd0.pointer(gpsIFDPointer, order); ok {
+		if t.gps, _, err = parseIFD(data, order, off); err != nil {
+			return nil, err
+		}
+	}
+
+	if next != 0 {
+		if t.ifd1, _, err = parseIFD(data, order, next); err == nil {
+			off, okOff := t.ifd1.pointer(thumbnailOffsetTag, order)
+			length, okLen := t.ifd1.pointer(thumbnailLengthTag, order)
+			if okOff && okLen && uint64(off)+uint64(length) <= uint64(len(data)) {
+				t.thumbnail = data[off : off+length]
+			} else {
+				t.ifd1 = t.ifd1.withPointer(thumbnailOffsetTag, false).withPointer(thumbnailLengthTag, false)
+			}
+		} else {
+			t.ifd1 = nil
+		}
+	}
+	return t, nil
+}
+
+// linkIFDs adds the pointers to the sub-IFDs that have entries and removes those to empty ones
+func (t *tiffData) linkIFDs() {
+	link := func(d tiffIFD, id uint16, sub tiffIFD) tiffIFD {
+		if len(sub) == 0 {
+			return d.withPointer(id, false)
+		}
+		if _, ok := d.pointer(id, t.order); ok {
+			return d
+		}
+		return d.set(tiffEntry{id: id, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	t.exif = link(t.exif, interopIFDPointer, t.interop)
+	t.ifd0 = link(t.ifd0, exifIFDPointer, t.exif)
+	t.ifd0 = link(t.ifd0, gpsIFDPointer, t.gps)
+}
+
+// bytes lays the IFDs out one after another and returns the TIFF data. The pointers must
+// have been linked, see linkIFDs.
+func (t *tiffData) bytes() []byte {
+	order := t.order
+	exifOff := 8 + t.ifd0.size()
+	gpsOff := exifOff + t.exif.size()
+	interopOff := gpsOff + t.gps.size()
+	ifd1Off := interopOff + t.interop.size()
+	thumbnailOff := ifd1Off + t.ifd1.size()
+
+	t.ifd0.setLong(exifIFDPointer, uint32(exifOff), order)
+	t.ifd0.setLong(gpsIFDPointer, uint32(gpsOff), order)
+	t.exif.setLong(interopIFDPointer, uint32(interopOff), order)
+	t.ifd1.setLong(thumbnailOffsetTag, uint32(thumbnailOff), order)
+
+	ifd0Next := 0
+	if len(t.ifd1) > 0 {
+		ifd0Next = ifd1Off
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, thumbnailOff+len(t.thumbnail)))
+	if order == binary.LittleEndian {
+		out.WriteString("II")
+	} else {
+		out.WriteString("MM")
+	}
+	binary.Write(out, order, uint16(42))
+	binary.Write(out, order, uint32(8))
+	t.ifd0.write(out, order, 8, ifd0Next)
+	t.exif.write(out, order, exifOff, 0)
+	t.gps.write(out, order, gpsOff, 0)
+	t.interop.write(out, order, interopOff, 0)
+	t.ifd1.write(out, order, ifd1Off, 0)
+	out.Write(t.thumbnail)
+	return out.Bytes()
+}
+
+// parseIFD parses the IFD at offset, returning it and the offset of the next IFD.
+// Entries of unknown types cannot be relocated and are dropped.
+func parseIFD(data []byte, order binary.ByteOrder, offset uint32) (tiffIFD, uint32, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, 0, fmt.Errorf("%w: IFD offset %d out of range", ErrNoEXIF, offset)
+	}
+	n := int(order.Uint16(data[offset:]))
+	start := int(offset) + 2
+	if start+12*n+4 > len(data) {
+		return nil, 0, fmt.Errorf("%w: truncated IFD at offset %d", ErrNoEXIF, offset)
+	}
+
+	var ifd tiffIFD
+	for i := 0; i < n; i++ {
+		raw := data[start+12*i : start+12*i+12]
+		entry := tiffEntry{id: order.Uint16(raw), typ: order.Uint16(raw[2:]), count: order.Uint32(raw[4:])}
+		size, ok := tiffTypeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(entry.count)
+		if total <= 4 {
+			entry.value = append([]byte(nil), raw[8:8+total]...)
+		} else {
+			valueOff := uint64(order.Uint32(raw[8:]))
+			if valueOff+total > uint64(len(data)) {
+				return nil, 0, fmt.Errorf("%w: tag 0x%04x value out of range", ErrNoEXIF, entry.id)
+			}
+			entry.value = data[valueOff : valueOff+total]
+		}
+		ifd = append(ifd, entry)
+	}
+	return ifd, order.Uint32(data[start+12*n:]), nil
+}
+
+// pointer returns the LONG value of the tag id, used for offsets
+func (d tiffIFD) pointer(id uint16, order binary.ByteOrder) (uint32, bool) {
+	for _, entry := range d {
+		if entry.id == id && entry.typ == 4 && entry.count == 1 {
+			return order.Uint32(entry.value), true
+		}
+	}
+	return 0, false
+}
+
+// filter returns the entries kept by keep. Sub-IFD pointers are left alone, see withPointer.
+func (d tiffIFD) filter(names map[uint16]string, keep TagFilter) tiffIFD {
+	var kept tiffIFD
+	for _, entry := range d {
+		if entry.id == exifIFDPointer || entry.id == gpsIFDPointer || entry.id == interopIFDPointer ||
+			keep(tagName(names, entry.id)) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// withPointer keeps the pointer tag id if present, or removes it
+func (d tiffIFD) withPointer(id uint16, present bool) tiffIFD {
+	if present {
+		return d
+	}
+	var kept tiffIFD
+	for _, entry := range d {
+		if entry.id != id {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// set returns the IFD with entry replacing the entry of the same tag, or added in tag order
+func (d tiffIFD) set(entry tiffEntry) tiffIFD {
+	for i := range d {
+		if d[i].id == entry.id {
+			d[i] = entry
+			return d
+		}
+		if d[i].id > entry.id {
+			return append(d[:i], append(tiffIFD{entry}, d[i:]...)...)
+		}
+	}
+	return append(d, entry)
+}
+
+// setLong sets the value of the LONG tag id if present
+func (d tiffIFD) setLong(id uint16, value uint32, order binary.ByteOrder) {
+	for i := range d {
+		if d[i].id == id {
+			d[i].value = make([]byte, 4)
+			order.PutUint32(d[i].value, value)
+		}
+	}
+}
+
+// size returns the number of bytes the IFD and its values take, zero for an empty IFD
+func (d tiffIFD) size() int {
+	if len(d) == 0 {
+		return 0
+	}
+	n := 2 + 12*len(d) + 4
+	for _, entry := range d {
+		if len(entry.value) > 4 {
+			n += len(entry.value) + len(entry.value)%2
+		}
+	}
+	return n
+}
+
+// write writes the IFD located at offset followed by its values. Empty IFDs are not written.
+func (d tiffIFD) write(w *bytes.Buffer, order binary.ByteOrder, offset, next int) {
+	if len(d) == 0 {
+		return
+	}
+	binary.Write(w, order, uint16(len(d)))
+	valueOff := offset + 2 + 12*len(d) + 4
+	for _, entry := range d {
+		binary.Write(w, order, entry.id)
+		binary.Write(w, order, entry.typ)
+		binary.Write(w, order, entry.count)
+		if len(entry.value) <= 4 {
+			w.Write(entry.value)
+			w.Write(make([]byte, 4-len(entry.value)))
+		} else {
+			binary.Write(w, order, uint32(valueOff))
+			valueOff += len(entry.value) + len(entry.value)%2
+		}
+	}
+	binary.Write(w, order, uint32(next))
+	for _, entry := range d {
+		if len(entry.value) > 4 {
+			w.Write(entry.value)
+			if len(entry.value)%2 == 1 {
+				w.WriteByte(0)
+			}
+		}
+	}
+}
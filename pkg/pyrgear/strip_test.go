@@ -0,0 +1,179 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTestJPEG returns a 1x1 JPEG with camera, date and GPS EXIF tags and a thumbnail IFD
+func buildTestJPEG(t *testing.T, order binary.ByteOrder) []byte {
+	t.Helper()
+
+	ascii := func(id uint16, s string) tiffEntry {
+		return tiffEntry{id: id, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+	}
+	short := func(id uint16, v uint16) tiffEntry {
+		value := make([]byte, 2)
+		order.PutUint16(value, v)
+		return tiffEntry{id: id, typ: 3, count: 1, value: value}
+	}
+	long := func(id uint16, v uint32) tiffEntry {
+		value := make([]byte, 4)
+		order.PutUint32(value, v)
+		return tiffEntry{id: id, typ: 4, count: 1, value: value}
+	}
+	degrees := func(id uint16, d uint32) tiffEntry {
+		value := make([]byte, 24)
+		for i, v := range []uint32{d, 1, 30, 1, 0, 1} {
+			order.PutUint32(value[4*i:], v)
+		}
+		return tiffEntry{id: id, typ: 5, count: 3, value: value}
+	}
+
+	var thumb bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&thumb, image.NewGray(image.Rect(0, 0, 2, 2)), nil))
+
+	ifd0 := tiffIFD{
+		ascii(0x010F, "Canon"), ascii(0x0110, "EOS R5"), short(0x0112, 6),
+		long(exifIFDPointer, 0), long(gpsIFDPointer, 0),
+	}
+	exifIFD := tiffIFD{ascii(0x9003, "2024:05:06 07:08:09"), long(interopIFDPointer, 0)}
+	gpsIFD := tiffIFD{ascii(0x0001, "N"), degrees(0x0002, 52), ascii(0x0003, "E"), degrees(0x0004, 13)}
+	interopIFD := tiffIFD{ascii(0x0001, "R98")}
+	ifd1 := tiffIFD{long(thumbnailOffsetTag, 0), long(thumbnailLengthTag, uint32(thumb.Len()))}
+
+	exifOff := 8 + ifd0.size()
+	gpsOff := exifOff + exifIFD.size()
+	interopOff := gpsOff + gpsIFD.size()
+	ifd1Off := interopOff + interopIFD.size()
+	ifd0.setLong(exifIFDPointer, uint32(exifOff), order)
+	ifd0.setLong(gpsIFDPointer, uint32(gpsOff), order)
+	exifIFD.setLong(interopIFDPointer, uint32(interopOff), order)
+	ifd1.setLong(thumbnailOffsetTag, uint32(ifd1Off+ifd1.size()), order)
+
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	ifd0.write(&tiff, order, 8, ifd1Off)
+	exifIFD.write(&tiff, order, exifOff, 0)
+	gpsIFD.write(&tiff, order, gpsOff, 0)
+	interopIFD.write(&tiff, order, interopOff, 0)
+	ifd1.write(&tiff, order, ifd1Off, 0)
+	tiff.Write(thumb.Bytes())
+
+	var img bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 1, 1)), nil))
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(2+len(exifHeader)+tiff.Len()))
+	out.Write(exifHeader)
+	out.Write(tiff.Bytes())
+	out.Write(img.Bytes()[2:])
+	return out.Bytes()
+}
+
+// stripToRecord strips src with keep and decodes the EXIF data of the result
+func stripToRecord(t *testing.T, src []byte, keep TagFilter) (*Record, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	assert.NoError(t, StripEXIF(bytes.NewReader(src), &out, keep))
+
+	// The image itself is unchanged and still decodes
+	_, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+
+	return DecodeEXIF(bytes.NewReader(out.Bytes()))
+}
+
+func TestStripEXIFSelectively(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		src := buildTestJPEG(t, order)
+
+		record, err := DecodeEXIF(bytes.NewReader(src))
+		assert.NoError(t, err)
+		assert.True(t, record.HasGPS)
+
+		// Removing the location keeps everything else
+		record, err = stripToRecord(
+			t, src, func(name string) bool {
+				return name != "GPSLatitude" && name != "GPSLongitude"
+			},
+		)
+		assert.NoError(t, err)
+		assert.False(t, record.HasGPS)
+		camera, _ := record.Get("Make")
+		assert.Equal(t, "Canon", camera)
+		_, ok := record.Get("GPSLatitudeRef")
+		assert.True(t, ok)
+		_, ok = record.Get("InteroperabilityIndex")
+		assert.True(t, ok)
+		taken, ok := record.DateTime()
+		assert.True(t, ok)
+		assert.Equal(t, 2024, taken.Year())
+
+		// Keeping the camera drops the date, the location and the emptied sub-IFDs
+		record, err = stripToRecord(
+			t, src, func(name string) bool {
+				return name == "Make" || name == "Model" || name == "Orientation"
+			},
+		)
+		assert.NoError(t, err)
+		var names []string
+		for _, tag := range record.Tags {
+			names = append(names, tag.Name)
+		}
+		assert.ElementsMatch(
+			t, []string{"Make", "Model", "Orientation", "ThumbJPEGInterchangeFormat", "ThumbJPEGInterchangeFormatLength"},
+			names,
+		)
+
+		// Removing every tag or stripping everything removes the EXIF segment
+		for _, keep := range []TagFilter{nil, func(string) bool { return false }} {
+			_, err = stripToRecord(t, src, keep)
+			assert.ErrorIs(t, err, ErrNoEXIF)
+		}
+	}
+}
+
+func TestStripEXIFFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pyrgear_strip_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		assert.NoError(t, err)
+	}()
+
+	path := filepath.Join(tempDir, "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, buildTestJPEG(t, binary.LittleEndian), 0600))
+	assert.NoError(t, StripEXIFFile(path, func(name string) bool { return name != "Model" }))
+
+	record, err := DecodeEXIFFile(path)
+	assert.NoError(t, err)
+	_, ok := record.Get("Model")
+	assert.False(t, ok)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.ErrorIs(t, StripEXIF(bytes.NewReader([]byte("not a jpeg")), &bytes.Buffer{}, nil), ErrUnsupportedFormat)
+}
@@ -0,0 +1,158 @@
+package pyrgear
+
+import "fmt"
+
+// mainTagNames are the names of the IFD0 and Exif sub-IFD tags, matching the names reported by DecodeEXIF
+var mainTagNames = map[uint16]string{
+	0x0100: "ImageWidth",
+	0x0101: "ImageLength",
+	0x0102: "BitsPerSample",
+	0x0103: "Compression",
+	0x0106: "PhotometricInterpretation",
+	0x0112: "Orientation",
+	0x0115: "SamplesPerPixel",
+	0x011C: "PlanarConfiguration",
+	0x0212: "YCbCrSubSampling",
+	0x0213: "YCbCrPositioning",
+	0x011A: "XResolution",
+	0x011B: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0132: "DateTime",
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0131: "Software",
+	0x013B: "Artist",
+	0x8298: "Copyright",
+	0x9C9B: "XPTitle",
+	0x9C9C: "XPComment",
+	0x9C9D: "XPAuthor",
+	0x9C9E: "XPKeywords",
+	0x9C9F: "XPSubject",
+	0x8769: "ExifIFDPointer",
+	0x8825: "GPSInfoIFDPointer",
+	0xA005: "InteroperabilityIFDPointer",
+	0x9000: "ExifVersion",
+	0xA000: "FlashpixVersion",
+	0xA001: "ColorSpace",
+	0x9101: "ComponentsConfiguration",
+	0x9102: "CompressedBitsPerPixel",
+	0xA002: "PixelXDimension",
+	0xA003: "PixelYDimension",
+	0x927C: "MakerNote",
+	0x9286: "UserComment",
+	0xA004: "RelatedSoundFile",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xA420: "ImageUniqueID",
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8824: "SpectralSensitivity",
+	0x8827: "ISOSpeedRatings",
+	0x8828: "OECF",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9203: "BrightnessValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9206: "SubjectDistance",
+	0x9207: "MeteringMode",
+	0x9208: "LightSource",
+	0x9209: "Flash",
+	0x920A: "FocalLength",
+	0x9214: "SubjectArea",
+	0xA20B: "FlashEnergy",
+	0xA20C: "SpatialFrequencyResponse",
+	0xA20E: "FocalPlaneXResolution",
+	0xA20F: "FocalPlaneYResolution",
+	0xA210: "FocalPlaneResolutionUnit",
+	0xA214: "SubjectLocation",
+	0xA215: "ExposureIndex",
+	0xA217: "SensingMethod",
+	0xA300: "FileSource",
+	0xA301: "SceneType",
+	0xA302: "CFAPattern",
+	0xA401: "CustomRendered",
+	0xA402: "ExposureMode",
+	0xA403: "WhiteBalance",
+	0xA404: "DigitalZoomRatio",
+	0xA405: "FocalLengthIn35mmFilm",
+	0xA406: "SceneCaptureType",
+	0xA407: "GainControl",
+	0xA408: "Contrast",
+	0xA409: "Saturation",
+	0xA40A: "Sharpness",
+	0xA40B: "DeviceSettingDescription",
+	0xA40C: "SubjectDistanceRange",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+}
+
+// gpsTagNames are the names of the GPS sub-IFD tags
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0008: "GPSSatelites",
+	0x0009: "GPSStatus",
+	0x000A: "GPSMeasureMode",
+	0x000B: "GPSDOP",
+	0x000C: "GPSSpeedRef",
+	0x000D: "GPSSpeed",
+	0x000E: "GPSTrackRef",
+	0x000F: "GPSTrack",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x0013: "GPSDestLatitudeRef",
+	0x0014: "GPSDestLatitude",
+	0x0015: "GPSDestLongitudeRef",
+	0x0016: "GPSDestLongitude",
+	0x0017: "GPSDestBearingRef",
+	0x0018: "GPSDestBearing",
+	0x0019: "GPSDestDistanceRef",
+	0x001A: "GPSDestDistance",
+	0x001B: "GPSProcessingMethod",
+	0x001C: "GPSAreaInformation",
+	0x001D: "GPSDateStamp",
+	0x001E: "GPSDifferential",
+}
+
+// newerTagNames are Exif sub-IFD tags of EXIF 2.31 that goexif does not know and would
+// leave out, DecodeEXIF loads them by these names.
+var newerTagNames = map[uint16]string{
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+}
+
+func init() {
+	for id, name := range newerTagNames {
+		mainTagNames[id] = name
+	}
+}
+
+// interopTagNames are the names of the Interoperability sub-IFD tags
+var interopTagNames = map[uint16]string{
+	0x0001: "InteroperabilityIndex",
+}
+
+// unknownTagPrefix prefixes the hex ID of tags without a name, as DecodeEXIF does
+const unknownTagPrefix = "UnknownTag_"
+
+// tagName returns the name of the tag id in names
+func tagName(names map[uint16]string, id uint16) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s%x", unknownTagPrefix, id)
+}
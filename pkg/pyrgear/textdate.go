@@ -0,0 +1,193 @@
+package pyrgear
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// monthNames are the English month names and abbreviations FindDate accepts, by month
+var monthNames = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "sept": time.September, "oct": time.October, "nov": time.November,
+	"dec": time.December,
+}
+
+// monthPattern matches a month name or abbreviation, captured in its first three or four letters
+const monthPattern = `(jan|feb|mar|apr|may|jun|jul|aug|sept|sep|oct|nov|dec)[a-z]*\.?`
+
+// Date patterns of FindDate, matched case-insensitively
+var (
+	// isoDate matches 2023-07-14, 2023/07/14 and 2023.7.14
+	isoDate = regexp.MustCompile(`\b(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\b`)
+	// numericDate matches 14.07.2023, 07/14/23 and the like, in day or month first order
+	numericDate = regexp.MustCompile(`\b(\d{1,2})[-/.](\d{1,2})[-/.](\d{4}|\d{2})\b`)
+	// dayMonthDate matches 14 Jul 2023 and 14. July 2023
+	dayMonthDate = regexp.MustCompile(`(?i)\b(\d{1,2})\.?\s+` + monthPattern + `,?\s+(\d{4})\b`)
+	// monthDayDate matches Jul 14, 2023 and July 14th 2023
+	monthDayDate = regexp.MustCompile(`(?i)\b` + monthPattern + `\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)
+)
+
+// FindDate returns the first date written in text, such as the text recognized in a scanned
+// receipt, at midnight in loc. It finds ISO dates (2023-07-14), numeric dates (14.07.2023 or
+// 14/07/23) and dates with an English month name (14 Jul 2023, July 14, 2023). Numeric dates
+// are read day first, or month first (07/14/2023) without dayFirst; two-digit years are taken
+// as 19xx from 70 on and as 20xx below. Matches that are no valid date, such as 31.02.2023 or
+// version numbers, are skipped.
+func FindDate(text string, dayFirst bool, loc *time.Location) (time.Time, bool) {
+	type found struct {
+		at int
+		t  time.Time
+	}
+	var first *found
+	consider := func(at int, year, month, day int) {
+		if first != nil && first.at <= at {
+			return
+		}
+		if !validDate(year, month, day) {
+			return
+		}
+		first = &found{at: at, t: time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)}
+	}
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	for _, m := range isoDate.FindAllStringSubmatchIndex(text, -1) {
+		g := submatches(text, m)
+		consider(m[0], number(g[1]), number(g[2]), number(g[3]))
+	}
+	for _, m := range numericDate.FindAllStringSubmatchIndex(text, -1) {
+		g := submatches(text, m)
+		day, month := number(g[1]), number(g[2])
+		if !dayFirst {
+			day, month = month, day
+		}
+		year := number(g[3])
+		if len(g[3]) == 2 {
+			year += 2000
+			if year >= 2070 {
+				year -= 100
+			}
+		}
+		consider(m[0], year, month, day)
+	}
+	for _, m := range dayMonthDate.FindAllStringSubmatchIndex(text, -1) {
+		g := submatches(text, m)
+		consider(m[0], number(g[3]), int(monthNames[strings.ToLower(g[2])]), number(g[1]))
+	}
+	for _, m := range monthDayDate.FindAllStringSubmatchIndex(text, -1) {
+		g := submatches(text, m)
+		consider(m[0], number(g[3]), int(monthNames[strings.ToLower(g[1])]), number(g[2]))
+	}
+
+	if first == nil {
+		return time.Time{}, false
+	}
+	return first.t, true
+}
+
+// validDate reports whether year, month and day form a date, as dates in text and filenames
+// are read: a year from 1900 to 2999 and a day that exists in the month
+func validDate(year, month, day int) bool {
+	if year < 1900 || year > 2999 || month < 1 || month > 12 || day < 1 {
+		return false
+	}
+	// time.Date normalizes 31.02 into March, which is not what the text says
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	return t.Day() == day && t.Month() == time.Month(month)
+}
+
+// Date patterns of NormalizeNameDate. They end at digits rather than at word boundaries, as
+// \b does not separate a date from the underscores around it in IMG_20230615_x.jpg.
+var (
+	// nameISODate matches 2023-06-15, 2023_06_15 and 2023.6.15
+	nameISODate = regexp.MustCompile(`(?:^|\D)((\d{4})[-_.](\d{1,2})[-_.](\d{1,2}))(?:\D|$)`)
+	// nameCompactDate matches 20230615, but not the digits of longer numbers
+	nameCompactDate = regexp.MustCompile(`(?:^|\D)((\d{4})(\d{2})(\d{2}))(?:\D|$)`)
+	// nameNumericDate matches 15.06.2023 and 06-15-2023, in day or month first order
+	nameNumericDate = regexp.MustCompile(`(?:^|\D)((\d{1,2})[-_.](\d{1,2})[-_.](\d{4}))(?:\D|$)`)
+)
+
+// NormalizeNameDate rewrites the first date in the stem of name to YYYY-MM-DD, keeping the rest
+// of the name: IMG_20230615_1430.jpg becomes IMG_2023-06-15_1430.jpg and scan 15.06.2023.pdf
+// scan 2023-06-15.pdf. It finds dates written year first (2023-06-15, 2023_06_15, 20230615)
+// and day or month first with a four-digit year (15.06.2023, 06-15-2023). Eight digits are
+// always read as YYYYMMDD. Day and month first dates are read in the order that gives a valid
+// date; where both do, as in 04.07.2023, day first unless dayFirst is false. ok is false if
+// the name holds no date.
+func NormalizeNameDate(name string, dayFirst bool) (string, bool) {
+	stem, ext := SplitExt(name)
+	start, end := -1, -1
+	var date string
+	consider := func(at, to int, year, month, day int) {
+		if (start < 0 || at < start) && validDate(year, month, day) {
+			start, end = at, to
+			date = fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+		}
+	}
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	for _, re := range []*regexp.Regexp{nameISODate, nameCompactDate} {
+		for _, m := range findAllOverlapping(re, stem) {
+			g := submatches(stem, m)
+			consider(m[2], m[3], number(g[2]), number(g[3]), number(g[4]))
+		}
+	}
+	for _, m := range findAllOverlapping(nameNumericDate, stem) {
+		g := submatches(stem, m)
+		first, second, year := number(g[2]), number(g[3]), number(g[4])
+		day, month := first, second
+		if !dayFirst {
+			day, month = second, first
+		}
+		if !validDate(year, month, day) {
+			day, month = month, day
+		}
+		consider(m[2], m[3], year, month, day)
+	}
+
+	if start < 0 {
+		return name, false
+	}
+	return stem[:start] + date + stem[end:] + ext, true
+}
+
+// findAllOverlapping returns the submatch indexes of every match of re in s. Unlike
+// re.FindAllStringSubmatchIndex, it resumes after the start of the first group of each match,
+// so the separator a match ends with can start the next one, as in 20230615_20230616.
+func findAllOverlapping(re *regexp.Regexp, s string) [][]int {
+	var matches [][]int
+	for offset := 0; offset < len(s); {
+		m := re.FindStringSubmatchIndex(s[offset:])
+		if m == nil {
+			break
+		}
+		for i := range m {
+			if m[i] >= 0 {
+				m[i] += offset
+			}
+		}
+		matches = append(matches, m)
+		offset = m[2] + 1
+	}
+	return matches
+}
+
+// submatches returns the strings of the submatch indexes m of text
+func submatches(text string, m []int) []string {
+	groups := make([]string, len(m)/2)
+	for i := range groups {
+		if m[2*i] >= 0 {
+			groups[i] = text[m[2*i]:m[2*i+1]]
+		}
+	}
+	return groups
+}
@@ -0,0 +1,61 @@
+package pyrgear
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDate(t *testing.T) {
+	date := func(text string, dayFirst bool) string {
+		found, ok := FindDate(text, dayFirst, time.UTC)
+		if !ok {
+			return ""
+		}
+		return found.Format("2006-01-02")
+	}
+
+	assert.Equal(t, "2023-07-14", date("INVOICE\nDate: 2023-07-14\nTotal 12.50", true))
+	assert.Equal(t, "2023-07-14", date("Kassenbon 14.07.2023 10:31", true))
+	assert.Equal(t, "2023-07-14", date("Receipt 07/14/23", false))
+	assert.Equal(t, "2023-07-04", date("Receipt 04/07/23", true))
+	assert.Equal(t, "1998-03-01", date("1.3.98", true))
+	assert.Equal(t, "2023-07-14", date("Issued 14 Jul 2023", true))
+	assert.Equal(t, "2023-09-02", date("September 2nd, 2023", true))
+
+	// The first date of the text wins, invalid dates and other numbers are skipped
+	assert.Equal(t, "2022-12-24", date("printed 24.12.2022, due 2023-01-15", true))
+	assert.Equal(t, "2023-03-01", date("v1.2.3 31.02.2023 01.03.2023", true))
+	assert.Equal(t, "", date("Total 12.50 EUR, table 4", true))
+	assert.Equal(t, "", date("", true))
+}
+
+func TestNormalizeNameDate(t *testing.T) {
+	normalize := func(name string, dayFirst bool) string {
+		normalized, ok := NormalizeNameDate(name, dayFirst)
+		if !ok {
+			return ""
+		}
+		return normalized
+	}
+
+	assert.Equal(t, "IMG_2023-06-15_143022.jpg", normalize("IMG_20230615_143022.jpg", true))
+	assert.Equal(t, "2023-06-15 party.jpg", normalize("2023_06_15 party.jpg", true))
+	assert.Equal(t, "scan 2023-06-15.pdf", normalize("scan 15.06.2023.pdf", true))
+	assert.Equal(t, "2023-06-05-notes.txt", normalize("2023.6.5-notes.txt", true))
+	assert.Equal(t, "2023-06-15.jpg", normalize("2023-06-15.jpg", true))
+
+	// Ambiguous day and month follow dayFirst, unambiguous ones are read the way that is valid
+	assert.Equal(t, "2023-07-04.pdf", normalize("04.07.2023.pdf", true))
+	assert.Equal(t, "2023-04-07.pdf", normalize("04.07.2023.pdf", false))
+	assert.Equal(t, "2023-06-15.pdf", normalize("06-15-2023.pdf", true))
+	assert.Equal(t, "2023-06-15.pdf", normalize("15-06-2023.pdf", false))
+
+	// The first valid date wins, longer numbers, invalid dates and the extension are not dates
+	assert.Equal(t, "a 2023-06-16 b 20230617.jpg", normalize("a 20230616 b 20230617.jpg", true))
+	assert.Equal(t, "x_20230230_2023-06-16.jpg", normalize("x_20230230_20230616.jpg", true))
+	assert.Equal(t, "", normalize("IMG_123456789.jpg", true))
+	assert.Equal(t, "", normalize("photo.20230615", true))
+	assert.Equal(t, "", normalize("notes.txt", true))
+}
@@ -0,0 +1,156 @@
+package pyrgear
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition tests the value of an EXIF tag, e.g. Make=Canon or ISO>800
+type Condition struct {
+	// Tag is the tag name as reported by DecodeEXIF
+	Tag string
+	// Op is one of =, !=, <, <=, >, >= and contains
+	Op string
+	// Value is the operand, unquoted
+	Value string
+}
+
+// conditionOps are the comparison operators, longer ones first so <= is not read as <
+var conditionOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// ParseCondition parses a condition of the form <tag> <op> <value>, where op is one of =, !=,
+// <, <=, >, >= or the word contains. Spaces around the operator are optional, except around
+// contains. The value may be quoted with ' or " to keep leading or trailing spaces.
+func ParseCondition(expr string) (Condition, error) {
+	s := strings.TrimSpace(expr)
+	end := strings.IndexFunc(
+		s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		},
+	)
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 {
+		return Condition{}, fmt.Errorf("invalid condition %q: expected a tag name", expr)
+	}
+	c := Condition{Tag: s[:end]}
+	rest := strings.TrimSpace(s[end:])
+
+	if word, value, ok := strings.Cut(rest, " "); ok && strings.EqualFold(word, "contains") {
+		c.Op, rest = "contains", value
+	} else {
+		for _, op := range conditionOps {
+			if strings.HasPrefix(rest, op) {
+				c.Op, rest = op, rest[len(op):]
+				break
+			}
+		}
+	}
+	if c.Op == "" {
+		return Condition{}, fmt.Errorf(
+			"invalid condition %q: expected one of =, !=, <, <=, >, >= or contains after %s", expr, c.Tag,
+		)
+	}
+
+	c.Value = strings.TrimSpace(rest)
+	if len(c.Value) >= 2 && (c.Value[0] == '\'' || c.Value[0] == '"') && c.Value[len(c.Value)-1] == c.Value[0] {
+		c.Value = c.Value[1 : len(c.Value)-1]
+	} else if c.Value == "" {
+		return Condition{}, fmt.Errorf("invalid condition %q: expected a value after %s", expr, c.Op)
+	}
+	return c, nil
+}
+
+// String returns the condition as it would be parsed
+func (c Condition) String() string {
+	if c.Op == "contains" {
+		return fmt.Sprintf("%s contains %q", c.Tag, c.Value)
+	}
+	return fmt.Sprintf("%s%s%q", c.Tag, c.Op, c.Value)
+}
+
+// Match reports whether record satisfies the condition. Images without the tag never match,
+// not even a != condition. When both the tag value and the operand are numbers (see
+// ParseNumber) they are compared as numbers, so FNumber=2.8 matches a stored 28/10 and
+// ExposureTime<1/100 selects fast shutter speeds. Otherwise = and != ignore case, contains
+// looks for a case-insensitive substring and the ordering operators compare the strings,
+// which orders EXIF dates (2024:05:06 10:00:00) correctly.
+func (c Condition) Match(record *Record) bool {
+	if record == nil {
+		return false
+	}
+	value, ok := record.Get(c.Tag)
+	if !ok {
+		return false
+	}
+	value = strings.TrimSpace(value)
+
+	if c.Op == "contains" {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(c.Value))
+	}
+
+	var cmp int
+	a, aok := ParseNumber(value)
+	b, bok := ParseNumber(c.Value)
+	switch {
+	case aok && bok:
+		cmp = compareFloats(a, b)
+	case c.Op == "=" || c.Op == "!=":
+		if strings.EqualFold(value, c.Value) {
+			cmp = 0
+		} else {
+			cmp = 1
+		}
+	default:
+		cmp = strings.Compare(value, c.Value)
+	}
+
+	switch c.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareFloats returns -1, 0 or 1 as a is less than, equal to or greater than b
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ParseNumber parses a single numeric EXIF value: an integer (800), a decimal (2.8) or a
+// rational as stored by EXIF (28/10, -1/3). Rationals with a zero denominator are not numbers.
+func ParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil {
+			return 0, false
+		}
+		d, err := strconv.ParseFloat(strings.TrimSpace(den), 64)
+		if err != nil || d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
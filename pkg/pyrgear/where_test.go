@@ -0,0 +1,81 @@
+package pyrgear
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondition(t *testing.T) {
+	for expr, want := range map[string]Condition{
+		"Make=Canon":                   {Tag: "Make", Op: "=", Value: "Canon"},
+		"ISO > 800":                    {Tag: "ISO", Op: ">", Value: "800"},
+		"ExposureTime<=1/250":          {Tag: "ExposureTime", Op: "<=", Value: "1/250"},
+		"Model != 'EOS R5'":            {Tag: "Model", Op: "!=", Value: "EOS R5"},
+		"LensModel contains 70-200":    {Tag: "LensModel", Op: "contains", Value: "70-200"},
+		`Artist CONTAINS " Smith "`:    {Tag: "Artist", Op: "contains", Value: " Smith "},
+		"Software=''":                  {Tag: "Software", Op: "=", Value: ""},
+		"DateTimeOriginal>=2024:05:01": {Tag: "DateTimeOriginal", Op: ">=", Value: "2024:05:01"},
+	} {
+		c, err := ParseCondition(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, want, c, expr)
+	}
+
+	for _, expr := range []string{"", "Make", "=Canon", "Make~Canon", "ISO>", "Make contains"} {
+		_, err := ParseCondition(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestConditionMatch(t *testing.T) {
+	record := &Record{
+		Tags: []Tag{
+			{Name: "Make", Value: "Canon"},
+			{Name: "Model", Value: "Canon EOS R5 "},
+			{Name: "ISOSpeedRatings", Value: "1600"},
+			{Name: "ExposureTime", Value: "1/250"},
+			{Name: "FNumber", Value: "28/10"},
+			{Name: "ExposureBiasValue", Value: "-2/3"},
+			{Name: "DateTimeOriginal", Value: "2024:05:06 07:08:09"},
+		},
+	}
+	for expr, want := range map[string]bool{
+		"Make=Canon":                     true,
+		"Make=canon":                     true,
+		"Make!=Canon":                    false,
+		"Model contains eos":             true,
+		"Model=Canon EOS R5":             true,
+		"ISOSpeedRatings>800":            true,
+		"ISOSpeedRatings<=800":           false,
+		"ISOSpeedRatings=1600.0":         true,
+		"ExposureTime<1/100":             true,
+		"ExposureTime>0.01":              false,
+		"FNumber=2.8":                    true,
+		"FNumber>=28/10":                 true,
+		"ExposureBiasValue<0":            true,
+		"DateTimeOriginal>=2024:05:01":   true,
+		"DateTimeOriginal<2024:05:06 07": false,
+		"LensModel!=RF":                  false,
+		"LensModel contains RF":          false,
+	} {
+		c, err := ParseCondition(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, want, c.Match(record), expr)
+	}
+	assert.False(t, Condition{Tag: "Make", Op: "=", Value: "Canon"}.Match(nil))
+
+	_, ok := ParseNumber("1/0")
+	assert.False(t, ok)
+}
+
+func TestDecodeEXIFNumericTags(t *testing.T) {
+	record, err := DecodeEXIF(bytes.NewReader(buildTestJPEG(t, binary.LittleEndian)))
+	assert.NoError(t, err)
+	orientation, _ := record.Get("Orientation")
+	assert.Equal(t, "6", orientation)
+	latitude, _ := record.Get("GPSLatitude")
+	assert.Equal(t, "52/1, 30/1, 0/1", latitude)
+}